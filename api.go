@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// seriesPageSize is the default number of series returned by /api/metrics/{name}/series.
+const seriesPageSize = 1000
+
+// apiHandler serves /api/metrics/{name}/last, which returns the most recent matching line per label set.
+// This helps to trace a spike seen in a dashboard back to concrete log lines.
+// It also serves /api/metrics/{name}/series, which returns the current label sets and values, see series().
+// metricList returns the current metrics, which change when the config is reloaded.
+func apiHandler(metricList func() []metrics.Metric) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/metrics/")
+		slash := strings.LastIndex(path, "/")
+		if slash < 0 || (path[slash:] != "/last" && path[slash:] != "/series") {
+			http.NotFound(w, r)
+			return
+		}
+		name := path[:slash]
+		for _, metric := range metricList() {
+			if metric.Name() != name {
+				continue
+			}
+			if path[slash:] == "/series" {
+				series(w, r, metric)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"metric":  name,
+				"matches": metric.LastMatches(),
+			})
+			return
+		}
+		http.Error(w, "Unknown metric "+name, http.StatusNotFound)
+	})
+}
+
+type seriesJson struct {
+	Labels map[string]string `json:"labels"`
+	Value  *float64          `json:"value,omitempty"` // counters and gauges
+	Count  *uint64           `json:"count,omitempty"` // histograms and summaries
+	Sum    *float64          `json:"sum,omitempty"`   // histograms and summaries
+}
+
+// series writes the label sets and values of the metric as JSON, sorted by labels. The query parameters 'offset' and 'limit'
+// select a page, and 'next_offset' in the response is the offset of the next page, or missing on the last page.
+func series(w http.ResponseWriter, r *http.Request, metric metrics.Metric) {
+	offset, limit := 0, seriesPageSize
+	for param, target := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if value := r.URL.Query().Get(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("Invalid parameter '%v': %v is not a non-negative number.", param, value), http.StatusBadRequest)
+				return
+			}
+			*target = n
+		}
+	}
+	all := seriesJsons(metrics.Snapshot(metric))
+	start, end := offset, offset+limit
+	if start > len(all) {
+		start = len(all)
+	}
+	if end > len(all) {
+		end = len(all)
+	}
+	page := all[start:end]
+	result := map[string]interface{}{
+		"metric": metric.Name(),
+		"total":  len(all),
+		"series": page,
+	}
+	if offset+limit < len(all) {
+		result["next_offset"] = offset + limit
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// seriesJsons converts the series of the collector, sorted by labels.
+func seriesJsons(collector prometheus.Collector) []seriesJson {
+	collected := collectSeries(collector)
+	sort.Sort(byLabels(collected))
+	result := make([]seriesJson, 0, len(collected))
+	for _, d := range collected {
+		s := seriesJson{Labels: make(map[string]string, len(d.Label))}
+		for _, label := range d.Label {
+			s.Labels[label.GetName()] = label.GetValue()
+		}
+		switch {
+		case d.Counter != nil:
+			s.Value = d.Counter.Value
+		case d.Gauge != nil:
+			s.Value = d.Gauge.Value
+		case d.Untyped != nil:
+			s.Value = d.Untyped.Value
+		case d.Histogram != nil:
+			s.Count, s.Sum = d.Histogram.SampleCount, d.Histogram.SampleSum
+		case d.Summary != nil:
+			s.Count, s.Sum = d.Summary.SampleCount, d.Summary.SampleSum
+		}
+		result = append(result, s)
+	}
+	return result
+}
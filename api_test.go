@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSeriesApi(t *testing.T) {
+	cfg, err := config.LoadConfigString([]byte(`
+input:
+    type: stdin
+grok:
+    patterns: ['WORD \w+']
+metrics:
+    - type: counter
+      name: api_requests_total
+      help: Requests.
+      match: 'user=%{WORD:user}'
+      labels:
+          - grok_field_name: user
+            prometheus_label: user
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\w+")
+	metricList, err := createMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"user=carol", "user=alice", "user=bob", "user=alice"} {
+		metricList[0].Process(line, nil)
+	}
+	handler := apiHandler(func() []metrics.Metric { return metricList })
+	var result struct {
+		Total      int
+		NextOffset *int `json:"next_offset"`
+		Series     []seriesJson
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/metrics/api_requests_total/series?limit=2", nil))
+	if err = json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse %q: %v", recorder.Body.String(), err)
+	}
+	if result.Total != 3 || len(result.Series) != 2 || result.NextOffset == nil || *result.NextOffset != 2 {
+		t.Fatalf("Expected the first page of 2 of 3 series, but got %v.", recorder.Body.String())
+	}
+	if s := result.Series[0]; s.Labels["user"] != "alice" || s.Value == nil || *s.Value != 2 {
+		t.Errorf("Expected user alice with value 2 first, but got %v.", recorder.Body.String())
+	}
+	result.NextOffset = nil
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/metrics/api_requests_total/series?offset=2&limit=2", nil))
+	if err = json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse %q: %v", recorder.Body.String(), err)
+	}
+	if len(result.Series) != 1 || result.Series[0].Labels["user"] != "carol" || result.NextOffset != nil {
+		t.Errorf("Expected the last page with user carol, but got %v.", recorder.Body.String())
+	}
+	for path, expected := range map[string]int{
+		"/api/metrics/api_requests_total/series?limit=x": 400,
+		"/api/metrics/unknown_total/series":              404,
+		"/api/metrics/api_requests_total/other":          404,
+	} {
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != expected {
+			t.Errorf("%v: Expected status %v, but got %v.", path, expected, recorder.Code)
+		}
+	}
+}
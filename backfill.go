@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// backfill processes the rotated files path.n, ..., path.1 before the live file is tailed, oldest first.
+// If since is not zero, lines up to that timestamp were processed before the restart and are skipped.
+// Missing files are ignored, because there may be fewer rotated generations than configured.
+func backfill(path string, generations int, since time.Time, p *pipeline) {
+	for i := generations; i >= 1; i-- {
+		rotated := fmt.Sprintf("%v.%v", path, i)
+		err := backfillFile(rotated, since, p)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Failed to backfill %v: %v\n", rotated, err.Error())
+		}
+	}
+}
+
+func backfillFile(path string, since time.Time, p *pipeline) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	skipping := !since.IsZero()
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimRight(line, "\r\n")
+			if skipping {
+				// Timestamps are increasing, so all lines after the first newer line are processed.
+				// Lines without timestamp are skipped until then.
+				if timestamp, ok := p.timestamps.parse(line); ok && timestamp.After(since) {
+					skipping = false
+				}
+			}
+			if !skipping {
+				p.process(line, time.Now())
+			}
+		}
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBackfill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(path+".2", []byte("2016-04-01 12:00:00 a\n2016-04-01 12:00:01 b\n"), 0644)
+	ioutil.WriteFile(path+".1", []byte("2016-04-01 12:00:02 c\nno timestamp d\n2016-04-01 12:00:03 e\n"), 0644)
+	cfg, err := config.LoadConfigString([]byte(`
+input:
+    type: file
+    path: ` + path + `
+    backfill: 3
+    timestamp:
+        match: '^%{TS:timestamp} '
+        field: timestamp
+        layout: '2006-01-02 15:04:05'
+grok:
+    patterns:
+        - 'TS \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}'
+metrics:
+    - type: counter
+      name: lines_total
+      help: Lines.
+      match: ' (?<msg>[a-z]+)$'
+      labels:
+          - grok_field_name: msg
+            prometheus_label: msg
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := initPatterns(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := createMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, err := newTimestampParser(cfg.Input.Timestamp, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pipeline{metrics: metrics, input: path, timestamps: parser}
+	since, _ := time.Parse("2006-01-02 15:04:05", "2016-04-01 12:00:01")
+	backfill(path, cfg.Input.Backfill, since, p)
+	processed := make([]string, 0)
+	for _, match := range metrics[0].LastMatches() {
+		processed = append(processed, match.Labels["msg"])
+	}
+	sort.Strings(processed)
+	if len(processed) != 3 || processed[0] != "c" || processed[1] != "d" || processed[2] != "e" {
+		t.Errorf("Expected the lines after %v to be processed, but got %v.", since, processed)
+	}
+}
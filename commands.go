@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+func runVersion(args []string) int {
+	flags := flag.NewFlagSet("grok_exporter version", flag.ContinueOnError)
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	adjustMaxProcs()
+	fmt.Printf("grok_exporter version %v build date %v.\n", VERSION, BUILD_DATE)
+	fmt.Printf("GOMAXPROCS %v (%v CPUs).\n", runtime.GOMAXPROCS(0), runtime.NumCPU())
+	fmt.Printf("Default regex engine %v.\n", regex.DefaultEngine)
+	return exitOK
+}
+
+func runCheck(args []string) int {
+	flags, configFlags := newFlagSet("check")
+	listPatterns := flags.Bool("list-patterns", false, "Print all patterns with namespace and source. The patterns used for unqualified references are marked with '*'.")
+	explainMetric := flags.String("explain", "", "Print the expanded expressions of the metric with this name, and where each named group comes from.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	if *explainMetric != "" {
+		// The explanation is printed before the config is validated, because it helps to find the cause of validation errors.
+		if exitCode := runExplain(configFlags, *explainMetric); exitCode != exitOK {
+			return exitCode
+		}
+	}
+	_, patterns, _, err := initialize(configFlags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	if *listPatterns {
+		for _, line := range patterns.List() {
+			fmt.Println(line)
+		}
+	}
+	fmt.Printf("%v: OK\n", *configFlags.path)
+	return exitOK
+}
+
+func runExplain(configFlags *configFlags, name string) int {
+	cfg, err := configFlags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	patterns, err := initPatterns(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	for _, m := range *cfg.Metrics {
+		if m.Name == name && m.Type != "derived" {
+			explain(os.Stdout, m, patterns)
+			return exitOK
+		}
+	}
+	fmt.Fprintf(os.Stderr, "'-explain': Metric %v is not defined in the config, or is a derived metric.\n", name)
+	return exitFailure
+}
+
+func runLint(args []string) int {
+	flags, configFlags := newFlagSet("lint")
+	ignoreDir := flags.Bool("ignore-patterns-dir", false, "Don't report unused patterns from 'grok.patterns_dir', for example if it contains a library of patterns.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	cfg, err := configFlags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	patterns, err := initPatterns(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	findings, err := lint(cfg, patterns, *ignoreDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	for _, finding := range findings {
+		fmt.Println(finding)
+	}
+	if len(findings) > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// runTest processes the lines without starting the server, and prints the resulting metrics in Prometheus text format.
+func runTest(args []string) int {
+	flags, configFlags := newFlagSet("test")
+	profiling := addProfileFlags(flags)
+	input := flags.String("input", "-", "Path to a log file. '-' means stdin.")
+	verbose := flags.Bool("v", false, "Print the names of the matching metrics for each line.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	stopProfiling, err := profiling.start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	defer stopProfiling()
+	cfg, patterns, metrics, err := initialize(configFlags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	lines, err := readLines(*input, cfg.Input.Framing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	if cfg.Input.Multiline != nil {
+		joiner, err := newMultiline(cfg.Input.Multiline, patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+		lines = joiner.joinAll(lines)
+	}
+	router, err := newRouter(cfg, patterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	fields := inputFields(cfg.Input, *input)
+	for i, line := range lines {
+		matched := make([]string, 0)
+		route := router.route(line, fields)
+		for j, metric := range metrics {
+			if router.accepts(j, route) && metric.Matches(line) {
+				metric.Process(line, fields)
+				matched = append(matched, metric.Name())
+			}
+		}
+		if *verbose {
+			fmt.Printf("line %v: matched %v\n", i+1, matched)
+		}
+	}
+	printed := withCompanions(metrics)
+	for _, m := range printed {
+		prometheus.MustRegister(m.Collector())
+	}
+	printMetrics(os.Stdout, printed)
+	return exitOK
+}
+
+// runBench processes all lines of a file and prints how much time each metric took.
+func runBench(args []string) int {
+	flags, configFlags := newFlagSet("bench")
+	profiling := addProfileFlags(flags)
+	input := flags.String("input", "", "Path to a log file.")
+	repeat := flags.Int("repeat", 1, "Number of times the log file is processed.")
+	debugAllocs := flags.Bool("debug.allocs", false, "After the benchmark, process the file once more and print the allocations per line for each stage.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	stopProfiling, err := profiling.start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	defer stopProfiling()
+	if *input == "" || *repeat < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: grok_exporter bench -config <path> -input <path> [-repeat <n>] [-debug.allocs]\n")
+		return exitUsage
+	}
+	cfg, patterns, metrics, err := initialize(configFlags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	lines, err := readLines(*input, cfg.Input.Framing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	if cfg.Input.Multiline != nil {
+		joiner, err := newMultiline(cfg.Input.Multiline, patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+		lines = joiner.joinAll(lines)
+	}
+	if len(lines) == 0 {
+		fmt.Fprintf(os.Stderr, "%v is empty.\n", *input)
+		return exitFailure
+	}
+	fields := inputFields(cfg.Input, *input)
+	if *debugAllocs {
+		// The allocations are measured after the benchmark, when the series already exist,
+		// so that the numbers show the steady state and are comparable across releases.
+		defer printAllocs(cfg, patterns, metrics, *input, fields)
+	}
+	durations := make([]time.Duration, len(metrics))
+	matches := make([]int, len(metrics))
+	start := time.Now()
+	for r := 0; r < *repeat; r++ {
+		for _, line := range lines {
+			for i, metric := range metrics {
+				metricStart := time.Now()
+				if metric.Matches(line) {
+					metric.Process(line, fields)
+					matches[i]++
+				}
+				durations[i] += time.Since(metricStart)
+			}
+		}
+	}
+	total := time.Since(start)
+	n := len(lines) * *repeat
+	fmt.Printf("Processed %v lines in %v (%.0f lines/s).\n", n, total, float64(n)/total.Seconds())
+	for i, metric := range metrics {
+		fmt.Printf("  %v: %v matches, %v total, %v/line\n", metric.Name(), matches[i], durations[i], durations[i]/time.Duration(n))
+	}
+	return exitOK
+}
+
+// printAllocs processes the file once and prints the heap allocations per line of each stage:
+// reading the file, joining multiline records, and matching and processing for each metric.
+// The allocations of each stage are divided by the number of lines read, so that the stages add up.
+func printAllocs(cfg *config.Config, patterns *Patterns, metricList []metrics.Metric, input string, fields map[string]string) {
+	var lines []string
+	var err error
+	read := measureAllocs(func() {
+		lines, err = readLines(input, cfg.Input.Framing)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
+	}
+	n := len(lines)
+	fmt.Printf("Allocations while processing %v lines:\n", n)
+	fmt.Printf("  read: %v\n", read.perLine(n))
+	if cfg.Input.Multiline != nil {
+		joiner, err := newMultiline(cfg.Input.Multiline, patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		join := measureAllocs(func() {
+			lines = joiner.joinAll(lines)
+		})
+		fmt.Printf("  multiline: %v\n", join.perLine(n))
+	}
+	for _, metric := range metricList {
+		matches := make([]bool, len(lines))
+		match := measureAllocs(func() {
+			for i, line := range lines {
+				matches[i] = metric.Matches(line)
+			}
+		})
+		process := measureAllocs(func() {
+			for i, line := range lines {
+				if matches[i] {
+					metric.Process(line, fields)
+				}
+			}
+		})
+		fmt.Printf("  %v: match %v, process %v\n", metric.Name(), match.perLine(n), process.perLine(n))
+	}
+}
+
+// readLines reads all lines of the file, or all frames if framing is configured, see readFrame().
+func readLines(path string, framing string) ([]string, error) {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %v: %v", path, err.Error())
+		}
+		defer file.Close()
+		reader = file
+	}
+	result := make([]string, 0)
+	if framing != "" {
+		buffered := bufio.NewReader(reader)
+		for {
+			frame, err := readFrame(buffered, framing)
+			switch {
+			case err == io.EOF:
+				return result, nil
+			case err != nil:
+				return nil, fmt.Errorf("Failed to read %v: %v", path, err.Error())
+			}
+			result = append(result, frame)
+		}
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		result = append(result, scanner.Text())
+	}
+	if scanner.Err() != nil {
+		return nil, fmt.Errorf("Failed to read %v: %v", path, scanner.Err().Error())
+	}
+	return result, nil
+}
+
+// withCompanions returns the metrics, each followed by its companions, see metrics.Companions().
+func withCompanions(metricList []metrics.Metric) []metrics.Metric {
+	result := make([]metrics.Metric, 0, len(metricList))
+	for _, m := range metricList {
+		result = append(result, m)
+		result = append(result, metrics.Companions(m)...)
+	}
+	return result
+}
+
+// printMetrics writes the metrics in Prometheus text format.
+// The metrics must be registered. Other registered metrics, like the go_* metrics, are skipped.
+func printMetrics(w io.Writer, metrics []metrics.Metric) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	prometheus.UninstrumentedHandler().ServeHTTP(recorder, req)
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		for _, metric := range metrics {
+			if isLineOfMetric(line, metric.Name()) {
+				fmt.Fprintln(w, line)
+				break
+			}
+		}
+	}
+}
+
+// isLineOfMetric is true for the HELP and TYPE lines of the metric, and for the samples of the metric,
+// including the _bucket, _sum, and _count samples of histograms and summaries. Other metrics whose name starts with
+// the metric's name, like foo_total for foo, are not included.
+func isLineOfMetric(line, name string) bool {
+	for _, prefix := range []string{"# HELP ", "# TYPE "} {
+		if strings.HasPrefix(line, prefix+name+" ") {
+			return true
+		}
+	}
+	for _, suffix := range []string{"", "_bucket", "_sum", "_count"} {
+		if strings.HasPrefix(line, name+suffix) {
+			rest := line[len(name+suffix):]
+			if strings.HasPrefix(rest, " ") || strings.HasPrefix(rest, "{") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsLineOfMetric(t *testing.T) {
+	for line, expected := range map[string]bool{
+		"# HELP foo Help text.":             true,
+		"# TYPE foo histogram":              true,
+		"foo 3":                             true,
+		"foo{level=\"error\"} 3":            true,
+		"foo_bucket{le=\"0.5\"} 1":          true,
+		"foo_sum 1.5":                       true,
+		"foo_count 3":                       true,
+		"# HELP foo_total Other metric.":    false,
+		"foo_total 3":                       false,
+		"foo_bar_count 3":                   false,
+		"foobar 3":                          false,
+		"# TYPE foo_seconds summary":        false,
+		"foo_seconds{quantile=\"0.5\"} 0.2": false,
+	} {
+		if isLineOfMetric(line, "foo") != expected {
+			t.Errorf("%q: Expected %v.", line, expected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Builder constructs a configuration in Go code, for embedders and tests that don't want to generate YAML.
+// Build() applies the same defaults and validation as LoadConfigString(). Relative paths are resolved against 'global.base_dir', if set.
+//
+// A Builder may be used from multiple goroutines. Each Build() applies the options to a new Config, and the options copy
+// the values passed to them including the nested sections, so the configurations are independent of each other.
+// The values passed to the options must not be modified afterwards.
+type Builder struct {
+	mutex   sync.Mutex
+	options []Option
+}
+
+// Option modifies the configuration. Custom options can set any field, like
+//
+//	func(cfg *config.Config) { cfg.Server.Protocol = "https" }
+//
+// The sections Global, Input, Grok, Metrics, and Server are never nil when an option is applied.
+type Option func(cfg *Config)
+
+func NewBuilder(options ...Option) *Builder {
+	return (&Builder{}).With(options...)
+}
+
+// With adds options. Options are applied in the order they were added, so later options override earlier ones.
+func (b *Builder) With(options ...Option) *Builder {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.options = append(b.options, options...)
+	return b
+}
+
+func (b *Builder) Build() (*Config, error) {
+	b.mutex.Lock()
+	options := append([]Option(nil), b.options...)
+	b.mutex.Unlock()
+	metrics := MetricsConfig(make([]*MetricConfig, 0))
+	cfg := &Config{
+		Global:  &GlobalConfig{},
+		Input:   &InputConfig{},
+		Grok:    &GrokConfig{},
+		Metrics: &metrics,
+		Server:  &ServerConfig{},
+	}
+	for _, option := range options {
+		option(cfg)
+	}
+	cfg.setDefaults()
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	cfg.resolvePaths("")
+	return cfg, nil
+}
+
+// WithBaseDir sets 'global.base_dir'.
+func WithBaseDir(dir string) Option {
+	return func(cfg *Config) {
+		cfg.Global.BaseDir = dir
+	}
+}
+
+// WithFile sets the input to the log file at path.
+func WithFile(path string, readall bool) Option {
+	return func(cfg *Config) {
+		cfg.Input.Type, cfg.Input.Path, cfg.Input.Readall = "file", path, readall
+	}
+}
+
+// WithStdin sets the input to stdin.
+func WithStdin() Option {
+	return func(cfg *Config) {
+		cfg.Input.Type, cfg.Input.Path, cfg.Input.Readall = "stdin", "", false
+	}
+}
+
+// WithInput replaces the input section. The InputConfig is copied, including the nested sections.
+func WithInput(input *InputConfig) Option {
+	return func(cfg *Config) {
+		cfg.Input = deepCopy(input).(*InputConfig)
+	}
+}
+
+// WithPatternsDir sets 'grok.patterns_dir'.
+func WithPatternsDir(dir string) Option {
+	return func(cfg *Config) {
+		cfg.Grok.PatternsDir = dir
+	}
+}
+
+// WithPatterns adds patterns like "NUMBER \\d+" to 'grok.patterns'.
+func WithPatterns(patterns ...string) Option {
+	return func(cfg *Config) {
+		cfg.Grok.Patterns = append(cfg.Grok.Patterns, patterns...)
+	}
+}
+
+// WithMetric adds a metric. The MetricConfig is copied, including the nested sections.
+// As in YAML, Labels must be an empty list for a counter without labels, nil means the preset's default labels.
+func WithMetric(metric *MetricConfig) Option {
+	return func(cfg *Config) {
+		*cfg.Metrics = append(*cfg.Metrics, deepCopy(metric).(*MetricConfig))
+	}
+}
+
+// WithServer replaces the server section. The ServerConfig is copied, including the nested sections.
+func WithServer(server *ServerConfig) Option {
+	return func(cfg *Config) {
+		cfg.Server = deepCopy(server).(*ServerConfig)
+	}
+}
+
+// WithPort sets 'server.port'.
+func WithPort(port int) Option {
+	return func(cfg *Config) {
+		cfg.Server.Port = port
+	}
+}
+
+// deepCopy copies the pointers, slices, and maps of a config section, because Build() sets defaults in the nested sections,
+// which must not be shared between concurrent Build() calls.
+func deepCopy(value interface{}) interface{} {
+	return deepCopyValue(reflect.ValueOf(value)).Interface()
+}
+
+func deepCopyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.New(v.Type().Elem())
+		result.Elem().Set(deepCopyValue(v.Elem()))
+		return result
+	case reflect.Struct:
+		result := reflect.New(v.Type()).Elem()
+		result.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if result.Field(i).CanSet() {
+				result.Field(i).Set(deepCopyValue(v.Field(i)))
+			}
+		}
+		return result
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result.Index(i).Set(deepCopyValue(v.Index(i)))
+		}
+		return result
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			result.SetMapIndex(key, deepCopyValue(v.MapIndex(key)))
+		}
+		return result
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.New(v.Type()).Elem()
+		result.Set(deepCopyValue(v.Elem()))
+		return result
+	default:
+		return v
+	}
+}
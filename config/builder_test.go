@@ -0,0 +1,101 @@
+package config
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	builder := NewBuilder(
+		WithBaseDir("/etc/grok_exporter"),
+		WithFile("x/x/x", true),
+		WithPatternsDir("b/c"),
+		WithMetric(&MetricConfig{
+			Type:  "counter",
+			Name:  "test_count_total",
+			Help:  "Dummy help message.",
+			Match: "Some text here, then a %{DATE}.",
+			Labels: []Label{
+				{GrokFieldName: "a", PrometheusLabel: "b"},
+				{GrokFieldName: "c", PrometheusLabel: "d"},
+			},
+		}),
+		WithServer(&ServerConfig{Protocol: "https"}),
+		WithPort(1111),
+	)
+	cfg, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := LoadConfigString([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected.resolvePaths("")
+	if cfg.String() != expected.String() {
+		t.Errorf("Expected:\n%v\nActual:\n%v\n", expected, cfg)
+	}
+	// Each Build() returns an independent config, also when called concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			other, err := builder.Build()
+			if err != nil || other == cfg || (*other.Metrics)[0] == (*cfg.Metrics)[0] {
+				t.Errorf("Expected an independent config, but got %v.", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Build() sets defaults in the nested sections, like 'rate_limit.per', which must not be shared. Run with -race.
+func TestBuilderConcurrentNestedSections(t *testing.T) {
+	metric := &MetricConfig{
+		Type:      "counter",
+		Name:      "nested_total",
+		Help:      "Dummy help message.",
+		Match:     "%{NUMBER}",
+		Labels:    []Label{},
+		RateLimit: &RateLimitConfig{Max: 10},
+		Exemplar:  &ExemplarConfig{Fields: []string{"trace_id"}},
+		Context:   &ContextConfig{Match: "%{NUMBER}"},
+	}
+	builder := NewBuilder(WithStdin(), WithPatterns("NUMBER \\d+"), WithMetric(metric))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg, err := builder.Build()
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err.Error())
+				return
+			}
+			if (*cfg.Metrics)[0].RateLimit == metric.RateLimit || (*cfg.Metrics)[0].Context == metric.Context {
+				t.Error("Expected the nested sections to be copied.")
+			}
+		}()
+	}
+	wg.Wait()
+	if metric.RateLimit.Per != 0 || metric.Exemplar.SampleRate != 0 || metric.Context.Lines != 0 {
+		t.Errorf("Expected the defaults not to be written to the options' values, but got %+v.", metric)
+	}
+}
+
+func TestBuilderValidation(t *testing.T) {
+	_, err := NewBuilder(WithStdin(), WithPatterns("NUMBER \\d+"), WithMetric(&MetricConfig{
+		Type:  "counter",
+		Name:  "no_labels_total",
+		Help:  "Labels are required, like in YAML.",
+		Match: "%{NUMBER}",
+	})).Build()
+	if err == nil {
+		t.Error("Expected an error for a metric without labels.")
+	}
+	_, err = NewBuilder(WithStdin(), WithPatterns("NUMBER \\d+")).Build()
+	if err == nil {
+		t.Error("Expected an error for a config without metrics.")
+	}
+}
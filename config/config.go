@@ -2,8 +2,20 @@ package config
 
 import (
 	"fmt"
+	"github.com/fstab/grok_exporter/cron"
+	"github.com/fstab/grok_exporter/datepath"
+	"github.com/fstab/grok_exporter/locale"
+	"github.com/fstab/grok_exporter/mutate"
+	"github.com/fstab/grok_exporter/xpath"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Example config: See ./example/config.yml
@@ -17,6 +29,7 @@ func LoadConfigFile(filename string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load %v: %v", filename, err.Error())
 	}
+	cfg.resolvePaths(filepath.Dir(filename))
 	return cfg, nil
 }
 
@@ -42,15 +55,184 @@ func (cfg *Config) String() string {
 	return string(out)
 }
 
+// RestartRequired returns the sections that differ from the other config, and cannot be applied by reloading the config.
+// Only 'metrics', 'mappings', and 'grok.patterns' can be reloaded.
+func (cfg *Config) RestartRequired(other *Config) []string {
+	sections := []struct {
+		name   string
+		values [2]interface{}
+	}{
+		{"global", [2]interface{}{cfg.Global, other.Global}},
+		{"input", [2]interface{}{cfg.Input, other.Input}},
+		{"grok.patterns_dir", [2]interface{}{cfg.Grok.PatternsDir, other.Grok.PatternsDir}},
+		{"grok.watch_patterns_dir", [2]interface{}{cfg.Grok.WatchPatternsDir, other.Grok.WatchPatternsDir}},
+		{"grok.regex_engine", [2]interface{}{cfg.Grok.RegexEngine, other.Grok.RegexEngine}},
+		{"server", [2]interface{}{cfg.Server, other.Server}},
+		{"tenants", [2]interface{}{cfg.Tenants, other.Tenants}},
+		{"tracing", [2]interface{}{cfg.Tracing, other.Tracing}},
+		{"sessions", [2]interface{}{cfg.Sessions, other.Sessions}},
+		{"flush", [2]interface{}{cfg.Flush, other.Flush}},
+	}
+	result := make([]string, 0)
+	for _, section := range sections {
+		a, _ := yaml.Marshal(section.values[0])
+		b, _ := yaml.Marshal(section.values[1])
+		if string(a) != string(b) {
+			result = append(result, section.name)
+		}
+	}
+	return result
+}
+
+type GlobalConfig struct {
+	BaseDir                string            `yaml:"base_dir,omitempty"`
+	RetentionCheckInterval time.Duration     `yaml:"retention_check_interval,omitempty"` // how often series exceeding 'metrics.retention' are removed, 0 means once per minute
+	ResourceAttributes     map[string]string `yaml:"resource_attributes,omitempty"`      // like service.name, exposed as target_info and as OTLP resource
+	OnPatternError         string            `yaml:"on_pattern_error,omitempty"`         // "fail" or "skip" the metrics whose expressions are invalid, empty means "fail"
+	OnTestFailure          string            `yaml:"on_test_failure,omitempty"`          // "fail" or "warn" if a metric's 'test_lines' fail, empty means "fail"
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// LabelName converts a resource attribute name like 'service.name' to a Prometheus label name like 'service_name'.
+func LabelName(attribute string) string {
+	result := invalidLabelChars.ReplaceAllString(attribute, "_")
+	if result != "" && result[0] >= '0' && result[0] <= '9' {
+		result = "_" + result
+	}
+	return result
+}
+
 type InputConfig struct {
-	Type    string `yaml:",omitempty"`
-	Path    string `yaml:",omitempty"`
-	Readall bool   `yaml:",omitempty"`
+	Type                 string            `yaml:",omitempty"`
+	Path                 string            `yaml:",omitempty"`
+	Paths                []string          `yaml:",omitempty"` // more paths or glob patterns, file only
+	Readall              bool              `yaml:",omitempty"`
+	Mode                 string            `yaml:",omitempty"` // "tail" or "pull", file only. Empty means "tail".
+	Timestamp            *TimestampConfig  `yaml:",omitempty"`
+	MaxBytesPerSecond    int               `yaml:"max_bytes_per_second,omitempty"`
+	Multiline            *MultilineConfig  `yaml:",omitempty"`
+	Positions            *PositionsConfig  `yaml:",omitempty"`
+	Framing              string            `yaml:",omitempty"`                        // "varint", "len32", or empty for newline-separated lines
+	Backfill             int               `yaml:",omitempty"`                        // number of rotated files processed on start, like path.2 and path.1
+	PathMatch            string            `yaml:"path_match,omitempty"`              // regular expression whose named groups in the path are fields for all metrics
+	IgnoreLinesOlderThan time.Duration     `yaml:"ignore_lines_older_than,omitempty"` // lines with an older 'timestamp' are not processed
+	Labels               map[string]string `yaml:",omitempty"`                        // label name -> value, attached to all metrics
+	FailOnMissingLogfile bool              `yaml:"fail_on_missing_logfile,omitempty"` // exit on startup instead of waiting for the file
+	RescanInterval       time.Duration     `yaml:"rescan_interval,omitempty"`         // how often glob patterns are expanded again, 0 means every 10 seconds
+	Helper               string            `yaml:",omitempty"`                        // Unix socket of 'grok_exporter helper', which opens files the exporter has no permission for
+	WarmupLines          int               `yaml:"warmup_lines,omitempty"`            // number of lines at the end of the file that set the gauges on start
+}
+
+// PathFields returns the names of the groups in 'path_match', which are available as fields in all metrics.
+func (c *InputConfig) PathFields() []string {
+	result := make([]string, 0)
+	if c.PathMatch == "" {
+		return result
+	}
+	regex, err := regexp.Compile(c.PathMatch)
+	if err != nil {
+		return result
+	}
+	for _, name := range regex.SubexpNames() {
+		if name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// AllPaths returns 'input.path' and 'input.paths'. Each may be a glob pattern like /var/log/app/*.log.
+func (c *InputConfig) AllPaths() []string {
+	result := make([]string, 0, len(c.Paths)+1)
+	if c.Path != "" {
+		result = append(result, c.Path)
+	}
+	return append(result, c.Paths...)
+}
+
+// MultipleFiles is true if more than one file may be tailed, because 'input.paths' or a glob pattern is configured.
+func (c *InputConfig) MultipleFiles() bool {
+	paths := c.AllPaths()
+	return len(paths) > 1 || (len(paths) == 1 && IsGlob(paths[0]))
+}
+
+// IsGlob is true if the path contains the glob characters '*', '?', or '['.
+func IsGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// Positions is optional. If configured, the read position in the log file is stored periodically,
+// so that the exporter resumes where it stopped after a restart, even if the container was rescheduled to another node.
+type PositionsConfig struct {
+	Type         string        `yaml:",omitempty"`              // "file", "redis", or "configmap"
+	Path         string        `yaml:",omitempty"`              // file only
+	Address      string        `yaml:",omitempty"`              // redis only, host:port
+	Password     string        `yaml:",omitempty"`              // redis only
+	PasswordFile string        `yaml:"password_file,omitempty"` // redis only
+	PasswordEnv  string        `yaml:"password_env,omitempty"`  // redis only
+	Database     int           `yaml:",omitempty"`              // redis only
+	KeyPrefix    string        `yaml:"key_prefix,omitempty"`    // redis only
+	Namespace    string        `yaml:",omitempty"`              // configmap only, defaults to the namespace of the pod
+	Name         string        `yaml:",omitempty"`              // configmap only
+	Interval     time.Duration `yaml:",omitempty"`              // how often the position is stored
+}
+
+// Multiline is optional. It joins lines into records, like a Java exception with its stack trace.
+// Either lines matching 'start' begin a new record, or lines matching 'continuation' are appended to the previous one.
+type MultilineConfig struct {
+	Preset       string        `yaml:",omitempty"`
+	Start        string        `yaml:",omitempty"`
+	Continuation string        `yaml:",omitempty"`
+	Timeout      time.Duration `yaml:",omitempty"` // a pending record is processed if no line is appended within this time
+	MaxLines     int           `yaml:"max_lines,omitempty"`
+}
+
+// Timestamp is optional. It defines how the original timestamp is parsed from a log line.
+type TimestampConfig struct {
+	Match    string            `yaml:",omitempty"`
+	Field    string            `yaml:",omitempty"`
+	Layout   string            `yaml:",omitempty"`
+	Timezone string            `yaml:",omitempty"` // location for timestamps without zone, like Europe/Berlin, default UTC
+	Zones    map[string]string `yaml:",omitempty"` // zone abbreviations like CEST, mapped to a location or an offset like +02:00
+	Locale   string            `yaml:",omitempty"` // language of the month names, like de
+}
+
+// Location returns the location for timestamps without zone information.
+func (c *TimestampConfig) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid 'input.timestamp.timezone': %v", err.Error())
+	}
+	return location, nil
+}
+
+// ZoneLocations returns the locations of the zone abbreviations in 'zones'.
+func (c *TimestampConfig) ZoneLocations() (map[string]*time.Location, error) {
+	result := make(map[string]*time.Location, len(c.Zones))
+	for abbreviation, zone := range c.Zones {
+		if offset, err := time.Parse("-07:00", zone); err == nil {
+			_, seconds := offset.Zone()
+			result[abbreviation] = time.FixedZone(abbreviation, seconds)
+			continue
+		}
+		location, err := time.LoadLocation(zone)
+		if err != nil || zone == "" {
+			return nil, fmt.Errorf("Invalid 'input.timestamp.zones': %v: '%v' is neither an offset like +02:00 nor a location like Europe/Berlin.", abbreviation, zone)
+		}
+		result[abbreviation] = location
+	}
+	return result, nil
 }
 
 type GrokConfig struct {
-	PatternsDir string   `yaml:"patterns_dir,omitempty"`
-	Patterns    []string `yaml:",omitempty"`
+	PatternsDir      string   `yaml:"patterns_dir,omitempty"`
+	Patterns         []string `yaml:",omitempty"`
+	WatchPatternsDir bool     `yaml:"watch_patterns_dir,omitempty"`
+	RegexEngine      string   `yaml:"regex_engine,omitempty"` // 'oniguruma' or 're2', empty means the default of the build
 }
 
 type Label struct {
@@ -59,30 +241,355 @@ type Label struct {
 }
 
 type MetricConfig struct {
-	Type   string  `yaml:",omitempty"`
-	Name   string  `yaml:",omitempty"`
-	Help   string  `yaml:",omitempty"`
-	Match  string  `yaml:",omitempty"`
-	Labels []Label `yaml:",omitempty"`
+	Type              string            `yaml:",omitempty"`
+	Name              string            `yaml:",omitempty"`
+	Help              string            `yaml:",omitempty"`
+	Preset            string            `yaml:",omitempty"`
+	Match             string            `yaml:",omitempty"`
+	Repeat            string            `yaml:",omitempty"`
+	Labels            []Label           `yaml:",omitempty"`
+	Value             string            `yaml:",omitempty"`
+	SumField          string            `yaml:"sum_field,omitempty"` // if set, the field's values are summed in a companion counter
+	SumName           string            `yaml:"sum_name,omitempty"`  // name of the companion counter
+	FromTotal         bool              `yaml:"from_total,omitempty"`
+	Split             string            `yaml:",omitempty"`
+	ResetSchedule     string            `yaml:"reset_schedule,omitempty"`
+	PerScrape         bool              `yaml:"per_scrape,omitempty"` // expose the matches since the previous scrape as gauge
+	Tenant            string            `yaml:",omitempty"`
+	Route             string            `yaml:",omitempty"` // if set, only the lines routed to this name are processed, see 'routing'
+	Eviction          string            `yaml:",omitempty"` // "lru", requires max_series
+	MaxSeries         int               `yaml:"max_series,omitempty"`
+	Retention         time.Duration     `yaml:",omitempty"` // series not updated for this long are removed
+	Notify            *NotifyConfig     `yaml:",omitempty"`
+	RateLimit         *RateLimitConfig  `yaml:"rate_limit,omitempty"`
+	Exemplar          *ExemplarConfig   `yaml:",omitempty"`
+	TestLines         []TestLineConfig  `yaml:"test_lines,omitempty"`          // sample lines checked on startup and in 'grok_exporter check'
+	MaxLabelLength    int               `yaml:"max_label_length,omitempty"`    // in bytes
+	LabelLengthPolicy string            `yaml:"label_length_policy,omitempty"` // "truncate" or "drop"
+	Fields            *FieldsConfig     `yaml:",omitempty"`
+	Pipeline          []StageConfig     `yaml:",omitempty"` // applied in order to the fields of each match
+	Kv                *KvConfig         `yaml:",omitempty"`
+	Format            string            `yaml:",omitempty"` // "xml", "auto", or empty for plain text
+	Formats           map[string]string `yaml:",omitempty"` // glob pattern of the log file -> "json", "logfmt", or "plain", for format auto
+	Xml               map[string]string `yaml:",omitempty"` // field name -> XPath, for format xml
+	Source            string            `yaml:",omitempty"` // derived metrics only
+	Function          string            `yaml:",omitempty"` // derived metrics only
+	Window            time.Duration     `yaml:",omitempty"` // derived metrics only
+	Per               time.Duration     `yaml:",omitempty"` // derived metrics only
+	Context           *ContextConfig    `yaml:",omitempty"`
+	Quantiles         []float64         `yaml:",omitempty"` // quantile and summary metrics only
+	Buckets           []float64         `yaml:",omitempty"` // histogram metrics only
+	Compression       float64           `yaml:",omitempty"` // quantile metrics only, t-digest compression
 }
 
 type MetricsConfig []*MetricConfig
 
+// Equals is true if both metric configs are the same.
+func (c *MetricConfig) Equals(other *MetricConfig) bool {
+	a, _ := yaml.Marshal(c)
+	b, _ := yaml.Marshal(other)
+	return string(a) == string(b)
+}
+
+// SumHelp is the help text of the 'sum_field' companion counter.
+func (c *MetricConfig) SumHelp() string {
+	return fmt.Sprintf("%v (sum of %v)", strings.TrimSuffix(c.Help, "."), c.SumField)
+}
+
+// Context is optional. If configured, a line only matches if one of the previous lines matched the context expression,
+// like a service banner. The captures of the context line are available as fields.
+type ContextConfig struct {
+	Match string `yaml:",omitempty"`
+	Lines int    `yaml:",omitempty"` // number of previous lines searched for the context, default 1
+}
+
+// Notify is optional. If configured, each match is posted as JSON to the URL.
+type NotifyConfig struct {
+	Url          string        `yaml:",omitempty"`
+	MaxPerMinute int           `yaml:"max_per_minute,omitempty"` // further matches are not notified
+	Timeout      time.Duration `yaml:",omitempty"`
+}
+
+// RateLimit is optional. It caps the matches counted per label set and time window, so that a single misbehaving client
+// cannot dominate the metric. The excess is counted in grok_exporter_rate_limited_total.
+type RateLimitConfig struct {
+	Max int           `yaml:",omitempty"` // matches per window
+	Per time.Duration `yaml:",omitempty"` // window
+}
+
+// Exemplar is optional. It keeps the values of the fields, like a trace ID, for a sample of the observations of each label set,
+// so that a spike can be traced back to a request.
+type ExemplarConfig struct {
+	Fields     []string `yaml:",omitempty"`
+	SampleRate float64  `yaml:"sample_rate,omitempty"` // fraction of the observations that replace the exemplar, default 1
+}
+
+// Fields is optional. It renames and drops grok fields before they are used in labels and values,
+// so that the label config does not depend on the field names in a shared pattern library.
+// Mutate normalizes field values, like 'uppercase', so that this does not need to be encoded in the regex.
+type FieldsConfig struct {
+	Rename map[string]string   `yaml:",omitempty"` // grok field name -> new name
+	Drop   []string            `yaml:",omitempty"`
+	Mutate map[string][]string `yaml:",omitempty"` // field name (after rename) -> functions applied in order
+}
+
+// TestLine is a sample line with the expected result of processing it with a fresh instance of the metric.
+type TestLineConfig struct {
+	Line    string            `yaml:",omitempty"`
+	Labels  map[string]string `yaml:",omitempty"` // Prometheus label -> expected value, labels that are not listed are not checked
+	Value   *float64          `yaml:",omitempty"` // expected value of the series, not checked if missing
+	Matches *bool             `yaml:",omitempty"` // false means the line must not be recorded, default true
+}
+
+// ExpectsMatch is false if the line must not be recorded.
+func (c TestLineConfig) ExpectsMatch() bool {
+	return c.Matches == nil || *c.Matches
+}
+
+// Pipeline is optional. Each stage is one of 'filter', 'set', or 'mutate'. The stages are applied in order to the fields of each match,
+// after the fields are parsed (grok captures, 'kv', 'xml', and the input's fields), and before the labels and the value are recorded.
+// Field names are the names after 'fields.rename'.
+type StageConfig struct {
+	Filter *FilterStageConfig  `yaml:",omitempty"` // the match is only recorded if the field matches
+	Set    map[string]string   `yaml:",omitempty"` // field name -> value, adds or replaces fields
+	Mutate map[string][]string `yaml:",omitempty"` // field name -> functions applied in order, like 'fields.mutate'
+}
+
+// FilterStageConfig has either 'match' or 'not_match', which are Go regular expressions, not grok expressions.
+type FilterStageConfig struct {
+	Field    string `yaml:",omitempty"`
+	Match    string `yaml:",omitempty"`
+	NotMatch string `yaml:"not_match,omitempty"`
+}
+
+// Kv is optional. If configured, all key=value tokens in a matching line are available as fields, in addition to the grok captures.
+type KvConfig struct {
+	PairSeparator  string   `yaml:"pair_separator,omitempty"`
+	ValueSeparator string   `yaml:"value_separator,omitempty"`
+	Include        []string `yaml:",omitempty"` // if not empty, only these keys are extracted
+	Exclude        []string `yaml:",omitempty"`
+}
+
+// Allows returns true if the key is extracted according to 'include' and 'exclude'. A nil KvConfig does not allow any key.
+func (c *KvConfig) Allows(key string) bool {
+	if c == nil {
+		return false
+	}
+	for _, excluded := range c.Exclude {
+		if excluded == key {
+			return false
+		}
+	}
+	if len(c.Include) == 0 {
+		return true
+	}
+	for _, included := range c.Include {
+		if included == key {
+			return true
+		}
+	}
+	return false
+}
+
+// CaptureName returns the name of the grok capture providing a field.
+// For a renamed field, this is the original name. Returns false if the field was dropped or renamed to something else.
+// A nil FieldsConfig does not transform anything.
+func (c *FieldsConfig) CaptureName(field string) (string, bool) {
+	if c == nil {
+		return field, true
+	}
+	for from, to := range c.Rename {
+		if to == field {
+			return from, true
+		}
+	}
+	if _, renamed := c.Rename[field]; renamed {
+		return "", false
+	}
+	for _, dropped := range c.Drop {
+		if dropped == field {
+			return "", false
+		}
+	}
+	return field, true
+}
+
+// IsDropped returns true if the grok capture is in 'drop'.
+func (c *FieldsConfig) IsDropped(capture string) bool {
+	if c == nil {
+		return false
+	}
+	for _, dropped := range c.Drop {
+		if dropped == capture {
+			return true
+		}
+	}
+	return false
+}
+
 type ServerConfig struct {
-	Protocol string `yaml:",omitempty"`
-	Port     int    `yaml:",omitempty"`
-	Cert     string `yaml:",omitempty"`
-	Key      string `yaml:",omitempty"`
+	Protocol          string        `yaml:",omitempty"`
+	Port              int           `yaml:",omitempty"`
+	Cert              string        `yaml:",omitempty"`
+	Key               string        `yaml:",omitempty"`
+	KeyPassphrase     string        `yaml:"key_passphrase,omitempty"`
+	KeyPassphraseFile string        `yaml:"key_passphrase_file,omitempty"`
+	KeyPassphraseEnv  string        `yaml:"key_passphrase_env,omitempty"`
+	AllowedCidrs      []string      `yaml:"allowed_cidrs,omitempty"`
+	ReadTimeout       time.Duration `yaml:"read_timeout,omitempty"`
+	WriteTimeout      time.Duration `yaml:"write_timeout,omitempty"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout,omitempty"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes,omitempty"`
+	DisableHttp2      bool          `yaml:"disable_http2,omitempty"`
+	Acme              *AcmeConfig   `yaml:",omitempty"`
+	AccessLog         bool          `yaml:"access_log,omitempty"`    // log each request to stdout
+	EnableReload      bool          `yaml:"enable_reload,omitempty"` // serve POST /-/reload
+}
+
+// Acme is optional. If configured, the certificate for 'https' is obtained and renewed automatically from an ACME CA like Let's Encrypt.
+type AcmeConfig struct {
+	Domains       []string `yaml:",omitempty"`
+	CacheDir      string   `yaml:"cache_dir,omitempty"`
+	DirectoryUrl  string   `yaml:"directory_url,omitempty"`
+	Email         string   `yaml:",omitempty"`
+	ChallengePort int      `yaml:"challenge_port,omitempty"`
+}
+
+// ReadKeyPassphrase returns the passphrase for 'server.key', which may be configured inline, in a file, or in an environment variable.
+func (c *ServerConfig) ReadKeyPassphrase() (string, error) {
+	return readSecret("server.key_passphrase", c.KeyPassphrase, c.KeyPassphraseFile, c.KeyPassphraseEnv)
+}
+
+// AllowedNetworks returns the parsed 'server.allowed_cidrs'. Empty means all clients are allowed.
+func (c *ServerConfig) AllowedNetworks() []*net.IPNet {
+	result := make([]*net.IPNet, 0, len(c.AllowedCidrs))
+	for _, cidr := range c.AllowedCidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil { // errors are reported in validate()
+			result = append(result, network)
+		}
+	}
+	return result
+}
+
+// ReadPassword returns the Redis password, which may be configured inline, in a file, or in an environment variable.
+func (c *PositionsConfig) ReadPassword() (string, error) {
+	return readSecret("input.positions.password", c.Password, c.PasswordFile, c.PasswordEnv)
+}
+
+// Tenants are optional. Metrics assigned to a tenant are exposed at '/metrics/<name>' instead of '/metrics'.
+// If username is configured, the tenant's endpoint requires HTTP basic authentication.
+type TenantConfig struct {
+	Name         string `yaml:",omitempty"`
+	Username     string `yaml:",omitempty"`
+	Password     string `yaml:",omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+	PasswordEnv  string `yaml:"password_env,omitempty"`
+}
+
+type TenantsConfig []*TenantConfig
+
+// ReadPassword returns the basic auth password, which may be configured inline, in a file, or in an environment variable.
+func (c *TenantConfig) ReadPassword() (string, error) {
+	return readSecret("tenants.password", c.Password, c.PasswordFile, c.PasswordEnv)
+}
+
+// Tracing is optional. If the tracing section is missing, cfg.Tracing is nil and tracing is disabled.
+type TracingConfig struct {
+	Endpoint    string  `yaml:",omitempty"`
+	SampleRatio float64 `yaml:"sample_ratio,omitempty"`
+	ServiceName string  `yaml:"service_name,omitempty"`
 }
 
 type Config struct {
-	Input   *InputConfig   `yaml:",omitempty"`
-	Grok    *GrokConfig    `yaml:",omitempty"`
-	Metrics *MetricsConfig `yaml:",omitempty"`
-	Server  *ServerConfig  `yaml:",omitempty"`
+	Global   *GlobalConfig   `yaml:",omitempty"`
+	Input    *InputConfig    `yaml:",omitempty"`
+	Grok     *GrokConfig     `yaml:",omitempty"`
+	Metrics  *MetricsConfig  `yaml:",omitempty"`
+	Server   *ServerConfig   `yaml:",omitempty"`
+	Tenants  *TenantsConfig  `yaml:",omitempty"`
+	Tracing  *TracingConfig  `yaml:",omitempty"`
+	Sessions *SessionsConfig `yaml:",omitempty"`
+	Mappings MappingsConfig  `yaml:",omitempty"`
+	Flush    *FlushConfig    `yaml:",omitempty"`
+	Routing  *RoutingConfig  `yaml:",omitempty"`
+}
+
+// Routing is optional. If configured, each line is processed only by the metrics whose 'route' is the value of the routing field,
+// and by the metrics without 'route', so that one input, like a socket shared by many services, can feed independent sets of metrics.
+type RoutingConfig struct {
+	Match string `yaml:",omitempty"` // grok expression capturing the field, empty if the field is a field of the input
+	Field string `yaml:",omitempty"`
+}
+
+// Flush is optional. If configured, the final metric values are pushed to a Pushgateway and/or written to a textfile
+// when the exporter shuts down, so that the lines processed since the last scrape are not lost when a batch job ends.
+// With 'interval', the metrics are also pushed and written periodically.
+type FlushConfig struct {
+	Pushgateway string `yaml:",omitempty"` // URL like http://pushgateway:9091
+	Job         string `yaml:",omitempty"` // Pushgateway only, default grok_exporter
+	Instance    string `yaml:",omitempty"` // Pushgateway only, optional
+	Textfile    string `yaml:",omitempty"` // like /var/lib/node_exporter/textfile/grok.prom for the node exporter's textfile collector
+
+	Interval time.Duration `yaml:",omitempty"` // optional, the metrics are also flushed periodically while running, 0 means only on shutdown
+}
+
+// Mappings are optional. A mapping table maps field values to label values with the 'fields.mutate' function 'map(name)',
+// like hundreds of SQLSTATE codes onto a handful of categories. The table is either defined inline, or in a 'file' which is re-read when it changes.
+type MappingConfig struct {
+	Values  map[string]string `yaml:",omitempty"` // exact field value -> label value
+	Regex   []RegexMapping    `yaml:",omitempty"` // tried in order if no exact value matches
+	Default string            `yaml:",omitempty"` // label value for unmapped field values, empty means the field value is kept
+	File    string            `yaml:",omitempty"` // YAML file with 'values' and 'regex'
 }
 
+type RegexMapping struct {
+	Match string `yaml:",omitempty"`
+	Value string `yaml:",omitempty"`
+}
+
+type MappingsConfig map[string]*MappingConfig
+
+// LoadMappingFile reads the 'values' and 'regex' of a mapping table from a file.
+func LoadMappingFile(path string) (*MappingConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %v: %v", path, err.Error())
+	}
+	result := &MappingConfig{}
+	err = yaml.Unmarshal(content, result)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %v: %v", path, err.Error())
+	}
+	if result.File != "" || result.Default != "" {
+		return nil, fmt.Errorf("Failed to read %v: A mapping file can only contain 'values' and 'regex'.", path)
+	}
+	err = result.validateEntries()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %v: %v", path, err.Error())
+	}
+	return result, nil
+}
+
+// Sessions are optional. A session correlates the lines with the same 'key', like the login, activity, and logout of a user.
+type SessionConfig struct {
+	Name     string        `yaml:",omitempty"`
+	Help     string        `yaml:",omitempty"`
+	Key      string        `yaml:",omitempty"` // grok field identifying the session, must be captured by start, activity, and end
+	Start    string        `yaml:",omitempty"`
+	Activity string        `yaml:",omitempty"` // optional, extends the session
+	End      string        `yaml:",omitempty"`
+	Timeout  time.Duration `yaml:",omitempty"` // a session without activity for this long is removed
+	Buckets  []float64     `yaml:",omitempty"` // of the duration histogram, in seconds
+}
+
+type SessionsConfig []*SessionConfig
+
 func (cfg *Config) setDefaults() {
+	if cfg.Global == nil {
+		cfg.Global = &GlobalConfig{}
+	}
+	cfg.Global.setDefaults()
 	if cfg.Input == nil {
 		cfg.Input = &InputConfig{}
 	}
@@ -96,97 +603,770 @@ func (cfg *Config) setDefaults() {
 		cfg.Metrics = &metrics
 	}
 	cfg.Metrics.setDefaults()
+	cfg.Metrics.addInputLabels(cfg.Input.Labels)
+	cfg.Metrics.expandHelp(cfg.Input)
 	if cfg.Server == nil {
 		cfg.Server = &ServerConfig{}
 	}
-	cfg.Server.setDefaults()
+	cfg.Server.setDefaults()
+	if cfg.Tracing != nil {
+		cfg.Tracing.setDefaults()
+	}
+	if cfg.Flush != nil {
+		cfg.Flush.setDefaults()
+	}
+	if cfg.Sessions != nil {
+		for _, session := range *cfg.Sessions {
+			session.setDefaults()
+		}
+	}
+}
+
+func (c *SessionConfig) setDefaults() {
+	if c.Timeout == 0 {
+		c.Timeout = 30 * time.Minute
+	}
+}
+
+func (c *GlobalConfig) setDefaults() {}
+
+func (c *InputConfig) setDefaults() {
+	if c.Type == "" {
+		c.Type = "stdin"
+	}
+	if c.Timestamp != nil {
+		c.Timestamp.setDefaults()
+	}
+	if c.Multiline != nil {
+		c.Multiline.setDefaults()
+	}
+	if c.Positions != nil {
+		c.Positions.setDefaults()
+	}
+}
+
+func (c *PositionsConfig) setDefaults() {
+	if c.Type == "redis" && c.KeyPrefix == "" {
+		c.KeyPrefix = "grok_exporter:"
+	}
+	if c.Interval == 0 {
+		c.Interval = 10 * time.Second
+	}
+}
+
+func (c *MultilineConfig) setDefaults() {
+	if c.Preset != "" {
+		c.applyPreset()
+	}
+	if c.Timeout == 0 {
+		c.Timeout = time.Second
+	}
+	if c.MaxLines == 0 {
+		c.MaxLines = 500
+	}
+}
+
+func (c *TimestampConfig) setDefaults() {
+	if c.Field == "" {
+		c.Field = "timestamp"
+	}
+	if c.Layout == "" {
+		c.Layout = time.RFC3339
+	}
+}
+
+func (c *GrokConfig) setDefaults() {}
+
+// addInputLabels adds the 'input.labels' to the labels of all metrics. The values are input fields with the label's name.
+// Labels that are already defined are skipped, validateInputLabels() rejects a metric defining a different label with the same name.
+func (c *MetricsConfig) addInputLabels(labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, metric := range *c {
+		if metric.Type == "derived" {
+			continue // derived metrics take the labels from the source metric
+		}
+		for _, name := range names {
+			if !metric.hasLabel(name) {
+				metric.Labels = append(metric.Labels, Label{GrokFieldName: name, PrometheusLabel: name})
+			}
+		}
+	}
+}
+
+// Matches the placeholders in 'metrics.help', see expandHelp().
+var helpPlaceholderRegexp = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// Matches the name of the first pattern referenced in a Grok expression, like NUMBER in %{NUMBER:duration}.
+var firstPatternRegexp = regexp.MustCompile(`%{([^:}]+)`)
+
+// expandHelp replaces the placeholders ${name}, ${pattern}, and ${source} in the help texts.
+// Unknown placeholders are kept, so that validate() can report them. A missing help text is generated from the metric name.
+func (c *MetricsConfig) expandHelp(input *InputConfig) {
+	for _, metric := range *c {
+		if metric.Help == "" && metric.Name != "" {
+			words := strings.Replace(metric.Name, "_", " ", -1)
+			metric.Help = strings.ToUpper(words[:1]) + words[1:] + "."
+		}
+		values := map[string]string{
+			"name":    metric.Name,
+			"pattern": "",
+			"source":  metric.Source,
+		}
+		if match := firstPatternRegexp.FindStringSubmatch(metric.Match); match != nil {
+			values["pattern"] = match[1]
+		}
+		if metric.Type != "derived" {
+			values["source"] = input.Type
+			if input.Type == "file" {
+				values["source"] = strings.Join(input.AllPaths(), ", ")
+			}
+		}
+		metric.Help = helpPlaceholderRegexp.ReplaceAllStringFunc(metric.Help, func(placeholder string) string {
+			if value, known := values[placeholder[2:len(placeholder)-1]]; known {
+				return value
+			}
+			return placeholder
+		})
+	}
+}
+
+func (c *MetricConfig) hasLabel(prometheusLabel string) bool {
+	for _, label := range c.Labels {
+		if label.PrometheusLabel == prometheusLabel {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MetricsConfig) setDefaults() {
+	for _, metric := range *c {
+		if metric.Preset != "" {
+			metric.applyPreset()
+		}
+		if metric.Kv != nil {
+			metric.Kv.setDefaults()
+		}
+		if metric.Notify != nil {
+			metric.Notify.setDefaults()
+		}
+		if metric.SumField != "" && metric.SumName == "" {
+			metric.SumName = strings.TrimSuffix(metric.Name, "_total") + "_" + metric.SumField + "_total"
+		}
+		if metric.RateLimit != nil && metric.RateLimit.Per == 0 {
+			metric.RateLimit.Per = time.Minute
+		}
+		if metric.Exemplar != nil && metric.Exemplar.SampleRate == 0 {
+			metric.Exemplar.SampleRate = 1
+		}
+		if metric.MaxSeries > 0 && metric.Eviction == "" {
+			metric.Eviction = "lru"
+		}
+		if metric.MaxLabelLength > 0 && metric.LabelLengthPolicy == "" {
+			metric.LabelLengthPolicy = "truncate"
+		}
+		if metric.Type == "derived" && metric.Function == "rate" && metric.Per == 0 {
+			metric.Per = time.Second
+		}
+		if metric.Context != nil && metric.Context.Lines == 0 {
+			metric.Context.Lines = 1
+		}
+		if (metric.Type == "quantile" || metric.Type == "summary") && len(metric.Quantiles) == 0 {
+			metric.Quantiles = []float64{0.5, 0.9, 0.99}
+		}
+		if metric.Type == "quantile" && metric.Compression == 0 {
+			metric.Compression = 100
+		}
+		if metric.Type == "histogram" && len(metric.Buckets) == 0 {
+			metric.Buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+		}
+	}
+}
+
+func (c *NotifyConfig) setDefaults() {
+	if c.MaxPerMinute == 0 {
+		c.MaxPerMinute = 10
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+}
+
+func (c *KvConfig) setDefaults() {
+	if c.PairSeparator == "" {
+		c.PairSeparator = " "
+	}
+	if c.ValueSeparator == "" {
+		c.ValueSeparator = "="
+	}
+}
+
+func (c *ServerConfig) setDefaults() {
+	if c.Protocol == "" {
+		c.Protocol = "http"
+	}
+	if c.Port == 0 {
+		c.Port = 9144
+	}
+	if c.Acme != nil {
+		c.Acme.setDefaults()
+	}
+}
+
+func (c *AcmeConfig) setDefaults() {
+	if c.DirectoryUrl == "" {
+		c.DirectoryUrl = "https://acme-v02.api.letsencrypt.org/directory"
+	}
+	if c.ChallengePort == 0 {
+		c.ChallengePort = 80
+	}
+}
+
+// Relative paths in the config file are resolved relative to the directory containing the config file,
+// so that the exporter does not depend on the working directory of the process.
+// This can be overridden with 'global.base_dir'.
+func (cfg *Config) resolvePaths(configDir string) {
+	baseDir := configDir
+	if cfg.Global.BaseDir != "" {
+		baseDir = cfg.Global.BaseDir
+	}
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(baseDir, *path)
+		}
+	}
+	resolve(&cfg.Input.Path)
+	for i := range cfg.Input.Paths {
+		resolve(&cfg.Input.Paths[i])
+	}
+	resolve(&cfg.Input.Helper)
+	if cfg.Input.Positions != nil {
+		resolve(&cfg.Input.Positions.Path)
+		resolve(&cfg.Input.Positions.PasswordFile)
+	}
+	resolve(&cfg.Grok.PatternsDir)
+	resolve(&cfg.Server.Cert)
+	resolve(&cfg.Server.Key)
+	resolve(&cfg.Server.KeyPassphraseFile)
+	if cfg.Server.Acme != nil {
+		resolve(&cfg.Server.Acme.CacheDir)
+	}
+	if cfg.Tenants != nil {
+		for _, tenant := range *cfg.Tenants {
+			resolve(&tenant.PasswordFile)
+		}
+	}
+	for _, mapping := range cfg.Mappings {
+		if mapping != nil {
+			resolve(&mapping.File)
+		}
+	}
+	if cfg.Flush != nil {
+		resolve(&cfg.Flush.Textfile)
+	}
+}
+
+func (c *FlushConfig) setDefaults() {
+	if c.Pushgateway != "" && c.Job == "" {
+		c.Job = "grok_exporter"
+	}
+}
+
+func (c *TracingConfig) setDefaults() {
+	if c.SampleRatio == 0 {
+		c.SampleRatio = 0.01
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = "grok_exporter"
+	}
+}
+
+func (cfg *Config) validate() error {
+	err := cfg.Global.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Input.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Grok.validate(cfg.Metrics.needPatterns() || cfg.Sessions != nil || (cfg.Routing != nil && cfg.Routing.Match != ""))
+	if err != nil {
+		return err
+	}
+	if cfg.Grok.WatchPatternsDir && cfg.Grok.PatternsDir == "" {
+		return fmt.Errorf("'grok.watch_patterns_dir' requires 'grok.patterns_dir'.")
+	}
+	err = cfg.Metrics.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.validateInputLabels()
+	if err != nil {
+		return err
+	}
+	err = cfg.validateMappings()
+	if err != nil {
+		return err
+	}
+	err = cfg.Server.validate()
+	if err != nil {
+		return err
+	}
+	if cfg.Input.Type == "grpc" && (cfg.Server.Protocol != "https" || cfg.Server.DisableHttp2) {
+		return fmt.Errorf("Input type grpc requires HTTP/2, which is only available with 'server.protocol: https' and without 'server.disable_http2'.")
+	}
+	err = cfg.validateTenants()
+	if err != nil {
+		return err
+	}
+	if cfg.Tracing != nil {
+		err = cfg.Tracing.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Sessions != nil {
+		err = cfg.validateSessions()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Flush != nil {
+		err = cfg.Flush.validate()
+		if err != nil {
+			return err
+		}
+	}
+	return cfg.validateRouting()
+}
+
+func (cfg *Config) validateRouting() error {
+	if cfg.Routing == nil {
+		for _, metric := range *cfg.Metrics {
+			if metric.Route != "" {
+				return fmt.Errorf("Metric %v: 'metrics.route' requires the 'routing' section.", metric.Name)
+			}
+		}
+		return nil
+	}
+	if cfg.Routing.Field == "" {
+		return fmt.Errorf("'routing.field' must not be empty.")
+	}
+	if cfg.Routing.Match != "" {
+		return nil // the capture is checked when the expression is compiled
+	}
+	if cfg.Routing.Field == "logfile" && cfg.Input.Type == "file" {
+		return nil
+	}
+	if _, isLabel := cfg.Input.Labels[cfg.Routing.Field]; isLabel {
+		return nil
+	}
+	for _, field := range cfg.Input.PathFields() {
+		if field == cfg.Routing.Field {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid 'routing': 'routing.match' is required, because %v is not a field of the input.", cfg.Routing.Field)
+}
+
+func (cfg *Config) validateMappings() error {
+	for name, mapping := range cfg.Mappings {
+		switch {
+		case mapping == nil:
+			return fmt.Errorf("Mapping %v: The table must not be empty.", name)
+		case mapping.File != "" && (len(mapping.Values) > 0 || len(mapping.Regex) > 0):
+			return fmt.Errorf("Mapping %v: 'values' and 'regex' cannot be used with 'file'.", name)
+		case mapping.File == "" && len(mapping.Values) == 0 && len(mapping.Regex) == 0:
+			return fmt.Errorf("Mapping %v: One of 'values', 'regex', and 'file' must be configured.", name)
+		}
+		err := mapping.validateEntries()
+		if err != nil {
+			return fmt.Errorf("Mapping %v: %v", name, err.Error())
+		}
+	}
+	for _, metric := range *cfg.Metrics {
+		for _, stage := range metric.Pipeline {
+			for field, functions := range stage.Mutate {
+				for _, function := range functions {
+					if name, ok := mutate.MappingName(function); ok && cfg.Mappings[name] == nil {
+						return fmt.Errorf("Metric %v: Invalid 'mutate' in 'metrics.pipeline' for field %v: Mapping %v is not defined in 'mappings'.", metric.Name, field, name)
+					}
+				}
+			}
+		}
+		if metric.Fields == nil {
+			continue
+		}
+		for field, functions := range metric.Fields.Mutate {
+			for _, function := range functions {
+				if name, ok := mutate.MappingName(function); ok && cfg.Mappings[name] == nil {
+					return fmt.Errorf("Metric %v: Invalid 'fields.mutate' for field %v: Mapping %v is not defined in 'mappings'.", metric.Name, field, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *MappingConfig) validateEntries() error {
+	for _, r := range c.Regex {
+		if r.Match == "" {
+			return fmt.Errorf("'regex.match' must not be empty.")
+		}
+		_, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("Invalid 'regex.match': %v", err.Error())
+		}
+	}
+	return nil
+}
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func (cfg *Config) validateInputLabels() error {
+	pathFields := make(map[string]bool)
+	for _, field := range cfg.Input.PathFields() {
+		pathFields[field] = true
+	}
+	for name := range cfg.Input.Labels {
+		switch {
+		case !labelNameRegexp.MatchString(name):
+			return fmt.Errorf("Invalid 'input.labels': '%v' is not a valid Prometheus label name.", name)
+		case strings.HasPrefix(name, "__"):
+			return fmt.Errorf("Invalid 'input.labels': '%v'. Names starting with '__' are reserved.", name)
+		case pathFields[name]:
+			return fmt.Errorf("Invalid 'input.labels': '%v' is also a field of 'input.path_match'.", name)
+		}
+		for _, metric := range *cfg.Metrics {
+			for _, label := range metric.Labels {
+				if label.PrometheusLabel == name && label.GrokFieldName != name {
+					return fmt.Errorf("Metric %v: Label %v is already defined in 'input.labels'.", metric.Name, name)
+				}
+			}
+		}
+	}
+	return nil
 }
 
-func (c *InputConfig) setDefaults() {
-	if c.Type == "" {
-		c.Type = "stdin"
+func (c *GlobalConfig) validate() error {
+	switch {
+	case c.RetentionCheckInterval < 0:
+		return fmt.Errorf("'global.retention_check_interval' must be a positive duration like '1m'.")
+	case c.OnPatternError != "" && c.OnPatternError != "fail" && c.OnPatternError != "skip":
+		return fmt.Errorf("Invalid 'global.on_pattern_error': '%v'. Expecting 'fail' or 'skip'.", c.OnPatternError)
+	case c.OnTestFailure != "" && c.OnTestFailure != "fail" && c.OnTestFailure != "warn":
+		return fmt.Errorf("Invalid 'global.on_test_failure': '%v'. Expecting 'fail' or 'warn'.", c.OnTestFailure)
+	}
+	labels := make(map[string]string)
+	for attribute := range c.ResourceAttributes {
+		label := LabelName(attribute)
+		switch {
+		case attribute == "":
+			return fmt.Errorf("'global.resource_attributes' must not contain an empty name.")
+		case strings.HasPrefix(label, "__"):
+			return fmt.Errorf("Invalid 'global.resource_attributes': '%v'. Names starting with '__' are reserved.", attribute)
+		case labels[label] != "":
+			return fmt.Errorf("Invalid 'global.resource_attributes': '%v' and '%v' are both exposed as target_info label %v.", labels[label], attribute, label)
+		}
+		labels[label] = attribute
 	}
+	return nil
 }
 
-func (c *GrokConfig) setDefaults() {}
-
-func (c *MetricsConfig) setDefaults() {}
+func (c *InputConfig) validate() error {
+	switch {
+	case c.Type == "stdin":
+		if c.Path != "" || len(c.Paths) > 0 {
+			return fmt.Errorf("Cannot use 'input.path' or 'input.paths' when 'input.type' is stdin.")
+		}
+	case c.Type == "file":
+		if c.Path == "" && len(c.Paths) == 0 {
+			return fmt.Errorf("'input.path' or 'input.paths' is required for input type \"file\".")
+		}
+	case c.Type == "grpc":
+		if c.Path != "" || len(c.Paths) > 0 || c.Readall {
+			return fmt.Errorf("Cannot use 'input.path', 'input.paths', or 'input.readall' when 'input.type' is grpc.")
+		}
+	default:
+		return fmt.Errorf("Unsupported 'input.type': %v", c.Type)
+	}
+	switch {
+	case c.Mode != "" && c.Mode != "tail" && c.Mode != "pull":
+		return fmt.Errorf("Invalid 'input.mode': '%v'. Expecting 'tail' or 'pull'.", c.Mode)
+	case c.Mode != "" && c.Type != "file":
+		return fmt.Errorf("'input.mode' can only be used with input type \"file\".")
+	case c.FailOnMissingLogfile && c.Type != "file":
+		return fmt.Errorf("'input.fail_on_missing_logfile' can only be used with input type \"file\".")
+	case c.Mode == "pull" && c.Positions != nil:
+		return fmt.Errorf("'input.positions' cannot be used with 'input.mode: pull'.")
+	}
+	if datepath.IsTemplate(c.Path) {
+		err := datepath.Validate(c.Path)
+		switch {
+		case err != nil:
+			return fmt.Errorf("Invalid 'input.path': %v", err.Error())
+		case c.Mode == "pull" || c.Positions != nil || c.Backfill > 0:
+			return fmt.Errorf("'input.path' with date placeholders cannot be used with 'input.mode: pull', 'input.positions', or 'input.backfill'.")
+		}
+	}
+	if c.MultipleFiles() {
+		err := c.validateMultipleFiles()
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case c.Helper != "" && (c.Type != "file" || c.Mode == "pull"):
+		return fmt.Errorf("'input.helper' can only be used with input type \"file\" in tail mode.")
+	case c.Helper != "" && c.Backfill > 0:
+		return fmt.Errorf("'input.helper' cannot be used with 'input.backfill'.")
+	}
+	switch {
+	case c.RescanInterval < 0:
+		return fmt.Errorf("'input.rescan_interval' must not be negative.")
+	case c.RescanInterval > 0 && !c.MultipleFiles():
+		return fmt.Errorf("'input.rescan_interval' can only be used with 'input.paths' or glob patterns.")
+	}
+	if c.MaxBytesPerSecond < 0 {
+		return fmt.Errorf("'input.max_bytes_per_second' must not be negative.")
+	}
+	switch {
+	case c.Framing != "" && c.Framing != "varint" && c.Framing != "len32":
+		return fmt.Errorf("Invalid 'input.framing': '%v'. Expecting 'varint' or 'len32'.", c.Framing)
+	case c.Framing != "" && c.Type != "stdin":
+		return fmt.Errorf("'input.framing' can only be used with input type \"stdin\".")
+	}
+	if c.PathMatch != "" {
+		if c.Type != "file" {
+			return fmt.Errorf("'input.path_match' can only be used with input type \"file\".")
+		}
+		regex, err := regexp.Compile(c.PathMatch)
+		if err != nil {
+			return fmt.Errorf("Invalid 'input.path_match': %v", err.Error())
+		}
+		if len(c.PathFields()) == 0 {
+			return fmt.Errorf("Invalid 'input.path_match': '%v' has no named group like (?P<service>[^/]+).", regex.String())
+		}
+	}
+	switch {
+	case c.Backfill < 0:
+		return fmt.Errorf("'input.backfill' must not be negative.")
+	case c.Backfill > 0 && (c.Type != "file" || c.Mode == "pull"):
+		return fmt.Errorf("'input.backfill' can only be used with input type \"file\" in tail mode.")
+	case c.Backfill > 0 && c.Positions != nil && c.Timestamp == nil:
+		return fmt.Errorf("'input.backfill' with 'input.positions' requires 'input.timestamp', so that lines counted before the restart are skipped.")
+	}
+	switch {
+	case c.WarmupLines < 0:
+		return fmt.Errorf("'input.warmup_lines' must not be negative.")
+	case c.WarmupLines > 0 && (c.Type != "file" || c.Mode == "pull"):
+		return fmt.Errorf("'input.warmup_lines' can only be used with input type \"file\" in tail mode.")
+	case c.WarmupLines > 0 && (c.Readall || c.Backfill > 0 || c.Multiline != nil || c.Helper != "" || c.MultipleFiles()):
+		return fmt.Errorf("'input.warmup_lines' cannot be used with 'input.readall', 'input.backfill', 'input.multiline', 'input.helper', 'input.paths', or glob patterns.")
+	}
+	switch {
+	case c.IgnoreLinesOlderThan < 0:
+		return fmt.Errorf("'input.ignore_lines_older_than' must not be negative.")
+	case c.IgnoreLinesOlderThan > 0 && c.Timestamp == nil:
+		return fmt.Errorf("'input.ignore_lines_older_than' requires 'input.timestamp'.")
+	}
+	if c.Multiline != nil {
+		err := c.Multiline.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.Timestamp != nil {
+		err := c.Timestamp.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.Positions != nil {
+		if c.Type != "file" {
+			return fmt.Errorf("'input.positions' can only be used with input type \"file\".")
+		}
+		err := c.Positions.validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-func (c *ServerConfig) setDefaults() {
-	if c.Protocol == "" {
-		c.Protocol = "http"
+func (c *InputConfig) validateMultipleFiles() error {
+	for _, path := range c.AllPaths() {
+		switch {
+		case path == "":
+			return fmt.Errorf("'input.paths' must not contain an empty path.")
+		case datepath.IsTemplate(path):
+			return fmt.Errorf("Invalid path '%v': Date placeholders cannot be used with 'input.paths' or glob patterns.", path)
+		}
+		if _, err := filepath.Match(path, ""); err != nil {
+			return fmt.Errorf("Invalid glob pattern '%v': %v", path, err.Error())
+		}
 	}
-	if c.Port == 0 {
-		c.Port = 9144
+	if c.Mode == "pull" || c.Positions != nil || c.Backfill > 0 || c.Multiline != nil {
+		return fmt.Errorf("'input.paths' and glob patterns cannot be used with 'input.mode: pull', 'input.positions', 'input.backfill', or 'input.multiline'.")
 	}
+	return nil
 }
 
-func (cfg *Config) validate() error {
-	err := cfg.Input.validate()
-	if err != nil {
-		return err
+func (c *TimestampConfig) validate() error {
+	if c.Match == "" {
+		return fmt.Errorf("'input.timestamp.match' must not be empty.")
 	}
-	err = cfg.Grok.validate()
-	if err != nil {
+	if _, err := c.Location(); err != nil {
 		return err
 	}
-	err = cfg.Metrics.validate()
-	if err != nil {
+	if _, err := c.ZoneLocations(); err != nil {
 		return err
 	}
-	err = cfg.Server.validate()
-	if err != nil {
-		return err
+	if c.Locale != "" && !locale.IsSupported(c.Locale) {
+		return fmt.Errorf("Invalid 'input.timestamp.locale': '%v'. Expecting one of %v.", c.Locale, strings.Join(locale.Supported(), ", "))
 	}
 	return nil
 }
 
-func (c *InputConfig) validate() error {
+func (c *PositionsConfig) validate() error {
 	switch {
-	case c.Type == "stdin":
-		if c.Path != "" {
-			return fmt.Errorf("Cannot use 'input.path' when 'input.type' is stdin.")
-		}
-	case c.Type == "file":
-		if c.Path == "" {
-			return fmt.Errorf("'input.path' is required for input type \"file\".")
+	case c.Type != "file" && c.Type != "redis" && c.Type != "configmap":
+		return fmt.Errorf("Invalid 'input.positions.type': '%v'. Expecting 'file', 'redis', or 'configmap'.", c.Type)
+	case c.Type == "file" && c.Path == "":
+		return fmt.Errorf("'input.positions.path' is required for positions type file.")
+	case c.Type == "redis" && c.Address == "":
+		return fmt.Errorf("'input.positions.address' is required for positions type redis.")
+	case c.Type == "configmap" && c.Name == "":
+		return fmt.Errorf("'input.positions.name' is required for positions type configmap.")
+	case c.Type != "file" && c.Path != "":
+		return fmt.Errorf("'input.positions.path' can only be used with positions type file.")
+	case c.Type != "redis" && (c.Address != "" || c.Password != "" || c.PasswordFile != "" || c.PasswordEnv != "" || c.Database != 0 || c.KeyPrefix != ""):
+		return fmt.Errorf("'input.positions.address', 'password', 'database', and 'key_prefix' can only be used with positions type redis.")
+	case c.Type != "configmap" && (c.Namespace != "" || c.Name != ""):
+		return fmt.Errorf("'input.positions.namespace' and 'name' can only be used with positions type configmap.")
+	case c.Database < 0:
+		return fmt.Errorf("'input.positions.database' must not be negative.")
+	case c.Interval < 0:
+		return fmt.Errorf("'input.positions.interval' must be a positive duration like '10s'.")
+	}
+	return validateSecret("input.positions.password", c.Password, c.PasswordFile, c.PasswordEnv)
+}
+
+func (c *MultilineConfig) validate() error {
+	if c.Preset != "" {
+		err := c.validatePreset()
+		if err != nil {
+			return err
 		}
-	default:
-		return fmt.Errorf("Unsupported 'input.type': %v", c.Type)
+	}
+	switch {
+	case c.Start == "" && c.Continuation == "":
+		return fmt.Errorf("'input.multiline' requires 'start' or 'continuation'.")
+	case c.Start != "" && c.Continuation != "":
+		return fmt.Errorf("'input.multiline' cannot have both 'start' and 'continuation'.")
+	case c.Timeout < 0:
+		return fmt.Errorf("'input.multiline.timeout' must be a positive duration like '1s'.")
+	case c.MaxLines < 0:
+		return fmt.Errorf("'input.multiline.max_lines' must not be negative.")
 	}
 	return nil
 }
 
-func (c *GrokConfig) validate() error {
-	if c.PatternsDir == "" && len(c.Patterns) == 0 {
+// needPatterns is false if no expression references Grok patterns, like in a config where all metrics use presets.
+func (c *GrokConfig) validate(needPatterns bool) error {
+	if needPatterns && c.PatternsDir == "" && len(c.Patterns) == 0 {
 		return fmt.Errorf("No patterns defined: One of 'grok.patterns_dir' and 'grok.patterns' must be configured.")
 	}
+	switch c.RegexEngine {
+	case "", "oniguruma", "re2":
+	default:
+		return fmt.Errorf("Invalid 'grok.regex_engine': '%v'. Expecting 'oniguruma' or 're2'.", c.RegexEngine)
+	}
 	return nil
 }
 
+// Presets don't use any Grok patterns, so a config where all metrics use presets doesn't need to configure patterns.
+func (c *MetricsConfig) needPatterns() bool {
+	for _, metric := range *c {
+		if metric.Preset == "" && metric.Type != "derived" {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *MetricsConfig) validate() error {
 	if len(*c) == 0 {
 		return fmt.Errorf("'metrics' must not be empty.")
 	}
 	metricNames := make(map[string]bool)
 	for _, metric := range *c {
+		if placeholder := helpPlaceholderRegexp.FindString(metric.Help); placeholder != "" {
+			return fmt.Errorf("Metric %v: Unknown placeholder '%v' in 'metrics.help'. Expecting ${name}, ${pattern}, or ${source}.", metric.Name, placeholder)
+		}
 		_, exists := metricNames[metric.Name]
 		if exists {
 			return fmt.Errorf("%v defined twice.", metric.Name)
 		}
 		metricNames[metric.Name] = true
+		if metric.SumName != "" {
+			if metricNames[metric.SumName] {
+				return fmt.Errorf("%v defined twice.", metric.SumName)
+			}
+			metricNames[metric.SumName] = true
+		}
 		err := metric.validate()
 		if err != nil {
 			return err
 		}
 	}
+	for _, metric := range *c {
+		if metric.Type == "derived" {
+			err := c.validateSource(metric)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+func (c *MetricsConfig) validateSource(derived *MetricConfig) error {
+	for _, metric := range *c {
+		if metric.Name == derived.Source {
+			switch {
+			case metric.Type == "derived":
+				return fmt.Errorf("Metric %v: 'metrics.source' must not be a derived metric.", derived.Name)
+			case metric.PerScrape:
+				return fmt.Errorf("Metric %v: 'metrics.source' must not be a 'per_scrape' metric, because sampling it would reset it.", derived.Name)
+			case metric.Type == "counter" && derived.Function == "average":
+				return fmt.Errorf("Metric %v: 'average' is not supported for counters. Use 'rate' instead.", derived.Name)
+			default:
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Metric %v: 'metrics.source' %v is not defined.", derived.Name, derived.Source)
+}
+
 func (c *MetricConfig) validate() error {
+	if c.Type == "derived" {
+		return c.validateDerived()
+	}
+	if c.Preset != "" {
+		err := c.validatePreset()
+		if err != nil {
+			return err
+		}
+	}
 	switch {
-	case c.Type != "counter":
-		return fmt.Errorf("Invalid 'metrics.type': '%v'. We currently only support 'counter'.", c.Type)
+	case c.Type != "counter" && c.Type != "gauge" && c.Type != "histogram" && c.Type != "summary" && c.Type != "quantile":
+		return fmt.Errorf("Invalid 'metrics.type': '%v'. We currently only support 'counter', 'gauge', 'histogram', 'summary', 'quantile', and 'derived'.", c.Type)
 	case c.Name == "":
 		return fmt.Errorf("'metrics.name' must not be empty.")
 	case c.Help == "":
@@ -197,12 +1377,283 @@ func (c *MetricConfig) validate() error {
 	if c.Labels == nil {
 		return fmt.Errorf("Cannot find 'metrics.label' configuration.")
 	}
+	if c.Type != "counter" {
+		err := c.validateObservation()
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case c.Type != "quantile" && c.Compression != 0:
+		return fmt.Errorf("Metric %v: 'compression' can only be used with quantile metrics.", c.Name)
+	case c.Type != "quantile" && c.Type != "summary" && len(c.Quantiles) > 0:
+		return fmt.Errorf("Metric %v: 'quantiles' can only be used with quantile and summary metrics.", c.Name)
+	case c.Type != "histogram" && len(c.Buckets) > 0:
+		return fmt.Errorf("Metric %v: 'buckets' can only be used with histogram metrics.", c.Name)
+	}
+	switch {
+	case c.Type != "counter":
+		// checked in validateObservation()
+	case c.FromTotal && c.Value == "":
+		return fmt.Errorf("Metric %v: 'metrics.value' is required for 'from_total'.", c.Name)
+	case c.Split != "" && c.Value == "":
+		return fmt.Errorf("Metric %v: 'metrics.value' is required for 'split'.", c.Name)
+	case c.Split != "" && c.FromTotal:
+		return fmt.Errorf("Metric %v: 'split' cannot be used together with 'from_total'.", c.Name)
+	case c.Value != "" && !c.FromTotal && c.Split == "":
+		return fmt.Errorf("Metric %v: 'metrics.value' can only be used with 'from_total' or 'split' for counters.", c.Name)
+	}
 	for _, label := range c.Labels {
 		err := label.validate()
 		if err != nil {
 			return err
 		}
 	}
+	if c.Fields != nil {
+		err := c.Fields.validate()
+		if err != nil {
+			return fmt.Errorf("Metric %v: %v", c.Name, err.Error())
+		}
+	}
+	for i, stage := range c.Pipeline {
+		err := stage.validate()
+		if err != nil {
+			return fmt.Errorf("Metric %v: Invalid stage %v in 'metrics.pipeline': %v", c.Name, i+1, err.Error())
+		}
+	}
+	for i, test := range c.TestLines {
+		err := c.validateTestLine(test)
+		if err != nil {
+			return fmt.Errorf("Metric %v: Invalid entry %v in 'metrics.test_lines': %v", c.Name, i+1, err.Error())
+		}
+	}
+	switch {
+	case c.Format != "" && c.Format != "xml" && c.Format != "auto":
+		return fmt.Errorf("Metric %v: Invalid 'metrics.format': '%v'. Expecting 'xml', 'auto', or no format for plain text.", c.Name, c.Format)
+	case c.Format == "xml" && len(c.Xml) == 0:
+		return fmt.Errorf("Metric %v: 'metrics.xml' is required for format xml.", c.Name)
+	case c.Format != "xml" && len(c.Xml) > 0:
+		return fmt.Errorf("Metric %v: 'metrics.xml' can only be used with format xml.", c.Name)
+	case c.Format != "auto" && len(c.Formats) > 0:
+		return fmt.Errorf("Metric %v: 'metrics.formats' can only be used with format auto.", c.Name)
+	}
+	for pattern, format := range c.Formats {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Metric %v: Invalid glob pattern '%v' in 'metrics.formats': %v", c.Name, pattern, err.Error())
+		}
+		if format != "json" && format != "logfmt" && format != "plain" {
+			return fmt.Errorf("Metric %v: Invalid format '%v' for %v in 'metrics.formats'. Expecting 'json', 'logfmt', or 'plain'.", c.Name, format, pattern)
+		}
+	}
+	for field, path := range c.Xml {
+		_, err := xpath.Compile(path)
+		if err != nil {
+			return fmt.Errorf("Metric %v: Invalid 'metrics.xml' for field %v: %v", c.Name, field, err.Error())
+		}
+	}
+	if c.Kv != nil && c.Kv.PairSeparator == c.Kv.ValueSeparator {
+		return fmt.Errorf("Metric %v: 'kv.pair_separator' and 'kv.value_separator' must be different.", c.Name)
+	}
+	switch {
+	case c.MaxSeries < 0:
+		return fmt.Errorf("Metric %v: 'metrics.max_series' must not be negative.", c.Name)
+	case c.Eviction != "" && c.Eviction != "lru":
+		return fmt.Errorf("Metric %v: Invalid 'metrics.eviction': '%v'. We currently only support 'lru'.", c.Name, c.Eviction)
+	case c.Eviction != "" && c.MaxSeries == 0:
+		return fmt.Errorf("Metric %v: 'metrics.eviction' requires 'metrics.max_series'.", c.Name)
+	case c.Retention < 0:
+		return fmt.Errorf("Metric %v: 'metrics.retention' must be a positive duration like '24h'.", c.Name)
+	case c.SumName != "" && c.SumField == "":
+		return fmt.Errorf("Metric %v: 'metrics.sum_name' requires 'metrics.sum_field'.", c.Name)
+	case c.SumField != "" && c.PerScrape:
+		return fmt.Errorf("Metric %v: 'metrics.sum_field' cannot be used with 'per_scrape'.", c.Name)
+	case c.Context != nil && c.Context.Match == "":
+		return fmt.Errorf("Metric %v: 'metrics.context.match' must not be empty.", c.Name)
+	case c.Context != nil && c.Context.Lines < 0:
+		return fmt.Errorf("Metric %v: 'metrics.context.lines' must be a positive number.", c.Name)
+	case c.MaxLabelLength < 0:
+		return fmt.Errorf("Metric %v: 'metrics.max_label_length' must not be negative.", c.Name)
+	case c.LabelLengthPolicy != "" && c.LabelLengthPolicy != "truncate" && c.LabelLengthPolicy != "drop":
+		return fmt.Errorf("Metric %v: Invalid 'metrics.label_length_policy': '%v'. Expecting 'truncate' or 'drop'.", c.Name, c.LabelLengthPolicy)
+	case c.LabelLengthPolicy != "" && c.MaxLabelLength == 0:
+		return fmt.Errorf("Metric %v: 'metrics.label_length_policy' requires 'metrics.max_label_length'.", c.Name)
+	case c.RateLimit != nil && c.RateLimit.Max <= 0:
+		return fmt.Errorf("Metric %v: 'metrics.rate_limit.max' must be a positive number.", c.Name)
+	case c.RateLimit != nil && c.RateLimit.Per < 0:
+		return fmt.Errorf("Metric %v: 'metrics.rate_limit.per' must be a positive duration like '1m'.", c.Name)
+	case c.Exemplar != nil && len(c.Exemplar.Fields) == 0:
+		return fmt.Errorf("Metric %v: 'metrics.exemplar.fields' must not be empty.", c.Name)
+	case c.Exemplar != nil && (c.Exemplar.SampleRate < 0 || c.Exemplar.SampleRate > 1):
+		return fmt.Errorf("Metric %v: Invalid 'metrics.exemplar.sample_rate': %v. Expecting a value between 0 and 1, like 0.1.", c.Name, c.Exemplar.SampleRate)
+	}
+	if c.Notify != nil {
+		err := c.Notify.validate()
+		if err != nil {
+			return fmt.Errorf("Metric %v: %v", c.Name, err.Error())
+		}
+	}
+	if c.ResetSchedule != "" {
+		_, err := cron.Parse(c.ResetSchedule)
+		if err != nil {
+			return fmt.Errorf("Invalid 'metrics.reset_schedule' for %v: %v", c.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// validateObservation validates gauge, histogram, summary, and quantile metrics, which observe the 'value' of each match.
+func (c *MetricConfig) validateObservation() error {
+	switch {
+	case c.Value == "":
+		return fmt.Errorf("Metric %v: 'metrics.value' is required for %v metrics.", c.Name, c.Type)
+	case c.Compression < 0:
+		return fmt.Errorf("Metric %v: 'metrics.compression' must be a positive number.", c.Name)
+	case c.FromTotal || c.Split != "" || c.PerScrape || c.SumField != "" || c.Notify != nil || c.RateLimit != nil || c.Kv != nil || c.Format != "":
+		return fmt.Errorf("Metric %v: 'from_total', 'split', 'per_scrape', 'sum_field', 'notify', 'rate_limit', 'kv', and 'format' cannot be used with %v metrics.", c.Name, c.Type)
+	}
+	for _, q := range c.Quantiles {
+		if q <= 0 || q >= 1 {
+			return fmt.Errorf("Metric %v: Invalid 'metrics.quantiles': %v. Expecting values between 0 and 1, like 0.99.", c.Name, q)
+		}
+	}
+	for i, bucket := range c.Buckets {
+		if i > 0 && bucket <= c.Buckets[i-1] {
+			return fmt.Errorf("Metric %v: Invalid 'metrics.buckets': The upper bounds must be in increasing order.", c.Name)
+		}
+	}
+	reserved := map[string]string{"quantile": "quantile", "summary": "quantile", "histogram": "le"}[c.Type]
+	for _, label := range c.Labels {
+		if reserved != "" && label.PrometheusLabel == reserved {
+			return fmt.Errorf("Metric %v: The label name '%v' is reserved for %v metrics.", c.Name, reserved, c.Type)
+		}
+	}
+	return nil
+}
+
+func (c *NotifyConfig) validate() error {
+	u, err := url.Parse(c.Url)
+	switch {
+	case c.Url == "":
+		return fmt.Errorf("'notify.url' must not be empty.")
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		return fmt.Errorf("Invalid 'notify.url': '%v'. Expecting an http or https URL.", c.Url)
+	case c.MaxPerMinute < 0:
+		return fmt.Errorf("'notify.max_per_minute' must not be negative.")
+	case c.Timeout < 0:
+		return fmt.Errorf("'notify.timeout' must be a positive duration like '5s'.")
+	}
+	return nil
+}
+
+func (c *MetricConfig) validateDerived() error {
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("'metrics.name' must not be empty.")
+	case c.Help == "":
+		return fmt.Errorf("'metrics.help' must not be empty.")
+	case c.Source == "":
+		return fmt.Errorf("Metric %v: 'metrics.source' must not be empty for derived metrics.", c.Name)
+	case c.Function != "rate" && c.Function != "average":
+		return fmt.Errorf("Metric %v: Invalid 'metrics.function': '%v'. Expecting 'rate' or 'average'.", c.Name, c.Function)
+	case c.Window <= 0:
+		return fmt.Errorf("Metric %v: 'metrics.window' must be a positive duration like '5m'.", c.Name)
+	case c.Function == "average" && c.Per != 0:
+		return fmt.Errorf("Metric %v: 'metrics.per' can only be used with 'rate'.", c.Name)
+	case c.Function == "rate" && c.Per < 0:
+		return fmt.Errorf("Metric %v: 'metrics.per' must be a positive duration like '1m'.", c.Name)
+	case c.Match != "" || c.Repeat != "" || c.Context != nil || len(c.Labels) > 0 || c.Value != "" || c.Fields != nil || c.Kv != nil || c.Format != "" || c.Preset != "" || len(c.Pipeline) > 0 || len(c.TestLines) > 0 || c.Route != "":
+		return fmt.Errorf("Metric %v: Derived metrics take the labels and values from the source metric, so 'match', 'repeat', 'context', 'labels', 'value', 'fields', 'kv', 'format', 'preset', 'pipeline', 'test_lines', and 'route' cannot be used.", c.Name)
+	case c.FromTotal || c.Split != "" || c.ResetSchedule != "" || c.MaxSeries != 0 || c.Eviction != "" || c.Retention != 0 || c.Notify != nil || c.RateLimit != nil || c.Exemplar != nil || c.PerScrape || c.SumField != "" || c.SumName != "" || len(c.Quantiles) > 0 || c.Compression != 0 || c.MaxLabelLength != 0 || c.LabelLengthPolicy != "":
+		return fmt.Errorf("Metric %v: 'from_total', 'split', 'reset_schedule', 'per_scrape', 'sum_field', 'sum_name', 'max_series', 'eviction', 'retention', 'notify', 'rate_limit', 'exemplar', 'quantiles', 'compression', and 'max_label_length' cannot be used with derived metrics.", c.Name)
+	}
+	return nil
+}
+
+func (c *MetricConfig) validateTestLine(test TestLineConfig) error {
+	switch {
+	case test.Line == "":
+		return fmt.Errorf("'line' must not be empty.")
+	case c.Context != nil:
+		return fmt.Errorf("Test lines are processed without the previous lines, so they cannot be used with 'context'.")
+	case !test.ExpectsMatch() && (len(test.Labels) > 0 || test.Value != nil):
+		return fmt.Errorf("'labels' and 'value' cannot be used with 'matches: false'.")
+	}
+	for name := range test.Labels {
+		defined := false
+		for _, label := range c.Labels {
+			if label.PrometheusLabel == name {
+				defined = true
+			}
+		}
+		if !defined {
+			return fmt.Errorf("Label %v is not defined in 'metrics.labels'.", name)
+		}
+	}
+	return nil
+}
+
+func (c StageConfig) validate() error {
+	stages := 0
+	for _, configured := range []bool{c.Filter != nil, len(c.Set) > 0, len(c.Mutate) > 0} {
+		if configured {
+			stages++
+		}
+	}
+	if stages != 1 {
+		return fmt.Errorf("Expecting exactly one of 'filter', 'set', and 'mutate'.")
+	}
+	if c.Filter != nil {
+		switch {
+		case c.Filter.Field == "":
+			return fmt.Errorf("'filter.field' must not be empty.")
+		case (c.Filter.Match == "") == (c.Filter.NotMatch == ""):
+			return fmt.Errorf("Expecting exactly one of 'filter.match' and 'filter.not_match'.")
+		}
+		_, err := regexp.Compile(c.Filter.Match + c.Filter.NotMatch)
+		if err != nil {
+			return fmt.Errorf("Invalid regular expression in 'filter': %v", err.Error())
+		}
+	}
+	for field := range c.Set {
+		if field == "" {
+			return fmt.Errorf("Field names in 'set' must not be empty.")
+		}
+	}
+	for field, functions := range c.Mutate {
+		_, err := mutate.Chain(functions)
+		if err != nil {
+			return fmt.Errorf("Invalid 'mutate' for field %v: %v", field, err.Error())
+		}
+	}
+	return nil
+}
+
+func (c *FieldsConfig) validate() error {
+	targets := make(map[string]bool)
+	for from, to := range c.Rename {
+		switch {
+		case from == "" || to == "":
+			return fmt.Errorf("Field names in 'fields.rename' must not be empty.")
+		case targets[to]:
+			return fmt.Errorf("Two fields are renamed to %v in 'fields.rename'.", to)
+		}
+		targets[to] = true
+	}
+	for field, functions := range c.Mutate {
+		_, err := mutate.Chain(functions)
+		if err != nil {
+			return fmt.Errorf("Invalid 'fields.mutate' for field %v: %v", field, err.Error())
+		}
+	}
+	for _, dropped := range c.Drop {
+		_, renamed := c.Rename[dropped]
+		switch {
+		case dropped == "":
+			return fmt.Errorf("Field names in 'fields.drop' must not be empty.")
+		case renamed || targets[dropped]:
+			return fmt.Errorf("Field %v is in both 'fields.rename' and 'fields.drop'.", dropped)
+		}
+	}
 	return nil
 }
 
@@ -223,6 +1674,10 @@ func (c *ServerConfig) validate() error {
 		return fmt.Errorf("Invalid 'server.protocol': '%v'. Expecting 'http' or 'https'.", c.Protocol)
 	case c.Port <= 0:
 		return fmt.Errorf("Invalid 'server.port': '%v'.", c.Port)
+	case c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0:
+		return fmt.Errorf("'server.read_timeout', 'server.write_timeout', and 'server.idle_timeout' must not be negative.")
+	case c.MaxHeaderBytes < 0:
+		return fmt.Errorf("Invalid 'server.max_header_bytes': '%v'.", c.MaxHeaderBytes)
 	case c.Protocol == "https":
 		if c.Cert != "" && c.Key == "" {
 			return fmt.Errorf("'server.cert' must not be specified without 'server.key'")
@@ -230,10 +1685,144 @@ func (c *ServerConfig) validate() error {
 		if c.Cert == "" && c.Key != "" {
 			return fmt.Errorf("'server.key' must not be specified without 'server.cert'")
 		}
+		if c.Key == "" && (c.KeyPassphrase != "" || c.KeyPassphraseFile != "" || c.KeyPassphraseEnv != "") {
+			return fmt.Errorf("'server.key_passphrase' must not be specified without 'server.key'")
+		}
+		err := validateSecret("server.key_passphrase", c.KeyPassphrase, c.KeyPassphraseFile, c.KeyPassphraseEnv)
+		if err != nil {
+			return err
+		}
+		if c.Acme != nil {
+			if c.Cert != "" || c.Key != "" {
+				return fmt.Errorf("'server.cert' and 'server.key' must not be specified together with 'server.acme'.")
+			}
+			err = c.Acme.validate()
+			if err != nil {
+				return err
+			}
+		}
 	case c.Protocol == "http":
+		if c.Acme != nil {
+			return fmt.Errorf("'server.acme' can only be configured for protocol 'https'.")
+		}
+		if c.DisableHttp2 {
+			return fmt.Errorf("'server.disable_http2' can only be configured for protocol 'https'.")
+		}
 		if c.Cert != "" || c.Key != "" {
 			return fmt.Errorf("'server.cert' and 'server.key' can only be configured for protocol 'https'.")
 		}
+		if c.KeyPassphrase != "" || c.KeyPassphraseFile != "" || c.KeyPassphraseEnv != "" {
+			return fmt.Errorf("'server.key_passphrase' can only be configured for protocol 'https'.")
+		}
+	}
+	for _, cidr := range c.AllowedCidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid 'server.allowed_cidrs': '%v'. Expecting CIDR notation like '10.0.0.0/8'.", cidr)
+		}
+	}
+	return nil
+}
+
+func (c *AcmeConfig) validate() error {
+	switch {
+	case len(c.Domains) == 0:
+		return fmt.Errorf("'server.acme.domains' must not be empty.")
+	case c.CacheDir == "":
+		return fmt.Errorf("'server.acme.cache_dir' must not be empty.")
+	case c.ChallengePort <= 0:
+		return fmt.Errorf("Invalid 'server.acme.challenge_port': '%v'.", c.ChallengePort)
+	}
+	for _, domain := range c.Domains {
+		if domain == "" || strings.ContainsAny(domain, "*/: ") {
+			return fmt.Errorf("Invalid 'server.acme.domains': '%v'. Expecting a host name. Wildcards are not supported.", domain)
+		}
+	}
+	return nil
+}
+
+var tenantNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+func (cfg *Config) validateTenants() error {
+	tenants := make(map[string]bool)
+	if cfg.Tenants != nil {
+		for _, tenant := range *cfg.Tenants {
+			switch {
+			case !tenantNameRegexp.MatchString(tenant.Name):
+				return fmt.Errorf("Invalid 'tenants.name': '%v'. Expecting letters, digits, '_', and '-'.", tenant.Name)
+			case tenants[tenant.Name]:
+				return fmt.Errorf("Tenant %v defined twice.", tenant.Name)
+			case tenant.Username == "" && (tenant.Password != "" || tenant.PasswordFile != "" || tenant.PasswordEnv != ""):
+				return fmt.Errorf("'tenants.password' must not be specified without 'tenants.username' for tenant %v.", tenant.Name)
+			}
+			err := validateSecret("tenants.password", tenant.Password, tenant.PasswordFile, tenant.PasswordEnv)
+			if err != nil {
+				return err
+			}
+			tenants[tenant.Name] = true
+		}
+	}
+	for _, metric := range *cfg.Metrics {
+		if metric.Tenant != "" && !tenants[metric.Tenant] {
+			return fmt.Errorf("Metric %v: Tenant %v is not defined in 'tenants'.", metric.Name, metric.Tenant)
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validateSessions() error {
+	names := make(map[string]bool)
+	for _, metric := range *cfg.Metrics {
+		names[metric.Name] = true
+	}
+	for _, c := range *cfg.Sessions {
+		switch {
+		case c.Name == "":
+			return fmt.Errorf("'sessions.name' must not be empty.")
+		case names[c.Name]:
+			return fmt.Errorf("Session %v: The name is already used by another metric or session.", c.Name)
+		case c.Help == "":
+			return fmt.Errorf("Session %v: 'sessions.help' must not be empty.", c.Name)
+		case c.Key == "":
+			return fmt.Errorf("Session %v: 'sessions.key' must not be empty.", c.Name)
+		case c.Start == "" || c.End == "":
+			return fmt.Errorf("Session %v: 'sessions.start' and 'sessions.end' must not be empty.", c.Name)
+		case c.Timeout < 0:
+			return fmt.Errorf("Session %v: 'sessions.timeout' must be a positive duration like '30m'.", c.Name)
+		}
+		for i := 1; i < len(c.Buckets); i++ {
+			if c.Buckets[i] <= c.Buckets[i-1] {
+				return fmt.Errorf("Session %v: 'sessions.buckets' must be in increasing order.", c.Name)
+			}
+		}
+		names[c.Name] = true
+	}
+	return nil
+}
+
+func (c *FlushConfig) validate() error {
+	switch {
+	case c.Pushgateway == "" && c.Textfile == "":
+		return fmt.Errorf("'flush' requires 'flush.pushgateway' or 'flush.textfile'.")
+	case c.Pushgateway == "" && (c.Job != "" || c.Instance != ""):
+		return fmt.Errorf("'flush.job' and 'flush.instance' require 'flush.pushgateway'.")
+	case c.Interval < 0:
+		return fmt.Errorf("'flush.interval' must not be negative.")
+	}
+	if c.Pushgateway != "" {
+		u, err := url.Parse(c.Pushgateway)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid 'flush.pushgateway': '%v'. Expecting a URL like http://pushgateway:9091.", c.Pushgateway)
+		}
+	}
+	return nil
+}
+
+func (c *TracingConfig) validate() error {
+	switch {
+	case c.Endpoint == "":
+		return fmt.Errorf("'tracing.endpoint' must not be empty.")
+	case c.SampleRatio < 0 || c.SampleRatio > 1:
+		return fmt.Errorf("Invalid 'tracing.sample_ratio': '%v'. Expecting a value between 0 and 1.", c.SampleRatio)
 	}
 	return nil
 }
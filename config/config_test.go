@@ -1,11 +1,16 @@
 package config
 
 import (
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 const config = `
+global:
+    base_dir: /etc/grok_exporter
 input:
     type: file
     path: x/x/x
@@ -37,6 +42,23 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+func TestResolvePaths(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(config))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	cfg.resolvePaths("/home/user")
+	if cfg.Grok.PatternsDir != "/etc/grok_exporter/b/c" {
+		t.Errorf("Expected 'grok.patterns_dir' to be resolved relative to 'global.base_dir', but got %v.", cfg.Grok.PatternsDir)
+	}
+	cfg.Global.BaseDir = ""
+	cfg.Input.Path = "x.log"
+	cfg.resolvePaths("/home/user")
+	if cfg.Input.Path != "/home/user/x.log" {
+		t.Errorf("Expected 'input.path' to be resolved relative to the config directory, but got %v.", cfg.Input.Path)
+	}
+}
+
 func equalsIgnoreIndentation(a string, b string) bool {
 	aLines := stripEmptyLines(strings.Split(a, "\n"))
 	bLines := stripEmptyLines(strings.Split(b, "\n"))
@@ -60,3 +82,474 @@ func stripEmptyLines(lines []string) []string {
 	}
 	return result
 }
+
+const configTemplate = `
+grok:
+    patterns_dir: b/c
+metrics:
+{{- range .Values.hosts }}
+    - type: counter
+      name: {{ . | replace "-" "_" }}_count_total
+      help: Dummy help message for {{ . }}.
+      match: '%{HOSTNAME:host} {{ . }}'
+      labels:
+          - grok_field_name: host
+            prometheus_label: host
+{{- end }}
+`
+
+func TestExpandTemplate(t *testing.T) {
+	values := map[string]interface{}{
+		"hosts": []interface{}{"web-1", "web-2"},
+	}
+	expanded, err := expandTemplate("test", []byte(configTemplate), values)
+	if err != nil {
+		t.Fatalf("Failed to expand template: %v", err.Error())
+	}
+	cfg, err := LoadConfigString(expanded)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if len(*cfg.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, but got %v.", len(*cfg.Metrics))
+	}
+	if (*cfg.Metrics)[1].Name != "web_2_count_total" {
+		t.Errorf("Expected metric name web_2_count_total, but got %v.", (*cfg.Metrics)[1].Name)
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	os.Setenv("GROK_EXPORTER_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("GROK_EXPORTER_TEST_SECRET")
+	file, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err.Error())
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("s3cret\n")
+	file.Close()
+	for _, cfg := range []*ServerConfig{
+		{KeyPassphrase: "s3cret"},
+		{KeyPassphraseFile: file.Name()},
+		{KeyPassphraseEnv: "GROK_EXPORTER_TEST_SECRET"},
+	} {
+		secret, err := cfg.ReadKeyPassphrase()
+		if err != nil {
+			t.Errorf("Failed to read secret: %v", err.Error())
+		} else if secret != "s3cret" {
+			t.Errorf("Expected secret 's3cret', but got '%v'.", secret)
+		}
+	}
+	cfg := &ServerConfig{Protocol: "https", Port: 9144, Cert: "c", Key: "k", KeyPassphrase: "a", KeyPassphraseEnv: "b"}
+	if cfg.validate() == nil {
+		t.Errorf("Expected error when both 'key_passphrase' and 'key_passphrase_env' are configured.")
+	}
+}
+
+func TestAllowedCidrs(t *testing.T) {
+	cfg, err := LoadConfigString([]byte("server:\n    allowed_cidrs: [10.0.0.0/8, '::1/128']\n" + minimalMetricsConfig))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	networks := cfg.Server.AllowedNetworks()
+	if len(networks) != 2 || networks[0].String() != "10.0.0.0/8" {
+		t.Errorf("Unexpected networks %v.", networks)
+	}
+	_, err = LoadConfigString([]byte("server:\n    allowed_cidrs: [10.0.0.1]\n" + minimalMetricsConfig))
+	if err == nil {
+		t.Errorf("Expected error for an IP address without prefix length.")
+	}
+}
+
+const minimalMetricsConfig = `
+input:
+    type: stdin
+grok:
+    patterns: ['WORD \w+']
+metrics:
+    - type: counter
+      name: test_total
+      help: Test.
+      match: '%{WORD:word}'
+      labels:
+          - grok_field_name: word
+            prometheus_label: word
+`
+
+func TestInputLabels(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(`
+input:
+    type: stdin
+    labels:
+        source: nginx
+grok:
+    patterns:
+    - 'WORD \w+'
+metrics:
+    - type: counter
+      name: test_total
+      help: Dummy help message.
+      match: x
+      labels:
+          - grok_field_name: user
+            prometheus_label: user
+`))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	labels := (*cfg.Metrics)[0].Labels
+	if len(labels) != 2 || labels[1].GrokFieldName != "source" || labels[1].PrometheusLabel != "source" {
+		t.Errorf("Expected the input label to be attached to the metric, but got %v.", labels)
+	}
+	_, err = LoadConfigString([]byte(`
+input:
+    type: stdin
+    labels:
+        source: nginx
+grok:
+    patterns:
+    - 'WORD \w+'
+metrics:
+    - type: counter
+      name: test_total
+      help: Dummy help message.
+      match: x
+      labels:
+          - grok_field_name: service
+            prometheus_label: source
+`))
+	if err == nil || !strings.Contains(err.Error(), "already defined in 'input.labels'") {
+		t.Errorf("Expected an error for a label conflicting with 'input.labels', but got %v.", err)
+	}
+}
+
+func TestMappings(t *testing.T) {
+	mappingConfig := `
+grok:
+    patterns:
+    - 'WORD \w+'
+metrics:
+    - type: counter
+      name: test_total
+      help: Dummy help message.
+      match: '%{WORD:code}'
+      labels:
+          - grok_field_name: code
+            prometheus_label: category
+      fields:
+          mutate:
+              code: ['map(NAME)']
+mappings:
+    categories:
+        regex:
+            - match: 'REGEX'
+              value: connection
+`
+	_, err := LoadConfigString([]byte(strings.NewReplacer("NAME", "categories", "REGEX", "^08").Replace(mappingConfig)))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	_, err = LoadConfigString([]byte(strings.NewReplacer("NAME", "undefined", "REGEX", "^08").Replace(mappingConfig)))
+	if err == nil || !strings.Contains(err.Error(), "Mapping undefined is not defined") {
+		t.Errorf("Expected an error for an undefined mapping, but got %v.", err)
+	}
+	_, err = LoadConfigString([]byte(strings.NewReplacer("NAME", "categories", "REGEX", "(").Replace(mappingConfig)))
+	if err == nil || !strings.Contains(err.Error(), "Invalid 'regex.match'") {
+		t.Errorf("Expected an error for an invalid regex, but got %v.", err)
+	}
+}
+
+func TestHelpPlaceholders(t *testing.T) {
+	helpConfig := `
+input:
+    type: file
+    path: /var/log/app.log
+grok:
+    patterns:
+    - 'WORD \w+'
+metrics:
+    - type: counter
+      name: words_total
+      help: 'HELP'
+      match: 'user %{WORD:user}'
+      labels:
+          - grok_field_name: user
+            prometheus_label: user
+    - type: counter
+      name: lines_total
+      match: 'x'
+      labels: []
+`
+	cfg, err := LoadConfigString([]byte(strings.Replace(helpConfig, "HELP", "${name}: Lines in ${source} matching ${pattern}.", 1)))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if help := (*cfg.Metrics)[0].Help; help != "words_total: Lines in /var/log/app.log matching WORD." {
+		t.Errorf("Unexpected help text '%v'.", help)
+	}
+	if help := (*cfg.Metrics)[1].Help; help != "Lines total." {
+		t.Errorf("Unexpected generated help text '%v'.", help)
+	}
+	_, err = LoadConfigString([]byte(strings.Replace(helpConfig, "HELP", "${unknown}", 1)))
+	if err == nil || !strings.Contains(err.Error(), "Unknown placeholder '${unknown}'") {
+		t.Errorf("Expected an error for an unknown placeholder, but got %v.", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	cfg, err := LoadConfigString([]byte("flush:\n    pushgateway: http://pushgateway:9091\n    textfile: grok.prom\n" + minimalMetricsConfig))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if cfg.Flush.Job != "grok_exporter" {
+		t.Errorf("Expected default job grok_exporter, but got %v.", cfg.Flush.Job)
+	}
+	if cfg.Flush.Interval != 0 {
+		t.Errorf("Expected no 'flush.interval' by default, but got %v.", cfg.Flush.Interval)
+	}
+	periodic, err := LoadConfigString([]byte("flush:\n    textfile: grok.prom\n    interval: 500ms\n" + minimalMetricsConfig))
+	if err != nil {
+		t.Errorf("Failed to read config with 'flush.interval': %v", err.Error())
+	} else if periodic.Flush.Interval != 500*time.Millisecond {
+		t.Errorf("Expected 'flush.interval' 500ms, but got %v.", periodic.Flush.Interval)
+	}
+	cfg.resolvePaths("/home/user")
+	if cfg.Flush.Textfile != "/home/user/grok.prom" {
+		t.Errorf("Expected 'flush.textfile' to be resolved relative to the config directory, but got %v.", cfg.Flush.Textfile)
+	}
+	for _, invalid := range []string{
+		"flush: {}\n",
+		"flush:\n    textfile: grok.prom\n    job: batch\n",
+		"flush:\n    pushgateway: pushgateway:9091\n",
+		"flush:\n    textfile: grok.prom\n    interval: -1s\n",
+	} {
+		_, err = LoadConfigString([]byte(invalid + minimalMetricsConfig))
+		if err == nil {
+			t.Errorf("Expected error for %q.", invalid)
+		}
+	}
+}
+
+func TestRegexEngine(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "grok:\n", "grok:\n    regex_engine: re2\n", 1)))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if cfg.Grok.RegexEngine != "re2" {
+		t.Errorf("Expected regex engine re2, but got %v.", cfg.Grok.RegexEngine)
+	}
+	_, err = LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "grok:\n", "grok:\n    regex_engine: pcre\n", 1)))
+	if err == nil {
+		t.Errorf("Expected an error for an unknown regex engine.")
+	}
+}
+
+func TestObservationMetrics(t *testing.T) {
+	histogramConfig := `
+grok:
+    patterns: ['NUMBER \d+']
+metrics:
+    - type: TYPE
+      name: request_duration_seconds
+      help: Test.
+      match: 'took %{NUMBER:seconds}'
+      value: seconds
+      EXTRA
+      labels: []
+`
+	cfg, err := LoadConfigString([]byte(strings.NewReplacer("TYPE", "histogram", "EXTRA", "").Replace(histogramConfig)))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if len((*cfg.Metrics)[0].Buckets) != 11 {
+		t.Errorf("Expected the default buckets, but got %v.", (*cfg.Metrics)[0].Buckets)
+	}
+	cfg, err = LoadConfigString([]byte(strings.NewReplacer("TYPE", "summary", "EXTRA", "").Replace(histogramConfig)))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if len((*cfg.Metrics)[0].Quantiles) != 3 {
+		t.Errorf("Expected the default quantiles, but got %v.", (*cfg.Metrics)[0].Quantiles)
+	}
+	for _, invalid := range [][2]string{
+		{"histogram", "buckets: [1, 0.5]"},
+		{"gauge", "buckets: [1]"},
+		{"histogram", "quantiles: [0.5]"},
+		{"summary", "compression: 100"},
+		{"gauge", "from_total: true"},
+	} {
+		_, err = LoadConfigString([]byte(strings.NewReplacer("TYPE", invalid[0], "EXTRA", invalid[1]).Replace(histogramConfig)))
+		if err == nil {
+			t.Errorf("Expected error for %v with %v.", invalid[0], invalid[1])
+		}
+	}
+	_, err = LoadConfigString([]byte(strings.NewReplacer("TYPE", "gauge", "EXTRA", "", "value: seconds", "").Replace(histogramConfig)))
+	if err == nil || !strings.Contains(err.Error(), "'metrics.value' is required for gauge metrics") {
+		t.Errorf("Expected an error for a gauge without value, but got %v.", err)
+	}
+}
+
+func TestInputPaths(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "type: stdin", "type: file\n    paths: ['/var/log/app/*.log', audit.log]\n    rescan_interval: 1m", 1)))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	cfg.resolvePaths("/etc/grok_exporter")
+	if paths := cfg.Input.AllPaths(); len(paths) != 2 || paths[1] != "/etc/grok_exporter/audit.log" || !cfg.Input.MultipleFiles() || cfg.Input.RescanInterval != time.Minute {
+		t.Errorf("Unexpected paths %v.", paths)
+	}
+	for _, invalid := range []string{
+		"type: file\n    path: '/var/log/[a.log'",
+		"type: file\n    path: '/var/log/*.log'\n    mode: pull",
+		"type: file\n    paths: ['/var/log/app-%Y.log', /var/log/b.log]",
+		"type: stdin\n    paths: [/var/log/a.log]",
+		"type: file\n    path: '/var/log/*.log'\n    rescan_interval: -1s",
+		"type: file\n    path: /var/log/a.log\n    rescan_interval: 1m",
+	} {
+		_, err = LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "type: stdin", invalid, 1)))
+		if err == nil {
+			t.Errorf("Expected error for %q.", invalid)
+		}
+	}
+}
+
+func TestInputHelper(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "type: stdin", "type: file\n    path: /var/log/secure\n    helper: /run/grok_exporter/helper.sock", 1)))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if cfg.Input.Helper != "/run/grok_exporter/helper.sock" {
+		t.Errorf("Unexpected helper %v.", cfg.Input.Helper)
+	}
+	cfg.Input.Helper = "helper.sock"
+	cfg.resolvePaths("/etc/grok_exporter")
+	if cfg.Input.Helper != "/etc/grok_exporter/helper.sock" {
+		t.Errorf("Expected 'input.helper' to be resolved relative to the config directory, but got %v.", cfg.Input.Helper)
+	}
+	for _, invalid := range []string{
+		"type: stdin\n    helper: /run/grok_exporter/helper.sock",
+		"type: file\n    path: /var/log/secure\n    mode: pull\n    helper: /run/grok_exporter/helper.sock",
+		"type: file\n    path: /var/log/secure\n    backfill: 2\n    helper: /run/grok_exporter/helper.sock",
+	} {
+		_, err = LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "type: stdin", invalid, 1)))
+		if err == nil || !strings.Contains(err.Error(), "'input.helper'") {
+			t.Errorf("Expected an error for %q, but got %v.", invalid, err)
+		}
+	}
+}
+
+func TestFormatAuto(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(minimalMetricsConfig + "      format: auto\n      formats: {'/var/log/legacy/*.log': plain}\n"))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if m := (*cfg.Metrics)[0]; m.Format != "auto" || m.Formats["/var/log/legacy/*.log"] != "plain" {
+		t.Errorf("Unexpected format %v with formats %v.", m.Format, m.Formats)
+	}
+	for _, invalid := range []string{
+		"      format: json\n",
+		"      formats: {'/var/log/*.log': json}\n",
+		"      format: auto\n      formats: {'/var/log/[a.log': json}\n",
+		"      format: auto\n      formats: {'/var/log/*.log': xml}\n",
+	} {
+		_, err = LoadConfigString([]byte(minimalMetricsConfig + invalid))
+		if err == nil || !strings.Contains(err.Error(), "format") {
+			t.Errorf("Expected an error for %q, but got %v.", invalid, err)
+		}
+	}
+}
+
+func TestPipelineStages(t *testing.T) {
+	stages := "\n      pipeline:\n          - mutate: {word: [lowercase]}\n          - filter: {field: word, not_match: '^debug$'}\n          - set: {tier: backend}\n"
+	cfg, err := LoadConfigString([]byte(minimalMetricsConfig + stages))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if pipeline := (*cfg.Metrics)[0].Pipeline; len(pipeline) != 3 || pipeline[1].Filter.NotMatch != "^debug$" || pipeline[2].Set["tier"] != "backend" {
+		t.Errorf("Unexpected pipeline %v.", pipeline)
+	}
+	for _, invalid := range []string{
+		"\n      pipeline:\n          - {}\n",
+		"\n      pipeline:\n          - filter: {field: word, match: a}\n            set: {tier: backend}\n",
+		"\n      pipeline:\n          - filter: {field: word}\n",
+		"\n      pipeline:\n          - filter: {field: word, match: '('}\n",
+		"\n      pipeline:\n          - mutate: {word: [reverse]}\n",
+		"\n      pipeline:\n          - mutate: {word: ['map(undefined)']}\n",
+	} {
+		_, err = LoadConfigString([]byte(minimalMetricsConfig + invalid))
+		if err == nil {
+			t.Errorf("Expected error for %q.", invalid)
+		}
+	}
+}
+
+func TestTestLines(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(minimalMetricsConfig + "      test_lines:\n          - line: hello\n            labels: {word: hello}\n            value: 1\n"))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if test := (*cfg.Metrics)[0].TestLines[0]; test.Line != "hello" || *test.Value != 1 || !test.ExpectsMatch() {
+		t.Errorf("Unexpected test line %v.", test)
+	}
+	for _, invalid := range []string{
+		"      test_lines:\n          - labels: {word: hello}\n",
+		"      test_lines:\n          - line: hello\n            labels: {user: hello}\n",
+		"      test_lines:\n          - line: hello\n            matches: false\n            value: 1\n",
+	} {
+		_, err = LoadConfigString([]byte(minimalMetricsConfig + invalid))
+		if err == nil {
+			t.Errorf("Expected error for %q.", invalid)
+		}
+	}
+	_, err = LoadConfigString([]byte("global:\n    on_test_failure: ignore\n" + minimalMetricsConfig))
+	if err == nil {
+		t.Errorf("Expected error for an invalid 'global.on_test_failure'.")
+	}
+}
+
+func TestRouting(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(minimalMetricsConfig + "      route: nginx\nrouting:\n    match: 'app=%{WORD:app}'\n    field: app\n"))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if cfg.Routing.Field != "app" || (*cfg.Metrics)[0].Route != "nginx" {
+		t.Errorf("Unexpected routing %v with route %v.", cfg.Routing, (*cfg.Metrics)[0].Route)
+	}
+	for _, invalid := range []string{
+		"      route: nginx\n",
+		"routing:\n    match: 'app=%{WORD:app}'\n",
+		"routing:\n    field: app\n",
+	} {
+		_, err = LoadConfigString([]byte(minimalMetricsConfig + invalid))
+		if err == nil || !strings.Contains(err.Error(), "routing") {
+			t.Errorf("Expected an error for %q, but got %v.", invalid, err)
+		}
+	}
+	_, err = LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "type: stdin", "type: stdin\n    labels: {app: nginx}", 1) + "routing:\n    field: app\n"))
+	if err != nil {
+		t.Errorf("Expected an input label to be a valid 'routing.field', but got %v.", err)
+	}
+}
+
+func TestWarmupLines(t *testing.T) {
+	file := "type: file\n    path: /var/log/app.log"
+	cfg, err := LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "type: stdin", file+"\n    warmup_lines: 1000", 1)))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err.Error())
+	}
+	if cfg.Input.WarmupLines != 1000 {
+		t.Errorf("Unexpected warmup_lines %v.", cfg.Input.WarmupLines)
+	}
+	for _, invalid := range []string{
+		file + "\n    warmup_lines: -1",
+		"type: stdin\n    warmup_lines: 1000",
+		file + "\n    mode: pull\n    warmup_lines: 1000",
+		file + "\n    readall: true\n    warmup_lines: 1000",
+		file + "\n    backfill: 1\n    warmup_lines: 1000",
+	} {
+		_, err = LoadConfigString([]byte(strings.Replace(minimalMetricsConfig, "type: stdin", invalid, 1)))
+		if err == nil || !strings.Contains(err.Error(), "'input.warmup_lines'") {
+			t.Errorf("Expected an error for %q, but got %v.", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A preset configures the match expression and labels for a well-known log format,
+// so that the most common use cases do not need any Grok expressions.
+// The expressions are self-contained, i.e. they don't reference patterns, so they work without 'grok.patterns_dir'.
+// They match the whole line, and capture all fields of the format as named groups.
+type preset struct {
+	match  string
+	labels []Label
+}
+
+const (
+	// The request is "-" for malformed requests. Values are in double quotes. Inside the quotes, Apache and Nginx escape double quotes as \".
+	requestRegex  = `"(?:(?<method>[A-Z]+) (?<path>[^ "]+)(?: (?<protocol>[^"]*))?|[^"]*)"`
+	quoted        = `"(?<%v>(?:[^"\\]|\\.)*)"`
+	durationRegex = `(?: (?<duration>[0-9]+(?:\.[0-9]+)?))?`
+	commonRegex   = `^(?<client>\S+) (?<ident>\S+) (?<auth>\S+) \[(?<timestamp>[^\]]+)\] ` + requestRegex + ` (?<status>[0-9]{3}) (?<bytes>[0-9]+|-)`
+)
+
+// HAProxy's 'option httplog' format, optionally after the syslog prefix. The timers are in milliseconds, -1 if the phase was not reached.
+// 'option logasap' prefixes tt, bytes, and retries with '+'.
+const haproxyRegex = `^(?:.*?: )?(?<client>[^ :]+):(?<client_port>[0-9]+) \[(?<timestamp>[^\]]+)\] (?<frontend>\S+) (?<backend>[^ /]+)/(?<server>\S+) ` +
+	`(?<tq>-?[0-9]+)/(?<tw>-?[0-9]+)/(?<tc>-?[0-9]+)/(?<tr>-?[0-9]+)/\+?(?<tt>-?[0-9]+) (?<status>-?[0-9]+) \+?(?<bytes>[0-9]+) ` +
+	`(?<request_cookie>\S+) (?<response_cookie>\S+) (?<termination_state>\S+) ` +
+	`(?<actconn>[0-9]+)/(?<feconn>[0-9]+)/(?<beconn>[0-9]+)/(?<srv_conn>[0-9]+)/\+?(?<retries>[0-9]+) (?<srv_queue>[0-9]+)/(?<backend_queue>[0-9]+)` +
+	`(?: \{(?<request_headers>[^}]*)\})?(?: \{(?<response_headers>[^}]*)\})? ` + requestRegex + `.*$`
+
+// Envoy's default access log format. duration and upstream_service_time are in milliseconds.
+const envoyRegex = `^\[(?<timestamp>[^\]]+)\] ` + requestRegex + ` (?<status>[0-9]+) (?<response_flags>\S+) (?<bytes_received>[0-9]+) (?<bytes_sent>[0-9]+) ` +
+	`(?<duration>[0-9]+) (?<upstream_service_time>[0-9]+|-) "(?<forwarded_for>[^"]*)" "(?<agent>[^"]*)" "(?<request_id>[^"]*)" "(?<authority>[^"]*)" "(?<upstream_host>[^"]*)".*$`
+
+// A fully qualified class name ending with Exception, Error, or Throwable, like java.lang.IllegalStateException.
+const javaExceptionClass = `[a-zA-Z_$][\w$]*(?:\.[a-zA-Z_$][\w$]*)+(?:Exception|Error|Throwable)\b`
+
+// For records joined with the java_exception multiline preset. The exception is the first exception in the record,
+// the root cause is the last 'Caused by'.
+const javaExceptionRegex = `(?<exception>` + javaExceptionClass + `)(?::[ ]*(?<message>[^\n]*))?(?:[\s\S]*\nCaused by: (?<root_cause>` + javaExceptionClass + `))?`
+
+// Unified GC logging (JDK 9 and later), like '[0.123s][info][gc] GC(3) Pause Young (Normal) (G1 Evacuation Pause) 24M->4M(256M) 4.645ms'.
+// The heap sizes are in heap_unit, which is usually M.
+const jvmGcRegex = `GC\((?<gc_id>[0-9]+)\) (?<pause>Pause [^(]*?)(?: \((?<reason>[^)]*)\))* ` +
+	`(?<heap_before>[0-9]+)(?<heap_unit>[KMG])->(?<heap_after>[0-9]+)[KMG]\((?<heap_total>[0-9]+)[KMG]\) (?<pause_ms>[0-9]+(?:\.[0-9]+)?)ms`
+
+// Legacy GC logging (-XX:+PrintGC or -XX:+PrintGCDetails, JDK 8 and earlier), like
+// '[GC (Allocation Failure) [PSYoungGen: 33280K->4096K(38400K)] 33280K->4112K(125952K), 0.0050000 secs]'.
+// Heap sizes are in K. With -XX:+PrintGCDetails, the generations are skipped, and the heap is the total heap.
+const jvmGcLegacyRegex = `\[(?<pause>GC|Full GC) \((?<reason>[^)]*)\)(?:[\s\S]*?\])? +` +
+	`(?<heap_before>[0-9]+)K->(?<heap_after>[0-9]+)K\((?<heap_total>[0-9]+)K\)[\s\S]*?, (?<pause_seconds>[0-9]+(?:\.[0-9]+)?) secs\]`
+
+var httpLabels = []Label{
+	{GrokFieldName: "method", PrometheusLabel: "method"},
+	{GrokFieldName: "status", PrometheusLabel: "status"},
+}
+
+var presets = map[string]preset{
+	// LogFormat "%h %l %u %t \"%r\" %>s %b" common
+	"apache_common": {
+		match:  commonRegex + durationRegex + `.*$`,
+		labels: httpLabels,
+	},
+	// LogFormat "%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-agent}i\"" combined
+	"apache_combined": {
+		match:  commonRegex + ` ` + fmt.Sprintf(quoted, "referrer") + ` ` + fmt.Sprintf(quoted, "agent") + durationRegex + `.*$`,
+		labels: httpLabels,
+	},
+	// log_format combined '$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"'
+	"nginx_default": {
+		match:  commonRegex + ` ` + fmt.Sprintf(quoted, "referrer") + ` ` + fmt.Sprintf(quoted, "agent") + durationRegex + `.*$`,
+		labels: httpLabels,
+	},
+	"haproxy_http": {
+		match: haproxyRegex,
+		labels: []Label{
+			{GrokFieldName: "backend", PrometheusLabel: "backend"},
+			{GrokFieldName: "status", PrometheusLabel: "status"},
+		},
+	},
+	"envoy_default": {
+		match:  envoyRegex,
+		labels: httpLabels,
+	},
+	"java_exception": {
+		match: javaExceptionRegex,
+		labels: []Label{
+			{GrokFieldName: "exception", PrometheusLabel: "exception"},
+		},
+	},
+	"jvm_gc": {
+		match: jvmGcRegex,
+		labels: []Label{
+			{GrokFieldName: "pause", PrometheusLabel: "pause"},
+		},
+	},
+	"jvm_gc_legacy": {
+		match: jvmGcLegacyRegex,
+		labels: []Label{
+			{GrokFieldName: "pause", PrometheusLabel: "pause"},
+		},
+	},
+}
+
+// Multiline presets define how lines are joined into records.
+var multilinePresets = map[string]MultilineConfig{
+	// Stack frames and suppressed exceptions are indented. The exception itself is logged in the line after the log message.
+	"java_exception": {Continuation: `^(?:\s|Caused by: |` + javaExceptionClass + `(?::|$))`},
+	// Records start with a date stamp (-XX:+PrintGCDateStamps), an uptime (legacy logs), or '[' (unified logging, JDK 9 and later).
+	"jvm_gc": {Start: `^(?:[0-9]{4}-[0-9]{2}-[0-9]{2}T|[0-9]+\.[0-9]+: |\[)`},
+}
+
+func (c *MultilineConfig) applyPreset() {
+	p, exists := multilinePresets[c.Preset]
+	if !exists || c.Start != "" || c.Continuation != "" {
+		return
+	}
+	c.Start, c.Continuation = p.Start, p.Continuation
+}
+
+func (c *MultilineConfig) validatePreset() error {
+	p, exists := multilinePresets[c.Preset]
+	switch {
+	case !exists:
+		names := make([]string, 0, len(multilinePresets))
+		for name := range multilinePresets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("Unknown 'input.multiline.preset': '%v'. Expecting one of %v.", c.Preset, strings.Join(names, ", "))
+	case c.Start != p.Start || c.Continuation != p.Continuation:
+		return fmt.Errorf("'input.multiline.start' and 'input.multiline.continuation' cannot be used together with 'input.multiline.preset'.")
+	}
+	return nil
+}
+
+// applyPreset sets the match expression and the default labels. Unknown presets are reported in validatePreset().
+func (c *MetricConfig) applyPreset() {
+	p, exists := presets[c.Preset]
+	if !exists || c.Match != "" {
+		return
+	}
+	c.Match = p.match
+	if c.Labels == nil {
+		c.Labels = append([]Label{}, p.labels...)
+	}
+}
+
+func (c *MetricConfig) validatePreset() error {
+	p, exists := presets[c.Preset]
+	switch {
+	case !exists:
+		return fmt.Errorf("Metric %v: Unknown 'metrics.preset': '%v'. Expecting one of %v.", c.Name, c.Preset, strings.Join(presetNames(), ", "))
+	case c.Match != p.match:
+		return fmt.Errorf("Metric %v: 'metrics.match' cannot be used together with 'metrics.preset'.", c.Name)
+	}
+	return nil
+}
+
+func presetNames() []string {
+	result := make([]string, 0, len(presets))
+	for name := range presets {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
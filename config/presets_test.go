@@ -0,0 +1,113 @@
+package config
+
+import (
+	"github.com/fstab/grok_exporter/regex"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	for _, test := range []struct {
+		preset   string
+		line     string
+		expected map[string]string
+	}{
+		{
+			preset:   "apache_common",
+			line:     `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			expected: map[string]string{"client": "127.0.0.1", "auth": "frank", "method": "GET", "path": "/apache_pb.gif", "status": "200", "bytes": "2326", "duration": ""},
+		},
+		{
+			preset:   "apache_combined",
+			line:     `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "POST /login HTTP/1.1" 302 - "http://example.com/" "Mozilla/5.0 \"X\"" 0.012`,
+			expected: map[string]string{"method": "POST", "status": "302", "bytes": "-", "referrer": "http://example.com/", "agent": `Mozilla/5.0 \"X\"`, "duration": "0.012"},
+		},
+		{
+			preset:   "nginx_default",
+			line:     `10.0.0.1 - - [10/Oct/2000:13:55:36 +0000] "-" 400 0 "-" "-"`,
+			expected: map[string]string{"client": "10.0.0.1", "method": "", "status": "400", "bytes": "0"},
+		},
+		{
+			preset:   "haproxy_http",
+			line:     `Feb  6 12:14:14 localhost haproxy[14389]: 10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {1wt.eu} {} "GET /index.html HTTP/1.1"`,
+			expected: map[string]string{"client": "10.0.1.2", "backend": "static", "server": "srv1", "tq": "10", "tw": "0", "tc": "30", "tr": "69", "tt": "109", "status": "200", "bytes": "2750", "request_headers": "1wt.eu", "method": "GET", "path": "/index.html"},
+		},
+		{
+			preset:   "haproxy_http",
+			line:     `10.0.1.2:33319 [06/Feb/2009:12:12:51.443] www www/<NOSRV> -1/-1/-1/-1/+8002 503 +115 - - SC-- 202/202/202/0/0 0/0 "GET /v HTTP/1.1"`,
+			expected: map[string]string{"server": "<NOSRV>", "tq": "-1", "tt": "8002", "status": "503", "bytes": "115", "termination_state": "SC--", "request_headers": ""},
+		},
+		{
+			preset:   "envoy_default",
+			line:     `[2016-04-15T20:17:00.310Z] "POST /api/v1/locations HTTP/2" 204 - 154 0 226 100 "10.0.35.28" "nsq2http" "cc21d9b0-cf5c-432b-8c7e-98aeb7988cd2" "locations" "tcp://10.0.2.1:80"`,
+			expected: map[string]string{"method": "POST", "status": "204", "response_flags": "-", "bytes_received": "154", "bytes_sent": "0", "duration": "226", "upstream_service_time": "100", "authority": "locations", "upstream_host": "tcp://10.0.2.1:80"},
+		},
+		{
+			preset:   "java_exception",
+			line:     "12:00:00 ERROR c.e.ErrorHandler - Request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Foo.bar(Foo.java:12)\nCaused by: java.lang.RuntimeException: x\nCaused by: java.io.IOException: closed\n\t... 3 more",
+			expected: map[string]string{"exception": "java.lang.IllegalStateException", "message": "boom", "root_cause": "java.io.IOException"},
+		},
+		{
+			preset:   "jvm_gc",
+			line:     "[0.123s][info][gc] GC(3) Pause Young (Normal) (G1 Evacuation Pause) 24M->4M(256M) 4.645ms",
+			expected: map[string]string{"gc_id": "3", "pause": "Pause Young", "reason": "G1 Evacuation Pause", "heap_before": "24", "heap_unit": "M", "heap_after": "4", "heap_total": "256", "pause_ms": "4.645"},
+		},
+		{
+			preset:   "jvm_gc_legacy",
+			line:     "2019-01-01T12:00:00.000+0000: 1.234: [GC (Allocation Failure) [PSYoungGen: 33280K->4096K(38400K)] 33280K->4112K(125952K), 0.0050000 secs] [Times: user=0.01 sys=0.00, real=0.01 secs]",
+			expected: map[string]string{"pause": "GC", "reason": "Allocation Failure", "heap_before": "33280", "heap_after": "4112", "heap_total": "125952", "pause_seconds": "0.0050000"},
+		},
+		{
+			preset:   "jvm_gc_legacy",
+			line:     "[Full GC (Ergonomics) [PSYoungGen: 4096K->0K(38400K)] [ParOldGen: 16K->3960K(87552K)] 4112K->3960K(125952K), [Metaspace: 2780K->2780K(1056768K)], 0.0100000 secs]",
+			expected: map[string]string{"pause": "Full GC", "reason": "Ergonomics", "heap_before": "4112", "heap_after": "3960", "pause_seconds": "0.0100000"},
+		},
+		{
+			preset:   "jvm_gc_legacy",
+			line:     "1.234: [GC (Allocation Failure)  33280K->4112K(125952K), 0.0050000 secs]",
+			expected: map[string]string{"pause": "GC", "heap_before": "33280", "heap_after": "4112", "pause_seconds": "0.0050000"},
+		},
+	} {
+		regex := regex.MustCompile(presets[test.preset].match)
+		found := false
+		regex.GsubFunc(test.line, func(_ string, captures map[string]string) string {
+			found = true
+			for field, expected := range test.expected {
+				if captures[field] != expected {
+					t.Errorf("%v: Expected %v to be %q, but got %q.", test.preset, field, expected, captures[field])
+				}
+			}
+			return ""
+		})
+		if !found {
+			t.Errorf("%v: Expected %q to match.", test.preset, test.line)
+		}
+	}
+}
+
+func TestPresetConfig(t *testing.T) {
+	cfg, err := LoadConfigString([]byte(`
+metrics:
+    - type: counter
+      name: http_requests_total
+      help: HTTP requests.
+      preset: nginx_default
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	m := (*cfg.Metrics)[0]
+	if m.Match != presets["nginx_default"].match || len(m.Labels) != 2 {
+		t.Errorf("Expected the preset's match and labels, but got %v and %v.", m.Match, m.Labels)
+	}
+	_, err = LoadConfigString([]byte(`
+metrics:
+    - type: counter
+      name: http_requests_total
+      help: HTTP requests.
+      preset: nginx_default
+      match: 'GET'
+`))
+	if err == nil {
+		t.Error("Expected error for preset together with match.")
+	}
+}
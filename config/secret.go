@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Credentials should not be stored inline in config files that are committed to version control.
+// Each credential-bearing config field 'x' therefore has two variants: 'x_file' is the path to a file
+// containing the secret, and 'x_env' is the name of an environment variable containing the secret.
+// At most one of the three may be configured.
+
+func validateSecret(name, value, file, env string) error {
+	count := 0
+	for _, s := range []string{value, file, env} {
+		if s != "" {
+			count++
+		}
+	}
+	if count > 1 {
+		return fmt.Errorf("Invalid configuration: Only one of '%v', '%v_file', and '%v_env' can be configured.", name, name, name)
+	}
+	return nil
+}
+
+func readSecret(name, value, file, env string) (string, error) {
+	switch {
+	case file != "":
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read '%v_file' %v: %v", name, file, err.Error())
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case env != "":
+		result, exists := os.LookupEnv(env)
+		if !exists {
+			return "", fmt.Errorf("Failed to read '%v_env': Environment variable %v is not set.", name, env)
+		}
+		return result, nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Masterminds/sprig"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+)
+
+// LoadConfigTemplate is like LoadConfigFile, but the config file is processed as a Go template before it is parsed.
+// The template can use the sprig functions, and the content of the values file is available as {{ .Values }}.
+// The valuesFile is optional. If it is empty, {{ .Values }} is an empty map.
+func LoadConfigTemplate(filename string, valuesFile string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %v: %v", filename, err.Error())
+	}
+	values, err := loadValues(valuesFile)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := expandTemplate(filename, content, values)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %v: %v", filename, err.Error())
+	}
+	cfg, err := LoadConfigString(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %v: %v", filename, err.Error())
+	}
+	cfg.resolvePaths(filepath.Dir(filename))
+	return cfg, nil
+}
+
+func loadValues(valuesFile string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if valuesFile == "" {
+		return values, nil
+	}
+	content, err := ioutil.ReadFile(valuesFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %v: %v", valuesFile, err.Error())
+	}
+	err = yaml.Unmarshal(content, &values)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %v: %v", valuesFile, err.Error())
+	}
+	return values, nil
+}
+
+func expandTemplate(name string, content []byte, values map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(sprig.TxtFuncMap()).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse config template: %v", err.Error())
+	}
+	var result bytes.Buffer
+	err = tmpl.Execute(&result, map[string]interface{}{
+		"Values": values,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to execute config template: %v", err.Error())
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Metrics about the config, following the conventions of Prometheus' own prometheus_config_* metrics,
+// so that a failed reload can be alerted on.
+var (
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "grok_exporter_config_last_reload_successful",
+		Help: "1 if the last attempt to reload the config was successful, 0 if it failed and the previous config is still active.",
+	})
+	configLastReloadTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "grok_exporter_config_last_reload_timestamp_seconds",
+		Help: "Unix timestamp of the last successful config load, either on startup or on reload.",
+	})
+	metricSeriesDesc = prometheus.NewDesc(
+		"grok_exporter_metric_series",
+		"Number of label sets exposed by the metric. Histograms and summaries count once per label set, quantile metrics once per label set and quantile.",
+		[]string{"metric"}, nil)
+)
+
+// registerConfigMetrics registers the config metrics. metricList returns the current metrics, which change when the config is reloaded.
+func registerConfigMetrics(metricList func() []metrics.Metric, now time.Time) {
+	recordReload(true, now)
+	prometheus.MustRegister(configLastReloadSuccessful)
+	prometheus.MustRegister(configLastReloadTimestamp)
+	prometheus.MustRegister(&metricSeriesCollector{metricList: metricList})
+}
+
+func recordReload(successful bool, now time.Time) {
+	if successful {
+		configLastReloadSuccessful.Set(1)
+		configLastReloadTimestamp.Set(float64(now.UnixNano()) / 1e9)
+	} else {
+		configLastReloadSuccessful.Set(0)
+	}
+}
+
+// metricSeriesCollector exposes the number of series of each metric. The series are counted when the metrics are scraped.
+type metricSeriesCollector struct {
+	metricList func() []metrics.Metric
+}
+
+func (c *metricSeriesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metricSeriesDesc
+}
+
+func (c *metricSeriesCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range c.metricList() {
+		ch <- prometheus.MustNewConstMetric(metricSeriesDesc, prometheus.GaugeValue, float64(countSeries(metric)), metric.Name())
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+	"time"
+)
+
+func TestMetricSeriesCollector(t *testing.T) {
+	gauge := metrics.CreateGaugeMetric(&config.MetricConfig{
+		Name:   "queue_size",
+		Help:   "Queue size.",
+		Labels: []config.Label{{GrokFieldName: "queue", PrometheusLabel: "queue"}},
+		Value:  "size",
+	}, regex.MustCompile(`queue (?<queue>\w+) size (?<size>\d+)`), nil)
+	for _, line := range []string{"queue a size 1", "queue b size 2", "queue a size 3"} {
+		gauge.Process(line, nil)
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		(&metricSeriesCollector{metricList: func() []metrics.Metric { return []metrics.Metric{gauge} }}).Collect(ch)
+		close(ch)
+	}()
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if metric.Label[0].GetValue() != "queue_size" || metric.Gauge.GetValue() != 2 {
+			t.Errorf("Expected 2 series for queue_size, but got %v.", metric)
+		}
+	}
+}
+
+func TestRecordReload(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	recordReload(true, now)
+	recordReload(false, now.Add(time.Minute))
+	if successful := gaugeValue(t, configLastReloadSuccessful); successful != 0 {
+		t.Errorf("Expected the last reload to be unsuccessful, but got %v.", successful)
+	}
+	if timestamp := gaugeValue(t, configLastReloadTimestamp); timestamp != 1500000000 {
+		t.Errorf("Expected the timestamp of the last successful reload, but got %v.", timestamp)
+	}
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	var metric dto.Metric
+	if err := g.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.Gauge.GetValue()
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/mutate"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveMetrics holds the current metrics, which are replaced when the config is reloaded.
+type liveMetrics struct {
+	mutex  sync.Mutex
+	cfg    *config.Config
+	all    []metrics.Metric // in the same order as cfg.Metrics
+	global []metrics.Metric // the registered metrics including companions, without tenants' metrics
+	// metric -> channel stopping its 'reset_schedule', only used by the goroutine processing the log lines
+	schedules map[metrics.Metric]chan struct{}
+}
+
+func (l *liveMetrics) globalMetrics() []metrics.Metric {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.global
+}
+
+// helpTexts returns the help texts of the global metrics and their companions by name.
+func (l *liveMetrics) helpTexts() map[string]string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	result := make(map[string]string, len(l.global))
+	for i, m := range *l.cfg.Metrics {
+		result[m.Name] = m.Help
+		for _, companion := range metrics.Companions(l.all[i]) {
+			result[companion.Name()] = m.SumHelp()
+		}
+	}
+	return result
+}
+
+// expiring returns the metrics with a 'retention'.
+func (l *liveMetrics) expiring() []metrics.Metric {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	result := make([]metrics.Metric, 0)
+	for i, m := range *l.cfg.Metrics {
+		if m.Retention > 0 {
+			result = append(result, l.all[i])
+		}
+	}
+	return result
+}
+
+// configReloader re-reads the config file on SIGHUP or POST /-/reload. Metrics that did not change keep their values,
+// new and changed metrics start from zero, and removed metrics are unregistered.
+// Changes to other sections than 'metrics', 'mappings', and 'grok.patterns' require a restart, so the reload is rejected.
+type configReloader struct {
+	flags       *configFlags
+	live        *liveMetrics
+	patterns    *Patterns
+	stopWatches chan struct{} // closed to stop watching the mapping files of the current config, nil if not watched
+}
+
+// reloadDiff lists the names of the metrics that a reload adds, changes, and removes.
+// Changed metrics are re-created and start from zero.
+type reloadDiff struct {
+	added   []string
+	changed []string
+	removed []string
+}
+
+func (d *reloadDiff) String() string {
+	parts := make([]string, 0, 3)
+	for _, entry := range []struct {
+		name    string
+		metrics []string
+	}{{"added", d.added}, {"changed", d.changed}, {"removed", d.removed}} {
+		if len(entry.metrics) > 0 {
+			parts = append(parts, fmt.Sprintf("%v %v", entry.name, strings.Join(entry.metrics, ", ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "no metrics added, changed, or removed"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// reload must be called from the goroutine processing the log lines, because the metrics of the pipeline are replaced.
+// If the new config is invalid, nothing is changed, and the old config remains active.
+// With dryRun, the new config is validated and the diff is returned, but nothing is changed.
+func (r *configReloader) reload(p *pipeline, dryRun bool) (diff *reloadDiff, err error) {
+	if !dryRun {
+		defer func() {
+			recordReload(err == nil, time.Now())
+		}()
+	}
+	cfg, err := r.flags.load()
+	if err != nil {
+		return nil, err
+	}
+	old := r.live.cfg
+	if changed := old.RestartRequired(cfg); len(changed) > 0 {
+		return nil, fmt.Errorf("Changes to %v require a restart.", strings.Join(changed, ", "))
+	}
+	if cfg.Tenants != nil {
+		return nil, fmt.Errorf("Reloading the config is not supported with 'tenants'.")
+	}
+	mappings, err := buildMappings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := preparePatterns(cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = withMappings(mappings, func() error {
+		return runTestLines(cfg, patterns)
+	})
+	if err != nil {
+		return nil, err
+	}
+	router, err := newRouter(cfg, patterns)
+	if err != nil {
+		return nil, err
+	}
+	oldIndex := make(map[string]int, len(*old.Metrics))
+	for i, m := range *old.Metrics {
+		oldIndex[m.Name] = i
+	}
+	result := make([]metrics.Metric, len(*cfg.Metrics))
+	created := make([]bool, len(*cfg.Metrics))
+	applied := false
+	defer func() {
+		// Unless the new metrics are active, they are discarded, so their goroutines must not keep running.
+		if !applied {
+			for i, metric := range result {
+				if created[i] && metric != nil {
+					metric.Stop()
+				}
+			}
+		}
+	}()
+	for i, m := range *cfg.Metrics {
+		if m.Type == "derived" {
+			continue // created below, when all source metrics exist
+		}
+		if j, exists := oldIndex[m.Name]; exists && m.Equals((*old.Metrics)[j]) && sameExpressions(m, r.patterns, patterns) {
+			result[i] = r.live.all[j]
+			continue
+		}
+		result[i], err = createMetric(m, patterns)
+		if err != nil {
+			return nil, err
+		}
+		created[i] = true
+	}
+	for i, m := range *cfg.Metrics {
+		if m.Type != "derived" {
+			continue
+		}
+		for k, source := range *cfg.Metrics {
+			if source.Name != m.Source { // already validated in config
+				continue
+			}
+			if j, exists := oldIndex[m.Name]; exists && m.Equals((*old.Metrics)[j]) && !created[k] {
+				result[i] = r.live.all[j]
+			} else {
+				result[i], created[i] = metrics.CreateDerivedMetric(m, result[k], source), true
+			}
+		}
+	}
+	diff = &reloadDiff{}
+	newNames := make(map[string]bool, len(*cfg.Metrics))
+	for i, m := range *cfg.Metrics {
+		newNames[m.Name] = true
+		if _, exists := oldIndex[m.Name]; exists && created[i] {
+			diff.changed = append(diff.changed, m.Name)
+		} else if !exists {
+			diff.added = append(diff.added, m.Name)
+		}
+	}
+	for _, m := range *old.Metrics {
+		if !newNames[m.Name] {
+			diff.removed = append(diff.removed, m.Name)
+		}
+	}
+	if dryRun {
+		return diff, nil
+	}
+	global := make([]metrics.Metric, 0, len(result))
+	for _, metric := range result {
+		global = append(global, metric)
+		global = append(global, metrics.Companions(metric)...)
+	}
+	err = reregister(r.live.global, global)
+	if err != nil {
+		return nil, err
+	}
+	replaced := r.live.all
+	r.live.mutex.Lock()
+	r.live.cfg, r.live.all, r.live.global = cfg, result, global
+	r.live.mutex.Unlock()
+	applied = true
+	r.patterns = patterns
+	mutate.SetMappings(mappings)
+	r.restartMappingWatches(cfg)
+	p.metrics, p.router = result, router
+	r.stopReplaced(replaced, result)
+	if p.reloader != nil {
+		p.reloader, err = newPatternReloader(cfg, patterns, result)
+		if err != nil {
+			return nil, err // cannot happen, the expressions were expanded in preparePatterns()
+		}
+	}
+	if r.live.schedules == nil {
+		r.live.schedules = make(map[metrics.Metric]chan struct{})
+	}
+	for i, m := range *cfg.Metrics {
+		if !created[i] {
+			continue // the metric was kept, and its schedule is still running
+		}
+		if stop := startResetSchedule(m, result[i]); stop != nil {
+			r.live.schedules[result[i]] = stop
+		}
+	}
+	startRetentionSweep(cfg, r.live.expiring)
+	fmt.Fprintf(os.Stderr, "Reloaded the config: %v metrics, %v.\n", len(result), diff)
+	return diff, nil
+}
+
+// restartMappingWatches stops watching the mapping files of the old config, whose tables are no longer registered,
+// and watches the files of the registered tables.
+func (r *configReloader) restartMappingWatches(cfg *config.Config) {
+	if r.stopWatches == nil {
+		return // the files are not watched, like in the tests
+	}
+	close(r.stopWatches)
+	r.stopWatches = make(chan struct{})
+	err := watchMappings(cfg, r.stopWatches)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: The mapping files are not reloaded when they change: %v\n", err.Error())
+	}
+}
+
+// stopReplaced stops the goroutines of the old metrics that are not kept in the new metrics, because they were changed or removed.
+func (r *configReloader) stopReplaced(old []metrics.Metric, current []metrics.Metric) {
+	kept := make(map[metrics.Metric]bool, len(current))
+	for _, metric := range current {
+		kept[metric] = true
+	}
+	for _, metric := range old {
+		if kept[metric] {
+			continue
+		}
+		metric.Stop()
+		if stop, exists := r.live.schedules[metric]; exists {
+			close(stop)
+			delete(r.live.schedules, metric)
+		}
+	}
+}
+
+// sameExpressions is true if the metric's match, repeat, and context expressions expand to the same regular expressions with both patterns.
+func sameExpressions(m *config.MetricConfig, oldPatterns *Patterns, newPatterns *Patterns) bool {
+	expressions := []string{m.Match, m.Repeat}
+	if m.Context != nil {
+		expressions = append(expressions, m.Context.Match)
+	}
+	for _, expression := range expressions {
+		a, errA := expand(expression, oldPatterns)
+		b, errB := expand(expression, newPatterns)
+		if errA != nil || errB != nil || a != b {
+			return false
+		}
+	}
+	return true
+}
+
+// reregister unregisters the old metrics that are not in the new metrics, and registers the new metrics that are not in the old metrics.
+// If a new metric cannot be registered, the old metrics are restored.
+func reregister(oldMetrics []metrics.Metric, newMetrics []metrics.Metric) error {
+	oldCollectors := make(map[prometheus.Collector]bool, len(oldMetrics))
+	for _, m := range oldMetrics {
+		oldCollectors[m.Collector()] = true
+	}
+	newCollectors := make(map[prometheus.Collector]bool, len(newMetrics))
+	for _, m := range newMetrics {
+		newCollectors[m.Collector()] = true
+	}
+	removed := make([]prometheus.Collector, 0)
+	for _, m := range oldMetrics {
+		if !newCollectors[m.Collector()] {
+			prometheus.Unregister(m.Collector())
+			removed = append(removed, m.Collector())
+		}
+	}
+	added := make([]prometheus.Collector, 0)
+	for _, m := range newMetrics {
+		if oldCollectors[m.Collector()] {
+			continue
+		}
+		if err := prometheus.Register(m.Collector()); err != nil {
+			for _, c := range added {
+				prometheus.Unregister(c)
+			}
+			for _, c := range removed {
+				prometheus.MustRegister(c)
+			}
+			return fmt.Errorf("Failed to register metric %v: %v", m.Name(), err.Error())
+		}
+		added = append(added, m.Collector())
+	}
+	return nil
+}
+
+// reloadRequest is sent to the goroutine processing the log lines, which sets the result and closes done.
+type reloadRequest struct {
+	dryRun bool
+	diff   *reloadDiff
+	err    error
+	done   chan struct{}
+}
+
+// reloadConfig must be called from the goroutine processing the log lines.
+func (p *pipeline) reloadConfig(req *reloadRequest) {
+	req.diff, req.err = p.configReloader.reload(p, req.dryRun)
+	close(req.done)
+}
+
+// requestReload asks the goroutine processing the log lines to reload the config, and waits for the result.
+func (p *pipeline) requestReload(dryRun bool) (*reloadDiff, error) {
+	req := &reloadRequest{dryRun: dryRun, done: make(chan struct{})}
+	p.configReloads <- req
+	<-req.done
+	return req.diff, req.err
+}
+
+// reloadHandler serves POST /-/reload if 'server.enable_reload' is true.
+// With ?dry_run=true, the config is validated and the diff is reported, but not applied.
+func reloadHandler(p *pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Use POST to reload the config.", http.StatusMethodNotAllowed)
+			return
+		}
+		dryRun := false
+		if param := r.URL.Query().Get("dry_run"); param != "" {
+			var err error
+			dryRun, err = strconv.ParseBool(param)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid parameter 'dry_run': %v is not a boolean.", param), http.StatusBadRequest)
+				return
+			}
+		}
+		diff, err := p.requestReload(dryRun)
+		switch {
+		case err != nil && dryRun:
+			http.Error(w, "The config is invalid: "+err.Error(), http.StatusInternalServerError)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Failed to reload the config, keeping the previous config: %v\n", err.Error())
+			http.Error(w, "Failed to reload the config: "+err.Error(), http.StatusInternalServerError)
+		case dryRun:
+			fmt.Fprintf(w, "The config is valid, but was not applied: %v.\n", diff)
+		default:
+			fmt.Fprintf(w, "Reloaded the config: %v.\n", diff)
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/mutate"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const reloadConfig = `
+input:
+    type: stdin
+grok:
+    patterns: ['WORD \w+']
+metrics:
+    - type: counter
+      name: reload_errors_total
+      help: Errors.
+      match: 'ERROR %{WORD:service}'
+      labels:
+          - grok_field_name: service
+            prometheus_label: service
+    - type: counter
+      name: reload_warnings_total
+      help: Warnings.
+      match: 'WARN'
+      labels: []
+`
+
+func TestConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(reloadConfig)
+	template, values := false, ""
+	flags := &configFlags{path: &path, template: &template, values: &values}
+	cfg, patterns, metricList, err := initialize(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, _, err := registerMetrics(cfg, metricList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := &liveMetrics{cfg: cfg, all: metricList, global: global}
+	defer func() { reregister(live.global, nil) }()
+	r := &configReloader{flags: flags, live: live, patterns: patterns}
+	p := &pipeline{metrics: metricList}
+	errors := metricList[0]
+
+	writeConfig(strings.Replace(reloadConfig, "match: 'WARN'", "match: 'WARNING'", 1) + `
+    - type: counter
+      name: reload_fatal_total
+      help: Fatal errors.
+      match: 'FATAL'
+      labels: []
+`)
+	diff, err := r.reload(p, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	if len(p.metrics) != 3 || p.metrics[0] != errors || p.metrics[1] == metricList[1] || p.metrics[2].Name() != "reload_fatal_total" {
+		t.Errorf("Expected the unchanged metric to be kept, and the changed and new metrics to be created, but got %v.", p.metrics)
+	}
+	if !p.metrics[1].Matches("WARNING") || p.metrics[1].Matches("WARN") {
+		t.Error("Expected the changed match expression to be active.")
+	}
+	if diff.String() != "added reload_fatal_total; changed reload_warnings_total" {
+		t.Errorf("Unexpected diff: %v", diff)
+	}
+
+	writeConfig(reloadConfig)
+	p.configReloader, p.configReloads = r, make(chan *reloadRequest)
+	go func() {
+		p.reloadConfig(<-p.configReloads)
+	}()
+	recorder := httptest.NewRecorder()
+	reloadHandler(p).ServeHTTP(recorder, httptest.NewRequest("POST", "/-/reload?dry_run=true", nil))
+	if body := recorder.Body.String(); recorder.Code != 200 || !strings.Contains(body, "changed reload_warnings_total; removed reload_fatal_total") {
+		t.Errorf("Expected the diff of the dry run, but got %v %q.", recorder.Code, body)
+	}
+	if len(p.metrics) != 3 {
+		t.Error("Expected the dry run not to apply the config.")
+	}
+
+	writeConfig(strings.Replace(reloadConfig, "type: stdin", "type: file\n    path: /var/log/app.log", 1))
+	if _, err := r.reload(p, false); err == nil || !strings.Contains(err.Error(), "Changes to input require a restart") {
+		t.Errorf("Expected an error for a changed input, but got %v.", err)
+	}
+	writeConfig(strings.Replace(reloadConfig, "%{WORD:service}", "%{UNDEFINED:service}", 1))
+	if _, err := r.reload(p, false); err == nil {
+		t.Error("Expected an error for an undefined pattern.")
+	}
+	if len(p.metrics) != 3 || p.metrics[0] != errors {
+		t.Error("Expected the previous metrics to remain active after a failed reload.")
+	}
+	if successful := gaugeValue(t, configLastReloadSuccessful); successful != 0 {
+		t.Errorf("Expected grok_exporter_config_last_reload_successful 0 after a failed reload, but got %v.", successful)
+	}
+}
+
+func TestConfigReloadMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mappingConfig := reloadConfig + `
+mappings:
+    reload_severity:
+        values:
+            WARN: warning
+`
+	writeConfig(mappingConfig)
+	template, values := false, ""
+	flags := &configFlags{path: &path, template: &template, values: &values}
+	cfg, patterns, metricList, err := initialize(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, _, err := registerMetrics(cfg, metricList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := &liveMetrics{cfg: cfg, all: metricList, global: global}
+	defer func() { reregister(live.global, nil) }()
+	r := &configReloader{flags: flags, live: live, patterns: patterns}
+	p := &pipeline{metrics: metricList}
+	registered, _ := mutate.RegisteredMapping("reload_severity")
+
+	writeConfig(strings.Replace(mappingConfig, "WARN: warning", "WARN: warn", 1))
+	if _, err = r.reload(p, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	if current, _ := mutate.RegisteredMapping("reload_severity"); current != registered || current.Lookup("WARN") != "warning" {
+		t.Error("Expected the dry run not to register the new mapping.")
+	}
+	writeConfig(strings.Replace(strings.Replace(mappingConfig, "WARN: warning", "WARN: warn", 1), "%{WORD:service}", "%{UNDEFINED:service}", 1))
+	if _, err = r.reload(p, false); err == nil {
+		t.Fatal("Expected an error for an undefined pattern.")
+	}
+	if current, _ := mutate.RegisteredMapping("reload_severity"); current != registered || current.Lookup("WARN") != "warning" {
+		t.Error("Expected a failed reload not to register the new mapping.")
+	}
+	writeConfig(strings.Replace(mappingConfig, "WARN: warning", "WARN: warn", 1))
+	if _, err = r.reload(p, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	if current, _ := mutate.RegisteredMapping("reload_severity"); current.Lookup("WARN") != "warn" {
+		t.Error("Expected the reload to register the new mapping.")
+	}
+}
+
+func TestConfigReloadStopsReplacedMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	writeConfig := func(match string) {
+		content := strings.Replace(reloadConfig, "match: 'WARN'", "match: '"+match+"'", 1) + `
+      reset_schedule: '0 0 * * *'
+      notify:
+          url: http://localhost:9/
+    - type: derived
+      name: reload_warnings_rate
+      help: Warnings per second.
+      source: reload_warnings_total
+      function: rate
+      window: 1m
+`
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("WARN")
+	template, values := false, ""
+	flags := &configFlags{path: &path, template: &template, values: &values}
+	cfg, patterns, metricList, err := initialize(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, _, err := registerMetrics(cfg, metricList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := &liveMetrics{cfg: cfg, all: metricList, global: global, schedules: startResetSchedules(cfg, metricList)}
+	defer func() { reregister(live.global, nil) }()
+	r := &configReloader{flags: flags, live: live, patterns: patterns}
+	p := &pipeline{metrics: metricList}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		writeConfig(fmt.Sprintf("WARN%v", i))
+		if _, err := r.reload(p, i%2 == 0); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+	}
+	time.Sleep(50 * time.Millisecond) // let the stopped goroutines end
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Errorf("Expected the goroutines of replaced metrics to stop, but the number grew from %v to %v.", before, after)
+	}
+	if len(live.schedules) != 1 {
+		t.Errorf("Expected one reset schedule, but got %v.", len(live.schedules))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// The cgroup file system as seen from within the container.
+const cgroupRoot = "/sys/fs/cgroup"
+
+var (
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grok_exporter_build_info",
+		Help: "A metric with a constant '1' value labeled by version and build date of grok_exporter.",
+	}, []string{"version", "build_date"})
+	maxProcs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "grok_exporter_gomaxprocs",
+		Help: "Number of operating system threads executing Go code simultaneously, see GOMAXPROCS.",
+	})
+)
+
+func registerRuntimeMetrics() {
+	buildInfo.WithLabelValues(VERSION, BUILD_DATE).Set(1)
+	maxProcs.Set(float64(runtime.GOMAXPROCS(0)))
+	prometheus.MustRegister(buildInfo)
+	prometheus.MustRegister(maxProcs)
+}
+
+// adjustMaxProcs limits GOMAXPROCS to the container's CPU quota, rounded up.
+// By default, Go uses one thread per CPU of the host, which makes a container with a CPU limit
+// exhaust its quota early in each period and then get throttled for the rest of the period.
+// An explicit GOMAXPROCS environment variable takes precedence.
+func adjustMaxProcs() {
+	if os.Getenv("GOMAXPROCS") != "" {
+		return
+	}
+	if limit, ok := cpuLimit(cgroupRoot); ok {
+		procs := int(math.Ceil(limit))
+		if procs < runtime.NumCPU() {
+			runtime.GOMAXPROCS(procs)
+		}
+	}
+}
+
+// cpuLimit returns the CPU quota in number of CPUs, or false if there is no quota.
+// Both cgroup v2 (cpu.max) and cgroup v1 (cpu.cfs_quota_us and cpu.cfs_period_us) are supported.
+func cpuLimit(root string) (float64, bool) {
+	if content, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(content)) // "<quota> <period>", quota is "max" if unlimited.
+		if len(fields) != 2 {
+			return 0, false
+		}
+		return quota(fields[0], fields[1])
+	}
+	quotaContent, err := ioutil.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	periodContent, err := ioutil.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	return quota(strings.TrimSpace(string(quotaContent)), strings.TrimSpace(string(periodContent))) // quota is -1 if unlimited.
+}
+
+func quota(quotaString, periodString string) (float64, bool) {
+	q, err := strconv.ParseFloat(quotaString, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	period, err := strconv.ParseFloat(periodString, 64)
+	if err != nil || period <= 0 {
+		return 0, false
+	}
+	return q / period, true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCpuLimit(t *testing.T) {
+	for _, test := range []struct {
+		files    map[string]string
+		expected float64
+		ok       bool
+	}{
+		{map[string]string{"cpu.max": "150000 100000\n"}, 1.5, true},
+		{map[string]string{"cpu.max": "max 100000\n"}, 0, false},
+		{map[string]string{"cpu/cpu.cfs_quota_us": "200000\n", "cpu/cpu.cfs_period_us": "100000\n"}, 2, true},
+		{map[string]string{"cpu/cpu.cfs_quota_us": "-1\n", "cpu/cpu.cfs_period_us": "100000\n"}, 0, false},
+		{map[string]string{}, 0, false},
+	} {
+		root, err := ioutil.TempDir("", "grok_exporter_cgroup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		for name, content := range test.files {
+			path := filepath.Join(root, name)
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		limit, ok := cpuLimit(root)
+		if limit != test.expected || ok != test.ok {
+			t.Errorf("%v: Expected %v %v, but got %v %v.", test.files, test.expected, test.ok, limit, ok)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	start := time.Date(2016, 4, 1, 12, 30, 15, 0, time.UTC) // a Friday
+	for _, test := range []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"0 0 * * *", time.Date(2016, 4, 2, 0, 0, 0, 0, time.UTC)},
+		{"* * * * *", time.Date(2016, 4, 1, 12, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2016, 4, 1, 12, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2016, 4, 1, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1", time.Date(2016, 4, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2016, 4, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 0", time.Date(2016, 4, 3, 0, 0, 0, 0, time.UTC)}, // day of month OR day of week
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	} {
+		schedule, err := Parse(test.expression)
+		if err != nil {
+			t.Fatalf("%v: %v", test.expression, err.Error())
+		}
+		next := schedule.Next(start)
+		if !next.Equal(test.expected) {
+			t.Errorf("%v: Expected %v, but got %v.", test.expression, test.expected, next)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expression := range []string{"", "0 0 * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Expected error for '%v'.", expression)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package datepath
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A date path is a log file path with strftime-style placeholders, like /var/log/app-%Y-%m-%d.log.
+// The placeholders are expanded with the local time, so that the path names the current file.
+//
+// Supported placeholders:
+//   %Y  year, like 2016
+//   %y  year without century, like 16
+//   %m  month, 01-12
+//   %d  day of month, 01-31
+//   %j  day of year, 001-366
+//   %H  hour, 00-23
+//   %%  a literal '%'
+
+// IsTemplate returns true if the path contains placeholders.
+func IsTemplate(path string) bool {
+	return strings.Contains(path, "%")
+}
+
+// Validate returns an error if the path contains an unsupported placeholder.
+func Validate(template string) error {
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			continue
+		}
+		if i+1 == len(template) {
+			return fmt.Errorf("'%v' ends with an incomplete placeholder. Use '%%%%' for a literal '%%'.", template)
+		}
+		i++
+		if !strings.ContainsRune("YymdjH%", rune(template[i])) {
+			return fmt.Errorf("'%v' contains unsupported placeholder '%%%c'. Supported placeholders are %%Y, %%y, %%m, %%d, %%j, %%H, and %%%%.", template, template[i])
+		}
+	}
+	return nil
+}
+
+// Expand replaces the placeholders with the values for t. The template must be valid, see Validate().
+func Expand(template string, t time.Time) string {
+	var result strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' || i+1 == len(template) {
+			result.WriteByte(template[i])
+			continue
+		}
+		i++
+		switch template[i] {
+		case 'Y':
+			fmt.Fprintf(&result, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&result, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&result, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&result, "%02d", t.Day())
+		case 'j':
+			fmt.Fprintf(&result, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&result, "%02d", t.Hour())
+		default:
+			result.WriteByte(template[i])
+		}
+	}
+	return result.String()
+}
+
+// Next returns the next time after t when the expanded path changes:
+// the next full hour if the template contains %H, and the next midnight otherwise.
+func Next(template string, t time.Time) time.Time {
+	if strings.Contains(strings.Replace(template, "%%", "", -1), "%H") {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,47 @@
+package datepath
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpand(t *testing.T) {
+	now := time.Date(2016, 2, 9, 7, 30, 0, 0, time.UTC)
+	for template, expected := range map[string]string{
+		"/var/log/app.log":          "/var/log/app.log",
+		"/var/log/app-%Y-%m-%d.log": "/var/log/app-2016-02-09.log",
+		"/var/log/%y/%j/app-%H.log": "/var/log/16/040/app-07.log",
+		"/var/log/app-100%%-%Y.log": "/var/log/app-100%-2016.log",
+	} {
+		if err := Validate(template); err != nil {
+			t.Fatalf("%v: %v", template, err.Error())
+		}
+		if path := Expand(template, now); path != expected {
+			t.Errorf("%v: Expected %v, but got %v.", template, expected, path)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	now := time.Date(2016, 12, 31, 23, 30, 0, 0, time.UTC)
+	for template, expected := range map[string]time.Time{
+		"/var/log/app-%Y-%m-%d.log":    time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		"/var/log/app-%Y-%m-%d-%H.log": time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		"/var/log/app-%%H-%d.log":      time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		if next := Next(template, now); !next.Equal(expected) {
+			t.Errorf("%v: Expected %v, but got %v.", template, expected, next)
+		}
+	}
+	if next := Next("/var/log/app-%H.log", now.Add(-time.Hour)); !next.Equal(time.Date(2016, 12, 31, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the next full hour, but got %v.", next)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	for _, template := range []string{"/var/log/app-%Q.log", "/var/log/app-%"} {
+		if err := Validate(template); err == nil {
+			t.Errorf("Expected error for '%v'.", template)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A section of the state dump is skipped if it cannot be read within this time,
+// because a hanging pipeline may hold the locks it needs. The dump must not hang as well.
+const dumpSectionTimeout = time.Second
+
+// stateDump is the pipeline's state printed on SIGQUIT before Go's goroutine dump, see dumpOnQuit().
+// All methods are nil-safe, a nil stateDump means the state is not dumped, like in the 'test' and 'bench' commands.
+type stateDump struct {
+	mutex      sync.Mutex
+	queues     []queue
+	processing int64 // unix nanos when processing of the current record started, 0 if idle. Accessed atomically.
+}
+
+// queue is a channel feeding the pipeline. The functions return len() and cap() of the channel, which are safe to call concurrently.
+type queue struct {
+	name     string
+	length   func() int
+	capacity func() int
+}
+
+func newStateDump() *stateDump {
+	return &stateDump{}
+}
+
+// addQueue registers a channel for the dump. The channel must not be used by the dump, so it is only accessed via len() and cap().
+func (d *stateDump) addQueue(name string, length func() int, capacity func() int) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.queues = append(d.queues, queue{name: name, length: length, capacity: capacity})
+}
+
+// startProcessing and stopProcessing mark the time spent in processRecord(), so that a record stuck in a metric is visible.
+func (d *stateDump) startProcessing(now time.Time) {
+	if d != nil {
+		atomic.StoreInt64(&d.processing, now.UnixNano())
+	}
+}
+
+func (d *stateDump) stopProcessing() {
+	if d != nil {
+		atomic.StoreInt64(&d.processing, 0)
+	}
+}
+
+// write prints the queue depths, the input offsets, and the number of series per metric.
+// Each section is read with a timeout, see dumpSectionTimeout.
+func (d *stateDump) write(w io.Writer, p *pipeline, now time.Time) {
+	fmt.Fprintf(w, "=== grok_exporter state at %v ===\n", now.Format(time.RFC3339))
+	if started := atomic.LoadInt64(&d.processing); started > 0 {
+		fmt.Fprintf(w, "processing: busy for %v\n", now.Sub(time.Unix(0, started)))
+	} else {
+		fmt.Fprintf(w, "processing: idle\n")
+	}
+	fmt.Fprintf(w, "queues:\n")
+	d.mutex.Lock()
+	for _, q := range d.queues {
+		fmt.Fprintf(w, "  %v: length=%v capacity=%v\n", q.name, q.length(), q.capacity())
+	}
+	d.mutex.Unlock()
+	fmt.Fprintf(w, "inputs:\n")
+	writeSection(w, func(w io.Writer) {
+		for _, input := range sortedSamples(inputBytesTotal) {
+			fmt.Fprintf(w, "  %v: bytes_read=%v\n", input.label, input.value)
+		}
+	})
+	if p.files != nil {
+		writeSection(w, func(w io.Writer) {
+			writeFileStatus(w, p.files.status())
+		})
+	}
+	if p.fileSet != nil {
+		writeSection(w, func(w io.Writer) {
+			for _, status := range p.fileSet.status() {
+				writeFileStatus(w, status)
+			}
+		})
+	}
+	fmt.Fprintf(w, "metrics:\n")
+	for _, metric := range p.metrics {
+		metric := metric
+		writeSection(w, func(w io.Writer) {
+			fmt.Fprintf(w, "  %v: series=%v\n", metric.Name(), countSeries(metric))
+		})
+	}
+	fmt.Fprintf(w, "=== end of grok_exporter state ===\n")
+}
+
+func writeFileStatus(w io.Writer, status fileInfo) {
+	fmt.Fprintf(w, "  %v: offset=%v size=%v lag=%v state=%v rotations=%v\n", status.Path, status.Offset, status.Size, status.Lag, status.State, status.Rotations)
+}
+
+// writeSection runs f with a timeout. The output is buffered, so that a section is either printed completely or not at all.
+func writeSection(w io.Writer, f func(w io.Writer)) {
+	done := make(chan *bytes.Buffer, 1)
+	go func() {
+		var buf bytes.Buffer
+		f(&buf)
+		done <- &buf
+	}()
+	select {
+	case buf := <-done:
+		buf.WriteTo(w)
+	case <-time.After(dumpSectionTimeout):
+		fmt.Fprintf(w, "  (not available, timed out after %v)\n", dumpSectionTimeout)
+	}
+}
+
+// countSeries is the number of series exposed by the metric's collector. 'per_scrape' metrics are not reset.
+func countSeries(metric metrics.Metric) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		metrics.Snapshot(metric).Collect(ch)
+		close(ch)
+	}()
+	result := 0
+	for range ch {
+		result++
+	}
+	return result
+}
+
+type labeledSample struct {
+	label string
+	value float64
+}
+
+// sortedSamples returns the values of a counter with a single label, sorted by label value.
+func sortedSamples(counter *prometheus.CounterVec) []labeledSample {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		counter.Collect(ch)
+		close(ch)
+	}()
+	result := make([]labeledSample, 0)
+	for m := range ch {
+		var d dto.Metric
+		if m.Write(&d) != nil || len(d.Label) == 0 || d.Counter == nil {
+			continue
+		}
+		result = append(result, labeledSample{label: d.Label[0].GetValue(), value: d.Counter.GetValue()})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].label < result[j].label })
+	return result
+}
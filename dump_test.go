@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateDump(t *testing.T) {
+	d := newStateDump()
+	lines := make(chan string, 2)
+	lines <- "pending"
+	d.addQueue("lines", func() int { return len(lines) }, func() int { return cap(lines) })
+	now := time.Now()
+	d.startProcessing(now.Add(-3 * time.Second))
+	var buf bytes.Buffer
+	d.write(&buf, &pipeline{}, now)
+	for _, expected := range []string{"processing: busy for 3s\n", "  lines: length=1 capacity=2\n", "=== end of grok_exporter state ===\n"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %q in the dump, but got:\n%v", expected, buf.String())
+		}
+	}
+	d.stopProcessing()
+	buf.Reset()
+	d.write(&buf, &pipeline{}, now)
+	if !strings.Contains(buf.String(), "processing: idle\n") {
+		t.Errorf("Expected the pipeline to be idle, but got:\n%v", buf.String())
+	}
+}
+
+func TestWriteSectionTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	hang := make(chan struct{})
+	defer close(hang)
+	writeSection(&buf, func(w io.Writer) {
+		io.WriteString(w, "incomplete")
+		<-hang
+	})
+	if buf.String() != "  (not available, timed out after 1s)\n" {
+		t.Errorf("Unexpected output of a hanging section: %q", buf.String())
+	}
+}
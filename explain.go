@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// namedGroup is a named capturing group in the expanded expression, with the chain of patterns it comes from.
+type namedGroup struct {
+	name string
+	via  []string // like ["%{COMMONAPACHELOG}", "%{WORD:verb}"], empty if the group is written in the expression itself
+}
+
+// Matches the grok patterns %{..} and the named groups (?<name>...) in the order they appear.
+var groupOrPatternRegexp = regexp.MustCompile(PATTERN_RE + `|\(\?<([a-zA-Z0-9_]+)>`)
+
+// namedGroupsOf returns the named groups of the expanded expression in the order they appear.
+// Each group is listed as often as it occurs, so duplicate names can be found.
+func namedGroupsOf(expression string, patterns *Patterns) []namedGroup {
+	return appendNamedGroups(nil, expression, patterns, nil)
+}
+
+func appendNamedGroups(result []namedGroup, expression string, patterns *Patterns, via []string) []namedGroup {
+	for _, match := range groupOrPatternRegexp.FindAllStringSubmatch(expression, -1) {
+		if match[1] == "" {
+			result = append(result, namedGroup{name: match[2], via: via})
+			continue
+		}
+		parts := strings.Split(match[1], ":")
+		chain := append(append(make([]string, 0, len(via)+1), via...), match[0])
+		if len(parts) >= 2 {
+			result = append(result, namedGroup{name: parts[1], via: chain})
+		}
+		definition, exists := patterns.Find(parts[0])
+		if exists && !contains(via, match[0]) { // expand() fails for recursive patterns anyway
+			result = appendNamedGroups(result, definition, patterns, chain)
+		}
+	}
+	return result
+}
+
+// duplicateGroups returns the names occurring more than once, with the number of occurrences.
+func duplicateGroups(groups []namedGroup) map[string]int {
+	counts := make(map[string]int)
+	for _, g := range groups {
+		counts[g.name]++
+	}
+	for name, count := range counts {
+		if count < 2 {
+			delete(counts, name)
+		}
+	}
+	return counts
+}
+
+// explain prints the expansion of the metric's expressions with the origin of each named group, for 'grok_exporter check -explain'.
+func explain(w io.Writer, m *config.MetricConfig, patterns *Patterns) {
+	fmt.Fprintf(w, "Metric %v:\n", m.Name)
+	expressions := [][2]string{{"match", m.Match}, {"repeat", m.Repeat}}
+	if m.Context != nil {
+		expressions = append(expressions, [2]string{"context.match", m.Context.Match})
+	}
+	for _, e := range expressions {
+		if e[1] == "" {
+			continue
+		}
+		fmt.Fprintf(w, "  %v: %v\n", e[0], e[1])
+		expanded, err := expand(e[1], patterns)
+		if err != nil {
+			fmt.Fprintf(w, "    error: %v\n", err.Error())
+			continue
+		}
+		fmt.Fprintf(w, "    expanded: %v\n", expanded)
+		groups := namedGroupsOf(e[1], patterns)
+		duplicates := duplicateGroups(groups)
+		fmt.Fprintf(w, "    named groups: %v\n", len(groups))
+		for _, g := range groups {
+			origin := "written in the expression"
+			if len(g.via) > 0 {
+				origin = "via " + strings.Join(g.via, " -> ")
+			}
+			if duplicates[g.name] > 0 {
+				origin += fmt.Sprintf(" (duplicate, %v groups named %v)", duplicates[g.name], g.name)
+			}
+			fmt.Fprintf(w, "      %v: %v\n", g.name, origin)
+		}
+	}
+}
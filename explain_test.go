@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"github.com/fstab/grok_exporter/config"
+	"strings"
+	"testing"
+)
+
+func TestNamedGroupsOf(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\b\\w+\\b")
+	patterns.AddPattern("REQUEST %{WORD:verb} (?<path>\\S+)")
+	groups := namedGroupsOf("%{REQUEST} %{WORD:verb} (?<status>\\d+)", patterns)
+	names := make([]string, 0, len(groups))
+	for _, g := range groups {
+		names = append(names, g.name)
+	}
+	if strings.Join(names, ",") != "verb,path,verb,status" {
+		t.Fatalf("Unexpected named groups %v.", names)
+	}
+	if via := strings.Join(groups[0].via, " -> "); via != "%{REQUEST} -> %{WORD:verb}" {
+		t.Errorf("Unexpected origin %v of the first group.", via)
+	}
+	if len(groups[3].via) != 0 {
+		t.Errorf("Expected the status group to be written in the expression, but got %v.", groups[3].via)
+	}
+	if duplicates := duplicateGroups(groups); len(duplicates) != 1 || duplicates["verb"] != 2 {
+		t.Errorf("Expected verb to be duplicate, but got %v.", duplicates)
+	}
+	var buf bytes.Buffer
+	explain(&buf, &config.MetricConfig{Name: "requests_total", Match: "%{REQUEST} %{WORD:verb}"}, patterns)
+	for _, expected := range []string{"Metric requests_total:\n", "named groups: 3\n", "verb: via %{REQUEST} -> %{WORD:verb} (duplicate, 2 groups named verb)\n"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %q in the explanation, but got:\n%v", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Package filehelper lets an unprivileged grok_exporter read log files it has no permission for, like /var/log/secure.
+// A small privileged process runs 'grok_exporter helper', which listens on a Unix socket, opens the files it is allowed to open,
+// and passes the open file descriptors to the exporter. The helper never reads the files, and it only opens them read-only.
+package filehelper
+
+import (
+	"github.com/google/mtail/watcher"
+	"github.com/spf13/afero"
+	"os"
+	"path/filepath"
+)
+
+// Allowed is true if the path matches one of the glob patterns. Only absolute, clean paths are allowed, so that '..' cannot escape a pattern.
+func Allowed(patterns []string, path string) bool {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Fs is the file system for the tailer. Files are opened directly if possible, and by the helper if permission is denied.
+type Fs struct {
+	afero.OsFs
+	Socket string
+}
+
+func (fs Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil && os.IsPermission(err) && flag == os.O_RDONLY {
+		return Open(fs.Socket, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Watcher is the file system watcher for the tailer. A file that cannot be read cannot be watched either,
+// but the tailer watches the directory as well, which reports the changes of the files in it.
+type Watcher struct {
+	watcher.Watcher
+}
+
+func (w Watcher) Add(name string) error {
+	err := w.Watcher.Add(name)
+	if err != nil && os.IsPermission(err) {
+		return nil
+	}
+	return err
+}
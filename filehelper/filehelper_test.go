@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package filehelper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	patterns := []string{"/var/log/secure", "/var/log/audit/*.log"}
+	for path, expected := range map[string]bool{
+		"/var/log/secure":             true,
+		"/var/log/audit/audit.log":    true,
+		"/var/log/audit/../../shadow": false,
+		"/var/log/messages":           false,
+		"var/log/secure":              false,
+	} {
+		if Allowed(patterns, path) != expected {
+			t.Errorf("Expected Allowed(%v) to be %v.", path, expected)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secure := filepath.Join(dir, "secure")
+	ioutil.WriteFile(secure, []byte("line 1\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "shadow"), []byte("secret\n"), 0600)
+	os.Symlink(filepath.Join(dir, "shadow"), filepath.Join(dir, "link"))
+	socket := filepath.Join(dir, "helper.sock")
+	server, err := Listen(socket, []string{secure, filepath.Join(dir, "link")}, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve()
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the socket to have mode 0600, but got %v (%v).", info, err)
+	}
+	f, err := Open(socket, secure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "line 1\n" || f.Name() != secure {
+		t.Errorf("Expected to read %v, but got %q (%v).", secure, data, err)
+	}
+	for path, expected := range map[string]string{
+		filepath.Join(dir, "shadow"): "not allowed",
+		filepath.Join(dir, "link"):   "is a link to",
+	} {
+		_, err = Open(socket, path)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q for %v, but got %v.", expected, path, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	secure, other := filepath.Join(dir, "secure"), filepath.Join(dir, "other")
+	ioutil.WriteFile(secure, []byte("line 1\n"), 0600)
+	ioutil.WriteFile(other, []byte("secret\n"), 0600)
+	f, err := os.Open(secure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = verify(f, secure); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err = verify(f, other); err == nil {
+		t.Errorf("Expected an error, because the opened file is not %v.", other)
+	}
+}
@@ -0,0 +1,169 @@
+//go:build !windows
+// +build !windows
+
+package filehelper
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// timeout limits how long a client may take to send the path, and how long the client waits for the file.
+const timeout = 10 * time.Second
+
+// Server opens the allowed files for the clients connecting to the socket.
+// Each connection sends one absolute path terminated by a newline. The response is "OK" with the file descriptor attached,
+// or "ERR" followed by the error message.
+type Server struct {
+	listener *net.UnixListener
+	allowed  []string
+}
+
+// Listen creates the socket with mode 0600, owned by uid and gid, so that only the exporter's user can connect.
+// An existing socket is removed, like one left over after a crash.
+func Listen(socket string, allowed []string, uid int, gid int) (*Server, error) {
+	os.Remove(socket)
+	umask := syscall.Umask(0177) // no other user can connect before the owner is changed
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on %v: %v", socket, err.Error())
+	}
+	err = os.Chown(socket, uid, gid)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Failed to change the owner of %v: %v", socket, err.Error())
+	}
+	return &Server{listener: listener, allowed: allowed}, nil
+}
+
+// Serve handles the connections until the server is closed.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.AcceptUnix()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Server) handle(conn *net.UnixConn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	path, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	path = strings.TrimSuffix(path, "\n")
+	f, err := s.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Refused to open %q: %v\n", path, err.Error())
+		conn.Write([]byte("ERR " + err.Error() + "\n"))
+		return
+	}
+	defer f.Close()
+	conn.WriteMsgUnix([]byte("OK\n"), syscall.UnixRights(int(f.Fd())), nil)
+}
+
+// open opens the file read-only if the path is allowed. Symbolic links are followed only if the target is allowed, too.
+// The links are resolved before the file is opened, so the opened file is verified, because a link could be swapped in meanwhile.
+func (s *Server) open(path string) (*os.File, error) {
+	if !Allowed(s.allowed, path) {
+		return nil, fmt.Errorf("The path is not allowed.")
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	if !Allowed(s.allowed, resolved) {
+		return nil, fmt.Errorf("The path is a link to %v, which is not allowed.", resolved)
+	}
+	f, err := os.OpenFile(resolved, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = verify(f, resolved)
+	var info os.FileInfo
+	if err == nil {
+		info, err = f.Stat()
+	}
+	if err == nil && !info.Mode().IsRegular() {
+		err = fmt.Errorf("The path is not a regular file.")
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// verify checks that the opened file is the file at the resolved path. O_NOFOLLOW only refuses a link as the last element of the path,
+// so a directory in the path could have been replaced by a link to another directory between resolving and opening.
+// /proc/self/fd shows the path of the file that was actually opened. Without /proc, the opened file is compared with the file at the path.
+func verify(f *os.File, resolved string) error {
+	if opened, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%v", f.Fd())); err == nil {
+		if opened != resolved {
+			return fmt.Errorf("The path was changed to %v while it was opened.", opened)
+		}
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	current, err := os.Lstat(resolved)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, current) {
+		return fmt.Errorf("The path was changed while it was opened.")
+	}
+	return nil
+}
+
+// Open asks the helper listening on the socket to open the file.
+func Open(socket string, path string) (*os.File, error) {
+	if strings.Contains(path, "\n") {
+		return nil, fmt.Errorf("Cannot open %q with the helper: The path contains a newline.", path)
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open %v with the helper: %v", path, err.Error())
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err = conn.Write([]byte(path + "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open %v with the helper: %v", path, err.Error())
+	}
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open %v with the helper: %v", path, err.Error())
+	}
+	reply := strings.TrimSuffix(string(buf[:n]), "\n")
+	if reply != "OK" {
+		return nil, fmt.Errorf("Cannot open %v with the helper: %v", path, strings.TrimPrefix(reply, "ERR "))
+	}
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) != 1 {
+		return nil, fmt.Errorf("Cannot open %v with the helper: The response has no file descriptor.", path)
+	}
+	fds, err := syscall.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) != 1 {
+		return nil, fmt.Errorf("Cannot open %v with the helper: The response has no file descriptor.", path)
+	}
+	return os.NewFile(uintptr(fds[0]), path), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// fileStatus is the state of the tailed log file, served at /api/files, so that tools can verify which files are covered.
+// All methods are nil-safe, a nil fileStatus means the input is not a file.
+type fileStatus struct {
+	mutex     sync.Mutex
+	path      string
+	offset    int64       // bytes read from the current file
+	file      os.FileInfo // the file the offset refers to, nil if it did not exist yet
+	rotations int
+	rotated   bool // a rotation was detected, and no line was read since
+}
+
+type fileInfo struct {
+	Path      string `json:"path"`
+	Inode     uint64 `json:"inode,omitempty"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	Lag       int64  `json:"lag"`   // bytes not read yet
+	State     string `json:"state"` // "tailing", "rotated", or "waiting"
+	Rotations int    `json:"rotations"`
+}
+
+// start must be called when reading starts. The offset is at the end of the file, or at the beginning if readall is true.
+func (s *fileStatus) start(readall bool) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if info, err := os.Stat(s.path); err == nil {
+		s.file = info
+		if !readall {
+			s.offset = info.Size()
+		}
+	}
+}
+
+// read counts the bytes of a line read from the file.
+func (s *fileStatus) read(n int) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.offset += int64(n)
+	s.rotated = false
+}
+
+// set is for pull mode, where the reader knows the offset.
+func (s *fileStatus) set(offset int64, file os.FileInfo) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.offset, s.file = offset, file
+}
+
+// follow is called when the tailer switches to another file, like the next day's file of a date path.
+// Reading starts at the beginning of the new file.
+func (s *fileStatus) follow(path string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.path, s.offset, s.file, s.rotated = path, 0, nil, false
+	if info, err := os.Stat(path); err == nil {
+		s.file = info
+	}
+}
+
+// truncated is true if info is the file being read, and it is smaller than the bytes read, because it was truncated.
+func (s *fileStatus) truncated(info os.FileInfo) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file != nil && os.SameFile(info, s.file) && info.Size() < s.offset
+}
+
+// status compares the file at the path with the file that is being read. If the file was rotated,
+// the tailer follows the new file from the start, so the offset is reset. The state is "rotated" until the next line is read,
+// and the offset is approximate until the remaining lines of the old file are read.
+func (s *fileStatus) status() fileInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := fileInfo{Path: s.path, State: "tailing"}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		result.State = "waiting"
+		result.Offset, result.Rotations = s.offset, s.rotations
+		return result
+	}
+	if s.file == nil || !os.SameFile(info, s.file) {
+		if s.file != nil {
+			s.rotations++
+			s.rotated = true
+		}
+		s.file, s.offset = info, 0
+	}
+	if s.rotated {
+		result.State = "rotated"
+	}
+	result.Inode = inode(info)
+	result.Offset, result.Size, result.Rotations = s.offset, info.Size(), s.rotations
+	if result.Size > result.Offset {
+		result.Lag = result.Size - result.Offset
+	}
+	return result
+}
+
+// ServeHTTP serves /api/files. The list is empty if the input is not a file.
+func (s *fileStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	files := make([]fileInfo, 0, 1)
+	if s != nil {
+		files = append(files, s.status())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": files,
+	})
+}
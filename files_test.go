@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+	ioutil.WriteFile(path, []byte("old line\n"), 0644)
+	s := &fileStatus{path: path}
+	s.start(false)
+	appendFile(t, path, "new line\n")
+	expectStatus(t, s, "tailing", 9, 9)
+	s.read(len("new line\n"))
+	expectStatus(t, s, "tailing", 18, 0)
+	os.Rename(path, path+".1")
+	ioutil.WriteFile(path, []byte("rotated\n"), 0644)
+	expectStatus(t, s, "rotated", 0, 8)
+	s.read(len("rotated\n"))
+	expectStatus(t, s, "tailing", 8, 0)
+	os.Remove(path)
+	expectStatus(t, s, "waiting", 8, 0)
+}
+
+func expectStatus(t *testing.T, s *fileStatus, state string, offset int64, lag int64) {
+	status := s.status()
+	if status.State != state || status.Offset != offset || status.Lag != lag {
+		t.Errorf("Expected state %v with offset %v and lag %v, but got %#v.", state, offset, lag, status)
+	}
+}
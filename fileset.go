@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/google/mtail/tailer"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fileSetRescanInterval is the default for 'input.rescan_interval', which is how often the glob patterns are expanded to find new files,
+// and the tailed files are checked for truncation and replacement.
+const fileSetRescanInterval = 10 * time.Second
+
+// Metrics about the files tailed by a fileSet, so that files that are not tailed as expected can be noticed.
+var (
+	filesAttachedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grok_exporter_input_files_attached_total",
+		Help: "Number of times tailing a file matching 'input.path' or 'input.paths' was started. The reason is 'new', 'truncated', or 'replaced'.",
+	}, []string{"reason"})
+	filesDetachedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grok_exporter_input_files_detached_total",
+		Help: "Number of times tailing a file matching 'input.path' or 'input.paths' was stopped. The reason is 'removed', 'truncated', or 'replaced'.",
+	}, []string{"reason"})
+	filesTailed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "grok_exporter_input_files",
+		Help: "Number of files matching 'input.path' or 'input.paths' that are currently tailed.",
+	})
+)
+
+// fileLine is a line read by a fileSet, together with the path of the file.
+type fileLine struct {
+	path string
+	line string
+}
+
+// fileSet tails all files matching 'input.path' and 'input.paths'. Each file has its own tailer, so that the lines
+// can be attributed to the file, and so that a truncated file can be re-read from the start.
+// Rename and create rotation is handled by the tailer. The remaining lines of the renamed file are read,
+// and if the renamed file matches a pattern, too, it is not tailed again.
+// Because the tailer relies on file system events that can be missed, scan re-opens a file that was replaced if the new file grows,
+// but no line of it is read for a whole rescan interval.
+type fileSet struct {
+	cfg   *config.InputConfig
+	lines chan fileLine
+	mutex sync.Mutex
+	files map[string]*tailedFile // by path
+	seen  map[uint64]bool        // inodes of the files that were tailed and still exist
+}
+
+type tailedFile struct {
+	tailer *tailer.Tailer
+	status *fileStatus
+	fields map[string]string // the input fields, including 'logfile'
+	// replacedSize is the size of the new file when scan noticed that the file was replaced and no line of the new file was read yet.
+	// It is -1 if the file was not replaced, or if a line was read since.
+	replacedSize int64
+}
+
+func newFileSet(cfg *config.InputConfig) *fileSet {
+	return &fileSet{
+		cfg:   cfg,
+		lines: make(chan fileLine, queueSize),
+		files: make(map[string]*tailedFile),
+		seen:  make(map[uint64]bool),
+	}
+}
+
+// paths returns the existing files matching the patterns, sorted and without duplicates.
+func (s *fileSet) paths() []string {
+	unique := make(map[string]bool)
+	for _, pattern := range s.cfg.AllPaths() {
+		matches, _ := filepath.Glob(pattern) // patterns are validated in config
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				unique[path] = true
+			}
+		}
+	}
+	result := make([]string, 0, len(unique))
+	for path := range unique {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// rescanInterval is how often scan should be called.
+func (s *fileSet) rescanInterval() time.Duration {
+	if s.cfg.RescanInterval > 0 {
+		return s.cfg.RescanInterval
+	}
+	return fileSetRescanInterval
+}
+
+// scan starts tailing new files, re-reads truncated and missed replaced files from the start, and stops tailing files that were removed.
+// Files found on the first scan are read from the start if readall is true, files found later are always read from the start.
+func (s *fileSet) scan(readall bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	paths := s.paths()
+	current := make(map[string]bool, len(paths))
+	present := make(map[uint64]bool, len(paths))
+	for _, path := range paths {
+		current[path] = true
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		present[inode(info)] = true
+		if file, exists := s.files[path]; exists {
+			s.seen[inode(info)] = true // the file may have been rotated, the tailer follows the new file
+			status := file.status.status()
+			switch {
+			case file.status.truncated(info):
+				fmt.Fprintf(os.Stderr, "%v was truncated. Reading it from the start.\n", path)
+				s.stop(path, "truncated")
+				err = s.start(path, true, "truncated")
+			case status.State != "rotated":
+				file.replacedSize = -1
+			case file.replacedSize < 0:
+				file.replacedSize = status.Size
+			case status.Size > file.replacedSize:
+				fmt.Fprintf(os.Stderr, "%v was replaced, but no line of the new file was read. Reading it from the start.\n", path)
+				s.stop(path, "replaced")
+				err = s.start(path, true, "replaced")
+			}
+		} else if n := inode(info); n == 0 || !s.seen[n] {
+			s.seen[n] = true
+			err = s.start(path, readall, "new")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for path := range s.files {
+		if !current[path] {
+			s.stop(path, "removed")
+		}
+	}
+	// Inode numbers of deleted files are reused, so a new file with the inode of a deleted file must not be taken for a rotated file.
+	for n := range s.seen {
+		if !present[n] {
+			delete(s.seen, n)
+		}
+	}
+	return nil
+}
+
+// stop stops tailing the file. The caller must hold the mutex.
+func (s *fileSet) stop(path string, reason string) {
+	s.files[path].tailer.Close()
+	delete(s.files, path)
+	filesDetachedTotal.WithLabelValues(reason).Inc()
+	filesTailed.Set(float64(len(s.files)))
+}
+
+// start tails the file. The caller must hold the mutex.
+func (s *fileSet) start(path string, readall bool, reason string) error {
+	lines := make(chan string)
+	t, err := newTailer(s.cfg, lines)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize the tail process for %v: %v", path, err.Error())
+	}
+	status := &fileStatus{path: path}
+	status.start(readall)
+	s.files[path] = &tailedFile{
+		tailer:       t,
+		status:       status,
+		fields:       inputFields(s.cfg, path),
+		replacedSize: -1,
+	}
+	filesAttachedTotal.WithLabelValues(reason).Inc()
+	filesTailed.Set(float64(len(s.files)))
+	go func() {
+		// The tailer closes the channel when it is closed.
+		for line := range lines {
+			s.lines <- fileLine{path: path, line: line}
+		}
+	}()
+	go t.Tail(path, readall)
+	return nil
+}
+
+// read counts the bytes of a line read from the file, and returns the input fields for the file.
+func (s *fileSet) read(l fileLine) map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, exists := s.files[l.path]
+	if !exists {
+		return inputFields(s.cfg, l.path) // a remaining line of a file that was removed
+	}
+	file.status.read(lineBytes(l.line))
+	return file.fields
+}
+
+func (s *fileSet) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, file := range s.files {
+		file.tailer.Close()
+	}
+}
+
+// status returns the status of each tailed file, sorted by path.
+func (s *fileSet) status() []fileInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]fileInfo, 0, len(s.files))
+	for _, file := range s.files {
+		result = append(result, file.status.status())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// ServeHTTP serves /api/files.
+func (s *fileSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": s.status(),
+	})
+}
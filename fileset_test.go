@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.log"), []byte("a1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("b1\n"), 0644)
+	s := newFileSet(&config.InputConfig{Type: "file", Path: filepath.Join(dir, "*.log*")})
+	defer s.close()
+	if err := s.scan(true); err != nil {
+		t.Fatal(err)
+	}
+	expectFileLine(t, s, "a.log", "a1")
+	ioutil.WriteFile(filepath.Join(dir, "c.log"), []byte("c1\n"), 0644)
+	s.scan(true)
+	expectFileLine(t, s, "c.log", "c1")
+	// The rotated file matches the pattern, but it was read already.
+	os.Rename(filepath.Join(dir, "c.log"), filepath.Join(dir, "c.log.1"))
+	s.scan(true)
+	if status := s.status(); len(status) != 1 || filepath.Base(status[0].Path) != "a.log" {
+		t.Errorf("Expected only a.log to be tailed, but got %v.", status)
+	}
+	// The inode of a deleted file may be reused by a new file, which must be tailed.
+	info, _ := os.Stat(filepath.Join(dir, "c.log.1"))
+	os.Remove(filepath.Join(dir, "c.log.1"))
+	s.scan(true)
+	if n := inode(info); n != 0 && s.seen[n] {
+		t.Errorf("Expected the inode of the deleted file to be forgotten.")
+	}
+	truncated := counterValue(t, filesAttachedTotal.WithLabelValues("truncated"))
+	ioutil.WriteFile(filepath.Join(dir, "a.log"), []byte("x\n"), 0644) // truncated, shorter than the bytes read
+	s.scan(true)
+	expectFileLine(t, s, "a.log", "x")
+	if after := counterValue(t, filesAttachedTotal.WithLabelValues("truncated")); after != truncated+1 {
+		t.Errorf("Expected %v files attached after truncation, but got %v.", truncated+1, after)
+	}
+}
+
+func TestFileSetReplaced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.log")
+	ioutil.WriteFile(path, []byte("a1\n"), 0644)
+	s := newFileSet(&config.InputConfig{Type: "file", Path: filepath.Join(dir, "*.log"), RescanInterval: time.Minute})
+	defer s.close()
+	if s.rescanInterval() != time.Minute {
+		t.Fatalf("Expected rescan interval 1m, but got %v.", s.rescanInterval())
+	}
+	if err := s.scan(true); err != nil {
+		t.Fatal(err)
+	}
+	expectFileLine(t, s, "a.log", "a1")
+	// Simulate a tailer that missed the replacement, and replace the file.
+	s.files[path].tailer.Close()
+	ioutil.WriteFile(filepath.Join(dir, "new"), []byte("b1\n"), 0644)
+	os.Rename(filepath.Join(dir, "new"), path)
+	s.scan(true) // notices the replacement
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("b2\n")
+	f.Close()
+	replaced := counterValue(t, filesDetachedTotal.WithLabelValues("replaced"))
+	s.scan(true) // the new file grew, but no line was read
+	expectFileLine(t, s, "a.log", "b1")
+	expectFileLine(t, s, "a.log", "b2")
+	if after := counterValue(t, filesDetachedTotal.WithLabelValues("replaced")); after != replaced+1 {
+		t.Errorf("Expected %v files detached after replacement, but got %v.", replaced+1, after)
+	}
+}
+
+func expectFileLine(t *testing.T, s *fileSet, file string, line string) {
+	select {
+	case l := <-s.lines:
+		fields := s.read(l)
+		if filepath.Base(l.path) != file || l.line != line || fields["logfile"] != l.path {
+			t.Errorf("Expected line %q from %v, but got %q from %v with fields %v.", line, file, l.line, l.path, fields)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timeout while waiting for line %q from %v.", line, file)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/text"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// newFlush returns the function pushing the final values of the metrics to the Pushgateway and writing the textfile,
+// as configured in the flush section. metricList returns the current metrics, and help their help texts by name.
+// The function only flushes on the first call. If 'flush.interval' is configured, the metrics are also flushed periodically until the final flush.
+func newFlush(cfg *config.FlushConfig, metricList func() []metrics.Metric, help func() map[string]string) func() {
+	var (
+		mutex sync.Mutex
+		done  bool
+	)
+	flush := func(final bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if done {
+			return
+		}
+		done = final
+		flushMetrics(cfg, metricList(), help())
+	}
+	if cfg.Interval > 0 {
+		go func() {
+			ticker := time.NewTicker(cfg.Interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				flush(false)
+				mutex.Lock()
+				stopped := done
+				mutex.Unlock()
+				if stopped {
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		flush(true)
+	}
+}
+
+// flushMetrics pushes the metrics to the Pushgateway and writes the textfile. Errors are printed, because a failed
+// flush must neither stop the exporter, nor prevent the other destination from being written.
+func flushMetrics(cfg *config.FlushConfig, metricList []metrics.Metric, help map[string]string) {
+	if cfg.Pushgateway != "" {
+		err := pushMetrics(cfg, metricList)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to push the metrics to %v: %v\n", cfg.Pushgateway, err.Error())
+		}
+	}
+	if cfg.Textfile != "" {
+		err := writeTextfile(cfg.Textfile, metricList, help)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write the metrics to %v: %v\n", cfg.Textfile, err.Error())
+		}
+	}
+}
+
+// pushMetrics replaces the metrics of the job and instance on the Pushgateway.
+// Only the configured metrics are pushed, the exporter's own metrics would be stale once the process is gone.
+// The metrics are not collected through the registry, so that a periodic flush does not reset 'per_scrape' metrics.
+func pushMetrics(cfg *config.FlushConfig, metricList []metrics.Metric) error {
+	collectors := make([]prometheus.Collector, 0, len(metricList))
+	for _, m := range metricList {
+		collectors = append(collectors, metrics.Snapshot(m))
+	}
+	return prometheus.PushCollectors(cfg.Job, cfg.Instance, cfg.Pushgateway, collectors...)
+}
+
+// writeTextfile writes the metrics to a temporary file, which is then renamed,
+// so that the node exporter's textfile collector never reads a partially written file.
+func writeTextfile(path string, metricList []metrics.Metric, help map[string]string) error {
+	families, err := collectFamilies(metricList, help, metrics.Snapshot)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		text.MetricFamilyToText(&buf, family)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPeriodicFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter_flush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	textfile := filepath.Join(dir, "grok.prom")
+	var calls int32
+	metricList := func() []metrics.Metric {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+	flush := newFlush(&config.FlushConfig{Textfile: textfile, Interval: 10 * time.Millisecond}, metricList, func() map[string]string { return nil })
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&calls) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the metrics to be flushed periodically.")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := os.Stat(textfile); err != nil {
+		t.Fatalf("Expected %v to be written: %v", textfile, err)
+	}
+	flush()
+	flush()
+	final := atomic.LoadInt32(&calls)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != final {
+		t.Fatalf("Expected no flush after the final flush, but got %v more.", n-final)
+	}
+}
@@ -2,35 +2,93 @@ package main
 
 import (
 	"fmt"
-	"github.com/moovweb/rubex"
+	"github.com/fstab/grok_exporter/regex"
+	"regexp"
 	"strings"
 )
 
+// regexEngine compiles the expanded expressions, see 'grok.regex_engine'. It is set when the config is loaded.
+var regexEngine = regex.DefaultEngine
+
 // Compile a grok pattern string into a regular expression.
-func Compile(pattern string, patterns *Patterns) (*rubex.Regexp, error) {
-	regex, err := expand(pattern, patterns)
+func Compile(pattern string, patterns *Patterns) (regex.Regexp, error) {
+	expanded, err := expand(pattern, patterns)
 	if err != nil {
 		return nil, err
 	}
-	result, err := rubex.CompileWithOption(regex, rubex.ONIG_OPTION_DEFAULT)
+	result, err := regex.Compile(expanded, regexEngine)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to compile pattern %v: Error with regular expression %v: %v", pattern, regex, err.Error())
+		return nil, compileError(pattern, expanded, err, patterns)
 	}
 	return result, nil
 }
 
+// compileError finds the pattern causing the error, so that the user doesn't need to search the expanded regex.
+// The culprit is the innermost referenced pattern that fails to compile on its own.
+// If all referenced patterns compile, the error is in the expression itself, for example a duplicate capture name.
+func compileError(pattern string, expression string, err error, patterns *Patterns) error {
+	chain := failingPatterns(pattern, patterns, nil)
+	if len(chain) == 0 {
+		return fmt.Errorf("Failed to compile pattern %v: Error with regular expression %v: %v", pattern, expression, err.Error())
+	}
+	culprit := chain[len(chain)-1]
+	definition, _ := patterns.Find(culprit)
+	expanded, _ := expand(definition, patterns)
+	_, culpritErr := regex.Compile(expanded, regexEngine)
+	expansions := make([]string, 0, len(chain))
+	for _, name := range chain {
+		expansions = append(expansions, "%{"+name+"}")
+	}
+	position := strings.Index(expression, expanded)
+	return fmt.Errorf("Failed to compile pattern %v: Error in pattern %v (expanded via %v) at position %v of the regular expression %v: %v", pattern, culprit, strings.Join(expansions, " -> "), position, expression, culpritErr.Error())
+}
+
+// failingPatterns returns the chain of pattern names from the first referenced pattern that fails to compile
+// down to the innermost failing pattern, or an empty list if all referenced patterns compile.
+func failingPatterns(expression string, patterns *Patterns, chain []string) []string {
+	for _, name := range referencedPatterns(expression) {
+		definition, exists := patterns.Find(name)
+		if !exists || contains(chain, name) {
+			continue
+		}
+		expanded, err := expand(definition, patterns)
+		if err != nil {
+			continue
+		}
+		compiled, err := regex.Compile(expanded, regexEngine)
+		if err == nil {
+			compiled.Free()
+			continue
+		}
+		return failingPatterns(definition, patterns, append(chain, name))
+	}
+	return chain
+}
+
+func contains(list []string, s string) bool {
+	for _, element := range list {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
+
 // PATTERN_RE matches the %{..} patterns. There are three possibilities:
 // 1) %{USER}               - grok pattern
 // 2) %{IP:clientip}        - grok pattern with name
 // 3) %{INT:clientport:int} - grok pattern with name and type (type is currently ignored)
+// The pattern name may be qualified with a namespace, like %{mycorp.ORDERID}, see Patterns.
 // TODO: Replace with PATTERN_RE from https://github.com/jordansissel/ruby-grok/blob/master/lib/grok-pure.rb
 const PATTERN_RE = `%{(.+?)}`
 
+var patternRegexp = regexp.MustCompile(PATTERN_RE)
+
 // Expand recursively resolves all grok patterns %{..} and returns a regular expression.
 func expand(pattern string, patterns *Patterns) (string, error) {
 	result := pattern
 	for i := 0; i < 1000; i++ { // After 1000 replacements, we assume this is an infinite loop and abort.
-		match := rubex.MustCompile(PATTERN_RE).FindStringSubmatch(result)
+		match := patternRegexp.FindStringSubmatch(result)
 		if match == nil {
 			// No match means all grok patterns %{..} are expanded. We are done.
 			return result, nil
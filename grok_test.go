@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -17,10 +18,26 @@ func loadPatterns(t *testing.T) *Patterns {
 
 func TestAllRegexpsCompile(t *testing.T) {
 	patterns := loadPatterns(t)
-	for pattern, _ := range *patterns {
+	for _, pattern := range patterns.Names() {
 		_, err := Compile(fmt.Sprintf("%{%v}", pattern), patterns)
 		if err != nil {
 			t.Errorf("%v", err.Error())
 		}
 	}
 }
+
+func TestCompileError(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\w+")
+	patterns.AddPattern("OUTER outer %{INNER}")
+	patterns.AddPattern("INNER a(b")
+	_, err := Compile("%{WORD}x %{OUTER:o}", patterns)
+	if err == nil {
+		t.Fatal("Expected error, because INNER is not a valid regular expression.")
+	}
+	for _, expected := range []string{"Error in pattern INNER", "%{OUTER} -> %{INNER}", "at position 23 "} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error message to contain '%v', but got: %v", expected, err.Error())
+		}
+	}
+}
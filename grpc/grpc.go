@@ -0,0 +1,147 @@
+// Package grpc implements the server side of the LineService in lines.proto on top of net/http.
+// The messages are simple enough to be encoded by hand, so no gRPC or protobuf library is needed.
+// net/http supports HTTP/2 only with TLS, so the service must be served via https.
+package grpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Path is the HTTP/2 path of the PushLines method.
+const Path = "/grok_exporter.LineService/PushLines"
+
+// maxMessageSize is the largest accepted message, like the default of gRPC servers.
+// The length is sent by the client, so without a limit a single message header could make the exporter allocate 4 GiB.
+const maxMessageSize = 4 * 1024 * 1024
+
+// gRPC status codes, see https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	statusOK                = 0
+	statusInvalidArgument   = 3
+	statusResourceExhausted = 8
+	statusUnimplemented     = 12
+	statusInternal          = 13
+)
+
+// Handler returns a handler for the PushLines method. Each line is sent to the lines channel.
+// The handler blocks while the queue of lines is full, which is how backpressure works.
+// Multiple streams can be open at the same time, their lines are interleaved.
+func Handler(lines chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "Expecting a gRPC request via HTTP/2.", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		count, status, err := receive(r.Body, lines)
+		if err == nil {
+			writeMessage(w, encodeResponse(count))
+		}
+		w.Header().Set("Grpc-Status", fmt.Sprintf("%v", status))
+		if err != nil {
+			w.Header().Set("Grpc-Message", err.Error())
+		}
+	})
+}
+
+// receive reads LogLine messages until the client closes the stream.
+func receive(body io.Reader, lines chan<- string) (uint64, int, error) {
+	var count uint64
+	header := make([]byte, 5) // compressed flag and message length
+	for {
+		_, err := io.ReadFull(body, header)
+		switch {
+		case err == io.EOF:
+			return count, statusOK, nil
+		case err != nil:
+			return count, statusInternal, fmt.Errorf("Failed to read message: %v", err.Error())
+		case header[0] != 0:
+			return count, statusUnimplemented, fmt.Errorf("Compressed messages are not supported.")
+		}
+		length := binary.BigEndian.Uint32(header[1:])
+		if length > maxMessageSize {
+			return count, statusResourceExhausted, fmt.Errorf("Message of %v bytes exceeds the limit of %v bytes.", length, maxMessageSize)
+		}
+		message := make([]byte, length)
+		_, err = io.ReadFull(body, message)
+		if err != nil {
+			return count, statusInternal, fmt.Errorf("Failed to read message: %v", err.Error())
+		}
+		line, err := decodeLogLine(message)
+		if err != nil {
+			return count, statusInvalidArgument, err
+		}
+		lines <- line
+		count++
+	}
+}
+
+func writeMessage(w http.ResponseWriter, message []byte) {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+	w.Write(header)
+	w.Write(message)
+}
+
+// decodeLogLine decodes the protobuf encoding of LogLine. Unknown fields are skipped.
+func decodeLogLine(message []byte) (string, error) {
+	line := ""
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return "", fmt.Errorf("Invalid LogLine message.")
+		}
+		message = message[n:]
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case 0: // varint
+			_, n = binary.Uvarint(message)
+			if n <= 0 {
+				return "", fmt.Errorf("Invalid LogLine message.")
+			}
+			message = message[n:]
+		case 1: // 64 bit
+			if len(message) < 8 {
+				return "", fmt.Errorf("Invalid LogLine message.")
+			}
+			message = message[8:]
+		case 2: // length-delimited
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return "", fmt.Errorf("Invalid LogLine message.")
+			}
+			if field == 1 {
+				line = string(message[n : n+int(length)])
+			}
+			message = message[n+int(length):]
+		case 5: // 32 bit
+			if len(message) < 4 {
+				return "", fmt.Errorf("Invalid LogLine message.")
+			}
+			message = message[4:]
+		default:
+			return "", fmt.Errorf("Invalid LogLine message: Unsupported wire type %v.", wireType)
+		}
+	}
+	return line, nil
+}
+
+// encodeResponse encodes PushLinesResponse. A zero count is encoded as empty message, like protobuf does.
+func encodeResponse(count uint64) []byte {
+	if count == 0 {
+		return []byte{}
+	}
+	result := []byte{0x08} // field 1, wire type varint
+	return append(result, encodeVarint(count)...)
+}
+
+func encodeVarint(x uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, x)]
+}
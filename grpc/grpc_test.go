@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func encodeLogLine(line string) []byte {
+	message := append([]byte{0x0a}, encodeVarint(uint64(len(line)))...) // field 1, wire type length-delimited
+	message = append(message, line...)
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+	return append(header, message...)
+}
+
+func TestPushLines(t *testing.T) {
+	lines := make(chan string)
+	server := httptest.NewUnstartedServer(Handler(lines))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	body, writer := io.Pipe()
+	go func() {
+		for _, line := range []string{"line 1", "", "line 3"} {
+			writer.Write(encodeLogLine(line))
+		}
+		writer.Close()
+	}()
+	received := make([]string, 0)
+	done := make(chan struct{})
+	go func() {
+		for line := range lines {
+			received = append(received, line)
+		}
+		close(done)
+	}()
+	req, _ := http.NewRequest("POST", server.URL+Path, body)
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	close(lines)
+	<-done
+	if resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatalf("Expected status 0, but got %q: %v", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"))
+	}
+	if len(received) != 3 || received[0] != "line 1" || received[1] != "" || received[2] != "line 3" {
+		t.Errorf("Unexpected lines: %q", received)
+	}
+	expected := []byte{0, 0, 0, 0, 2, 0x08, 3} // PushLinesResponse{lines: 3}
+	if string(response) != string(expected) {
+		t.Errorf("Expected response %v, but got %v.", expected, response)
+	}
+}
+
+func TestDecodeLogLineSkipsUnknownFields(t *testing.T) {
+	message := []byte{0x10, 0x96, 0x01, 0x0a, 0x02, 'o', 'k'} // field 2 varint 150, field 1 "ok"
+	line, err := decodeLogLine(message)
+	if err != nil || line != "ok" {
+		t.Errorf("Expected \"ok\", but got %q, %v", line, err)
+	}
+	_, err = decodeLogLine([]byte{0x0a, 0x05, 'x'})
+	if err == nil {
+		t.Error("Expected an error for a truncated message.")
+	}
+}
+
+func TestReceiveRejectsLargeMessages(t *testing.T) {
+	header := []byte{0, 0xff, 0xff, 0xff, 0xff} // 4 GiB, without the message
+	lines := make(chan string, 1)
+	count, status, err := receive(bytes.NewReader(append(encodeLogLine("ok"), header...)), lines)
+	if status != statusResourceExhausted || err == nil || count != 1 {
+		t.Errorf("Expected status %v after one line, but got %v after %v lines: %v", statusResourceExhausted, status, count, err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnHangup reloads the config when SIGHUP is received.
+func reloadOnHangup(p *pipeline) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			_, err := p.requestReload(false)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reload the config, keeping the previous config: %v\n", err.Error())
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// Windows has no SIGHUP, the config can be reloaded with POST /-/reload.
+func reloadOnHangup(p *pipeline) {}
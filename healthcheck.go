@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthzHandler serves /healthz, which responds with 200 as long as the server is running.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "OK")
+}
+
+// runHealthcheck requests /healthz of the exporter running on this host with the same config.
+// This is for Docker HEALTHCHECK and similar, so that the image does not need curl or wget.
+func runHealthcheck(args []string) int {
+	flags, configFlags := newFlagSet("healthcheck")
+	timeout := flags.Duration("timeout", 5*time.Second, "How long to wait for the response.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	cfg, err := configFlags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	err = checkHealth(healthzUrl(cfg), *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func healthzUrl(cfg *config.Config) string {
+	return fmt.Sprintf("%v://localhost:%v/healthz", cfg.Server.Protocol, cfg.Server.Port)
+}
+
+// checkHealth returns an error unless the URL responds with 200.
+// The certificate is not verified, because it is issued for the public host name, not for localhost.
+func checkHealth(url string, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("Health check failed: %v", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Health check failed: %v returned %v.", url, resp.Status)
+	}
+	return nil
+}
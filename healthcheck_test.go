@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckHealth(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(healthzHandler))
+	defer server.Close()
+	err := checkHealth(server.URL+"/healthz", time.Second)
+	if err != nil {
+		t.Errorf("Expected the health check to succeed, but got %v", err)
+	}
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	if checkHealth(notFound.URL+"/healthz", time.Second) == nil {
+		t.Error("Expected the health check to fail if /healthz returns 404.")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/filehelper"
+	"github.com/google/mtail/tailer"
+	"github.com/google/mtail/watcher"
+	"github.com/spf13/afero"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// runHelper runs the privileged helper, which opens the log files for an exporter with 'input.helper'.
+// It does not read the config file, because the config is usually writable by the exporter's user,
+// which must not be able to change the files the helper opens.
+func runHelper(args []string) int {
+	flags := flag.NewFlagSet("grok_exporter helper", flag.ContinueOnError)
+	socket := flags.String("socket", "/run/grok_exporter/helper.sock", "Path of the Unix socket. It must be the same as 'input.helper' in the exporter's config.")
+	allow := flags.String("allow", "", "Comma-separated glob patterns of the files the helper may open, like '/var/log/secure,/var/log/secure-*'.")
+	owner := flags.String("user", "", "The user running the exporter. Only this user can connect to the socket.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	if *allow == "" || *owner == "" {
+		fmt.Fprintf(os.Stderr, "'-allow' and '-user' are required.\n")
+		flags.Usage()
+		return exitUsage
+	}
+	allowed := strings.Split(*allow, ",")
+	for _, pattern := range allowed {
+		if !strings.HasPrefix(pattern, "/") {
+			fmt.Fprintf(os.Stderr, "Invalid pattern '%v' in '-allow': The pattern must be an absolute path.\n", pattern)
+			return exitUsage
+		}
+	}
+	uid, gid, err := lookupUser(*owner)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	server, err := filehelper.Listen(*socket, allowed, uid, gid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	fmt.Printf("Opening %v for user %v on %v\n", strings.Join(allowed, ", "), *owner, *socket)
+	err = server.Serve()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func lookupUser(name string) (int, int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid '-user': %v", err.Error())
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid '-user': The user id %v is not a number.", u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid '-user': The group id %v is not a number.", u.Gid)
+	}
+	return uid, gid, nil
+}
+
+// newTailer creates a tailer for the file input. With 'input.helper', files that cannot be opened because permission is denied
+// are opened by the helper.
+func newTailer(cfg *config.InputConfig, lines chan<- string) (*tailer.Tailer, error) {
+	if cfg.Helper == "" {
+		return tailer.New(tailer.Options{Lines: lines})
+	}
+	w, err := watcher.NewLogWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return tailer.New(tailer.Options{Lines: lines, W: filehelper.Watcher{Watcher: w}, FS: inputFs(cfg)})
+}
+
+// inputFs is the file system for reading the log files, like the tailer does. With 'input.helper', files that cannot be opened
+// because permission is denied are opened by the helper.
+func inputFs(cfg *config.InputConfig) afero.Fs {
+	if cfg.Helper == "" {
+		return afero.NewOsFs()
+	}
+	return filehelper.Fs{Socket: cfg.Helper}
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+)
+
+// Windows has no inodes. The file index is not available from os.FileInfo.
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Metrics about the log input, so that log volume budgets can be monitored.
+var (
+	inputBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grok_exporter_input_bytes_total",
+		Help: "Number of bytes read from the input, including line terminators. The input is the log file path, or 'stdin'.",
+	}, []string{"input"})
+	bytesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "grok_exporter_bytes_total",
+		Help: "Number of bytes read from all inputs, including line terminators.",
+	})
+	linesTooOldTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "grok_exporter_lines_too_old_total",
+		Help: "Number of lines or multiline records ignored because their timestamp is older than 'input.ignore_lines_older_than'.",
+	})
+)
+
+func registerInputMetrics() {
+	prometheus.MustRegister(inputBytesTotal)
+	prometheus.MustRegister(bytesTotal)
+	prometheus.MustRegister(linesTooOldTotal)
+	prometheus.MustRegister(filesAttachedTotal)
+	prometheus.MustRegister(filesDetachedTotal)
+	prometheus.MustRegister(filesTailed)
+}
+
+// inputFields returns the fields that are available in all metrics: the 'input.labels', the 'input.path_match' fields of the path,
+// and for file inputs the 'logfile' field with the path. The 'input.labels' take precedence over path fields with the same name.
+// It returns nil if there are no fields.
+func inputFields(cfg *config.InputConfig, path string) map[string]string {
+	result := pathFields(cfg.PathMatch, path)
+	if cfg.Type == "file" {
+		if result == nil {
+			result = make(map[string]string, len(cfg.Labels)+1)
+		}
+		result["logfile"] = path
+	}
+	if len(cfg.Labels) == 0 {
+		return result
+	}
+	if result == nil {
+		result = make(map[string]string, len(cfg.Labels))
+	}
+	for name, value := range cfg.Labels {
+		result[name] = value
+	}
+	return result
+}
+
+// pathFields returns the named groups of 'input.path_match' in the path of the log file, or nil if it is not configured.
+// If the path does not match, a warning is printed and the fields are empty.
+func pathFields(expression string, path string) map[string]string {
+	if expression == "" {
+		return nil
+	}
+	regex := regexp.MustCompile(expression) // already validated in config
+	match := regex.FindStringSubmatch(path)
+	if match == nil {
+		fmt.Fprintf(os.Stderr, "Warning: 'input.path_match' does not match %v, the path fields are empty.\n", path)
+		return nil
+	}
+	result := make(map[string]string)
+	for i, name := range regex.SubexpNames() {
+		if name != "" {
+			result[name] = match[i]
+		}
+	}
+	return result
+}
+
+// lineBytes is the size of the line in the log file. Lines from the tailer have the newline removed, lines from stdin don't.
+func lineBytes(line string) int {
+	if strings.HasSuffix(line, "\n") {
+		return len(line)
+	}
+	return len(line) + 1
+}
+
+// throttle limits the read throughput to 'input.max_bytes_per_second'.
+// As the tailer blocks until the line is processed, delaying processing slows down reading.
+// Bursts of up to one second worth of bytes are allowed, so that short peaks are not delayed.
+type throttle struct {
+	bytesPerSecond float64
+	next           time.Time // the time when all bytes seen so far are within the budget
+}
+
+func newThrottle(bytesPerSecond int) *throttle {
+	return &throttle{bytesPerSecond: float64(bytesPerSecond)}
+}
+
+// delay returns how long to wait before processing n bytes. A nil throttle never delays.
+func (t *throttle) delay(n int, now time.Time) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / t.bytesPerSecond * float64(time.Second)))
+	if wait := t.next.Sub(now) - time.Second; wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Frames larger than this are rejected, so that a corrupt length prefix does not allocate all memory.
+const maxFrameLength = 16 * 1024 * 1024
+
+// readFrame reads the next line, or the next frame with 'input.framing'.
+// With framing, each frame is preceded by its length in bytes: 'varint' is an unsigned varint like in protobuf,
+// 'len32' is a 4 byte big-endian unsigned integer. Frames may contain newlines, like pre-merged multiline records.
+// Without framing, the line includes the trailing newline, and the last line may be returned together with io.EOF.
+func readFrame(reader *bufio.Reader, framing string) (string, error) {
+	var length uint64
+	var err error
+	switch framing {
+	case "varint":
+		length, err = binary.ReadUvarint(reader)
+	case "len32":
+		var length32 uint32
+		err = binary.Read(reader, binary.BigEndian, &length32)
+		length = uint64(length32)
+	default:
+		return reader.ReadString('\n')
+	}
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		return "", fmt.Errorf("Truncated frame length.")
+	case err != nil:
+		return "", err
+	case length > maxFrameLength:
+		return "", fmt.Errorf("Frame length %v exceeds the maximum of %v bytes.", length, maxFrameLength)
+	}
+	frame := make([]byte, length)
+	_, err = io.ReadFull(reader, frame)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("Truncated frame: Expected %v bytes.", length)
+	}
+	return string(frame), err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottleDelay(t *testing.T) {
+	limiter := newThrottle(100)
+	now := time.Now()
+	for _, test := range []struct {
+		bytes    int
+		now      time.Time
+		expected time.Duration
+	}{
+		{50, now, 0},
+		{50, now, 0},                      // one second burst used up
+		{50, now, 500 * time.Millisecond}, // half a second over budget
+		{50, now.Add(2 * time.Second), 0}, // idle time is not saved up beyond the burst
+		{200, now.Add(2 * time.Second), 1500 * time.Millisecond},
+	} {
+		delay := limiter.delay(test.bytes, test.now)
+		if delay != test.expected {
+			t.Errorf("%v bytes: Expected delay %v, but got %v.", test.bytes, test.expected, delay)
+		}
+	}
+	var disabled *throttle
+	if disabled.delay(1000, now) != 0 {
+		t.Error("Expected nil throttle not to delay lines.")
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	for framing, data := range map[string]string{
+		"varint": "\x07line\n 2\x00\x06line 3",
+		"len32":  "\x00\x00\x00\x07line\n 2\x00\x00\x00\x00\x00\x00\x00\x06line 3",
+	} {
+		reader := bufio.NewReader(strings.NewReader(data))
+		for _, expected := range []string{"line\n 2", "", "line 3"} {
+			frame, err := readFrame(reader, framing)
+			if err != nil || frame != expected {
+				t.Errorf("%v: Expected %q, but got %q, %v", framing, expected, frame, err)
+			}
+		}
+		if _, err := readFrame(reader, framing); err != io.EOF {
+			t.Errorf("%v: Expected EOF, but got %v", framing, err)
+		}
+	}
+	_, err := readFrame(bufio.NewReader(strings.NewReader("\x00\x00\x00\x07line")), "len32")
+	if err == nil || err == io.EOF {
+		t.Errorf("Expected an error for a truncated frame, but got %v", err)
+	}
+}
+
+func TestPathFields(t *testing.T) {
+	fields := pathFields(`^/var/log/(?P<service>[^/]+)/(?P<file>[^/]+)\.log$`, "/var/log/checkout/app.log")
+	if len(fields) != 2 || fields["service"] != "checkout" || fields["file"] != "app" {
+		t.Errorf("Unexpected path fields %v.", fields)
+	}
+	if pathFields(``, "/var/log/checkout/app.log") != nil {
+		t.Error("Expected no path fields if 'input.path_match' is not configured.")
+	}
+}
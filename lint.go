@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"regexp"
+	"strings"
+)
+
+// lint reports config drift, i.e. things that are not errors,
+// but indicate that the config file does not do what it is supposed to do.
+// With ignoreDir, unused patterns from 'grok.patterns_dir' are not reported, for a directory containing a library of patterns,
+// most of which are not meant to be used.
+func lint(cfg *config.Config, patterns *Patterns, ignoreDir bool) ([]string, error) {
+	findings := make([]string, 0)
+	used := make(map[string]bool)
+	expressions := make([]string, 0)
+	sharedFields := make([]string, 0) // fields used outside of the metric, by 'routing' and 'sessions'
+	if cfg.Routing != nil {
+		expressions = append(expressions, cfg.Routing.Match)
+		sharedFields = append(sharedFields, cfg.Routing.Field)
+	}
+	if cfg.Sessions != nil {
+		for _, session := range *cfg.Sessions {
+			expressions = append(expressions, session.Start, session.Activity, session.End)
+			sharedFields = append(sharedFields, session.Key)
+		}
+	}
+	for _, m := range *cfg.Metrics {
+		expressions = append(expressions, m.Match, m.Repeat)
+		if m.Context != nil {
+			expressions = append(expressions, m.Context.Match)
+		}
+	}
+	for _, expression := range expressions {
+		for _, name := range referencedPatterns(expression) {
+			markUsed(name, patterns, used)
+		}
+	}
+	for _, m := range *cfg.Metrics {
+		usedFields := make(map[string]bool) // grok capture names, see 'fields'
+		for _, label := range m.Labels {
+			capture, _ := m.Fields.CaptureName(label.GrokFieldName)
+			usedFields[capture] = true
+		}
+		fields := append([]string{m.Value, m.SumField}, sharedFields...)
+		if m.Exemplar != nil {
+			fields = append(fields, m.Exemplar.Fields...)
+		}
+		for _, stage := range m.Pipeline {
+			if stage.Filter != nil {
+				fields = append(fields, stage.Filter.Field)
+			}
+		}
+		for _, field := range fields {
+			if field != "" {
+				capture, _ := m.Fields.CaptureName(field)
+				usedFields[capture] = true
+			}
+		}
+		for _, field := range append(capturedFields(m.Match), capturedFields(m.Repeat)...) {
+			// Presets capture all fields of the log format, using only some of them is expected.
+			if !usedFields[field] && !m.Fields.IsDropped(field) && m.Preset == "" {
+				findings = append(findings, fmt.Sprintf("Metric %v: Grok field %v is captured but not used in any label.", m.Name, field))
+			}
+		}
+		for _, label := range m.Labels {
+			labelExpression := m.Match
+			if m.Repeat != "" {
+				labelExpression = m.Repeat
+			}
+			capture, _ := m.Fields.CaptureName(label.GrokFieldName)
+			alwaysEmpty, err := isAlwaysEmpty(labelExpression, capture, patterns)
+			if err != nil {
+				return nil, err
+			}
+			if alwaysEmpty {
+				findings = append(findings, fmt.Sprintf("Metric %v: Label %v will always be empty, because grok field %v always captures the empty string.", m.Name, label.PrometheusLabel, label.GrokFieldName))
+			}
+		}
+	}
+	findings = append(findings, patterns.Shadowed()...)
+	unused := make([]string, 0)
+	for _, name := range patterns.Names() {
+		if ignoreDir && patterns.effective[name].precedence == precedenceDir {
+			continue
+		}
+		if !used[name] && !used[patterns.effective[name].qualifiedName()] {
+			unused = append(unused, name)
+		}
+	}
+	for _, name := range unused {
+		findings = append(findings, fmt.Sprintf("Pattern %v is defined but not used in any match expression.", name))
+	}
+	return findings, nil
+}
+
+// Matches %{NAME}, %{NAME:field}, and %{NAME:field:type}
+var grokReferenceRegexp = regexp.MustCompile(`%{([^:}]+)(?::([^:}]+))?(?::[^}]+)?}`)
+
+func referencedPatterns(expression string) []string {
+	result := make([]string, 0)
+	for _, match := range grokReferenceRegexp.FindAllStringSubmatch(expression, -1) {
+		result = append(result, match[1])
+	}
+	return result
+}
+
+// capturedFields returns the field names captured directly in the expression.
+// Named captures within the definitions of the referenced patterns are not included,
+// because these are usually not meant to be used as labels.
+func capturedFields(expression string) []string {
+	result := make([]string, 0)
+	for _, match := range grokReferenceRegexp.FindAllStringSubmatch(expression, -1) {
+		if match[2] != "" {
+			result = append(result, match[2])
+		}
+	}
+	for _, match := range namedGroupRegexp.FindAllStringSubmatch(expression, -1) {
+		result = append(result, match[1])
+	}
+	return result
+}
+
+func markUsed(name string, patterns *Patterns, used map[string]bool) {
+	if used[name] {
+		return
+	}
+	used[name] = true
+	if definition, exists := patterns.Find(name); exists {
+		for _, child := range referencedPatterns(definition) {
+			markUsed(child, patterns, used)
+		}
+	}
+}
+
+// A lazy quantifier on a single atom, like .*? in the DATA pattern.
+var lazyNullableRegexp = regexp.MustCompile(`^(\.|\\[sSwWdD]|\[[^\]]*\])[*?]\?$`)
+
+// Zero-width assertions like \b, ^, $, and look-ahead/look-behind.
+var zeroWidthRegexp = regexp.MustCompile(`\\[bBAzZG]|\^|\$|\(\?<?[=!][^()]*\)|\(\?:\)`)
+
+// isAlwaysEmpty finds two common mistakes:
+// A field captured with a lazy pattern like %{DATA:field} at the end of an expression always captures the empty string,
+// because matching is not anchored and the shortest match is preferred.
+// A field captured with a pattern consisting of zero-width assertions only will also always be empty.
+func isAlwaysEmpty(expression string, field string, patterns *Patterns) (bool, error) {
+	for _, match := range grokReferenceRegexp.FindAllStringSubmatchIndex(expression, -1) {
+		if match[4] < 0 || expression[match[4]:match[5]] != field {
+			continue
+		}
+		regex, err := expand(fmt.Sprintf("%%{%v}", expression[match[2]:match[3]]), patterns)
+		if err != nil {
+			return false, err
+		}
+		regex = stripNonCapturingGroups(regex)
+		if zeroWidthRegexp.ReplaceAllString(regex, "") == "" {
+			return true, nil
+		}
+		if lazyNullableRegexp.MatchString(regex) && strings.TrimSpace(expression[match[1]:]) == "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// stripNonCapturingGroups removes enclosing (?:...) groups, as created by expand().
+func stripNonCapturingGroups(regex string) string {
+	for strings.HasPrefix(regex, "(?:") && strings.HasSuffix(regex, ")") {
+		depths := nestingDepths(regex)
+		for i := 1; i < len(regex)-1; i++ {
+			if depths[i] == 0 {
+				// The group closes before the end of regex, like in (?:a)(?:b)
+				return regex
+			}
+		}
+		regex = regex[3 : len(regex)-1]
+	}
+	return regex
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\b\\w+\\b")
+	patterns.AddPattern("DATA .*?")
+	patterns.AddPattern("NUMBER \\d+")
+	patterns.AddPattern("USER %{WORD}")
+	patterns.AddPattern("UNUSED x")
+	patterns.add("LIBRARY y", "library", "patterns/library", precedenceDir)
+	cfg, err := config.LoadConfigString([]byte(`
+grok:
+    patterns: ['UNUSED x']
+metrics:
+    - type: counter
+      name: test_total
+      help: Dummy help message.
+      match: 'user=%{USER:user} n=%{NUMBER:n} msg=%{DATA:msg}'
+      labels:
+          - grok_field_name: user
+            prometheus_label: user
+          - grok_field_name: msg
+            prometheus_label: msg
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err.Error())
+	}
+	findings, err := lint(cfg, patterns, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	expected := []string{
+		"Grok field n is captured but not used",
+		"Label msg will always be empty",
+		"Pattern LIBRARY is defined but not used",
+		"Pattern UNUSED is defined but not used",
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("Expected %v findings, but got %v: %v", len(expected), len(findings), findings)
+	}
+	for i := range expected {
+		if !strings.Contains(findings[i], expected[i]) {
+			t.Errorf("Expected finding '%v', but got '%v'.", expected[i], findings[i])
+		}
+	}
+}
+
+func TestLintIgnorePatternsDir(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.add("WORD \\b\\w+\\b", "grok-patterns", "patterns/grok-patterns", precedenceDir)
+	patterns.add("LIBRARY y", "library", "patterns/library", precedenceDir)
+	cfg, err := config.LoadConfigString([]byte(`
+grok:
+    patterns_dir: ./patterns
+metrics:
+    - type: counter
+      name: test_total
+      help: Dummy help message.
+      match: 'user=%{WORD:user}'
+      labels:
+          - grok_field_name: user
+            prometheus_label: user
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err.Error())
+	}
+	findings, err := lint(cfg, patterns, true)
+	if err != nil || len(findings) != 0 {
+		t.Fatalf("Expected no findings, but got %v (error %v)", findings, err)
+	}
+	findings, err = lint(cfg, patterns, false)
+	if err != nil || len(findings) != 1 || !strings.Contains(findings[0], "Pattern LIBRARY is defined but not used") {
+		t.Fatalf("Expected LIBRARY to be reported, but got %v (error %v)", findings, err)
+	}
+}
+
+func TestLintSharedFields(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\b\\w+\\b")
+	patterns.AddPattern("PROG [a-z]+")
+	cfg, err := config.LoadConfigString([]byte(`
+grok:
+    patterns: ['WORD \\b\\w+\\b', 'PROG [a-z]+']
+routing:
+    match: '%{PROG:app}:'
+    field: app
+sessions:
+    - name: user_sessions
+      help: User sessions.
+      key: user
+      start: 'login user=%{WORD:user}'
+      end: 'logout user=%{WORD:user}'
+      timeout: 30m
+metrics:
+    - type: counter
+      name: test_total
+      help: Dummy help message.
+      match: '%{PROG:app}: user=%{WORD:user} status=%{WORD:status}'
+      pipeline:
+          - filter: {field: status, match: '^5'}
+      labels: []
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err.Error())
+	}
+	findings, err := lint(cfg, patterns, false)
+	if err != nil || len(findings) != 0 {
+		t.Fatalf("Expected no findings, but got %v (error %v)", findings, err)
+	}
+}
@@ -0,0 +1,95 @@
+package locale
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Month names in other languages, so that timestamps like "3. März 2016" can be parsed with Go time layouts,
+// which only understand English month names. For each language, the names are listed in order January to December,
+// and each month may have several names, like the full name and its abbreviations.
+var months = map[string][12][]string{
+	"de": {{"januar", "jan", "jänner", "jän"}, {"februar", "feb"}, {"märz", "mär", "mrz"}, {"april", "apr"}, {"mai"}, {"juni", "jun"},
+		{"juli", "jul"}, {"august", "aug"}, {"september", "sep", "sept"}, {"oktober", "okt"}, {"november", "nov"}, {"dezember", "dez"}},
+	"es": {{"enero", "ene"}, {"febrero", "feb"}, {"marzo", "mar"}, {"abril", "abr"}, {"mayo", "may"}, {"junio", "jun"},
+		{"julio", "jul"}, {"agosto", "ago"}, {"septiembre", "setiembre", "sep", "sept", "set"}, {"octubre", "oct"}, {"noviembre", "nov"}, {"diciembre", "dic"}},
+	"fr": {{"janvier", "janv"}, {"février", "févr", "fév"}, {"mars", "mar"}, {"avril", "avr"}, {"mai"}, {"juin"},
+		{"juillet", "juil"}, {"août"}, {"septembre", "sept"}, {"octobre", "oct"}, {"novembre", "nov"}, {"décembre", "déc"}},
+	"it": {{"gennaio", "gen"}, {"febbraio", "feb"}, {"marzo", "mar"}, {"aprile", "apr"}, {"maggio", "mag"}, {"giugno", "giu"},
+		{"luglio", "lug"}, {"agosto", "ago"}, {"settembre", "set"}, {"ottobre", "ott"}, {"novembre", "nov"}, {"dicembre", "dic"}},
+	"nl": {{"januari", "jan"}, {"februari", "feb"}, {"maart", "mrt"}, {"april", "apr"}, {"mei"}, {"juni", "jun"},
+		{"juli", "jul"}, {"augustus", "aug"}, {"september", "sep", "sept"}, {"oktober", "okt"}, {"november", "nov"}, {"december", "dec"}},
+	"pt": {{"janeiro", "jan"}, {"fevereiro", "fev"}, {"março", "mar"}, {"abril", "abr"}, {"maio", "mai"}, {"junho", "jun"},
+		{"julho", "jul"}, {"agosto", "ago"}, {"setembro", "set"}, {"outubro", "out"}, {"novembro", "nov"}, {"dezembro", "dez"}},
+}
+
+// Supported returns the language codes with month names, sorted.
+func Supported() []string {
+	result := make([]string, 0, len(months))
+	for language := range months {
+		result = append(result, language)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// IsSupported returns true if there are month names for the language code.
+func IsSupported(language string) bool {
+	_, exists := months[language]
+	return exists
+}
+
+// Translator replaces month names in timestamps with the English names expected by a Go time layout.
+type Translator struct {
+	months map[string]time.Month // lower case name -> month
+	long   bool                  // the layout has full month names ("January"), not abbreviations ("Jan")
+}
+
+// NewTranslator returns nil if the language is not supported.
+func NewTranslator(language string, layout string) *Translator {
+	names, exists := months[language]
+	if !exists {
+		return nil
+	}
+	result := &Translator{
+		months: make(map[string]time.Month),
+		long:   strings.Contains(layout, "January"),
+	}
+	for i, aliases := range names {
+		for _, name := range aliases {
+			result.months[name] = time.Month(i + 1)
+		}
+	}
+	return result
+}
+
+// Translate replaces each word that is a month name with the English name. Other words are kept, so that English
+// names still work. The comparison is case-insensitive.
+func (t *Translator) Translate(value string) string {
+	var result strings.Builder
+	word := make([]rune, 0)
+	flush := func() {
+		if month, isMonth := t.months[strings.ToLower(string(word))]; isMonth {
+			if t.long {
+				result.WriteString(month.String())
+			} else {
+				result.WriteString(month.String()[:3])
+			}
+		} else {
+			result.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range value {
+		if unicode.IsLetter(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		result.WriteRune(r)
+	}
+	flush()
+	return result.String()
+}
@@ -5,62 +5,365 @@ import (
 	"flag"
 	"fmt"
 	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/cron"
+	"github.com/fstab/grok_exporter/datepath"
+	"github.com/fstab/grok_exporter/grpc"
 	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/notify"
+	"github.com/fstab/grok_exporter/regex"
 	"github.com/fstab/grok_exporter/server"
-	"github.com/google/mtail/tailer"
+	"github.com/fstab/grok_exporter/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-var (
-	showVersion = flag.Bool("version", false, "Show the grok_exporter version.")
-	configPath  = flag.String("config", "", "Path to the config file. Try '-config ./example/config.yml' to get started.")
+// Exit codes. Scripts may rely on these, so they must not change.
+const (
+	exitOK      = 0 // Success.
+	exitFailure = 1 // The command failed, e.g. the config is invalid, there are lint findings, or a runtime error occurred.
+	exitUsage   = 2 // Invalid command line.
 )
 
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
+
+var commands = []command{
+	{"run", "Run the exporter.", runExporter},
+	{"check", "Check if the config file is valid.", runCheck},
+	{"lint", "Report unused patterns, unused fields, and labels that will always be empty.", runLint},
+	{"test", "Process log lines from a file or stdin and print the resulting metrics.", runTest},
+	{"bench", "Measure how fast log lines from a file are processed.", runBench},
+	{"suggest", "Propose grok expressions for the most common kinds of lines in a sample log file.", runSuggest},
+	{"tui", "Interactively edit match expressions while watching a log file.", runTui},
+	{"healthcheck", "Check if the exporter running on this host is healthy.", runHealthcheck},
+	{"helper", "Open log files for an exporter running as an unprivileged user, see 'input.helper'.", runHelper},
+	{"version", "Show the grok_exporter version.", runVersion},
+}
+
 func main() {
-	flag.Parse()
-	if *showVersion {
-		fmt.Printf("grok_exporter version %v build date %v.\n", VERSION, BUILD_DATE)
-		return
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		// For backwards compatibility, 'grok_exporter -config <path>' is the same as 'grok_exporter run -config <path>'.
+		for _, arg := range args {
+			if arg == "-version" || arg == "--version" {
+				return runVersion(nil)
+			}
+		}
+		if len(args) == 0 {
+			printUsage()
+			return exitUsage
+		}
+		return runExporter(args)
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	if args[0] == "help" {
+		printUsage()
+		return exitOK
 	}
-	cfg, err := loadConfig()
+	fmt.Fprintf(os.Stderr, "Unknown command '%v'.\n", args[0])
+	printUsage()
+	return exitUsage
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: grok_exporter <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-13v%v\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'grok_exporter <command> -h' for the flags of a command.\n")
+}
+
+// newFlagSet creates the flags for a command. Each command has its own flags, but the config flags are the same for all commands.
+func newFlagSet(name string) (*flag.FlagSet, *configFlags) {
+	flags := flag.NewFlagSet("grok_exporter "+name, flag.ContinueOnError)
+	return flags, &configFlags{
+		path:     flags.String("config", "", "Path to the config file. Try '-config ./example/config.yml' to get started."),
+		template: flags.Bool("config-template", false, "Process the config file as a Go template before parsing it."),
+		values:   flags.String("config-values", "", "Path to a YAML file with values for the config template. Implies '-config-template'."),
+	}
+}
+
+// parseFlags returns -1 if the flags were parsed successfully, and the exit code otherwise.
+func parseFlags(flags *flag.FlagSet, args []string) int {
+	err := flags.Parse(args)
+	switch {
+	case err == flag.ErrHelp:
+		return exitOK
+	case err != nil:
+		return exitUsage
+	case flags.NArg() > 0:
+		fmt.Fprintf(os.Stderr, "Unexpected argument '%v'.\n", flags.Arg(0))
+		flags.Usage()
+		return exitUsage
+	default:
+		return -1
+	}
+}
+
+type configFlags struct {
+	path     *string
+	template *bool
+	values   *string
+}
+
+func (f *configFlags) load() (*config.Config, error) {
+	if *f.path == "" {
+		return nil, fmt.Errorf("Usage: grok_exporter <command> -config <path>")
+	}
+	if *f.template || *f.values != "" {
+		return config.LoadConfigTemplate(*f.path, *f.values)
+	}
+	return config.LoadConfigFile(*f.path)
+}
+
+// initialize loads the config, the patterns, and the metrics. Warnings are printed to stderr.
+func initialize(f *configFlags) (*config.Config, *Patterns, []metrics.Metric, error) {
+	cfg, err := f.load()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(-1)
+		return nil, nil, nil, err
 	}
+	if cfg.Grok.RegexEngine != "" {
+		regexEngine = cfg.Grok.RegexEngine
+	}
+	err = loadMappings(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	patterns, err := preparePatterns(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	metrics, err := createMetrics(cfg, patterns)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	err = runTestLines(cfg, patterns)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cfg, patterns, metrics, nil
+}
+
+// preparePatterns loads the patterns, and validates the metrics and sessions against the patterns.
+func preparePatterns(cfg *config.Config) (*Patterns, error) {
 	patterns, err := initPatterns(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range patterns.Shadowed() {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", warning)
+	}
+	if cfg.Global.OnPatternError == "skip" {
+		for _, err := range skipInvalidMetrics(cfg, patterns) {
+			fmt.Fprintf(os.Stderr, "ERROR: %v The metric is disabled.\n", err.Error())
+		}
+	}
+	warnings, err := validateMetrics(cfg, patterns)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", warning)
+	}
+	err = validateSessions(cfg, patterns)
+	if err != nil {
+		return nil, err
+	}
+	err = validateRouting(cfg, patterns)
+	if err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+func runExporter(args []string) int {
+	flags, configFlags := newFlagSet("run")
+	profiling := addProfileFlags(flags)
+	replaySpeed := flags.Float64("replay-speed", 0, "Process log lines at the pace given by their timestamps, sped up by this factor. Requires 'input.timestamp' in the config. 0 means as fast as possible.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	stopProfiling, err := profiling.start()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(-1)
+		return exitFailure
 	}
-	metrics, err := createMetrics(cfg, patterns)
+	defer stopProfiling()
+	adjustMaxProcs()
+	cfg, patterns, metrics, err := initialize(configFlags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	p := &pipeline{metrics: metrics, input: cfg.Input.Type, fields: inputFields(cfg.Input, ""), dump: newStateDump()}
+	if cfg.Input.Type == "file" && cfg.Input.MultipleFiles() {
+		p.fileSet = newFileSet(cfg.Input)
+	} else if cfg.Input.Type == "file" {
+		path := datepath.Expand(cfg.Input.Path, time.Now())
+		p.input = cfg.Input.Path
+		p.files = &fileStatus{path: path}
+		p.fields = inputFields(cfg.Input, path)
+	}
+	if cfg.Input.MaxBytesPerSecond > 0 {
+		p.throttle = newThrottle(cfg.Input.MaxBytesPerSecond)
+	}
+	p.router, err = newRouter(cfg, patterns)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(-1)
+		return exitFailure
 	}
-	for _, m := range metrics {
-		prometheus.MustRegister(m.Collector())
+	if cfg.Input.Multiline != nil {
+		p.multiline, err = newMultiline(cfg.Input.Multiline, patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
 	}
-	serverErrorChannel := startServer(cfg, "/metrics", prometheus.Handler())
+	if cfg.Input.Backfill > 0 && cfg.Input.Timestamp != nil {
+		p.timestamps, err = newTimestampParser(cfg.Input.Timestamp, patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+	}
+	if cfg.Input.IgnoreLinesOlderThan > 0 {
+		parser, err := newTimestampParser(cfg.Input.Timestamp, patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+		p.ageFilter = newAgeFilter(parser, cfg.Input.IgnoreLinesOlderThan)
+	}
+	if *replaySpeed > 0 {
+		if cfg.Input.Timestamp == nil {
+			fmt.Fprintf(os.Stderr, "'-replay-speed' requires 'input.timestamp' to be configured.\n")
+			return exitFailure
+		}
+		parser, err := newTimestampParser(cfg.Input.Timestamp, patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+		p.replay = newReplayer(parser, *replaySpeed)
+	}
+	globalMetrics, tenantHandlers, err := registerMetrics(cfg, metrics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	live := &liveMetrics{cfg: cfg, all: metrics, global: globalMetrics}
+	p.configReloader = &configReloader{flags: configFlags, live: live, patterns: patterns}
+	p.configReloads = make(chan *reloadRequest)
+	if cfg.Flush != nil {
+		flush := newFlush(cfg.Flush, live.globalMetrics, live.helpTexts)
+		onShutdown(flush)
+		defer flush()
+	}
+	if cfg.Grok.WatchPatternsDir {
+		p.reloader, err = newPatternReloader(cfg, patterns, metrics)
+		if err == nil {
+			p.reloads, err = watchDir(cfg.Grok.PatternsDir, nil)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+	}
+	p.configReloader.stopWatches = make(chan struct{})
+	err = watchMappings(cfg, p.configReloader.stopWatches)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	p.sessions, err = createSessions(cfg, patterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	registerSessions(p.sessions)
+	registerInputMetrics()
+	registerRuntimeMetrics()
+	registerTargetInfo(cfg.Global.ResourceAttributes)
+	registerMatchMetrics()
+	registerScrapeMetrics()
+	registerPipelineMetrics(p.dump)
+	registerConfigMetrics(live.globalMetrics, time.Now())
+	live.schedules = startResetSchedules(cfg, metrics)
+	startRetentionSweep(cfg, live.expiring)
+	startSessionTimeouts(cfg, p.sessions)
+	p.tracer = tracing.NewTracer(cfg.Tracing, cfg.Global.ResourceAttributes)
+	if p.tracer != nil {
+		onShutdown(p.tracer.Shutdown)
+		defer p.tracer.Shutdown()
+	}
+	metricsHandler := nameFilter(prometheus.Handler())
+	if cfg.Input.Mode == "pull" {
+		p.pulls = make(chan chan struct{})
+		metricsHandler = pullHandler(p.pulls, metricsHandler)
+		for path, handler := range tenantHandlers {
+			tenantHandlers[path] = pullHandler(p.pulls, handler)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", instrumentScrapes("/metrics", metricsHandler))
+	mux.HandleFunc("/healthz", healthzHandler)
+	// Tenants' metrics are not available in the API, because the lines could leak to other tenants.
+	mux.Handle("/api/metrics/", apiHandler(live.globalMetrics))
+	if p.fileSet != nil {
+		mux.Handle("/api/files", p.fileSet)
+	} else {
+		mux.Handle("/api/files", p.files)
+	}
+	p.unmatched = newUnmatchedSample()
+	mux.Handle("/debug/unmatched", p.unmatched)
+	for path, handler := range tenantHandlers {
+		mux.Handle(path, instrumentScrapes(path, nameFilter(handler)))
+	}
+	if cfg.Input.Type == "grpc" {
+		p.pushed = make(chan string, queueSize)
+		p.dump.addQueue("grpc", func() int { return len(p.pushed) }, func() int { return cap(p.pushed) })
+		mux.Handle(grpc.Path, grpc.Handler(p.pushed))
+	}
+	if cfg.Server.EnableReload {
+		mux.Handle("/-/reload", reloadHandler(p))
+	}
+	dumpOnQuit(p)
+	reloadOnHangup(p)
+	serverErrorChannel := startServer(cfg, "/", mux)
 	fmt.Printf("Starting server on %v://localhost:%v/metrics\n", cfg.Server.Protocol, cfg.Server.Port)
-	err = processLogLines(cfg, metrics, serverErrorChannel)
+	err = processLogLines(cfg, p, serverErrorChannel)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err.Error())
-		os.Exit(-1)
+		return exitFailure
 	}
+	return exitOK
 }
 
-func loadConfig() (*config.Config, error) {
-	if *configPath == "" {
-		return nil, fmt.Errorf("Usage: grok_exporter -config <path>")
-	}
-	return config.LoadConfigFile(*configPath)
+// registerMatchMetrics registers the metrics about notifications, rate limits, and disabled metrics.
+func registerMatchMetrics() {
+	prometheus.MustRegister(notify.Collector())
+	prometheus.MustRegister(metrics.RateLimitCollector())
+	prometheus.MustRegister(metrics.LabelLengthCollector())
+	prometheus.MustRegister(metricDisabled)
 }
 
 func initPatterns(cfg *config.Config) (*Patterns, error) {
 	patterns := InitPatterns()
-	if len(cfg.Grok.Patterns) > 0 {
+	if cfg.Grok.PatternsDir != "" {
 		err := patterns.AddDir(cfg.Grok.PatternsDir)
 		if err != nil {
 			return nil, err
@@ -80,31 +383,142 @@ func initPatterns(cfg *config.Config) (*Patterns, error) {
 func createMetrics(cfg *config.Config, patterns *Patterns) ([]metrics.Metric, error) {
 	result := make([]metrics.Metric, 0, len(*cfg.Metrics))
 	for _, m := range *cfg.Metrics {
-		regex, err := Compile(m.Match, patterns)
+		if m.Type == "derived" {
+			result = append(result, nil) // created below, when all source metrics exist
+			continue
+		}
+		metric, err := createMetric(m, patterns)
 		if err != nil {
 			return nil, err
 		}
-		switch {
-		case m.Type == "counter":
-			result = append(result, metrics.CreateGenericCounterVecMetric(m, regex))
-		default:
-			return nil, fmt.Errorf("Failed to initialize metrics: Metric type %v is not supported.\n", m.Type)
+		result = append(result, metric)
+	}
+	for i, m := range *cfg.Metrics {
+		if m.Type != "derived" {
+			continue
+		}
+		for j, source := range *cfg.Metrics {
+			if source.Name == m.Source { // already validated in config
+				result[i] = metrics.CreateDerivedMetric(m, result[j], source)
+			}
 		}
 	}
 	return result, nil
 }
 
+// createMetric creates a metric that is not derived.
+func createMetric(m *config.MetricConfig, patterns *Patterns) (metrics.Metric, error) {
+	match, err := Compile(m.Match, patterns)
+	if err != nil {
+		return nil, err
+	}
+	var repeat regex.Regexp
+	if m.Repeat != "" {
+		repeat, err = Compile(m.Repeat, patterns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var context regex.Regexp
+	if m.Context != nil {
+		context, err = Compile(m.Context.Match, patterns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var metric metrics.Metric
+	switch {
+	case m.Type == "counter":
+		metric = metrics.CreateGenericCounterVecMetric(m, match, repeat)
+	case m.Type == "gauge":
+		metric = metrics.CreateGaugeMetric(m, match, repeat)
+	case m.Type == "histogram":
+		metric = metrics.CreateHistogramMetric(m, match, repeat)
+	case m.Type == "summary":
+		metric = metrics.CreateSummaryMetric(m, match, repeat)
+	case m.Type == "quantile":
+		metric = metrics.CreateQuantileMetric(m, match, repeat)
+	default:
+		return nil, fmt.Errorf("Failed to initialize metrics: Metric type %v is not supported.\n", m.Type)
+	}
+	return metrics.WithContext(metric, m.Context, context), nil
+}
+
+// startResetSchedules starts a goroutine for each metric with a 'reset_schedule', which resets the metric on schedule.
+// The metrics are in the same order as in cfg.Metrics. The result maps each of these metrics to the channel stopping its goroutine.
+func startResetSchedules(cfg *config.Config, metricList []metrics.Metric) map[metrics.Metric]chan struct{} {
+	result := make(map[metrics.Metric]chan struct{})
+	for i, m := range *cfg.Metrics {
+		if stop := startResetSchedule(m, metricList[i]); stop != nil {
+			result[metricList[i]] = stop
+		}
+	}
+	return result
+}
+
+// startResetSchedule starts a goroutine resetting the metric on its 'reset_schedule', if configured.
+// The goroutine ends when the returned channel is closed. The result is nil if there is no 'reset_schedule'.
+func startResetSchedule(m *config.MetricConfig, metric metrics.Metric) chan struct{} {
+	if m.ResetSchedule == "" {
+		return nil
+	}
+	schedule, _ := cron.Parse(m.ResetSchedule) // already validated in config
+	stop := make(chan struct{})
+	go func() {
+		for {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+			select {
+			case <-time.After(next.Sub(time.Now())):
+				metric.Reset()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
 func startServer(cfg *config.Config, path string, handler http.Handler) chan error {
 	result := make(chan error)
+	if len(cfg.Server.AllowedCidrs) > 0 {
+		handler = server.AllowNetworks(cfg.Server.AllowedNetworks(), handler)
+	}
+	if cfg.Server.AccessLog {
+		handler = server.AccessLog(os.Stdout, handler)
+	}
+	options := server.Options{
+		ReadTimeout:    cfg.Server.ReadTimeout,
+		WriteTimeout:   cfg.Server.WriteTimeout,
+		IdleTimeout:    cfg.Server.IdleTimeout,
+		MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+		DisableHttp2:   cfg.Server.DisableHttp2,
+	}
 	go func() {
 		switch {
 		case cfg.Server.Protocol == "http":
-			result <- server.RunHttp(cfg.Server.Port, path, handler)
+			result <- server.RunHttp(cfg.Server.Port, path, handler, options)
 		case cfg.Server.Protocol == "https":
-			if cfg.Server.Cert != "" && cfg.Server.Key != "" {
-				result <- server.RunHttps(cfg.Server.Port, cfg.Server.Cert, cfg.Server.Key, path, handler)
+			if cfg.Server.Acme != nil {
+				acme := server.AcmeOptions{
+					Domains:       cfg.Server.Acme.Domains,
+					CacheDir:      cfg.Server.Acme.CacheDir,
+					DirectoryUrl:  cfg.Server.Acme.DirectoryUrl,
+					Email:         cfg.Server.Acme.Email,
+					ChallengePort: cfg.Server.Acme.ChallengePort,
+				}
+				result <- server.RunHttpsWithAcme(cfg.Server.Port, acme, path, handler, options)
+			} else if cfg.Server.Cert != "" && cfg.Server.Key != "" {
+				passphrase, err := cfg.Server.ReadKeyPassphrase()
+				if err != nil {
+					result <- err
+					return
+				}
+				result <- server.RunHttps(cfg.Server.Port, cfg.Server.Cert, cfg.Server.Key, passphrase, path, handler, options)
 			} else {
-				result <- server.RunHttpsWithDefaultKeys(cfg.Server.Port, path, handler)
+				result <- server.RunHttpsWithDefaultKeys(cfg.Server.Port, path, handler, options)
 			}
 		default:
 			// This is a bug, because cfg.validate() should make sure that protocol is either http or https.
@@ -114,37 +528,173 @@ func startServer(cfg *config.Config, path string, handler http.Handler) chan err
 	return result
 }
 
-func processLogLines(cfg *config.Config, metrics []metrics.Metric, serverErrorChannel chan error) error {
+func processLogLines(cfg *config.Config, p *pipeline, serverErrorChannel chan error) error {
+	if p.fileSet != nil && cfg.Input.FailOnMissingLogfile && len(p.fileSet.paths()) == 0 {
+		return fmt.Errorf("Initialization error: No log file matches %v.", strings.Join(cfg.Input.AllPaths(), ", "))
+	}
+	if cfg.Input.Type == "file" && cfg.Input.FailOnMissingLogfile && p.fileSet == nil {
+		path := datepath.Expand(cfg.Input.Path, time.Now())
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("Initialization error: Failed to read the log file: %v", err.Error())
+		}
+	}
 	switch {
+	case p.fileSet != nil:
+		return processLogLinesFileSet(cfg, p, serverErrorChannel)
+	case cfg.Input.Type == "file" && cfg.Input.Mode == "pull":
+		return processLogLinesPull(cfg, p, serverErrorChannel)
 	case cfg.Input.Type == "file":
-		return processLogLinesFile(cfg, metrics, serverErrorChannel)
+		return processLogLinesFile(cfg, p, serverErrorChannel)
 	case cfg.Input.Type == "stdin":
-		return processLogLinesStdin(cfg, metrics, serverErrorChannel)
+		return processLogLinesStdin(cfg, p, serverErrorChannel)
+	case cfg.Input.Type == "grpc":
+		return processLogLinesGrpc(p, serverErrorChannel)
 	default:
 		return fmt.Errorf("Config error: Input type '%v' unknown.", cfg.Input.Type)
 	}
 }
 
-func processLogLinesFile(cfg *config.Config, metrics []metrics.Metric, serverErrorChannel chan error) error {
-	lines := make(chan string)
-	t, err := tailer.New(tailer.Options{Lines: lines})
+// rolloverGracePeriod is how long the previous file of a path with date placeholders is still followed after the rollover.
+const rolloverGracePeriod = time.Minute
+
+// While the log file does not exist, it is checked with exponential backoff. The tailer would pick up the file when it
+// is created, but only if the directory exists, so the tailer is started when the file is there.
+const (
+	missingLogfileInitialBackoff = time.Second
+	missingLogfileMaxBackoff     = 30 * time.Second
+)
+
+func processLogLinesFile(cfg *config.Config, p *pipeline, serverErrorChannel chan error) error {
+	lines := make(chan string, queueSize)
+	p.dump.addQueue("lines", func() int { return len(lines) }, func() int { return cap(lines) })
+	t, err := newTailer(cfg.Input, lines)
 	if err != nil {
 		return fmt.Errorf("Initialization error: Failed to initialize the tail process: %v", err.Error())
 	}
-	go t.Tail(cfg.Input.Path, cfg.Input.Readall)
+	readall := cfg.Input.Readall || cfg.Input.Backfill > 0
+	if cfg.Input.Positions != nil {
+		p.positions, readall, err = newPositionTracker(cfg.Input.Positions, inputFs(cfg.Input), cfg.Input.Path, readall, p.timestamps)
+		if err != nil {
+			return fmt.Errorf("Initialization error: %v", err.Error())
+		}
+		onShutdown(p.positions.stop)
+	}
+	if cfg.Input.Backfill > 0 {
+		backfill(cfg.Input.Path, cfg.Input.Backfill, p.positions.lastTimestamp(), p)
+	}
+	path := cfg.Input.Path
+	var rollover <-chan time.Time // fires when the path with date placeholders names the next file, nil otherwise
+	if datepath.IsTemplate(cfg.Input.Path) {
+		now := time.Now()
+		path = datepath.Expand(cfg.Input.Path, now)
+		rollover = time.After(datepath.Next(cfg.Input.Path, now).Sub(now))
+	}
+	if cfg.Input.WarmupLines > 0 && !readall {
+		err = warmup(path, cfg.Input.WarmupLines, cfg, p)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Failed to warm up the gauges with the last lines of %v: %v\n", path, err.Error())
+		}
+	}
+	p.files.start(readall)
+	var retry <-chan time.Time // fires while waiting for the log file to appear, nil otherwise
+	backoff := missingLogfileInitialBackoff
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%v does not exist. Waiting for the file to be created.\n", path)
+		retry = time.After(backoff)
+	} else {
+		go t.Tail(path, readall)
+	}
 	for {
 		select {
+		case <-retry:
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				if backoff *= 2; backoff > missingLogfileMaxBackoff {
+					backoff = missingLogfileMaxBackoff
+				}
+				retry = time.After(backoff)
+				break
+			}
+			// The file is new, so all lines are read.
+			retry = nil
+			p.files.follow(path)
+			go t.Tail(path, true)
 		case err := <-serverErrorChannel:
 			t.Close()
+			p.positions.save()
 			return fmt.Errorf("Server error: %v", err.Error())
+		case now := <-rollover:
+			// The previous file is still followed for a while, because the application may write a few more lines to it.
+			retry = nil // the new tailer watches the directory for the next file
+			previous := t
+			t, err = newTailer(cfg.Input, lines)
+			if err != nil {
+				return fmt.Errorf("Failed to initialize the tail process: %v", err.Error())
+			}
+			time.AfterFunc(rolloverGracePeriod, func() { previous.Close() })
+			path = datepath.Expand(cfg.Input.Path, now)
+			p.files.follow(path)
+			p.fields = inputFields(cfg.Input, path)
+			go t.Tail(path, true)
+			rollover = time.After(datepath.Next(cfg.Input.Path, now).Sub(now))
 		case line := <-lines:
-			process(line, metrics)
+			p.files.read(lineBytes(line))
+			if !p.positions.skip(line) {
+				p.process(line, time.Now())
+			}
+		case <-p.positions.saveChannel():
+			p.positions.save()
+		case done := <-p.positions.stopChannel():
+			p.positions.save()
+			close(done)
+			// The process terminates when the shutdown hooks are done. Lines processed after the position was stored
+			// would be processed again after the restart, so no more lines are processed.
+			select {}
+		case <-p.multiline.timeoutChannel():
+			p.flush()
+		case <-p.reloads:
+			p.reloadPatterns()
+		case req := <-p.configReloads:
+			p.reloadConfig(req)
 		}
 	}
 }
 
-func processLogLinesStdin(cfg *config.Config, metrics []metrics.Metric, serverErrorChannel chan error) error {
-	c := stdinChan()
+// processLogLinesFileSet tails all files matching 'input.path' and 'input.paths'. The patterns are expanded periodically to find new files.
+func processLogLinesFileSet(cfg *config.Config, p *pipeline, serverErrorChannel chan error) error {
+	p.dump.addQueue("lines", func() int { return len(p.fileSet.lines) }, func() int { return cap(p.fileSet.lines) })
+	err := p.fileSet.scan(cfg.Input.Readall)
+	if err != nil {
+		return fmt.Errorf("Initialization error: %v", err.Error())
+	}
+	if len(p.fileSet.paths()) == 0 {
+		fmt.Fprintf(os.Stderr, "No log file matches %v. Waiting for files to be created.\n", strings.Join(cfg.Input.AllPaths(), ", "))
+	}
+	rescan := time.NewTicker(p.fileSet.rescanInterval())
+	defer rescan.Stop()
+	for {
+		select {
+		case <-rescan.C:
+			err = p.fileSet.scan(true)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err.Error())
+			}
+		case err := <-serverErrorChannel:
+			p.fileSet.close()
+			return fmt.Errorf("Server error: %v", err.Error())
+		case l := <-p.fileSet.lines:
+			p.input, p.fields = l.path, p.fileSet.read(l)
+			p.process(l.line, time.Now())
+		case <-p.reloads:
+			p.reloadPatterns()
+		case req := <-p.configReloads:
+			p.reloadConfig(req)
+		}
+	}
+}
+
+func processLogLinesStdin(cfg *config.Config, p *pipeline, serverErrorChannel chan error) error {
+	c := stdinChan(cfg.Input.Framing)
+	p.dump.addQueue("stdin", func() int { return len(c) }, func() int { return cap(c) })
 	for {
 		select {
 		case err := <-serverErrorChannel:
@@ -152,27 +702,54 @@ func processLogLinesStdin(cfg *config.Config, metrics []metrics.Metric, serverEr
 			return fmt.Errorf("Server error: %v", err.Error())
 		case r := <-c:
 			if r.err != nil {
+				p.flush()
 				// TODO: We should stop the server here.
 				return fmt.Errorf("Stopped reading on stdin: %v", r.err.Error())
 			}
-			process(r.line, metrics)
+			p.process(r.line, r.time)
+		case <-p.multiline.timeoutChannel():
+			p.flush()
+		case <-p.reloads:
+			p.reloadPatterns()
+		case req := <-p.configReloads:
+			p.reloadConfig(req)
+		}
+	}
+}
+
+func processLogLinesGrpc(p *pipeline, serverErrorChannel chan error) error {
+	for {
+		select {
+		case err := <-serverErrorChannel:
+			p.flush()
+			return fmt.Errorf("Server error: %v", err.Error())
+		case line := <-p.pushed:
+			p.process(line, time.Now())
+		case <-p.multiline.timeoutChannel():
+			p.flush()
+		case <-p.reloads:
+			p.reloadPatterns()
+		case req := <-p.configReloads:
+			p.reloadConfig(req)
 		}
 	}
 }
 
 type stdinRead struct {
 	line string
+	time time.Time
 	err  error
 }
 
-func stdinChan() chan (*stdinRead) {
-	out := make(chan (*stdinRead))
+func stdinChan(framing string) chan (*stdinRead) {
+	out := make(chan (*stdinRead), queueSize)
 	go func() {
 		reader := bufio.NewReader(os.Stdin)
 		for {
-			line, err := reader.ReadString('\n')
+			line, err := readFrame(reader, framing)
 			out <- &stdinRead{
 				line: line,
+				time: time.Now(),
 				err:  err,
 			}
 			if err != nil {
@@ -183,10 +760,117 @@ func stdinChan() chan (*stdinRead) {
 	return out
 }
 
-func process(line string, metrics []metrics.Metric) {
-	for _, metric := range metrics {
-		if metric.Matches(line) {
-			metric.Process(line)
+// pipeline holds everything needed to process a log line.
+type pipeline struct {
+	metrics    []metrics.Metric
+	input      string          // the input label of grok_exporter_input_bytes_total
+	tracer     *tracing.Tracer // nil if tracing is disabled
+	replay     *replayer       // nil if not in replay mode
+	throttle   *throttle       // nil if 'input.max_bytes_per_second' is not configured
+	multiline  *multiline      // nil if 'input.multiline' is not configured
+	reloader   *patternReloader
+	reloads    <-chan struct{}  // receives when the patterns changed, nil if 'grok.watch_patterns_dir' is not enabled
+	positions  *positionTracker // nil if 'input.positions' is not configured
+	pushed     chan string      // lines received via gRPC, nil if the input type is not grpc
+	sessions   []*metrics.SessionTracker
+	pulls      chan chan struct{} // receives on each scrape in pull mode, nil otherwise
+	timestamps *timestampParser   // nil unless both 'input.backfill' and 'input.timestamp' are configured
+	files      *fileStatus        // served at /api/files, nil if the input is not a single file
+	fileSet    *fileSet           // served at /api/files if 'input.paths' or a glob pattern is configured, nil otherwise
+	fields     map[string]string  // from 'input.labels' and 'input.path_match', and the 'logfile' of file inputs
+	unmatched  *unmatchedSample   // lines matching no metric, served at /debug/unmatched
+	ageFilter  *ageFilter         // nil if 'input.ignore_lines_older_than' is not configured
+	router     *router            // nil if 'routing' is not configured
+	dump       *stateDump         // printed on SIGQUIT, nil in the 'test' and 'bench' commands
+
+	configReloader *configReloader
+	configReloads  chan *reloadRequest // receives a request when the config should be reloaded, nil in the 'test' and 'bench' commands
+}
+
+// process reads a line from the input. With multiline, the line is added to the pending record,
+// and the previous record is processed if it is complete.
+func (p *pipeline) process(line string, readTime time.Time) {
+	n := lineBytes(line)
+	inputBytesTotal.WithLabelValues(p.input).Add(float64(n))
+	bytesTotal.Add(float64(n))
+	pipelineLinesTotal.Inc()
+	if delay := p.throttle.delay(n, time.Now()); delay > 0 {
+		time.Sleep(delay)
+	}
+	if p.multiline == nil {
+		p.processRecord(line, readTime)
+	} else if record, recordTime, complete := p.multiline.add(line, readTime); complete {
+		p.processRecord(record, recordTime)
+	}
+}
+
+func (p *pipeline) reloadPatterns() {
+	patterns, router, err := p.reloader.reload()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to reload the patterns, keeping the previous match expressions: %v\n", err.Error())
+		return
+	}
+	p.router = router
+	if p.configReloader != nil {
+		// Otherwise the next config reload would compare the expressions with the old patterns, and re-create the metrics.
+		p.configReloader.patterns = patterns
+	}
+}
+
+// flush processes the pending multiline record, if any.
+func (p *pipeline) flush() {
+	if p.multiline == nil {
+		return
+	}
+	if record, recordTime, complete := p.multiline.flush(); complete {
+		p.processRecord(record, recordTime)
+	}
+}
+
+// processRecord updates all metrics matching the line or multiline record. If the trace is sampled, it starts at readTime,
+// so that the time the line was waiting to be processed is included.
+func (p *pipeline) processRecord(line string, readTime time.Time) {
+	if p.ageFilter.tooOld(line, time.Now()) {
+		linesTooOldTotal.Inc()
+		return
+	}
+	if delay := p.replay.delay(line, time.Now()); delay > 0 {
+		time.Sleep(delay)
+	}
+	p.dump.startProcessing(time.Now())
+	defer p.dump.stopProcessing()
+	span := p.tracer.StartTrace("process_line", readTime)
+	defer span.End()
+	span.StartChildAt("input_read", readTime).End()
+	timer := startStageTimer()
+	matched := false
+	route := p.router.route(line, p.fields)
+	for i, metric := range p.metrics {
+		if !p.router.accepts(i, route) {
+			continue
 		}
+		matchSpan := span.StartChild("match")
+		matchSpan.SetAttribute("metric", metric.Name())
+		matchStart := time.Now()
+		matches := metric.Matches(line)
+		matchEnd := time.Now()
+		timer.match += matchEnd.Sub(matchStart)
+		matchSpan.SetAttribute("matched", strconv.FormatBool(matches))
+		matchSpan.End()
+		if matches {
+			updateSpan := span.StartChild("update")
+			updateSpan.SetAttribute("metric", metric.Name())
+			metric.Process(line, p.fields)
+			timer.update += time.Since(matchEnd)
+			updateSpan.End()
+			matched = true
+		}
+	}
+	if !matched {
+		p.unmatched.add(line)
+	}
+	for _, s := range p.sessions {
+		s.Process(line, time.Now())
 	}
+	timer.observe(matched)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/mutate"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// loadMappings registers the tables of 'mappings' for the 'fields.mutate' function 'map(name)'.
+// Tables with a 'file' are read from the file.
+func loadMappings(cfg *config.Config) error {
+	byName, err := buildMappings(cfg)
+	if err != nil {
+		return err
+	}
+	mutate.SetMappings(byName)
+	return nil
+}
+
+// buildMappings creates the tables of 'mappings' without registering them, so that a config reload can check the new config first.
+func buildMappings(cfg *config.Config) (map[string]*mutate.Mapping, error) {
+	result := make(map[string]*mutate.Mapping, len(cfg.Mappings))
+	for name, mappingConfig := range cfg.Mappings {
+		exact, rules, err := mappingEntries(mappingConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Mapping %v: %v", name, err.Error())
+		}
+		result[name] = mutate.NewMapping(exact, rules, mappingConfig.Default)
+	}
+	return result, nil
+}
+
+// withMappings registers the tables while f runs, and restores the previously registered tables afterwards.
+// A config reload runs the 'test_lines' of the new config with the new tables before they are registered for good.
+// It must be called from the goroutine processing the log lines, so that no log line is processed with the new tables.
+func withMappings(byName map[string]*mutate.Mapping, f func() error) error {
+	previous := mutate.SetMappings(byName)
+	defer mutate.SetMappings(previous)
+	return f()
+}
+
+// mappingEntries returns the inline entries, or the entries from the file if 'file' is configured.
+func mappingEntries(cfg *config.MappingConfig) (map[string]string, []mutate.Rule, error) {
+	entries := cfg
+	if cfg.File != "" {
+		var err error
+		entries, err = config.LoadMappingFile(cfg.File)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	rules := make([]mutate.Rule, 0, len(entries.Regex))
+	for _, r := range entries.Regex {
+		regex := regexp.MustCompile(r.Match) // already validated in config
+		rules = append(rules, mutate.Rule{Regex: regex, Value: r.Value})
+	}
+	return entries.Values, rules, nil
+}
+
+// watchMappings re-reads the mapping files when they change. If a file is invalid, an error is printed and the previous table remains active.
+// The tables are synchronized, so they can be updated while the lines are processed. loadMappings() must be called first.
+// The files are watched until stop is closed, which happens when a config reload registers new tables.
+func watchMappings(cfg *config.Config, stop <-chan struct{}) error {
+	for name, mappingConfig := range cfg.Mappings {
+		mapping, registered := mutate.RegisteredMapping(name)
+		if mappingConfig.File == "" || !registered {
+			continue
+		}
+		changes, err := watchDir(filepath.Dir(mappingConfig.File), stop)
+		if err != nil {
+			return err
+		}
+		go func(name string, mappingConfig *config.MappingConfig) {
+			for {
+				select {
+				case <-changes:
+				case <-stop:
+					return
+				}
+				exact, rules, err := mappingEntries(mappingConfig)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: Failed to reload mapping %v: %v\n", name, err.Error())
+					continue
+				}
+				mapping.Update(exact, rules, mappingConfig.Default)
+				fmt.Fprintf(os.Stderr, "Reloaded mapping %v.\n", name)
+			}
+		}(name, mappingConfig)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"sync"
+)
+
+// contextMetric wraps a metric with 'context', so that a line only matches if one of the previous 'context.lines' lines
+// matched the context expression. The captures of the most recent context line are fields for the labels and value,
+// with lower precedence than the line's own captures.
+//
+// The window is updated in Matches(), which is called for every line, so lines that match no metric count as well.
+type contextMetric struct {
+	Metric
+	regex    regex.Regexp
+	lines    int
+	mutex    sync.Mutex
+	seen     bool              // a context line was read
+	distance int               // number of lines since the latest context line
+	captures map[string]string // captures of the latest context line
+	matched  map[string]string // captures of the context line for the current line, see Process()
+}
+
+// WithContext returns the metric itself if 'context' is not configured. regex is the compiled 'context.match' expression.
+func WithContext(m Metric, cfg *config.ContextConfig, regex regex.Regexp) Metric {
+	if cfg == nil {
+		return m
+	}
+	return &contextMetric{
+		Metric: m,
+		regex:  regex,
+		lines:  cfg.Lines,
+	}
+}
+
+// SetMatch replaces the compiled 'context.match' expression and the expressions of the wrapped metric.
+func (m *contextMetric) SetMatch(regex, repeat, context regex.Regexp) {
+	m.mutex.Lock()
+	m.regex = context
+	m.mutex.Unlock()
+	m.Metric.SetMatch(regex, repeat, nil)
+}
+
+func (m *contextMetric) Matches(line string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.distance++
+	inContext, captures := m.seen && m.distance <= m.lines, m.captures
+	if m.regex.MatchString(line) {
+		m.seen, m.distance, m.captures = true, 0, firstMatch(m.regex, line)
+	}
+	if inContext && m.Metric.Matches(line) {
+		m.matched = captures
+		return true
+	}
+	return false
+}
+
+// Process must be called after Matches() returned true for the line.
+func (m *contextMetric) Process(line string, fields map[string]string) {
+	m.mutex.Lock()
+	context := m.matched
+	m.mutex.Unlock()
+	if len(context) == 0 {
+		m.Metric.Process(line, fields)
+		return
+	}
+	merged := make(map[string]string, len(context)+len(fields))
+	for key, value := range fields {
+		merged[key] = value
+	}
+	for key, value := range context {
+		merged[key] = value
+	}
+	m.Metric.Process(line, merged)
+}
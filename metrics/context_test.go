@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	counter := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "oom_total",
+		Help: "Out of memory errors.",
+		Labels: []config.Label{
+			{GrokFieldName: "service", PrometheusLabel: "service"},
+		},
+	}, regex.MustCompile(`Caused by: OutOfMemoryError`), nil).(*genericCounterVecMetric)
+	m := WithContext(counter, &config.ContextConfig{Lines: 2}, regex.MustCompile(`Starting (?<service>[a-z]+)`))
+	for _, test := range []struct {
+		line     string
+		expected bool
+	}{
+		{"Caused by: OutOfMemoryError", false}, // no context yet
+		{"Starting checkout", false},
+		{"Caused by: OutOfMemoryError", true},
+		{"at Main.main()", false},
+		{"Caused by: OutOfMemoryError", false}, // the context is 3 lines back
+		{"Starting billing", false},
+		{"Caused by: OutOfMemoryError", true},
+	} {
+		matches := m.Matches(test.line)
+		if matches != test.expected {
+			t.Errorf("%q: Expected Matches() to return %v.", test.line, test.expected)
+		}
+		if matches {
+			m.Process(test.line, nil)
+		}
+	}
+	for service, expected := range map[string]float64{"checkout": 1, "billing": 1} {
+		var result dto.Metric
+		counter.counter.WithLabelValues(service).Write(&result)
+		if result.GetCounter().GetValue() != expected {
+			t.Errorf("%v: Expected %v, but got %v.", service, expected, result.GetCounter().GetValue())
+		}
+	}
+	if WithContext(counter, nil, nil) != counter {
+		t.Error("Expected the metric to be returned unchanged without context.")
+	}
+}
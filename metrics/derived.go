@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A derived metric exposes a windowed rate or average of another metric as gauge.
+// This is for consumers reading /metrics directly without PromQL, like scripts or simple dashboards.
+// The source metric is sampled periodically, so the derived values lag behind by up to one sample interval.
+
+type derivedMetric struct {
+	name     string
+	source   Metric
+	function string // "rate" or "average"
+	window   time.Duration
+	per      time.Duration
+	labels   []string // label names of the source metric
+	desc     *prometheus.Desc
+	mutex    sync.Mutex
+	series   map[string]*derivedSeries
+	stop     chan struct{} // closed by Stop() to end the sampling
+}
+
+type derivedSeries struct {
+	labelValues []string
+	samples     []derivedSample // oldest first
+}
+
+type derivedSample struct {
+	time         time.Time
+	value        float64 // counter or gauge value, or the sample count for histograms and summaries
+	sum          float64 // sample sum for histograms and summaries
+	observations bool    // true for histograms and summaries
+}
+
+// CreateDerivedMetric creates a derived metric and starts sampling the source metric in the background.
+// sourceCfg is the configuration of the source metric, which is needed for the label names.
+func CreateDerivedMetric(cfg *config.MetricConfig, source Metric, sourceCfg *config.MetricConfig) Metric {
+	labels := make([]string, 0, len(sourceCfg.Labels))
+	for _, label := range sourceCfg.Labels {
+		labels = append(labels, label.PrometheusLabel)
+	}
+	m := &derivedMetric{
+		name:     cfg.Name,
+		source:   source,
+		function: cfg.Function,
+		window:   cfg.Window,
+		per:      cfg.Per,
+		labels:   labels,
+		desc:     prometheus.NewDesc(cfg.Name, cfg.Help, labels, nil),
+		series:   make(map[string]*derivedSeries),
+		stop:     make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(sampleInterval(m.window))
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				m.sample(now)
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// sampleInterval is 1/60 of the window, but at least one second and at most one minute.
+func sampleInterval(window time.Duration) time.Duration {
+	interval := window / 60
+	switch {
+	case interval < time.Second:
+		return time.Second
+	case interval > time.Minute:
+		return time.Minute
+	default:
+		return interval
+	}
+}
+
+func (m *derivedMetric) Name() string {
+	return m.name
+}
+
+func (m *derivedMetric) Collector() prometheus.Collector {
+	return m
+}
+
+// Derived metrics are not updated by log lines.
+func (m *derivedMetric) Matches(line string) bool {
+	return false
+}
+
+func (m *derivedMetric) Process(line string, fields map[string]string) {}
+
+func (m *derivedMetric) SetMatch(regex, repeat, context regex.Regexp) {}
+
+func (m *derivedMetric) Stop() {
+	close(m.stop)
+}
+
+func (m *derivedMetric) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.series = make(map[string]*derivedSeries)
+}
+
+// Derived series are removed when the source series is removed.
+func (m *derivedMetric) Expire(now time.Time) int {
+	return 0
+}
+
+func (m *derivedMetric) LastMatches() []Match {
+	return m.source.LastMatches()
+}
+
+func (m *derivedMetric) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+}
+
+func (m *derivedMetric) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, s := range m.series {
+		if value, ok := m.calculate(s.samples); ok {
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, value, s.labelValues...)
+		}
+	}
+}
+
+// sample records the current values of the source metric, and drops samples that are no longer needed.
+func (m *derivedMetric) sample(now time.Time) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		m.source.Collector().Collect(ch)
+		close(ch)
+	}()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for metric := range ch {
+		var d dto.Metric
+		if metric.Write(&d) != nil {
+			continue
+		}
+		labelValues := m.labelValues(&d)
+		key := strings.Join(labelValues, "\xff")
+		s, exists := m.series[key]
+		if !exists {
+			s = &derivedSeries{labelValues: labelValues}
+			m.series[key] = s
+		}
+		s.samples = append(s.samples, toSample(&d, now))
+	}
+	for key, s := range m.series {
+		// Keep the last sample before the window, so that the full window is covered.
+		i := 0
+		for i+1 < len(s.samples) && !s.samples[i+1].time.After(now.Add(-m.window)) {
+			i++
+		}
+		s.samples = s.samples[i:]
+		if len(s.samples) > 0 && s.samples[len(s.samples)-1].time.Before(now.Add(-m.window)) {
+			delete(m.series, key) // the series was removed from the source
+		}
+	}
+}
+
+func (m *derivedMetric) labelValues(d *dto.Metric) []string {
+	result := make([]string, len(m.labels))
+	for _, pair := range d.Label {
+		for i, name := range m.labels {
+			if pair.GetName() == name {
+				result[i] = pair.GetValue()
+			}
+		}
+	}
+	return result
+}
+
+func toSample(d *dto.Metric, now time.Time) derivedSample {
+	switch {
+	case d.Counter != nil:
+		return derivedSample{time: now, value: d.Counter.GetValue()}
+	case d.Gauge != nil:
+		return derivedSample{time: now, value: d.Gauge.GetValue()}
+	case d.Histogram != nil:
+		return derivedSample{time: now, value: float64(d.Histogram.GetSampleCount()), sum: d.Histogram.GetSampleSum(), observations: true}
+	case d.Summary != nil:
+		return derivedSample{time: now, value: float64(d.Summary.GetSampleCount()), sum: d.Summary.GetSampleSum(), observations: true}
+	default:
+		return derivedSample{time: now, value: d.Untyped.GetValue()}
+	}
+}
+
+// calculate returns false if there are not enough samples yet.
+//
+// rate is the increase per 'per' duration. As in Prometheus, a decreasing value is interpreted as a reset.
+// For histograms and summaries, this is the rate of observations.
+//
+// average is the mean of the samples for gauges, and the average observed value for histograms and summaries.
+func (m *derivedMetric) calculate(samples []derivedSample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	switch m.function {
+	case "rate":
+		increase := 0.0
+		for i := 1; i < len(samples); i++ {
+			if samples[i].value >= samples[i-1].value {
+				increase += samples[i].value - samples[i-1].value
+			} else {
+				increase += samples[i].value
+			}
+		}
+		return increase * float64(m.per) / float64(last.time.Sub(first.time)), true
+	default: // average
+		if last.observations {
+			count := last.value - first.value
+			if count <= 0 {
+				return 0, false
+			}
+			return (last.sum - first.sum) / count, true
+		}
+		total := 0.0
+		for _, s := range samples {
+			total += s.value
+		}
+		return total / float64(len(samples)), true
+	}
+}
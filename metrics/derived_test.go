@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+	"time"
+)
+
+func TestDerivedRate(t *testing.T) {
+	sourceCfg := &config.MetricConfig{
+		Name: "errors_total",
+		Help: "Errors.",
+		Labels: []config.Label{
+			{GrokFieldName: "level", PrometheusLabel: "level"},
+		},
+	}
+	source := CreateGenericCounterVecMetric(sourceCfg, regex.MustCompile(`(?<level>ERROR|WARN)`), nil)
+	m := CreateDerivedMetric(&config.MetricConfig{
+		Name:     "errors_per_minute",
+		Help:     "Errors per minute.",
+		Source:   "errors_total",
+		Function: "rate",
+		Window:   5 * time.Minute,
+		Per:      time.Minute,
+	}, source, sourceCfg).(*derivedMetric)
+	start := time.Now()
+	source.Process("ERROR", nil)
+	m.sample(start)
+	if len(collect(m)) != 0 {
+		t.Error("Expected no value before the second sample.")
+	}
+	for i := 0; i < 10; i++ {
+		source.Process("ERROR", nil)
+	}
+	m.sample(start.Add(time.Minute))
+	for i := 0; i < 20; i++ { // 30 errors in 2 minutes
+		source.Process("ERROR", nil)
+	}
+	m.sample(start.Add(2 * time.Minute))
+	result := collect(m)
+	if len(result) != 1 || result[0].GetGauge().GetValue() != 15 {
+		t.Fatalf("Expected 15 errors per minute, but got %v.", result)
+	}
+	if result[0].Label[0].GetValue() != "ERROR" {
+		t.Errorf("Expected label level=ERROR, but got %v.", result[0].Label)
+	}
+	// Samples older than the window are dropped, except for the last one before the window.
+	m.sample(start.Add(7 * time.Minute))
+	result = collect(m)
+	if len(result) != 1 || result[0].GetGauge().GetValue() != 0 {
+		t.Fatalf("Expected 0 errors per minute, but got %v.", result)
+	}
+}
+
+func collect(collector prometheus.Collector) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	result := make([]*dto.Metric, 0)
+	for metric := range ch {
+		var d dto.Metric
+		metric.Write(&d)
+		result = append(result, &d)
+	}
+	return result
+}
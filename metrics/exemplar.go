@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"math/rand"
+	"time"
+)
+
+// Exemplar is a sampled observation of a label set, with the values of the 'exemplar.fields', like a trace ID.
+type Exemplar struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+	Time   time.Time         `json:"time"`
+}
+
+// exemplarSampler keeps the current exemplar per label set. The first observation of a label set is always kept,
+// later observations replace it with probability 'sample_rate'. exemplarSampler is not thread safe.
+type exemplarSampler struct {
+	fields     []string // field names, as in 'exemplar.fields'
+	captures   []string // for each field, the name of the grok capture providing the value, see 'fields'
+	sampleRate float64
+	random     func() float64
+	exemplars  map[string]*Exemplar // label set key -> current exemplar
+}
+
+// newExemplarSampler returns nil if cfg is nil.
+func newExemplarSampler(cfg *config.ExemplarConfig, fields *config.FieldsConfig) *exemplarSampler {
+	if cfg == nil {
+		return nil
+	}
+	captures := make([]string, 0, len(cfg.Fields))
+	for _, field := range cfg.Fields {
+		capture, _ := fields.CaptureName(field) // dropped fields are rejected in validateMetrics()
+		captures = append(captures, capture)
+	}
+	return &exemplarSampler{
+		fields:     cfg.Fields,
+		captures:   captures,
+		sampleRate: cfg.SampleRate,
+		random:     rand.Float64,
+		exemplars:  make(map[string]*Exemplar),
+	}
+}
+
+// sample returns the current exemplar of the label set, after the observation was sampled. A nil sampler returns nil.
+// Fields without a value are omitted, and an observation without any of the fields is not sampled.
+func (s *exemplarSampler) sample(key string, captures map[string]string, value float64, now time.Time) *Exemplar {
+	if s == nil {
+		return nil
+	}
+	current, exists := s.exemplars[key]
+	if exists && s.random() >= s.sampleRate {
+		return current
+	}
+	labels := make(map[string]string, len(s.fields))
+	for i, field := range s.fields {
+		if v := captures[s.captures[i]]; v != "" {
+			labels[field] = v
+		}
+	}
+	if len(labels) == 0 {
+		return current
+	}
+	s.exemplars[key] = &Exemplar{Labels: labels, Value: value, Time: now}
+	return s.exemplars[key]
+}
+
+func (s *exemplarSampler) remove(key string) {
+	if s != nil {
+		delete(s.exemplars, key)
+	}
+}
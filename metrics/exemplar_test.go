@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"testing"
+)
+
+func TestExemplar(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "requests_total",
+		Help: "Requests.",
+		Labels: []config.Label{
+			{GrokFieldName: "status", PrometheusLabel: "status"},
+		},
+		Exemplar: &config.ExemplarConfig{Fields: []string{"trace_id"}, SampleRate: 0.5},
+	}, regex.MustCompile(`status=(?<status>[0-9]+)( trace=(?<trace_id>[a-f0-9]+))?`), nil).(*genericCounterVecMetric)
+	random := 0.9
+	m.exemplars.random = func() float64 { return random }
+	m.Process("status=500 trace=abc", nil)
+	m.Process("status=500 trace=def", nil) // not sampled, as 0.9 >= 0.5
+	assertExemplar(t, m.LastMatches(), "abc")
+	random = 0.1
+	m.Process("status=500", nil) // sampled, but there is no trace ID
+	assertExemplar(t, m.LastMatches(), "abc")
+	m.Process("status=500 trace=123", nil)
+	assertExemplar(t, m.LastMatches(), "123")
+}
+
+func assertExemplar(t *testing.T, matches []Match, traceId string) {
+	if len(matches) != 1 || matches[0].Exemplar == nil || matches[0].Exemplar.Labels["trace_id"] != traceId || matches[0].Exemplar.Value != 1 {
+		t.Errorf("Expected an exemplar with trace_id %v, but got %#v.", traceId, matches)
+	}
+}
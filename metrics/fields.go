@@ -0,0 +1,31 @@
+package metrics
+
+import "github.com/fstab/grok_exporter/regex"
+
+// firstMatch returns the named captures of the first match of regex in line, or an empty map if there is no match.
+func firstMatch(regex regex.Regexp, line string) map[string]string {
+	var result map[string]string
+	regex.GsubFunc(line, func(_ string, captures map[string]string) string {
+		if result == nil {
+			result = captures
+		}
+		return ""
+	})
+	if result == nil {
+		result = make(map[string]string)
+	}
+	return result
+}
+
+// ExtractField returns the value of the named capture for the first match of regex in line.
+func ExtractField(regex regex.Regexp, line string, field string) (string, bool) {
+	var value string
+	found := false
+	regex.GsubFunc(line, func(_ string, captures map[string]string) string {
+		if !found {
+			value, found = captures[field]
+		}
+		return ""
+	})
+	return value, found
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"encoding/json"
+	"github.com/fstab/grok_exporter/config"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// formatDetectionLines is the number of lines of a source inspected by format auto.
+// Then the most common format of these lines is used for all further lines of the source.
+const formatDetectionLines = 10
+
+// formatDetector implements format auto. It selects JSON, logfmt, or plain text for each source,
+// which is the log file, or the input if it has no log files, like stdin.
+// It is not thread-safe, the caller must hold the metric's mutex.
+type formatDetector struct {
+	overrides map[string]string // 'formats': glob pattern of the log file -> format
+	kv        *config.KvConfig  // how logfmt lines are parsed
+	sources   map[string]*sourceFormat
+}
+
+type sourceFormat struct {
+	format string         // the selected format, empty while the first lines are inspected
+	counts map[string]int // format -> number of lines, while the first lines are inspected
+}
+
+// newFormatDetector returns nil unless the metric has format auto.
+func newFormatDetector(cfg *config.MetricConfig) *formatDetector {
+	if cfg.Format != "auto" {
+		return nil
+	}
+	kv := cfg.Kv
+	if kv == nil {
+		kv = &config.KvConfig{PairSeparator: " ", ValueSeparator: "="}
+	}
+	return &formatDetector{
+		overrides: cfg.Formats,
+		kv:        kv,
+		sources:   make(map[string]*sourceFormat),
+	}
+}
+
+// fields returns the JSON or logfmt fields of the line, or nil for plain text.
+func (d *formatDetector) fields(line string, source string) map[string]string {
+	switch d.format(line, source) {
+	case "json":
+		return parseJson(line)
+	case "logfmt":
+		return parseKeyValues(line, d.kv)
+	default:
+		return nil
+	}
+}
+
+// format returns the format of the source, or the format of the line while the first lines of the source are inspected.
+func (d *formatDetector) format(line string, source string) string {
+	s, exists := d.sources[source]
+	if !exists {
+		s = &sourceFormat{counts: make(map[string]int)}
+		for pattern, format := range d.overrides {
+			if matched, _ := filepath.Match(pattern, source); matched {
+				s.format = format
+				break
+			}
+		}
+		d.sources[source] = s
+	}
+	if s.format != "" {
+		return s.format
+	}
+	format := detectFormat(line, d.kv)
+	s.counts[format]++
+	if s.counts["json"]+s.counts["logfmt"]+s.counts["plain"] >= formatDetectionLines {
+		s.format = "plain"
+		for _, f := range []string{"logfmt", "json"} { // on a tie, structured formats are preferred
+			if s.counts[f] >= s.counts[s.format] {
+				s.format = f
+			}
+		}
+		s.counts = nil
+	}
+	return format
+}
+
+// detectFormat returns "json" for a JSON object, "logfmt" if the line has at least two key=value pairs,
+// and at least half of the tokens are pairs, and "plain" otherwise.
+func detectFormat(line string, kv *config.KvConfig) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") && json.Valid([]byte(line)) {
+		return "json"
+	}
+	tokens := splitPairs(line, kv.PairSeparator)
+	pairs := 0
+	for _, token := range tokens {
+		if strings.Index(token, kv.ValueSeparator) > 0 {
+			pairs++
+		}
+	}
+	if pairs >= 2 && 2*pairs >= len(tokens) {
+		return "logfmt"
+	}
+	return "plain"
+}
+
+// parseJson returns the fields of a JSON object. Nested objects are flattened, {"http":{"status":404}} is the field http_status.
+// Arrays are returned as JSON text, and null is an empty string.
+func parseJson(line string) map[string]string {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil
+	}
+	result := make(map[string]string, len(doc))
+	flattenJson(result, "", doc)
+	return result
+}
+
+func flattenJson(result map[string]string, prefix string, doc map[string]interface{}) {
+	for key, value := range doc {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenJson(result, prefix+key+"_", v)
+		case string:
+			result[prefix+key] = v
+		case json.Number:
+			result[prefix+key] = v.String()
+		case bool:
+			result[prefix+key] = strconv.FormatBool(v)
+		case nil:
+			result[prefix+key] = ""
+		default:
+			data, _ := json.Marshal(v)
+			result[prefix+key] = string(data)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"reflect"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	kv := &config.KvConfig{PairSeparator: " ", ValueSeparator: "="}
+	for line, expected := range map[string]string{
+		`{"level":"error","user":"alice"}`:           "json",
+		`  {"level":"error"}`:                        "json",
+		`{"level":`:                                  "plain",
+		`level=error user=alice msg="not found"`:     "logfmt",
+		`2016-04-01 GET /index.html took=12ms`:       "plain",
+		`127.0.0.1 - - [10/Oct/2016:13:55:36] "GET"`: "plain",
+	} {
+		if format := detectFormat(line, kv); format != expected {
+			t.Errorf("%q: Expected %v, but got %v.", line, expected, format)
+		}
+	}
+}
+
+func TestParseJson(t *testing.T) {
+	result := parseJson(`{"level":"error","http":{"status":404,"ok":false},"tags":["a","b"],"trace":null}`)
+	expected := map[string]string{"level": "error", "http_status": "404", "http_ok": "false", "tags": `["a","b"]`, "trace": ""}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, but got %v.", expected, result)
+	}
+	if result = parseJson(`[1, 2]`); result != nil {
+		t.Errorf("Expected no fields for a JSON array, but got %v.", result)
+	}
+}
+
+func TestFormatDetector(t *testing.T) {
+	d := newFormatDetector(&config.MetricConfig{Format: "auto", Formats: map[string]string{"/var/log/legacy/*.log": "plain"}})
+	// While the first lines are inspected, each line is parsed according to its own format.
+	if fields := d.fields(`{"user":"alice"}`, "/var/log/app.log"); fields["user"] != "alice" {
+		t.Errorf("Expected JSON fields, but got %v.", fields)
+	}
+	for i := 1; i < formatDetectionLines; i++ {
+		d.fields("user=bob status=200", "/var/log/app.log")
+	}
+	// The most common format of the first lines is used for the source.
+	if fields := d.fields(`{"user":"alice"}`, "/var/log/app.log"); len(fields) > 0 {
+		t.Errorf("Expected logfmt to be selected, but got %v.", fields)
+	}
+	if fields := d.fields("user=bob status=200", "/var/log/app.log"); fields["user"] != "bob" {
+		t.Errorf("Expected logfmt fields, but got %v.", fields)
+	}
+	if fields := d.fields(`{"user":"alice"}`, "/var/log/other.log"); fields["user"] != "alice" {
+		t.Errorf("Expected the format to be detected independently for each source, but got %v.", fields)
+	}
+	if fields := d.fields("user=bob status=200", "/var/log/legacy/app.log"); fields != nil {
+		t.Errorf("Expected the format to be plain for /var/log/legacy/*.log, but got %v.", fields)
+	}
+	if newFormatDetector(&config.MetricConfig{Format: "xml"}) != nil {
+		t.Errorf("Expected no format detector without format auto.")
+	}
+}
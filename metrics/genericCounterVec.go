@@ -1,36 +1,126 @@
 package metrics
 
 import (
-	"fmt"
 	"github.com/fstab/grok_exporter/config"
-	"github.com/moovweb/rubex"
+	"github.com/fstab/grok_exporter/mutate"
+	"github.com/fstab/grok_exporter/notify"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/fstab/grok_exporter/xpath"
 	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type genericCounterVecMetric struct {
-	name    string
-	labels  []config.Label
-	regex   *rubex.Regexp
-	counter *prometheus.CounterVec
+	name      string
+	labels    []config.Label
+	captures  []string      // for each label, the name of the grok capture providing the value, see 'fields'
+	mutators  []mutate.Func // for each label, the 'fields.mutate' functions, or nil
+	regex     regex.Regexp
+	counter   *prometheus.CounterVec
+	mutex     sync.Mutex
+	series    *seriesCache  // label values of all series, so that Reset() can re-create them with value zero
+	retention time.Duration // 'retention', or 0 if series are kept forever
+	last      *lastMatches
+	value     string                 // grok capture providing the 'value', or empty
+	mutator   mutate.Func            // 'fields.mutate' functions for the value, or nil
+	fromTotal bool                   // value is a running total
+	totals    map[string]float64     // last running total per label set, for 'from_total'
+	split     string                 // if not empty, value is a list, and each element counts as one observation
+	repeat    regex.Regexp           // if not nil, each occurrence of repeat in a matching line is observed separately
+	kv        *config.KvConfig       // if not nil, key=value tokens in the line are available as fields
+	stages    stages                 // the 'pipeline', or nil
+	xml       map[string]*xpath.Path // for format xml, fields selected from the XML document in the line
+	formats   *formatDetector        // for format auto, or nil
+	notifier  *notify.Notifier       // nil if 'notify' is not configured
+	limiter   *rateLimiter           // nil if 'rate_limit' is not configured
+	exemplars *exemplarSampler       // nil if 'exemplar' is not configured
+	maxLength *labelLength           // nil if 'max_label_length' is not configured
+	perScrape *prometheus.Desc       // gauge for 'per_scrape', or nil
+	sum       *prometheus.CounterVec // companion counter for 'sum_field', or nil
+	sumName   string
+	sumField  string      // grok capture providing the values for the sum
+	sumMutate mutate.Func // 'fields.mutate' functions for the sum field, or nil
 }
 
-func CreateGenericCounterVecMetric(cfg *config.MetricConfig, regex *rubex.Regexp) Metric {
+// CreateGenericCounterVecMetric creates a counter. repeat is the compiled 'repeat' expression, or nil if not configured.
+func CreateGenericCounterVecMetric(cfg *config.MetricConfig, regex regex.Regexp, repeat regex.Regexp) Metric {
 	prometheusLabels := make([]string, 0, len(cfg.Labels))
+	captures := make([]string, 0, len(cfg.Labels))
+	mutators := make([]mutate.Func, 0, len(cfg.Labels))
 	for _, label := range cfg.Labels {
 		prometheusLabels = append(prometheusLabels, label.PrometheusLabel)
+		capture, _ := cfg.Fields.CaptureName(label.GrokFieldName) // dropped fields are rejected in validateMetrics()
+		captures = append(captures, capture)
+		mutators = append(mutators, mutator(cfg.Fields, label.GrokFieldName))
+	}
+	value := cfg.Value
+	if value != "" {
+		value, _ = cfg.Fields.CaptureName(value)
+	}
+	xml := make(map[string]*xpath.Path, len(cfg.Xml))
+	for field, expression := range cfg.Xml {
+		xml[field], _ = xpath.Compile(expression) // already validated in config
+	}
+	kv := cfg.Kv
+	if cfg.Format == "auto" {
+		kv = nil // only lines detected as logfmt are parsed with 'kv'
+	}
+	var perScrape *prometheus.Desc
+	if cfg.PerScrape {
+		perScrape = prometheus.NewDesc(cfg.Name, cfg.Help, prometheusLabels, nil)
+	}
+	var sum *prometheus.CounterVec
+	sumField := cfg.SumField
+	if sumField != "" {
+		sumField, _ = cfg.Fields.CaptureName(sumField)
+		sum = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: cfg.SumName,
+			Help: cfg.SumHelp(),
+		}, prometheusLabels)
 	}
 	return &genericCounterVecMetric{
-		name:   cfg.Name,
-		labels: cfg.Labels,
-		regex:  regex,
+		name:     cfg.Name,
+		labels:   cfg.Labels,
+		captures: captures,
+		mutators: mutators,
+		regex:    regex,
 		counter: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: cfg.Name,
 			Help: cfg.Help,
 		}, prometheusLabels),
+		series:    newSeriesCache(cfg.MaxSeries),
+		retention: cfg.Retention,
+		last:      newLastMatches(),
+		value:     value,
+		mutator:   mutator(cfg.Fields, cfg.Value),
+		fromTotal: cfg.FromTotal,
+		totals:    make(map[string]float64),
+		split:     cfg.Split,
+		repeat:    repeat,
+		kv:        kv,
+		stages:    newStages(cfg),
+		xml:       xml,
+		formats:   newFormatDetector(cfg),
+		notifier:  notify.New(cfg.Name, cfg.Notify),
+		limiter:   newRateLimiter(cfg.Name, cfg.RateLimit),
+		exemplars: newExemplarSampler(cfg.Exemplar, cfg.Fields),
+		maxLength: newLabelLength(cfg),
+		perScrape: perScrape,
+		sum:       sum,
+		sumName:   cfg.SumName,
+		sumField:  sumField,
+		sumMutate: mutator(cfg.Fields, cfg.SumField),
 	}
 }
 
 func (m *genericCounterVecMetric) Collector() prometheus.Collector {
+	if m.perScrape != nil {
+		return &perScrapeCollector{m: m}
+	}
 	return m.counter
 }
 
@@ -42,11 +132,245 @@ func (m *genericCounterVecMetric) Name() string {
 	return m.name
 }
 
-func (m *genericCounterVecMetric) Process(line string) {
+// Process observes the line. Grok captures take precedence over the 'kv', 'xml', and format auto fields,
+// which take precedence over the input's fields.
+func (m *genericCounterVecMetric) Process(line string, fields map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keyValues := m.extraFields(line, fields["logfile"])
+	if m.repeat == nil {
+		// The labels are the captures of the first match. Lines are usually matched once,
+		// but multiline records may contain more than one match.
+		captures := firstMatch(m.regex, line)
+		m.addExtraFields(captures, keyValues)
+		m.addExtraFields(captures, fields)
+		m.observe(line, captures)
+		return
+	}
+	m.repeat.GsubFunc(line, func(_ string, captures map[string]string) string {
+		m.addExtraFields(captures, keyValues)
+		m.addExtraFields(captures, fields)
+		m.observe(line, captures)
+		return ""
+	})
+}
+
+// addExtraFields adds fields that are not grok captures. Existing fields take precedence over fields with the same name.
+func (m *genericCounterVecMetric) addExtraFields(captures map[string]string, extraFields map[string]string) {
+	for key, value := range extraFields {
+		if _, isGroup := captures[key]; !isGroup {
+			captures[key] = value
+		}
+	}
+}
+
+// extraFields returns the fields from 'kv' and 'xml', or nil if neither is configured.
+// The XML fields take precedence over the kv fields with the same name.
+// If the line is not a valid XML document, the XML fields are empty.
+// With format auto, the fields depend on the format of the source, which is the log file.
+func (m *genericCounterVecMetric) extraFields(line string, source string) map[string]string {
+	if m.formats != nil {
+		return m.formats.fields(line, source)
+	}
+	if m.kv == nil && len(m.xml) == 0 {
+		return nil
+	}
+	result := make(map[string]string)
+	if m.kv != nil {
+		result = parseKeyValues(line, m.kv)
+	}
+	if len(m.xml) > 0 {
+		root, err := xpath.Parse(line)
+		if err == nil {
+			for field, path := range m.xml {
+				if value, found := path.Select(root); found {
+					result[field] = value
+				}
+			}
+		}
+	}
+	return result
+}
+
+// observe updates the counter with the grok captures. The caller must hold the mutex.
+func (m *genericCounterVecMetric) observe(line string, captures map[string]string) {
+	if !m.stages.run(captures) {
+		return
+	}
 	values := make([]string, 0, len(m.labels))
-	for _, field := range m.labels {
-		value := m.regex.Gsub(line, fmt.Sprintf("\\k<%v>", field.GrokFieldName))
+	for i := range m.labels {
+		value := captures[m.captures[i]]
+		if m.mutators[i] != nil {
+			value = m.mutators[i](value)
+		}
 		values = append(values, value)
 	}
-	m.counter.WithLabelValues(values...).Inc()
+	if !m.maxLength.limit(values) {
+		return
+	}
+	labels := make(map[string]string, len(m.labels))
+	for i, label := range m.labels {
+		labels[label.PrometheusLabel] = values[i]
+	}
+	key := strings.Join(values, "\xff")
+	value := captures[m.value]
+	if m.mutator != nil {
+		value = m.mutator(value)
+	}
+	increment := 1.0
+	switch {
+	case m.fromTotal:
+		var ok bool
+		increment, ok = m.increment(key, value)
+		if !ok {
+			return
+		}
+	case m.split != "":
+		increment = float64(len(splitValue(value, m.split)))
+		if increment == 0 {
+			return
+		}
+	}
+	now := time.Now()
+	if !m.limiter.allow(key, now) {
+		return
+	}
+	if evicted := m.series.touch(key, values, now); evicted != nil {
+		m.remove(evicted)
+	}
+	m.last.add(key, &Match{
+		Line:     strings.TrimRight(line, "\r\n"),
+		Time:     now,
+		Labels:   labels,
+		Exemplar: m.exemplars.sample(key, captures, increment, now),
+	})
+	m.counter.WithLabelValues(values...).Add(increment)
+	if m.sum != nil {
+		m.addSum(values, captures[m.sumField])
+	}
+	m.notifier.Notify(strings.TrimRight(line, "\r\n"), captures, now)
+}
+
+// addSum adds the value of the 'sum_field' to the companion counter. Values that are not a non-negative number are ignored,
+// but the series is created anyway, so that the sum has the same series as the count.
+func (m *genericCounterVecMetric) addSum(values []string, value string) {
+	if m.sumMutate != nil {
+		value = m.sumMutate(value)
+	}
+	sum := m.sum.WithLabelValues(values...)
+	f, err := strconv.ParseFloat(value, 64)
+	if err == nil && f >= 0 && !math.IsInf(f, 0) {
+		sum.Add(f)
+	}
+}
+
+// resetRatio is the fraction of the previous running total below which a decreasing total is interpreted as a reset.
+const resetRatio = 0.5
+
+// increment calculates the increment for 'from_total', where the log line contains a running total.
+// A total that drops below half of the previous one is interpreted as a reset of the total. A smaller decrease is a line
+// logged out of order: it does not increment the counter, and the previous total remains the reference for the next line.
+func (m *genericCounterVecMetric) increment(key string, value string) (float64, bool) {
+	total, err := strconv.ParseFloat(value, 64)
+	if err != nil || total < 0 || math.IsNaN(total) || math.IsInf(total, 0) {
+		return 0, false
+	}
+	previous, exists := m.totals[key]
+	switch {
+	case !exists:
+		m.totals[key] = total
+		return total, true
+	case total < previous*resetRatio:
+		m.totals[key] = total
+		return total, true
+	case total < previous:
+		return 0, false
+	default:
+		m.totals[key] = total
+		return total - previous, true
+	}
+}
+
+// mutator returns the 'fields.mutate' functions for a field, or nil if there are none.
+func mutator(fields *config.FieldsConfig, field string) mutate.Func {
+	if fields == nil || len(fields.Mutate[field]) == 0 {
+		return nil
+	}
+	f, _ := mutate.Chain(fields.Mutate[field]) // already validated in config
+	return f
+}
+
+// splitValue splits a captured list like "12,43,9" into its elements. Empty elements are skipped.
+func splitValue(value string, separator string) []string {
+	result := make([]string, 0)
+	for _, element := range strings.Split(value, separator) {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			result = append(result, element)
+		}
+	}
+	return result
+}
+
+// SetMatch must not be called concurrently with Matches(), which doesn't lock the mutex for performance.
+// The counter values are kept.
+func (m *genericCounterVecMetric) SetMatch(regex, repeat, context regex.Regexp) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.regex, m.repeat = regex, repeat
+}
+
+// Stop lets the notifier send the queued notifications and stop. Process() must not be called after Stop().
+func (m *genericCounterVecMetric) Stop() {
+	m.notifier.Close()
+}
+
+func (m *genericCounterVecMetric) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reset()
+}
+
+// reset sets all series to zero. The caller must hold the mutex.
+func (m *genericCounterVecMetric) reset() {
+	m.counter.Reset()
+	if m.sum != nil {
+		m.sum.Reset()
+	}
+	for _, s := range m.series.all() {
+		m.counter.WithLabelValues(s.labelValues...)
+		if m.sum != nil {
+			m.sum.WithLabelValues(s.labelValues...)
+		}
+	}
+}
+
+func (m *genericCounterVecMetric) Expire(now time.Time) int {
+	if m.retention == 0 {
+		return 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expired := m.series.expire(now.Add(-m.retention))
+	for _, s := range expired {
+		m.remove(s)
+	}
+	return len(expired)
+}
+
+// remove deletes a series that was evicted or expired. The caller must hold the mutex.
+func (m *genericCounterVecMetric) remove(s *series) {
+	m.counter.DeleteLabelValues(s.labelValues...)
+	if m.sum != nil {
+		m.sum.DeleteLabelValues(s.labelValues...)
+	}
+	delete(m.totals, s.key)
+	m.limiter.remove(s.key)
+	m.exemplars.remove(s.key)
+}
+
+func (m *genericCounterVecMetric) LastMatches() []Match {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.last.list()
 }
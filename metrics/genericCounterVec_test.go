@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+	"time"
+)
+
+func TestFromTotal(t *testing.T) {
+	regex := regex.MustCompile(`requests served: (?<total>[0-9.]+)`)
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name:      "requests_total",
+		Help:      "Requests.",
+		Labels:    []config.Label{},
+		Value:     "total",
+		FromTotal: true,
+	}, regex, nil).(*genericCounterVecMetric)
+	for _, test := range []struct {
+		line     string
+		expected float64
+	}{
+		{"requests served: 100", 100},
+		{"requests served: 150", 150},
+		{"requests served: 150", 150},
+		{"requests served: 20", 170}, // reset of the running total
+		{"requests served: 25", 175},
+		{"requests served: abc", 175},
+		{"requests served: 0", 175}, // reset to zero
+		{"requests served: 100", 275},
+		{"requests served: 99", 275}, // out of order
+		{"requests served: 101", 276},
+	} {
+		m.Process(test.line, nil)
+		var result dto.Metric
+		m.counter.WithLabelValues().Write(&result)
+		if result.GetCounter().GetValue() != test.expected {
+			t.Errorf("%q: Expected %v, but got %v.", test.line, test.expected, result.GetCounter().GetValue())
+		}
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "steps_total",
+		Help: "Steps.",
+		Labels: []config.Label{
+			{GrokFieldName: "step", PrometheusLabel: "step"},
+		},
+	}, regex.MustCompile(`timings:`), regex.MustCompile(`(?<step>[a-z]+)=(?<ms>[0-9]+)ms`)).(*genericCounterVecMetric)
+	m.Process("timings: parse=3ms render=12ms parse=1ms", nil)
+	for step, expected := range map[string]float64{"parse": 2, "render": 1} {
+		var result dto.Metric
+		m.counter.WithLabelValues(step).Write(&result)
+		if result.GetCounter().GetValue() != expected {
+			t.Errorf("%v: Expected %v, but got %v.", step, expected, result.GetCounter().GetValue())
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name:   "batch_items_total",
+		Help:   "Items.",
+		Labels: []config.Label{},
+		Value:  "sizes",
+		Split:  ",",
+	}, regex.MustCompile(`batch sizes: (?<sizes>[0-9, ]*)`), nil).(*genericCounterVecMetric)
+	m.Process("batch sizes: 12,43, 9", nil)
+	m.Process("batch sizes: ", nil)
+	var result dto.Metric
+	m.counter.WithLabelValues().Write(&result)
+	if result.GetCounter().GetValue() != 3 {
+		t.Errorf("Expected 3 observations, but got %v.", result.GetCounter().GetValue())
+	}
+}
+
+func TestFields(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "steps_total",
+		Help: "Steps.",
+		Labels: []config.Label{
+			{GrokFieldName: "name", PrometheusLabel: "step"},
+		},
+		Fields: &config.FieldsConfig{
+			Rename: map[string]string{"step": "name"},
+			Mutate: map[string][]string{"name": {"substring(0,3)", "uppercase"}},
+		},
+	}, regex.MustCompile(`timings:`), regex.MustCompile(`(?<step>[a-z]+)=(?<ms>[0-9]+)ms`)).(*genericCounterVecMetric)
+	m.Process("timings: parse=3ms", nil)
+	var result dto.Metric
+	m.counter.WithLabelValues("PAR").Write(&result)
+	if result.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected step PAR to be counted once, but got %v.", result.GetCounter().GetValue())
+	}
+}
+
+func TestKv(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "requests_total",
+		Help: "Requests.",
+		Labels: []config.Label{
+			{GrokFieldName: "method", PrometheusLabel: "method"},
+			{GrokFieldName: "status", PrometheusLabel: "status"},
+		},
+		Kv: &config.KvConfig{PairSeparator: " ", ValueSeparator: "="},
+	}, regex.MustCompile(`^(?<method>[A-Z]+) .*$`), nil).(*genericCounterVecMetric)
+	m.Process("GET /index.html status=200 method=POST", nil)
+	var result dto.Metric
+	m.counter.WithLabelValues("GET", "200").Write(&result)
+	if result.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected method from the grok capture and status from kv, but got %v.", result.GetCounter().GetValue())
+	}
+}
+
+func TestXml(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "logon_failures_total",
+		Help: "Failed logons.",
+		Labels: []config.Label{
+			{GrokFieldName: "user", PrometheusLabel: "user"},
+		},
+		Format: "xml",
+		Xml:    map[string]string{"user": "//Data[@Name='TargetUserName']"},
+	}, regex.MustCompile(`<EventID>4625</EventID>`), nil).(*genericCounterVecMetric)
+	m.Process(`<Event><System><EventID>4625</EventID></System><EventData><Data Name="TargetUserName">alice</Data></EventData></Event>`, nil)
+	var result dto.Metric
+	m.counter.WithLabelValues("alice").Write(&result)
+	if result.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected user alice to be counted once, but got %v.", result.GetCounter().GetValue())
+	}
+}
+
+func TestMaxSeries(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "requests_total",
+		Help: "Requests.",
+		Labels: []config.Label{
+			{GrokFieldName: "user", PrometheusLabel: "user"},
+		},
+		Eviction:  "lru",
+		MaxSeries: 2,
+	}, regex.MustCompile(`user=(?<user>[a-z]+)`), nil).(*genericCounterVecMetric)
+	for _, line := range []string{"user=alice", "user=bob", "user=alice", "user=carol"} {
+		m.Process(line, nil)
+	}
+	users := make(map[string]bool)
+	for _, d := range collect(m.counter) {
+		users[d.Label[0].GetValue()] = true
+	}
+	if len(users) != 2 || !users["alice"] || !users["carol"] {
+		t.Errorf("Expected the least recently updated series bob to be evicted, but got %v.", users)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "requests_total",
+		Help: "Requests.",
+		Labels: []config.Label{
+			{GrokFieldName: "client", PrometheusLabel: "client"},
+		},
+		RateLimit: &config.RateLimitConfig{Max: 2, Per: time.Minute},
+	}, regex.MustCompile(`client=(?<client>[a-z]+)`), nil).(*genericCounterVecMetric)
+	for _, line := range []string{"client=abuser", "client=abuser", "client=abuser", "client=alice"} {
+		m.Process(line, nil)
+	}
+	for _, d := range collect(m.counter) {
+		if d.Label[0].GetValue() == "abuser" && d.Counter.GetValue() != 2 {
+			t.Errorf("Expected the abuser to be capped at 2, but got %v.", d.Counter.GetValue())
+		}
+	}
+	if !m.limiter.allow("abuser", time.Now().Add(time.Minute)) {
+		t.Error("Expected the rate limit to allow matches in the next window.")
+	}
+}
+
+func TestPerScrape(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "requests",
+		Help: "Requests since the previous scrape.",
+		Labels: []config.Label{
+			{GrokFieldName: "user", PrometheusLabel: "user"},
+		},
+		PerScrape: true,
+	}, regex.MustCompile(`user=(?<user>[a-z]+)`), nil)
+	m.Process("user=alice", nil)
+	m.Process("user=alice", nil)
+	if snapshot := collect(Snapshot(m)); len(snapshot) != 1 || snapshot[0].Gauge.GetValue() != 2 {
+		t.Errorf("Expected the snapshot to show user=alice with value 2, but got %v.", snapshot)
+	}
+	for i, expected := range []float64{2, 0} {
+		scraped := collect(m.Collector())
+		if len(scraped) != 1 || scraped[0].Gauge == nil || scraped[0].Gauge.GetValue() != expected {
+			t.Errorf("Scrape %v: Expected gauge user=alice with value %v, but got %v.", i+1, expected, scraped)
+		}
+	}
+}
+
+func TestSumField(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "http_requests_total",
+		Help: "HTTP requests.",
+		Labels: []config.Label{
+			{GrokFieldName: "method", PrometheusLabel: "method"},
+		},
+		SumField: "bytes",
+		SumName:  "http_requests_bytes_total",
+	}, regex.MustCompile(`(?<method>[A-Z]+) (?<bytes>\S+)`), nil)
+	for _, line := range []string{"GET 100", "GET 20", "GET -"} {
+		m.Process(line, nil)
+	}
+	companions := Companions(m)
+	if len(companions) != 1 || companions[0].Name() != "http_requests_bytes_total" {
+		t.Fatalf("Expected companion http_requests_bytes_total, but got %v.", companions)
+	}
+	count, sum := collect(m.Collector()), collect(companions[0].Collector())
+	if len(count) != 1 || count[0].Counter.GetValue() != 3 {
+		t.Errorf("Expected 3 matches, but got %v.", count)
+	}
+	if len(sum) != 1 || sum[0].Counter.GetValue() != 120 {
+		t.Errorf("Expected sum 120, but got %v.", sum)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	m := CreateGenericCounterVecMetric(&config.MetricConfig{
+		Name: "requests_total",
+		Help: "Requests.",
+		Labels: []config.Label{
+			{GrokFieldName: "user", PrometheusLabel: "user"},
+		},
+		Retention: time.Hour,
+	}, regex.MustCompile(`user=(?<user>[a-z]+)`), nil).(*genericCounterVecMetric)
+	m.Process("user=alice", nil)
+	if expired := m.Expire(time.Now().Add(30 * time.Minute)); expired != 0 {
+		t.Errorf("Expected no series to expire within the retention, but %v expired.", expired)
+	}
+	if expired := m.Expire(time.Now().Add(2 * time.Hour)); expired != 1 {
+		t.Errorf("Expected 1 series to expire, but %v expired.", expired)
+	}
+	if len(collect(m.counter)) != 0 {
+		t.Error("Expected the expired series to be removed from the counter.")
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"strings"
+	"unicode"
+)
+
+// parseKeyValues extracts all key=value tokens from a line, like 'user=alice status=200 msg="not found"'.
+// Values in double quotes may contain the pair separator. If a key occurs more than once, the first value is used.
+// The default pair separator " " matches any run of whitespace.
+func parseKeyValues(line string, cfg *config.KvConfig) map[string]string {
+	result := make(map[string]string)
+	for _, token := range splitPairs(line, cfg.PairSeparator) {
+		i := strings.Index(token, cfg.ValueSeparator)
+		if i <= 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(token[:i]), strings.TrimSpace(token[i+len(cfg.ValueSeparator):])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if _, exists := result[key]; exists || key == "" || !cfg.Allows(key) {
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// splitPairs splits the line at the separator, except within double quotes.
+func splitPairs(line string, separator string) []string {
+	result := make([]string, 0)
+	start, quoted := 0, false
+	for i := 0; i < len(line); {
+		switch {
+		case line[i] == '"':
+			quoted = !quoted
+			i++
+		case quoted:
+			i++
+		case separator == " " && unicode.IsSpace(rune(line[i])):
+			result = append(result, line[start:i])
+			for i < len(line) && unicode.IsSpace(rune(line[i])) {
+				i++
+			}
+			start = i
+		case separator != " " && strings.HasPrefix(line[i:], separator):
+			result = append(result, line[start:i])
+			i += len(separator)
+			start = i
+		default:
+			i++
+		}
+	}
+	return append(result, line[start:])
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	for _, test := range []struct {
+		line     string
+		cfg      config.KvConfig
+		expected map[string]string
+	}{
+		{
+			line:     `level=info user=alice msg="not found" status=404`,
+			cfg:      config.KvConfig{PairSeparator: " ", ValueSeparator: "="},
+			expected: map[string]string{"level": "info", "user": "alice", "msg": "not found", "status": "404"},
+		},
+		{
+			line:     `2016-04-01 GET /index.html  took=12ms  took=13ms`,
+			cfg:      config.KvConfig{PairSeparator: " ", ValueSeparator: "="},
+			expected: map[string]string{"took": "12ms"},
+		},
+		{
+			line:     `a: 1; b: "x; y"; c: 3`,
+			cfg:      config.KvConfig{PairSeparator: ";", ValueSeparator: ":", Exclude: []string{"c"}},
+			expected: map[string]string{"a": "1", "b": "x; y"},
+		},
+		{
+			line:     `a=1 b=2 c=3`,
+			cfg:      config.KvConfig{PairSeparator: " ", ValueSeparator: "=", Include: []string{"b"}},
+			expected: map[string]string{"b": "2"},
+		},
+	} {
+		result := parseKeyValues(test.line, &test.cfg)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%q: Expected %v, but got %v.", test.line, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"unicode/utf8"
+)
+
+var labelTooLongTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "grok_exporter_label_too_long_total",
+	Help: "Number of samples with a label value longer than the metric's 'max_label_length'.",
+}, []string{"metric", "action"})
+
+// LabelLengthCollector returns the metric counting the samples affected by 'max_label_length'.
+func LabelLengthCollector() prometheus.Collector {
+	return labelTooLongTotal
+}
+
+// labelLength enforces 'max_label_length'.
+type labelLength struct {
+	metric string
+	max    int
+	drop   bool
+}
+
+// newLabelLength returns nil if 'max_label_length' is not configured.
+func newLabelLength(cfg *config.MetricConfig) *labelLength {
+	if cfg.MaxLabelLength == 0 {
+		return nil
+	}
+	return &labelLength{
+		metric: cfg.Name,
+		max:    cfg.MaxLabelLength,
+		drop:   cfg.LabelLengthPolicy == "drop",
+	}
+}
+
+// limit truncates the label values longer than 'max_label_length' bytes in place. With policy 'drop', it returns false instead,
+// and the sample must not be observed. limit may be called on a nil labelLength, which allows everything.
+func (l *labelLength) limit(values []string) bool {
+	if l == nil {
+		return true
+	}
+	exceeded := false
+	for i, value := range values {
+		if len(value) > l.max {
+			exceeded = true
+			values[i] = truncate(value, l.max)
+		}
+	}
+	switch {
+	case !exceeded:
+		return true
+	case l.drop:
+		labelTooLongTotal.WithLabelValues(l.metric, "dropped").Inc()
+		return false
+	default:
+		labelTooLongTotal.WithLabelValues(l.metric, "truncated").Inc()
+		return true
+	}
+}
+
+// truncate cuts the value to at most max bytes without splitting a UTF-8 character.
+func truncate(value string, max int) string {
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
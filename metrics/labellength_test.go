@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"testing"
+)
+
+func TestLabelLength(t *testing.T) {
+	truncating := newLabelLength(&config.MetricConfig{Name: "test_truncate_total", MaxLabelLength: 4})
+	values := []string{"short", "abc", "äöü"}
+	if !truncating.limit(values) {
+		t.Fatal("Expected the sample to be kept with policy 'truncate'.")
+	}
+	for i, expected := range []string{"shor", "abc", "äö"} {
+		if values[i] != expected {
+			t.Errorf("Expected '%v', but got '%v'.", expected, values[i])
+		}
+	}
+	dropping := newLabelLength(&config.MetricConfig{Name: "test_drop_total", MaxLabelLength: 4, LabelLengthPolicy: "drop"})
+	if dropping.limit([]string{"short"}) {
+		t.Error("Expected the sample to be dropped with policy 'drop'.")
+	}
+	if !dropping.limit([]string{"ok"}) {
+		t.Error("Expected a sample with short labels to be kept.")
+	}
+	var unlimited *labelLength
+	if !unlimited.limit([]string{"a very long label value"}) {
+		t.Error("A nil labelLength should allow everything.")
+	}
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// Number of label sets for which the last matching line is kept, per metric.
+const maxLastMatches = 100
+
+// Match is the most recent log line that matched a metric with the given label values.
+type Match struct {
+	Line     string            `json:"line"`
+	Time     time.Time         `json:"time"`
+	Labels   map[string]string `json:"labels"`
+	Exemplar *Exemplar         `json:"exemplar,omitempty"` // nil if 'exemplar' is not configured
+}
+
+// lastMatches is a bounded buffer keeping the last Match per label set.
+// If there are more label sets than maxLastMatches, the least recently matched label set is dropped.
+// lastMatches is not thread safe.
+type lastMatches struct {
+	matches map[string]*Match
+}
+
+func newLastMatches() *lastMatches {
+	return &lastMatches{
+		matches: make(map[string]*Match),
+	}
+}
+
+func (l *lastMatches) add(key string, match *Match) {
+	if _, exists := l.matches[key]; !exists && len(l.matches) >= maxLastMatches {
+		var oldestKey string
+		var oldest *Match
+		for k, m := range l.matches {
+			if oldest == nil || m.Time.Before(oldest.Time) {
+				oldestKey, oldest = k, m
+			}
+		}
+		delete(l.matches, oldestKey)
+	}
+	l.matches[key] = match
+}
+
+// list returns the matches, most recent first.
+func (l *lastMatches) list() []Match {
+	result := make([]Match, 0, len(l.matches))
+	for _, m := range l.matches {
+		result = append(result, *m)
+	}
+	sort.Sort(byTimeDesc(result))
+	return result
+}
+
+type byTimeDesc []Match
+
+func (m byTimeDesc) Len() int           { return len(m) }
+func (m byTimeDesc) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byTimeDesc) Less(i, j int) bool { return m[i].Time.After(m[j].Time) }
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLastMatches(t *testing.T) {
+	l := newLastMatches()
+	start := time.Now()
+	for i := 0; i <= maxLastMatches; i++ {
+		l.add(fmt.Sprintf("key%v", i), &Match{Line: fmt.Sprintf("line %v", i), Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	l.add("key1", &Match{Line: "line 1 again", Time: start.Add(time.Hour)})
+	matches := l.list()
+	if len(matches) != maxLastMatches {
+		t.Fatalf("Expected %v matches, but got %v.", maxLastMatches, len(matches))
+	}
+	if matches[0].Line != "line 1 again" {
+		t.Errorf("Expected the most recent match first, but got %q.", matches[0].Line)
+	}
+	for _, m := range matches {
+		if m.Line == "line 0" {
+			t.Errorf("Expected the oldest label set to be dropped.")
+		}
+	}
+}
@@ -1,10 +1,19 @@
 package metrics
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
 
 type Metric interface {
 	Name() string
 	Collector() prometheus.Collector
 	Matches(ling string) bool
-	Process(line string)
+	Process(line string, fields map[string]string) // fields are provided by the input, like 'input.path_match', or nil
+	Reset()                                        // sets all series to zero, used for 'reset_schedule'
+	Expire(now time.Time) int                      // removes the series not updated within 'retention', returns the number of removed series
+	LastMatches() []Match
+	SetMatch(regex, repeat, context regex.Regexp) // replaces the compiled expressions, used when patterns are reloaded. context is nil without 'context'
+	Stop()                                        // stops the background goroutines, used when a config reload replaces or removes the metric
 }
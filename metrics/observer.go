@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/mutate"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An observerMetric is a gauge, histogram, or summary. For each match, the 'value' is set (gauge) or observed (histogram and summary).
+type observerMetric struct {
+	name      string
+	labels    []config.Label
+	captures  []string      // for each label, the name of the grok capture providing the value
+	mutators  []mutate.Func // for each label, the 'fields.mutate' functions, or nil
+	regex     regex.Regexp
+	repeat    regex.Regexp
+	value     string      // grok capture providing the value
+	mutator   mutate.Func // 'fields.mutate' functions for the value, or nil
+	stages    stages      // the 'pipeline', or nil
+	vec       *prometheus.MetricVec
+	set       bool // true for gauges, which are set to the value instead of observing it
+	mutex     sync.Mutex
+	series    *seriesCache
+	retention time.Duration
+	last      *lastMatches
+	maxLength *labelLength     // nil if 'max_label_length' is not configured
+	exemplars *exemplarSampler // nil if 'exemplar' is not configured
+}
+
+// observer is implemented by histograms and summaries.
+type observer interface {
+	Observe(float64)
+}
+
+// CreateGaugeMetric creates a gauge, which is set to the 'value' of the last match.
+func CreateGaugeMetric(cfg *config.MetricConfig, regex regex.Regexp, repeat regex.Regexp) Metric {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: cfg.Name,
+		Help: cfg.Help,
+	}, labelNames(cfg))
+	return newObserverMetric(cfg, regex, repeat, &vec.MetricVec, true)
+}
+
+// CreateHistogramMetric creates a histogram observing the 'value' with the configured 'buckets'.
+func CreateHistogramMetric(cfg *config.MetricConfig, regex regex.Regexp, repeat regex.Regexp) Metric {
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    cfg.Name,
+		Help:    cfg.Help,
+		Buckets: cfg.Buckets,
+	}, labelNames(cfg))
+	return newObserverMetric(cfg, regex, repeat, &vec.MetricVec, false)
+}
+
+// CreateSummaryMetric creates a summary observing the 'value'. The allowed error of each of the 'quantiles' q is (1-q)/10.
+func CreateSummaryMetric(cfg *config.MetricConfig, regex regex.Regexp, repeat regex.Regexp) Metric {
+	objectives := make(map[float64]float64, len(cfg.Quantiles))
+	for _, q := range cfg.Quantiles {
+		objectives[q] = (1 - q) / 10
+	}
+	vec := prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       cfg.Name,
+		Help:       cfg.Help,
+		Objectives: objectives,
+	}, labelNames(cfg))
+	return newObserverMetric(cfg, regex, repeat, &vec.MetricVec, false)
+}
+
+func labelNames(cfg *config.MetricConfig) []string {
+	result := make([]string, 0, len(cfg.Labels))
+	for _, label := range cfg.Labels {
+		result = append(result, label.PrometheusLabel)
+	}
+	return result
+}
+
+func newObserverMetric(cfg *config.MetricConfig, regex regex.Regexp, repeat regex.Regexp, vec *prometheus.MetricVec, set bool) *observerMetric {
+	captures := make([]string, 0, len(cfg.Labels))
+	mutators := make([]mutate.Func, 0, len(cfg.Labels))
+	for _, label := range cfg.Labels {
+		capture, _ := cfg.Fields.CaptureName(label.GrokFieldName) // dropped fields are rejected in validateMetrics()
+		captures = append(captures, capture)
+		mutators = append(mutators, mutator(cfg.Fields, label.GrokFieldName))
+	}
+	value, _ := cfg.Fields.CaptureName(cfg.Value)
+	return &observerMetric{
+		name:      cfg.Name,
+		labels:    cfg.Labels,
+		captures:  captures,
+		mutators:  mutators,
+		regex:     regex,
+		repeat:    repeat,
+		value:     value,
+		mutator:   mutator(cfg.Fields, cfg.Value),
+		stages:    newStages(cfg),
+		vec:       vec,
+		set:       set,
+		series:    newSeriesCache(cfg.MaxSeries),
+		retention: cfg.Retention,
+		last:      newLastMatches(),
+		maxLength: newLabelLength(cfg),
+		exemplars: newExemplarSampler(cfg.Exemplar, cfg.Fields),
+	}
+}
+
+func (m *observerMetric) Name() string {
+	return m.name
+}
+
+func (m *observerMetric) Collector() prometheus.Collector {
+	return m.vec
+}
+
+func (m *observerMetric) Matches(line string) bool {
+	return m.regex.MatchString(line)
+}
+
+func (m *observerMetric) Process(line string, fields map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.repeat == nil {
+		captures := firstMatch(m.regex, line)
+		m.addFields(captures, fields)
+		m.observe(line, captures)
+		return
+	}
+	m.repeat.GsubFunc(line, func(_ string, captures map[string]string) string {
+		m.addFields(captures, fields)
+		m.observe(line, captures)
+		return ""
+	})
+}
+
+// addFields adds the input's fields. Grok captures take precedence over fields with the same name.
+func (m *observerMetric) addFields(captures map[string]string, fields map[string]string) {
+	for key, value := range fields {
+		if _, isGroup := captures[key]; !isGroup {
+			captures[key] = value
+		}
+	}
+}
+
+// observe sets or observes the value. Values that are not a number are ignored. The caller must hold the mutex.
+func (m *observerMetric) observe(line string, captures map[string]string) {
+	if !m.stages.run(captures) {
+		return
+	}
+	value := captures[m.value]
+	if m.mutator != nil {
+		value = m.mutator(value)
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return
+	}
+	values := make([]string, 0, len(m.labels))
+	for i := range m.labels {
+		labelValue := captures[m.captures[i]]
+		if m.mutators[i] != nil {
+			labelValue = m.mutators[i](labelValue)
+		}
+		values = append(values, labelValue)
+	}
+	if !m.maxLength.limit(values) {
+		return
+	}
+	labels := make(map[string]string, len(m.labels))
+	for i, label := range m.labels {
+		labels[label.PrometheusLabel] = values[i]
+	}
+	key := strings.Join(values, "\xff")
+	now := time.Now()
+	if evicted := m.series.touch(key, values, now); evicted != nil {
+		m.vec.DeleteLabelValues(evicted.labelValues...)
+		m.exemplars.remove(evicted.key)
+	}
+	if m.set {
+		m.vec.WithLabelValues(values...).(prometheus.Gauge).Set(f)
+	} else {
+		m.vec.WithLabelValues(values...).(observer).Observe(f)
+	}
+	m.last.add(key, &Match{
+		Line:     strings.TrimRight(line, "\r\n"),
+		Time:     now,
+		Labels:   labels,
+		Exemplar: m.exemplars.sample(key, captures, f, now),
+	})
+}
+
+func (m *observerMetric) Stop() {}
+
+// SetMatch must not be called concurrently with Matches(), which doesn't lock the mutex for performance.
+func (m *observerMetric) SetMatch(regex, repeat, context regex.Regexp) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.regex, m.repeat = regex, repeat
+}
+
+// Reset sets all series to zero, the series are kept.
+func (m *observerMetric) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.vec.Reset()
+	for _, s := range m.series.all() {
+		m.vec.WithLabelValues(s.labelValues...)
+	}
+}
+
+func (m *observerMetric) Expire(now time.Time) int {
+	if m.retention == 0 {
+		return 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expired := m.series.expire(now.Add(-m.retention))
+	for _, s := range expired {
+		m.vec.DeleteLabelValues(s.labelValues...)
+		m.exemplars.remove(s.key)
+	}
+	return len(expired)
+}
+
+func (m *observerMetric) LastMatches() []Match {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.last.list()
+}
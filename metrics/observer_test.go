@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"testing"
+)
+
+func TestGauge(t *testing.T) {
+	m := CreateGaugeMetric(&config.MetricConfig{
+		Name:  "queue_size",
+		Help:  "Queue size.",
+		Value: "size",
+	}, regex.MustCompile(`queue size (?<size>[0-9a-z.]+)`), nil)
+	m.Process("queue size 7", nil)
+	m.Process("queue size 3", nil)
+	m.Process("queue size abc", nil) // not a number, ignored
+	scraped := collect(m.Collector())
+	if len(scraped) != 1 || scraped[0].Gauge == nil || scraped[0].Gauge.GetValue() != 3 {
+		t.Fatalf("Expected gauge value 3, but got %v.", scraped)
+	}
+	m.Reset()
+	if scraped := collect(m.Collector()); len(scraped) != 1 || scraped[0].Gauge.GetValue() != 0 {
+		t.Errorf("Expected gauge value 0 after reset, but got %v.", scraped)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	m := CreateHistogramMetric(&config.MetricConfig{
+		Name: "request_duration_seconds",
+		Help: "Request duration.",
+		Labels: []config.Label{
+			{GrokFieldName: "path", PrometheusLabel: "path"},
+		},
+		Value:   "seconds",
+		Buckets: []float64{0.1, 1},
+	}, regex.MustCompile(`(?<path>/[a-z]+) took (?<seconds>[0-9.]+)s`), nil)
+	for _, line := range []string{"/index took 0.05s", "/index took 0.5s", "/index took 2s"} {
+		m.Process(line, nil)
+	}
+	scraped := collect(m.Collector())
+	if len(scraped) != 1 || scraped[0].Histogram == nil {
+		t.Fatalf("Expected one histogram, but got %v.", scraped)
+	}
+	h := scraped[0].Histogram
+	if h.GetSampleCount() != 3 || h.GetSampleSum() != 2.55 {
+		t.Errorf("Expected count 3 and sum 2.55, but got %v.", h)
+	}
+	if len(h.Bucket) != 2 || h.Bucket[0].GetCumulativeCount() != 1 || h.Bucket[1].GetCumulativeCount() != 2 {
+		t.Errorf("Unexpected buckets %v.", h.Bucket)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	m := CreateSummaryMetric(&config.MetricConfig{
+		Name:      "response_size_bytes",
+		Help:      "Response size.",
+		Value:     "bytes",
+		Quantiles: []float64{0.5},
+	}, regex.MustCompile(`sent (?<bytes>[0-9]+) bytes`), nil)
+	for i := 1; i <= 100; i++ {
+		m.Process(fmt.Sprintf("sent %v bytes", i), nil)
+	}
+	scraped := collect(m.Collector())
+	if len(scraped) != 1 || scraped[0].Summary == nil {
+		t.Fatalf("Expected one summary, but got %v.", scraped)
+	}
+	s := scraped[0].Summary
+	if s.GetSampleCount() != 100 || s.GetSampleSum() != 5050 || len(s.Quantile) != 1 || s.Quantile[0].GetValue() < 45 || s.Quantile[0].GetValue() > 55 {
+		t.Errorf("Expected count 100, sum 5050, and median about 50, but got %v.", s)
+	}
+}
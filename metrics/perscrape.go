@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// perScrapeCollector exposes a counter for 'per_scrape' as gauge with the matches since the previous scrape.
+// Each scrape resets the series to zero, so if more than one Prometheus server scrapes the exporter, each sees only part of the matches.
+type perScrapeCollector struct {
+	m    *genericCounterVecMetric
+	keep bool // don't reset, see Snapshot()
+}
+
+// Snapshot returns a collector of the metric's current values. Unlike Collector(), it does not reset the series of 'per_scrape' metrics,
+// so it is used wherever the values are read other than by a scrape, like the series count, the dump, the API, and the flush.
+func Snapshot(metric Metric) prometheus.Collector {
+	if c, ok := metric.Collector().(*perScrapeCollector); ok {
+		return &perScrapeCollector{m: c.m, keep: true}
+	}
+	return metric.Collector()
+}
+
+func (c *perScrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.m.perScrape
+}
+
+func (c *perScrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.m.mutex.Lock()
+	defer c.m.mutex.Unlock()
+	for _, s := range c.m.series.all() {
+		var d dto.Metric
+		if c.m.counter.WithLabelValues(s.labelValues...).Write(&d) != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.m.perScrape, prometheus.GaugeValue, d.Counter.GetValue(), s.labelValues...)
+	}
+	if !c.keep {
+		c.m.reset()
+	}
+}
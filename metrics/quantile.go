@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/mutate"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/fstab/grok_exporter/tdigest"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A quantile metric estimates quantiles of the 'value' with a t-digest per series, and exposes them as gauges with a 'quantile' label.
+// Unlike a classic summary, the extreme quantiles stay accurate for heavy-tailed distributions like request latencies.
+// The quantiles cover all values since the start, or since the last reset if 'reset_schedule' is configured.
+type quantileMetric struct {
+	name        string
+	labels      []config.Label
+	captures    []string      // for each label, the name of the grok capture providing the value
+	mutators    []mutate.Func // for each label, the 'fields.mutate' functions, or nil
+	regex       regex.Regexp
+	repeat      regex.Regexp
+	value       string      // grok capture providing the observed value
+	mutator     mutate.Func // 'fields.mutate' functions for the value, or nil
+	stages      stages      // the 'pipeline', or nil
+	quantiles   []float64
+	compression float64
+	desc        *prometheus.Desc
+	mutex       sync.Mutex
+	series      *seriesCache
+	retention   time.Duration
+	digests     map[string]*tdigest.TDigest
+	last        *lastMatches
+	maxLength   *labelLength     // nil if 'max_label_length' is not configured
+	exemplars   *exemplarSampler // nil if 'exemplar' is not configured
+}
+
+// CreateQuantileMetric creates a quantile metric. repeat is the compiled 'repeat' expression, or nil if not configured.
+func CreateQuantileMetric(cfg *config.MetricConfig, regex regex.Regexp, repeat regex.Regexp) Metric {
+	prometheusLabels := make([]string, 0, len(cfg.Labels)+1)
+	captures := make([]string, 0, len(cfg.Labels))
+	mutators := make([]mutate.Func, 0, len(cfg.Labels))
+	for _, label := range cfg.Labels {
+		prometheusLabels = append(prometheusLabels, label.PrometheusLabel)
+		capture, _ := cfg.Fields.CaptureName(label.GrokFieldName) // dropped fields are rejected in validateMetrics()
+		captures = append(captures, capture)
+		mutators = append(mutators, mutator(cfg.Fields, label.GrokFieldName))
+	}
+	value, _ := cfg.Fields.CaptureName(cfg.Value)
+	return &quantileMetric{
+		name:        cfg.Name,
+		labels:      cfg.Labels,
+		captures:    captures,
+		mutators:    mutators,
+		regex:       regex,
+		repeat:      repeat,
+		value:       value,
+		mutator:     mutator(cfg.Fields, cfg.Value),
+		stages:      newStages(cfg),
+		quantiles:   cfg.Quantiles,
+		compression: cfg.Compression,
+		desc:        prometheus.NewDesc(cfg.Name, cfg.Help, append(prometheusLabels, "quantile"), nil),
+		series:      newSeriesCache(cfg.MaxSeries),
+		retention:   cfg.Retention,
+		digests:     make(map[string]*tdigest.TDigest),
+		last:        newLastMatches(),
+		maxLength:   newLabelLength(cfg),
+		exemplars:   newExemplarSampler(cfg.Exemplar, cfg.Fields),
+	}
+}
+
+func (m *quantileMetric) Name() string {
+	return m.name
+}
+
+func (m *quantileMetric) Collector() prometheus.Collector {
+	return m
+}
+
+func (m *quantileMetric) Matches(line string) bool {
+	return m.regex.MatchString(line)
+}
+
+func (m *quantileMetric) Process(line string, fields map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.repeat == nil {
+		captures := firstMatch(m.regex, line)
+		m.addFields(captures, fields)
+		m.observe(line, captures)
+		return
+	}
+	m.repeat.GsubFunc(line, func(_ string, captures map[string]string) string {
+		m.addFields(captures, fields)
+		m.observe(line, captures)
+		return ""
+	})
+}
+
+// addFields adds the input's fields. Grok captures take precedence over fields with the same name.
+func (m *quantileMetric) addFields(captures map[string]string, fields map[string]string) {
+	for key, value := range fields {
+		if _, isGroup := captures[key]; !isGroup {
+			captures[key] = value
+		}
+	}
+}
+
+// observe adds the value to the series' t-digest. Values that are not a number are ignored. The caller must hold the mutex.
+func (m *quantileMetric) observe(line string, captures map[string]string) {
+	if !m.stages.run(captures) {
+		return
+	}
+	value := captures[m.value]
+	if m.mutator != nil {
+		value = m.mutator(value)
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return
+	}
+	values := make([]string, 0, len(m.labels))
+	for i := range m.labels {
+		labelValue := captures[m.captures[i]]
+		if m.mutators[i] != nil {
+			labelValue = m.mutators[i](labelValue)
+		}
+		values = append(values, labelValue)
+	}
+	if !m.maxLength.limit(values) {
+		return
+	}
+	labels := make(map[string]string, len(m.labels))
+	for i, label := range m.labels {
+		labels[label.PrometheusLabel] = values[i]
+	}
+	key := strings.Join(values, "\xff")
+	now := time.Now()
+	if evicted := m.series.touch(key, values, now); evicted != nil {
+		delete(m.digests, evicted.key)
+		m.exemplars.remove(evicted.key)
+	}
+	digest, exists := m.digests[key]
+	if !exists {
+		digest = tdigest.New(m.compression)
+		m.digests[key] = digest
+	}
+	digest.Add(f)
+	m.last.add(key, &Match{
+		Line:     strings.TrimRight(line, "\r\n"),
+		Time:     now,
+		Labels:   labels,
+		Exemplar: m.exemplars.sample(key, captures, f, now),
+	})
+}
+
+func (m *quantileMetric) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+}
+
+// Collect exposes a gauge per series and quantile. Series without values since the last reset are skipped.
+func (m *quantileMetric) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, s := range m.series.all() {
+		digest := m.digests[s.key]
+		if digest.Count() == 0 {
+			continue
+		}
+		for _, q := range m.quantiles {
+			labelValues := append(append(make([]string, 0, len(s.labelValues)+1), s.labelValues...), strconv.FormatFloat(q, 'g', -1, 64))
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, digest.Quantile(q), labelValues...)
+		}
+	}
+}
+
+func (m *quantileMetric) Stop() {}
+
+// SetMatch must not be called concurrently with Matches(), which doesn't lock the mutex for performance.
+func (m *quantileMetric) SetMatch(regex, repeat, context regex.Regexp) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.regex, m.repeat = regex, repeat
+}
+
+// Reset removes all values, the series are kept.
+func (m *quantileMetric) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, digest := range m.digests {
+		digest.Reset()
+	}
+}
+
+func (m *quantileMetric) Expire(now time.Time) int {
+	if m.retention == 0 {
+		return 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expired := m.series.expire(now.Add(-m.retention))
+	for _, s := range expired {
+		delete(m.digests, s.key)
+		m.exemplars.remove(s.key)
+	}
+	return len(expired)
+}
+
+func (m *quantileMetric) LastMatches() []Match {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.last.list()
+}
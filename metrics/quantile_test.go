@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"testing"
+)
+
+func TestQuantile(t *testing.T) {
+	m := CreateQuantileMetric(&config.MetricConfig{
+		Name: "request_duration_ms",
+		Help: "Request duration.",
+		Labels: []config.Label{
+			{GrokFieldName: "path", PrometheusLabel: "path"},
+		},
+		Value:       "ms",
+		Quantiles:   []float64{0.5, 0.99},
+		Compression: 100,
+	}, regex.MustCompile(`(?<path>/[a-z]+) took (?<ms>[0-9a-z.]+)ms`), nil)
+	for i := 1; i <= 1000; i++ {
+		m.Process(fmt.Sprintf("/index took %vms", i), nil)
+	}
+	m.Process("/index took abcms", nil) // not a number, ignored
+	scraped := collect(m.Collector())
+	if len(scraped) != 2 {
+		t.Fatalf("Expected two quantiles, but got %v.", scraped)
+	}
+	for _, metric := range scraped {
+		quantile := metric.Label[1].GetValue()
+		expected := map[string]float64{"0.5": 500, "0.99": 990}[quantile]
+		if metric.Label[0].GetValue() != "/index" || metric.Gauge == nil || metric.Gauge.GetValue() < expected-5 || metric.Gauge.GetValue() > expected+5 {
+			t.Errorf("Expected quantile %v to be about %v, but got %v.", quantile, expected, metric)
+		}
+	}
+	m.Reset()
+	if scraped := collect(m.Collector()); len(scraped) != 0 {
+		t.Errorf("Expected no quantiles after reset, but got %v.", scraped)
+	}
+}
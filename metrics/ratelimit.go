@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "grok_exporter_rate_limited_total",
+	Help: "Number of matches not counted because the label set exceeded the metric's 'rate_limit'.",
+}, []string{"metric"})
+
+// RateLimitCollector returns the metric counting the matches dropped by 'rate_limit'.
+func RateLimitCollector() prometheus.Collector {
+	return rateLimitedTotal
+}
+
+// rateLimiter counts the matches per label set in fixed time windows.
+type rateLimiter struct {
+	metric  string
+	max     int
+	per     time.Duration
+	windows map[string]*rateWindow // label set key -> current window
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newRateLimiter returns nil if cfg is nil.
+func newRateLimiter(metric string, cfg *config.RateLimitConfig) *rateLimiter {
+	if cfg == nil {
+		return nil
+	}
+	return &rateLimiter{
+		metric:  metric,
+		max:     cfg.Max,
+		per:     cfg.Per,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// allow returns false if the label set already had 'max' matches in the current window.
+// allow may be called on a nil rateLimiter, which allows everything.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	w, exists := l.windows[key]
+	if !exists || now.Sub(w.start) >= l.per {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.max {
+		rateLimitedTotal.WithLabelValues(l.metric).Inc()
+		return false
+	}
+	w.count++
+	return true
+}
+
+// remove forgets the window of a label set that was removed from the metric.
+func (l *rateLimiter) remove(key string) {
+	if l != nil {
+		delete(l.windows, key)
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"container/list"
+	"time"
+)
+
+// seriesCache keeps the label values and the last update time of each series of a metric.
+// If maxSeries is reached, adding a new series evicts the least recently updated one ('eviction: lru').
+// seriesCache is not thread safe.
+type seriesCache struct {
+	maxSeries int        // 0 means unbounded
+	order     *list.List // of *series, most recently updated first
+	entries   map[string]*list.Element
+}
+
+type series struct {
+	key         string
+	labelValues []string
+	updated     time.Time
+}
+
+func newSeriesCache(maxSeries int) *seriesCache {
+	return &seriesCache{
+		maxSeries: maxSeries,
+		order:     list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+}
+
+// touch records an update of the series. It returns the evicted series, or nil if no series was evicted.
+func (c *seriesCache) touch(key string, labelValues []string, now time.Time) *series {
+	if element, exists := c.entries[key]; exists {
+		element.Value.(*series).updated = now
+		c.order.MoveToFront(element)
+		return nil
+	}
+	var evicted *series
+	if c.maxSeries > 0 && c.order.Len() >= c.maxSeries {
+		evicted = c.order.Remove(c.order.Back()).(*series)
+		delete(c.entries, evicted.key)
+	}
+	c.entries[key] = c.order.PushFront(&series{key: key, labelValues: labelValues, updated: now})
+	return evicted
+}
+
+// all returns the series, most recently updated first.
+func (c *seriesCache) all() []*series {
+	result := make([]*series, 0, c.order.Len())
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		result = append(result, element.Value.(*series))
+	}
+	return result
+}
+
+func (c *seriesCache) len() int {
+	return c.order.Len()
+}
+
+// expire removes the series that were not updated since before, and returns them.
+func (c *seriesCache) expire(before time.Time) []*series {
+	result := make([]*series, 0)
+	for element := c.order.Back(); element != nil && element.Value.(*series).updated.Before(before); element = c.order.Back() {
+		s := c.order.Remove(element).(*series)
+		delete(c.entries, s.key)
+		result = append(result, s)
+	}
+	return result
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeriesCacheEviction(t *testing.T) {
+	cache := newSeriesCache(2)
+	now := time.Now()
+	if cache.touch("a", []string{"a"}, now) != nil {
+		t.Fatal("Unexpected eviction.")
+	}
+	if cache.touch("b", []string{"b"}, now.Add(time.Second)) != nil {
+		t.Fatal("Unexpected eviction.")
+	}
+	if cache.touch("a", []string{"a"}, now.Add(2*time.Second)) != nil {
+		t.Fatal("Unexpected eviction when updating an existing series.")
+	}
+	evicted := cache.touch("c", []string{"c"}, now.Add(3*time.Second))
+	if evicted == nil || evicted.key != "b" {
+		t.Fatalf("Expected the least recently updated series b to be evicted, but got %v.", evicted)
+	}
+	all := cache.all()
+	if cache.len() != 2 || all[0].key != "c" || all[1].key != "a" {
+		t.Fatalf("Expected series c and a, but got %v.", all)
+	}
+	if !all[1].updated.Equal(now.Add(2 * time.Second)) {
+		t.Errorf("Expected the update time of series a to be tracked, but got %v.", all[1].updated)
+	}
+}
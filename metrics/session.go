@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// SessionTracker correlates the start, activity, and end lines of sessions by their key field.
+// Sessions that started before the exporter are not tracked, so their end lines are ignored.
+type SessionTracker struct {
+	name     string
+	key      string
+	start    regex.Regexp
+	activity regex.Regexp // nil if not configured
+	end      regex.Regexp
+	timeout  time.Duration
+	mutex    sync.Mutex
+	sessions map[string]*session
+	active   prometheus.Gauge
+	duration prometheus.Histogram
+	timeouts prometheus.Counter
+}
+
+type session struct {
+	started      time.Time
+	lastActivity time.Time
+}
+
+// CreateSessionTracker creates a session tracker. activity is nil if 'activity' is not configured.
+func CreateSessionTracker(cfg *config.SessionConfig, start, activity, end regex.Regexp) *SessionTracker {
+	buckets := cfg.Buckets
+	if len(buckets) == 0 {
+		buckets = []float64{1, 10, 60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400}
+	}
+	return &SessionTracker{
+		name:     cfg.Name,
+		key:      cfg.Key,
+		start:    start,
+		activity: activity,
+		end:      end,
+		timeout:  cfg.Timeout,
+		sessions: make(map[string]*session),
+		active: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: cfg.Name + "_active",
+			Help: cfg.Help + " Number of active sessions.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    cfg.Name + "_duration_seconds",
+			Help:    cfg.Help + " Duration of the ended sessions.",
+			Buckets: buckets,
+		}),
+		timeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: cfg.Name + "_timeouts_total",
+			Help: cfg.Help + " Number of sessions removed after 'timeout' without activity.",
+		}),
+	}
+}
+
+func (t *SessionTracker) Name() string {
+	return t.name
+}
+
+func (t *SessionTracker) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{t.active, t.duration, t.timeouts}
+}
+
+// Process updates the sessions with a log line. A line matching start for an active session counts as activity.
+func (t *SessionTracker) Process(line string, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if key, found := ExtractField(t.end, line, t.key); found {
+		if s, exists := t.sessions[key]; exists {
+			t.duration.Observe(now.Sub(s.started).Seconds())
+			delete(t.sessions, key)
+		}
+		t.active.Set(float64(len(t.sessions)))
+		return
+	}
+	if key, found := ExtractField(t.start, line, t.key); found {
+		if s, exists := t.sessions[key]; exists {
+			s.lastActivity = now
+		} else {
+			t.sessions[key] = &session{started: now, lastActivity: now}
+		}
+		t.active.Set(float64(len(t.sessions)))
+		return
+	}
+	if t.activity != nil {
+		if key, found := ExtractField(t.activity, line, t.key); found {
+			if s, exists := t.sessions[key]; exists {
+				s.lastActivity = now
+			}
+		}
+	}
+}
+
+// Expire removes the sessions without activity within the timeout, and returns the number of removed sessions.
+func (t *SessionTracker) Expire(now time.Time) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	expired := 0
+	for key, s := range t.sessions {
+		if now.Sub(s.lastActivity) >= t.timeout {
+			delete(t.sessions, key)
+			expired++
+		}
+	}
+	t.timeouts.Add(float64(expired))
+	t.active.Set(float64(len(t.sessions)))
+	return expired
+}
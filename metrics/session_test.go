@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	tracker := CreateSessionTracker(&config.SessionConfig{
+		Name:    "user_sessions",
+		Help:    "User sessions.",
+		Key:     "user",
+		Timeout: 10 * time.Minute,
+	}, regex.MustCompile(`login user=(?<user>\w+)`), regex.MustCompile(`request user=(?<user>\w+)`), regex.MustCompile(`logout user=(?<user>\w+)`))
+	now := time.Now()
+	tracker.Process("login user=alice", now)
+	tracker.Process("login user=bob", now)
+	tracker.Process("logout user=carol", now) // started before the exporter, ignored
+	tracker.Process("request user=alice", now.Add(8*time.Minute))
+	tracker.Process("logout user=alice", now.Add(9*time.Minute))
+	if expired := tracker.Expire(now.Add(15 * time.Minute)); expired != 1 {
+		t.Errorf("Expected bob's session to time out, but %v sessions expired.", expired)
+	}
+	var active, duration, timeouts dto.Metric
+	tracker.active.Write(&active)
+	tracker.duration.Write(&duration)
+	tracker.timeouts.Write(&timeouts)
+	if active.GetGauge().GetValue() != 0 {
+		t.Errorf("Expected no active sessions, but got %v.", active.GetGauge().GetValue())
+	}
+	if duration.GetHistogram().GetSampleCount() != 1 || duration.GetHistogram().GetSampleSum() != 540 {
+		t.Errorf("Expected alice's session of 540 seconds, but got %v sessions with %v seconds.", duration.GetHistogram().GetSampleCount(), duration.GetHistogram().GetSampleSum())
+	}
+	if timeouts.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected 1 timeout, but got %v.", timeouts.GetCounter().GetValue())
+	}
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/mutate"
+	"regexp"
+)
+
+// A stage of the 'pipeline'. It modifies the fields in place, and returns false if the match is filtered out.
+type stage func(fields map[string]string) bool
+
+// stages are the compiled 'pipeline' of a metric. A nil stages has no stages.
+type stages []stage
+
+// newStages compiles the 'pipeline'. The field names are translated to the grok capture names, like the labels,
+// so that a field is found in the captures even if it is renamed in 'fields.rename'.
+func newStages(cfg *config.MetricConfig) stages {
+	var result stages
+	for _, s := range cfg.Pipeline {
+		switch {
+		case s.Filter != nil:
+			field, _ := cfg.Fields.CaptureName(s.Filter.Field) // already validated in validateMetrics()
+			if s.Filter.Match != "" {
+				regex := regexp.MustCompile(s.Filter.Match) // already validated in config
+				result = append(result, func(fields map[string]string) bool {
+					return regex.MatchString(fields[field])
+				})
+			} else {
+				regex := regexp.MustCompile(s.Filter.NotMatch)
+				result = append(result, func(fields map[string]string) bool {
+					return !regex.MatchString(fields[field])
+				})
+			}
+		case len(s.Set) > 0:
+			values := make(map[string]string, len(s.Set))
+			for field, value := range s.Set {
+				capture, _ := cfg.Fields.CaptureName(field)
+				values[capture] = value
+			}
+			result = append(result, func(fields map[string]string) bool {
+				for field, value := range values {
+					fields[field] = value
+				}
+				return true
+			})
+		case len(s.Mutate) > 0:
+			functions := make(map[string]mutate.Func, len(s.Mutate))
+			for field, expressions := range s.Mutate {
+				capture, _ := cfg.Fields.CaptureName(field)
+				functions[capture], _ = mutate.Chain(expressions) // already validated in config
+			}
+			result = append(result, func(fields map[string]string) bool {
+				for field, f := range functions {
+					fields[field] = f(fields[field]) // a missing field is empty, like in the labels
+				}
+				return true
+			})
+		}
+	}
+	return result
+}
+
+// run applies the stages in order. It returns false if a filter stage rejected the match, the remaining stages are skipped then.
+func (s stages) run(fields map[string]string) bool {
+	for _, f := range s {
+		if !f(fields) {
+			return false
+		}
+	}
+	return true
+}
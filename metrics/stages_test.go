@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"testing"
+)
+
+func TestPipelineStages(t *testing.T) {
+	cfg := &config.MetricConfig{
+		Name: "errors_total",
+		Help: "Server errors.",
+		Labels: []config.Label{
+			{GrokFieldName: "status", PrometheusLabel: "class"},
+			{GrokFieldName: "tier", PrometheusLabel: "tier"},
+		},
+		Pipeline: []config.StageConfig{
+			{Mutate: map[string][]string{"status": {"substring(0,1)"}}},
+			{Filter: &config.FilterStageConfig{Field: "status", Match: "^5$"}},
+			{Set: map[string]string{"tier": "backend"}},
+		},
+	}
+	match := regex.MustCompile(`status=(?<status>\d+)`)
+	m := CreateGenericCounterVecMetric(cfg, match, nil)
+	for _, line := range []string{"status=500", "status=503", "status=200"} {
+		m.Process(line, nil)
+	}
+	scraped := collect(m.Collector())
+	if len(scraped) != 1 || scraped[0].Counter.GetValue() != 2 {
+		t.Fatalf("Expected one series with value 2, but got %v.", scraped)
+	}
+	for _, label := range scraped[0].Label {
+		if (label.GetName() == "class" && label.GetValue() != "5") || (label.GetName() == "tier" && label.GetValue() != "backend") {
+			t.Errorf("Unexpected label %v=%v.", label.GetName(), label.GetValue())
+		}
+	}
+
+	// The stages are applied in order: If the filter comes first, it sees the full status code.
+	cfg.Pipeline[0], cfg.Pipeline[1] = cfg.Pipeline[1], cfg.Pipeline[0]
+	m = CreateGenericCounterVecMetric(cfg, match, nil)
+	m.Process("status=500", nil)
+	if scraped := collect(m.Collector()); len(scraped) != 0 {
+		t.Fatalf("Expected the filter to reject the unmodified status, but got %v.", scraped)
+	}
+}
+
+func TestPipelineNotMatch(t *testing.T) {
+	m := CreateGaugeMetric(&config.MetricConfig{
+		Name:  "queue_size",
+		Help:  "Queue size.",
+		Value: "size",
+		Pipeline: []config.StageConfig{
+			{Filter: &config.FilterStageConfig{Field: "queue", NotMatch: "^test"}},
+		},
+	}, regex.MustCompile(`queue (?<queue>\w+) size (?<size>\d+)`), nil)
+	m.Process("queue main size 7", nil)
+	m.Process("queue test1 size 3", nil)
+	scraped := collect(m.Collector())
+	if len(scraped) != 1 || scraped[0].Gauge.GetValue() != 7 {
+		t.Fatalf("Expected gauge value 7, but got %v.", scraped)
+	}
+}
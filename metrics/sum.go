@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// sumMetric is the companion counter for 'sum_field'. It is updated, reset, and expired together with the counter it belongs to,
+// so that both are computed from a single match.
+type sumMetric struct {
+	counter *genericCounterVecMetric
+}
+
+// Companions returns the metrics maintained by m in addition to m itself, like the sum for 'sum_field'.
+// The companions must be registered like m, but they do not process lines.
+func Companions(m Metric) []Metric {
+	if wrapped, ok := m.(*contextMetric); ok {
+		m = wrapped.Metric
+	}
+	if counter, ok := m.(*genericCounterVecMetric); ok && counter.sum != nil {
+		return []Metric{&sumMetric{counter}}
+	}
+	return nil
+}
+
+func (m *sumMetric) Name() string {
+	return m.counter.sumName
+}
+
+func (m *sumMetric) Collector() prometheus.Collector {
+	return m.counter.sum
+}
+
+func (m *sumMetric) Matches(line string) bool {
+	return false
+}
+
+func (m *sumMetric) Process(line string, fields map[string]string) {}
+
+func (m *sumMetric) SetMatch(regex, repeat, context regex.Regexp) {}
+
+// The sum is reset together with the counter.
+func (m *sumMetric) Reset() {}
+
+func (m *sumMetric) Stop() {}
+
+// The sum series expire together with the counter series.
+func (m *sumMetric) Expire(now time.Time) int {
+	return 0
+}
+
+func (m *sumMetric) LastMatches() []Match {
+	return m.counter.LastMatches()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/regex"
+	"strings"
+	"time"
+)
+
+// multiline joins lines into records, as configured in 'input.multiline'.
+// A record is complete when the next record starts, when it has 'max_lines' lines,
+// or when no line was appended within 'timeout'. The lines of a record are joined with "\n".
+type multiline struct {
+	start        regex.Regexp // nil if 'continuation' is configured
+	continuation regex.Regexp // nil if 'start' is configured
+	timeout      time.Duration
+	maxLines     int
+	pending      []string
+	pendingTime  time.Time // read time of the first pending line
+	lastTime     time.Time // read time of the last pending line
+}
+
+func newMultiline(cfg *config.MultilineConfig, patterns *Patterns) (*multiline, error) {
+	result := &multiline{
+		timeout:  cfg.Timeout,
+		maxLines: cfg.MaxLines,
+	}
+	var err error
+	if cfg.Start != "" {
+		result.start, err = Compile(cfg.Start, patterns)
+	} else {
+		result.continuation, err = Compile(cfg.Continuation, patterns)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// add appends the line to the pending record. If this completes the previous record, it is returned.
+func (m *multiline) add(line string, readTime time.Time) (string, time.Time, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	var continues bool
+	if m.start != nil {
+		continues = !m.start.MatchString(line)
+	} else {
+		continues = m.continuation.MatchString(line)
+	}
+	if continues && len(m.pending) > 0 && len(m.pending) < m.maxLines {
+		m.pending = append(m.pending, line)
+		m.lastTime = readTime
+		return "", time.Time{}, false
+	}
+	record, recordTime, complete := m.flush()
+	m.pending = append(m.pending, line)
+	m.pendingTime, m.lastTime = readTime, readTime
+	return record, recordTime, complete
+}
+
+// flush returns the pending record, if any.
+func (m *multiline) flush() (string, time.Time, bool) {
+	if len(m.pending) == 0 {
+		return "", time.Time{}, false
+	}
+	record := strings.Join(m.pending, "\n")
+	m.pending = m.pending[:0]
+	return record, m.pendingTime, true
+}
+
+// timeoutChannel fires when the pending record times out. Returns nil, which blocks forever,
+// if multiline is not configured or there is no pending record.
+func (m *multiline) timeoutChannel() <-chan time.Time {
+	if m == nil || len(m.pending) == 0 {
+		return nil
+	}
+	return time.After(m.lastTime.Add(m.timeout).Sub(time.Now()))
+}
+
+// joinAll joins all lines, for commands that read the whole input at once.
+func (m *multiline) joinAll(lines []string) []string {
+	result := make([]string, 0, len(lines))
+	now := time.Now()
+	for _, line := range lines {
+		if record, _, complete := m.add(line, now); complete {
+			result = append(result, record)
+		}
+	}
+	if record, _, complete := m.flush(); complete {
+		result = append(result, record)
+	}
+	return result
+}
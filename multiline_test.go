@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"reflect"
+	"testing"
+)
+
+func TestMultilineContinuation(t *testing.T) {
+	cfg, err := config.LoadConfigString([]byte(`
+input:
+    type: stdin
+    multiline:
+        preset: java_exception
+metrics:
+    - type: counter
+      name: exceptions_total
+      help: Exceptions.
+      preset: java_exception
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	joiner, err := newMultiline(cfg.Input.Multiline, InitPatterns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := joiner.joinAll([]string{
+		"2016-04-01 12:00:00 ERROR Request failed",
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.Foo.bar(Foo.java:12)",
+		"Caused by: java.io.IOException: closed",
+		"\t... 3 more",
+		"2016-04-01 12:00:01 INFO ok\n",
+	})
+	expected := []string{
+		"2016-04-01 12:00:00 ERROR Request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Foo.bar(Foo.java:12)\nCaused by: java.io.IOException: closed\n\t... 3 more",
+		"2016-04-01 12:00:01 INFO ok",
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %q, but got %q.", expected, records)
+	}
+	metric, err := createMetrics(cfg, InitPatterns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metric[0].Process(records[0], nil)
+	labels := metric[0].LastMatches()[0].Labels
+	if labels["exception"] != "java.lang.IllegalStateException" {
+		t.Errorf("Expected label exception=java.lang.IllegalStateException, but got %v.", labels)
+	}
+}
+
+func TestMultilineStart(t *testing.T) {
+	joiner, err := newMultiline(&config.MultilineConfig{Start: `^\d`, MaxLines: 2}, InitPatterns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := joiner.joinAll([]string{"continuation without start", "1 a", "b", "c", "2 d"})
+	expected := []string{"continuation without start", "1 a\nb", "c", "2 d"}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %q, but got %q.", expected, records)
+	}
+}
@@ -0,0 +1,104 @@
+package mutate
+
+import (
+	"regexp"
+	"sync"
+)
+
+// Mapping is a mapping table for the 'map(name)' function, like many SQLSTATE codes onto a few categories.
+// Mappings are registered by name with RegisterMapping(), and may be updated while they are in use.
+type Mapping struct {
+	mutex  sync.RWMutex
+	exact  map[string]string
+	rules  []Rule
+	orElse string // value for unmapped values, empty means the value is kept
+}
+
+// Rule maps all values matching the regular expression to Value. Rules are tried in order if there is no exact match.
+type Rule struct {
+	Regex *regexp.Regexp
+	Value string
+}
+
+func NewMapping(exact map[string]string, rules []Rule, orElse string) *Mapping {
+	m := &Mapping{}
+	m.Update(exact, rules, orElse)
+	return m
+}
+
+// Update replaces the table, for example when the mapping file was modified.
+func (m *Mapping) Update(exact map[string]string, rules []Rule, orElse string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.exact, m.rules, m.orElse = exact, rules, orElse
+}
+
+func (m *Mapping) Lookup(value string) string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if result, exists := m.exact[value]; exists {
+		return result
+	}
+	for _, rule := range m.rules {
+		if rule.Regex.MatchString(value) {
+			return rule.Value
+		}
+	}
+	if m.orElse != "" {
+		return m.orElse
+	}
+	return value
+}
+
+var mappings = struct {
+	sync.RWMutex
+	byName map[string]*Mapping
+}{byName: make(map[string]*Mapping)}
+
+// RegisterMapping makes the mapping available as 'map(name)'. A mapping registered earlier with the same name is replaced.
+func RegisterMapping(name string, m *Mapping) {
+	mappings.Lock()
+	defer mappings.Unlock()
+	mappings.byName[name] = m
+}
+
+// SetMappings replaces all registered mappings, and returns the previously registered mappings.
+func SetMappings(byName map[string]*Mapping) map[string]*Mapping {
+	mappings.Lock()
+	defer mappings.Unlock()
+	previous := mappings.byName
+	mappings.byName = byName
+	return previous
+}
+
+// RegisteredMapping returns the mapping registered with the name.
+func RegisteredMapping(name string) (*Mapping, bool) {
+	mappings.RLock()
+	defer mappings.RUnlock()
+	m, exists := mappings.byName[name]
+	return m, exists
+}
+
+// Matches map(name), with optional whitespace around the name.
+var mapRegexp = regexp.MustCompile(`^map\(\s*([^()\s]+)\s*\)$`)
+
+// MappingName returns the name of the mapping if the expression is a 'map(name)' function.
+func MappingName(expression string) (string, bool) {
+	match := mapRegexp.FindStringSubmatch(expression)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// mapping looks up the mapping when the function is called, so that map(name) can be parsed before the mapping is registered.
+// Values are kept unchanged if no mapping with that name is registered.
+func mapping(name string) Func {
+	return func(s string) string {
+		m, exists := RegisteredMapping(name)
+		if !exists {
+			return s
+		}
+		return m.Lookup(s)
+	}
+}
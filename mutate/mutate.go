@@ -0,0 +1,72 @@
+package mutate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Func transforms the value of a grok field before it is used as label or value.
+type Func func(string) string
+
+// Matches substring(start,end), with optional whitespace around the numbers.
+var substringRegexp = regexp.MustCompile(`^substring\(\s*(\d+)\s*,\s*(\d+)\s*\)$`)
+
+// Parse parses a function like 'uppercase', 'lowercase', 'strip', 'substring(0,3)', or 'map(name)'.
+func Parse(expression string) (Func, error) {
+	switch expression {
+	case "uppercase":
+		return strings.ToUpper, nil
+	case "lowercase":
+		return strings.ToLower, nil
+	case "strip":
+		return strings.TrimSpace, nil
+	}
+	if match := substringRegexp.FindStringSubmatch(expression); match != nil {
+		start, err1 := strconv.Atoi(match[1])
+		end, err2 := strconv.Atoi(match[2])
+		if err1 != nil || err2 != nil || end < start {
+			return nil, fmt.Errorf("Invalid function '%v': Expecting substring(start,end) with 0 <= start <= end.", expression)
+		}
+		return substring(start, end), nil
+	}
+	if name, ok := MappingName(expression); ok {
+		return mapping(name), nil
+	}
+	return nil, fmt.Errorf("Unknown function '%v'. Expecting 'uppercase', 'lowercase', 'strip', 'substring(start,end)', or 'map(name)'.", expression)
+}
+
+// Chain parses a list of functions, which are applied in the given order.
+func Chain(expressions []string) (Func, error) {
+	funcs := make([]Func, 0, len(expressions))
+	for _, expression := range expressions {
+		f, err := Parse(expression)
+		if err != nil {
+			return nil, err
+		}
+		funcs = append(funcs, f)
+	}
+	return func(s string) string {
+		for _, f := range funcs {
+			s = f(s)
+		}
+		return s
+	}, nil
+}
+
+// substring counts characters, not bytes, so that multi-byte characters are not cut.
+// Like in most languages, end is exclusive. Indexes beyond the end of the string are truncated.
+func substring(start, end int) Func {
+	return func(s string) string {
+		runes := []rune(s)
+		from, to := start, end
+		if from > len(runes) {
+			from = len(runes)
+		}
+		if to > len(runes) {
+			to = len(runes)
+		}
+		return string(runes[from:to])
+	}
+}
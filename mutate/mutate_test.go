@@ -0,0 +1,56 @@
+package mutate
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	for _, test := range []struct {
+		functions []string
+		input     string
+		expected  string
+	}{
+		{[]string{"uppercase"}, "get", "GET"},
+		{[]string{"lowercase"}, "GET", "get"},
+		{[]string{"strip"}, "  a b \t", "a b"},
+		{[]string{"substring(0,3)"}, "abcdef", "abc"},
+		{[]string{"substring(2, 10)"}, "abcdef", "cdef"},
+		{[]string{"substring(8,10)"}, "abcdef", ""},
+		{[]string{"substring(0,2)"}, "äöü", "äö"},
+		{[]string{"strip", "substring(0,3)", "uppercase"}, " abcdef ", "ABC"},
+		{[]string{}, "abc", "abc"},
+	} {
+		f, err := Chain(test.functions)
+		if err != nil {
+			t.Fatalf("%v: Unexpected error: %v", test.functions, err.Error())
+		}
+		if result := f(test.input); result != test.expected {
+			t.Errorf("%v(%q): Expected %q, but got %q.", test.functions, test.input, test.expected, result)
+		}
+	}
+	for _, invalid := range []string{"upper", "substring(3,1)", "substring(1)", "substring(-1,2)"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Expected error for %q.", invalid)
+		}
+	}
+}
+
+func TestMapping(t *testing.T) {
+	RegisterMapping("sqlstate", NewMapping(map[string]string{"23505": "unique_violation"}, []Rule{
+		{regexp.MustCompile(`^08`), "connection"},
+		{regexp.MustCompile(`^23`), "integrity"},
+	}, "other"))
+	f, err := Chain([]string{"strip", "map(sqlstate)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for input, expected := range map[string]string{"23505": "unique_violation", " 23503": "integrity", "08006": "connection", "42P01": "other"} {
+		if result := f(input); result != expected {
+			t.Errorf("map(sqlstate)(%q): Expected %q, but got %q.", input, expected, result)
+		}
+	}
+	if result := mapping("undefined")("x"); result != "x" {
+		t.Errorf("Expected an undefined mapping to keep the value, but got %q.", result)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"net/http"
+)
+
+// nameFilter wraps the handler of a metrics endpoint, so that consumers like a federating Prometheus can select metric families
+// with the query parameter name[], like /metrics?name[]=http_requests_total&name[]=grok_exporter_lines_total.
+// Without name[], the request is passed to the handler unchanged.
+// The vendored Prometheus client library cannot collect a subset of the registry, so all metrics are collected,
+// and the response is reduced to the selected families.
+func nameFilter(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["name[]"]
+		if len(names) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		selected := make(map[string]bool, len(names))
+		for _, name := range names {
+			selected[name] = true
+		}
+		// Request the delimited protobuf format, so that the families can be decoded, and encode them in the format the consumer accepts.
+		inner := new(http.Request)
+		*inner = *r
+		inner.Header = make(http.Header, len(r.Header))
+		for key, values := range r.Header {
+			inner.Header[key] = values
+		}
+		inner.Header.Set("Accept", prometheus.DelimitedTelemetryContentType)
+		inner.Header.Del("Accept-Encoding")
+		response := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		handler.ServeHTTP(response, inner)
+		if response.status != http.StatusOK {
+			response.copyTo(w)
+			return
+		}
+		encode, contentType := negotiate(r)
+		var buf bytes.Buffer
+		for {
+			family := &dto.MetricFamily{}
+			_, err := pbutil.ReadDelimited(&response.body, family)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("An error has occurred:\n\nFailed to decode the metrics: %v", err.Error()), http.StatusInternalServerError)
+				return
+			}
+			if !selected[family.GetName()] {
+				continue
+			}
+			_, err = encode(&buf, family)
+			if err != nil {
+				http.Error(w, "An error has occurred:\n\n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	})
+}
+
+// bufferedResponse is a http.ResponseWriter keeping the response in memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *bufferedResponse) Write(data []byte) (int, error) {
+	return r.body.Write(data)
+}
+
+func (r *bufferedResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+// copyTo writes the response unchanged, like an error or an authentication challenge.
+func (r *bufferedResponse) copyTo(w http.ResponseWriter) {
+	for key, values := range r.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
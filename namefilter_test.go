@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNameFilter(t *testing.T) {
+	for _, name := range []string{"name_filter_a_total", "name_filter_b_total", "name_filter_c_total"} {
+		prometheus.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: name, Help: "Test counter."}))
+	}
+	handler := nameFilter(prometheus.Handler())
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics?name[]=name_filter_a_total&name[]=name_filter_c_total", nil))
+	body := recorder.Body.String()
+	if !strings.Contains(body, "name_filter_a_total 0") || !strings.Contains(body, "name_filter_c_total 0") || strings.Contains(body, "name_filter_b_total") {
+		t.Errorf("Expected only name_filter_a_total and name_filter_c_total, but got:\n%v", body)
+	}
+	if strings.Contains(body, "go_goroutines") {
+		t.Errorf("Expected the Go runtime metrics to be filtered, but got:\n%v", body)
+	}
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics?name[]=name_filter_b_total", nil)
+	req.Header.Set("Accept", prometheus.DelimitedTelemetryContentType)
+	handler.ServeHTTP(recorder, req)
+	var family dto.MetricFamily
+	if _, err := pbutil.ReadDelimited(recorder.Body, &family); err != nil || family.GetName() != "name_filter_b_total" || recorder.Body.Len() != 0 {
+		t.Errorf("Expected only name_filter_b_total in protobuf format, but got %v (%v).", family, err)
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if body = recorder.Body.String(); !strings.Contains(body, "name_filter_b_total 0") || !strings.Contains(body, "go_goroutines") {
+		t.Errorf("Expected all metrics without name[], but got:\n%v", body)
+	}
+}
+
+func TestNameFilterError(t *testing.T) {
+	handler := nameFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/team-a?name[]=a_total", nil))
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected the authentication challenge to be passed through, but got %v %v.", recorder.Code, recorder.Header())
+	}
+}
@@ -0,0 +1,138 @@
+// Package notify posts matching log lines to a webhook, so that rare but critical events trigger immediate action.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Number of notifications waiting to be sent. If the webhook is slow, further notifications are dropped.
+const queueSize = 100
+
+var notificationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "grok_exporter_notifications_total",
+	Help: "Number of webhook notifications by metric and result, which is 'sent', 'failed', 'rate_limited', or 'dropped'.",
+}, []string{"metric", "result"})
+
+// Collector returns the metrics about the notifications.
+func Collector() prometheus.Collector {
+	return notificationsTotal
+}
+
+// Payload is the JSON body posted to the webhook.
+type Payload struct {
+	Metric string            `json:"metric"`
+	Line   string            `json:"line"`
+	Fields map[string]string `json:"fields"`
+	Time   time.Time         `json:"time"`
+}
+
+// Notifier sends the notifications of one metric in the background, in the order of the matches.
+type Notifier struct {
+	metric string
+	url    string
+	client *http.Client
+	queue  chan *Payload
+	mutex  sync.Mutex
+	tokens float64 // token bucket for 'max_per_minute'
+	rate   float64 // tokens per second
+	burst  float64
+	last   time.Time
+}
+
+// New creates a notifier and starts the goroutine sending the notifications.
+// If cfg is nil, New returns nil.
+func New(metric string, cfg *config.NotifyConfig) *Notifier {
+	if cfg == nil {
+		return nil
+	}
+	n := &Notifier{
+		metric: metric,
+		url:    cfg.Url,
+		client: &http.Client{Timeout: cfg.Timeout},
+		queue:  make(chan *Payload, queueSize),
+		tokens: float64(cfg.MaxPerMinute),
+		rate:   float64(cfg.MaxPerMinute) / 60,
+		burst:  float64(cfg.MaxPerMinute),
+		last:   time.Now(),
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues a notification. It never blocks, so a slow webhook does not delay log processing.
+// Notify may be called on a nil Notifier, which does nothing.
+func (n *Notifier) Notify(line string, fields map[string]string, now time.Time) {
+	if n == nil {
+		return
+	}
+	if !n.allow(now) {
+		notificationsTotal.WithLabelValues(n.metric, "rate_limited").Inc()
+		return
+	}
+	payload := &Payload{Metric: n.metric, Line: line, Fields: fields, Time: now}
+	select {
+	case n.queue <- payload:
+	default:
+		notificationsTotal.WithLabelValues(n.metric, "dropped").Inc()
+	}
+}
+
+// Close stops the notifier after the queued notifications are sent. Notify must not be called after Close.
+// Close may be called on a nil Notifier, which does nothing.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+}
+
+func (n *Notifier) allow(now time.Time) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.tokens += now.Sub(n.last).Seconds() * n.rate
+	if n.tokens > n.burst {
+		n.tokens = n.burst
+	}
+	n.last = now
+	if n.tokens < 1 {
+		return false
+	}
+	n.tokens--
+	return true
+}
+
+func (n *Notifier) run() {
+	for payload := range n.queue {
+		err := n.send(payload)
+		if err != nil {
+			notificationsTotal.WithLabelValues(n.metric, "failed").Inc()
+			fmt.Fprintf(os.Stderr, "Failed to send notification for metric %v: %v\n", n.metric, err.Error())
+			continue
+		}
+		notificationsTotal.WithLabelValues(n.metric, "sent").Inc()
+	}
+}
+
+func (n *Notifier) send(payload *Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%v returned %v", n.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"encoding/json"
+	"github.com/fstab/grok_exporter/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	received := make(chan *Payload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- &payload
+	}))
+	defer server.Close()
+	n := New("oom_kills_total", &config.NotifyConfig{Url: server.URL, MaxPerMinute: 2, Timeout: time.Second})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		n.Notify("Out of memory: Killed process 1234 (java)", map[string]string{"process": "java"}, now)
+	}
+	payload := <-received
+	if payload.Metric != "oom_kills_total" || payload.Fields["process"] != "java" {
+		t.Errorf("Unexpected payload: %v", payload)
+	}
+	<-received
+	select {
+	case <-received:
+		t.Error("Expected the third notification to be rate limited.")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !n.allow(now.Add(30 * time.Second)) {
+		t.Error("Expected the rate limit to allow one notification after 30 seconds.")
+	}
+}
@@ -7,14 +7,50 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 )
 
-type Patterns map[string]string
+// Patterns are the Grok patterns from 'grok.patterns_dir' and 'grok.patterns'.
+//
+// Each pattern belongs to a namespace: Patterns from a file in 'grok.patterns_dir' are in the namespace named after the file
+// without extension, inline patterns from 'grok.patterns' are in the namespace 'inline'. Pattern definitions may set
+// the namespace explicitly, like 'mycorp.ORDERID [0-9]+'.
+//
+// A qualified reference like %{mycorp.ORDERID} refers to the pattern in that namespace. An unqualified reference like
+// %{ORDERID} refers to the effective pattern: Inline patterns take precedence over patterns from 'grok.patterns_dir',
+// and within the same source the pattern added last takes precedence.
+type Patterns struct {
+	qualified map[string]*pattern // key is namespace.NAME
+	effective map[string]*pattern // key is NAME
+	shadowed  []shadowing
+}
+
+type pattern struct {
+	namespace  string
+	name       string
+	definition string
+	source     string // path of the pattern file, or 'grok.patterns'
+	precedence int
+}
+
+// shadowing means that an unqualified reference to the pattern's name resolves to a pattern with a different definition.
+type shadowing struct {
+	pattern *pattern
+	by      *pattern
+}
+
+const (
+	precedenceDir    = 1
+	precedenceInline = 2
+	inlineNamespace  = "inline"
+)
 
 func InitPatterns() *Patterns {
-	result := Patterns(make(map[string]string))
-	return &result
+	return &Patterns{
+		qualified: make(map[string]*pattern),
+		effective: make(map[string]*pattern),
+	}
 }
 
 func (p *Patterns) AddDir(path string) error {
@@ -38,11 +74,13 @@ func (p *Patterns) AddFile(path string) error {
 		return fmt.Errorf("Failed to read %v: %v", path, err.Error())
 	}
 	defer file.Close()
+	base := filepath.Base(path)
+	namespace := strings.TrimSuffix(base, filepath.Ext(base))
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if !isEmpty(line) && !isComment(line) {
-			err = p.AddPattern(scanner.Text())
+			err = p.add(scanner.Text(), namespace, path, precedenceDir)
 			if err != nil {
 				return fmt.Errorf("Failed to read %v: %v", path, err.Error())
 			}
@@ -54,19 +92,110 @@ func (p *Patterns) AddFile(path string) error {
 	return nil
 }
 
+// AddPattern adds an inline pattern, see 'grok.patterns'.
 func (p *Patterns) AddPattern(pattern string) error {
-	r := regexp.MustCompile(`([A-z0-9]+)\s+(.+)`)
-	match := r.FindStringSubmatch(pattern)
+	return p.add(pattern, inlineNamespace, "grok.patterns", precedenceInline)
+}
+
+var patternDefinitionRegexp = regexp.MustCompile(`(?:([^\s.]+)\.)?([A-z0-9]+)\s+(.+)`)
+
+func (p *Patterns) add(definition string, namespace string, source string, precedence int) error {
+	match := patternDefinitionRegexp.FindStringSubmatch(definition)
 	if match == nil {
-		return fmt.Errorf("'%v' is not a valid pattern definition.", pattern)
+		return fmt.Errorf("'%v' is not a valid pattern definition.", definition)
+	}
+	if match[1] != "" {
+		namespace = match[1]
+	}
+	added := &pattern{
+		namespace:  namespace,
+		name:       match[2],
+		definition: match[3],
+		source:     source,
+		precedence: precedence,
 	}
-	(*p)[match[1]] = match[2]
+	p.qualified[added.qualifiedName()] = added
+	if current, exists := p.effective[added.name]; exists {
+		if current.precedence > added.precedence {
+			p.shadow(added, current)
+			return nil
+		}
+		p.shadow(current, added)
+	}
+	p.effective[added.name] = added
 	return nil
 }
 
-func (p *Patterns) Find(pattern string) (string, bool) {
-	result, exists := (*p)[pattern]
-	return result, exists
+func (p *Patterns) shadow(shadowed *pattern, by *pattern) {
+	// Re-adding the same pattern, as in the well-known logstash pattern files, is not worth a warning.
+	if shadowed.definition == by.definition {
+		return
+	}
+	p.shadowed = append(p.shadowed, shadowing{pattern: shadowed, by: by})
+}
+
+// Find returns the definition of a pattern. The name is either qualified like 'mycorp.ORDERID',
+// or unqualified like 'ORDERID', which refers to the effective pattern.
+func (p *Patterns) Find(name string) (string, bool) {
+	var result *pattern
+	var exists bool
+	if strings.Contains(name, ".") {
+		result, exists = p.qualified[name]
+	} else {
+		result, exists = p.effective[name]
+	}
+	if !exists {
+		return "", false
+	}
+	return result.definition, true
+}
+
+// Names returns the sorted names of the effective patterns.
+func (p *Patterns) Names() []string {
+	result := make([]string, 0, len(p.effective))
+	for name := range p.effective {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Shadowed returns a warning for each pattern that is shadowed by a pattern with the same name and a different definition.
+func (p *Patterns) Shadowed() []string {
+	result := make([]string, 0, len(p.shadowed))
+	for _, s := range p.shadowed {
+		result = append(result, fmt.Sprintf("Pattern %v from %v is shadowed by %v from %v. Use %%{%v} to refer to the shadowed pattern.",
+			s.pattern.qualifiedName(), s.pattern.source, s.by.qualifiedName(), s.by.source, s.pattern.qualifiedName()))
+	}
+	return result
+}
+
+// List returns a line for each pattern with the qualified name, the source, and the definition, sorted by name.
+// The effective patterns are marked with '*'.
+func (p *Patterns) List() []string {
+	all := make([]*pattern, 0, len(p.qualified))
+	for _, candidate := range p.qualified {
+		all = append(all, candidate)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return all[i].namespace < all[j].namespace
+	})
+	result := make([]string, 0, len(all))
+	for _, candidate := range all {
+		marker := " "
+		if p.effective[candidate.name] == candidate {
+			marker = "*"
+		}
+		result = append(result, fmt.Sprintf("%v %v (%v): %v", marker, candidate.qualifiedName(), candidate.source, candidate.definition))
+	}
+	return result
+}
+
+func (p *pattern) qualifiedName() string {
+	return p.namespace + "." + p.name
 }
 
 func isEmpty(line string) bool {
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -14,3 +17,53 @@ func TestLoadPatternFiles(t *testing.T) {
 		t.Errorf("Unexpected error: %v", err.Error())
 	}
 }
+
+func TestPatternNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter_patterns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "mycorp.patterns"), []byte("ORDERID [0-9]+\nWORD \\w+\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := InitPatterns()
+	err = p.AddDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddPattern("ORDERID [A-Z]{3}[0-9]+")
+	p.AddPattern("core.NUMBER \\d+")
+	p.AddPattern("WORD \\w+")
+	for name, expected := range map[string]string{
+		"ORDERID":        "[A-Z]{3}[0-9]+",
+		"mycorp.ORDERID": "[0-9]+",
+		"inline.ORDERID": "[A-Z]{3}[0-9]+",
+		"NUMBER":         "\\d+",
+		"core.NUMBER":    "\\d+",
+	} {
+		definition, exists := p.Find(name)
+		if !exists || definition != expected {
+			t.Errorf("%v: expected '%v', but got '%v'.", name, expected, definition)
+		}
+	}
+	if _, exists := p.Find("inline.NUMBER"); exists {
+		t.Errorf("inline.NUMBER should not be defined.")
+	}
+	shadowed := p.Shadowed()
+	if len(shadowed) != 1 || !strings.Contains(shadowed[0], "mycorp.ORDERID") {
+		t.Errorf("Expected mycorp.ORDERID to be shadowed, but got %v.", shadowed)
+	}
+	list := p.List()
+	if len(list) != 5 || !strings.HasPrefix(list[1], "* inline.ORDERID") || !strings.HasPrefix(list[2], "  mycorp.ORDERID") {
+		t.Errorf("Unexpected pattern list %v.", list)
+	}
+	regex, err := Compile("%{mycorp.ORDERID:id}", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regex.MatchString("42") || regex.MatchString("ABC") {
+		t.Errorf("%%{mycorp.ORDERID} should refer to the pattern from mycorp.patterns.")
+	}
+}
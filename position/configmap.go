@@ -0,0 +1,160 @@
+package position
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// configMapStore keeps the positions in a Kubernetes ConfigMap, with one data key per log file.
+// This is for DaemonSets and stateless pods, where the local file system is lost when the pod is rescheduled.
+// The service account of the pod needs the permissions get, create, and patch for the ConfigMap.
+type configMapStore struct {
+	url    string // of the ConfigMaps resource in the namespace
+	name   string
+	token  string
+	client *http.Client
+}
+
+// NewConfigMapStore creates a store using the Kubernetes API with the credentials of the pod's service account.
+// If namespace is empty, the namespace of the pod is used.
+func NewConfigMapStore(namespace string, name string) (Store, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("Positions type configmap requires running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set.")
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the service account token: %v", err.Error())
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the service account CA certificate: %v", err.Error())
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("Failed to parse the service account CA certificate.")
+	}
+	if namespace == "" {
+		data, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the namespace of the pod: %v", err.Error())
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certPool}},
+		Timeout:   10 * time.Second,
+	}
+	apiServer := "https://" + net.JoinHostPort(host, port)
+	return newConfigMapStore(apiServer, namespace, name, strings.TrimSpace(string(token)), client), nil
+}
+
+func newConfigMapStore(apiServer string, namespace string, name string, token string, client *http.Client) *configMapStore {
+	return &configMapStore{
+		url:    fmt.Sprintf("%v/api/v1/namespaces/%v/configmaps", apiServer, namespace),
+		name:   name,
+		token:  token,
+		client: client,
+	}
+}
+
+type configMap struct {
+	ApiVersion string            `json:"apiVersion,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Data       map[string]string `json:"data"`
+}
+
+func (s *configMapStore) Load(path string) (*Position, error) {
+	var cm configMap
+	status, err := s.request("GET", s.url+"/"+s.name, "", nil, &cm)
+	switch {
+	case err != nil:
+		return nil, err
+	case status == http.StatusNotFound:
+		return nil, nil
+	case cm.Data[configMapKey(path)] == "":
+		return nil, nil
+	}
+	var position Position
+	err = json.Unmarshal([]byte(cm.Data[configMapKey(path)]), &position)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse position of %v from ConfigMap %v: %v", path, s.name, err.Error())
+	}
+	return &position, nil
+}
+
+// Save patches the ConfigMap, so that the positions of other files are kept. The ConfigMap is created if it does not exist.
+func (s *configMapStore) Save(path string, position *Position) error {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+	patch := &configMap{Data: map[string]string{configMapKey(path): string(data)}}
+	status, err := s.request("PATCH", s.url+"/"+s.name, "application/merge-patch+json", patch, nil)
+	if err != nil || status != http.StatusNotFound {
+		return err
+	}
+	patch.ApiVersion, patch.Kind, patch.Metadata = "v1", "ConfigMap", map[string]string{"name": s.name}
+	_, err = s.request("POST", s.url, "application/json", patch, nil)
+	return err
+}
+
+// request returns the status code if the request succeeded or the resource was not found, and an error otherwise.
+func (s *configMapStore) request(method string, url string, contentType string, body interface{}, result interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to access ConfigMap %v: %v", s.name, err.Error())
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return resp.StatusCode, nil
+	case resp.StatusCode >= 300:
+		message, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("Failed to access ConfigMap %v: %v %v", s.name, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if result != nil {
+		err = json.NewDecoder(resp.Body).Decode(result)
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("Failed to parse ConfigMap %v: %v", s.name, err.Error())
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+var invalidConfigMapKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// configMapKey converts the path to a valid ConfigMap data key, like '/var/log/app.log' to 'var_log_app.log'.
+func configMapKey(path string) string {
+	return invalidConfigMapKeyChars.ReplaceAllString(strings.TrimPrefix(path, "/"), "_")
+}
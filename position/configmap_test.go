@@ -0,0 +1,54 @@
+package position
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConfigMapStore(t *testing.T) {
+	var data map[string]string // nil until the ConfigMap is created
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body configMap
+		json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/v1/namespaces/monitoring/configmaps/positions" && data != nil:
+			json.NewEncoder(w).Encode(&configMap{Data: data})
+		case r.Method == "PATCH" && r.URL.Path == "/api/v1/namespaces/monitoring/configmaps/positions" && data != nil:
+			for key, value := range body.Data {
+				data[key] = value
+			}
+			json.NewEncoder(w).Encode(&configMap{Data: data})
+		case r.Method == "POST" && r.URL.Path == "/api/v1/namespaces/monitoring/configmaps" && body.Metadata["name"] == "positions":
+			data = body.Data
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(&body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	store := newConfigMapStore(server.URL, "monitoring", "positions", "token", http.DefaultClient)
+	position, err := store.Load("/var/log/a.log")
+	if position != nil || err != nil {
+		t.Fatalf("Expected no position, but got %v, %v.", position, err)
+	}
+	for path, offset := range map[string]int64{"/var/log/a.log": 10, "/var/log/b.log": 20} {
+		err = store.Save(path, &Position{Offset: offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(data) != 2 || data["var_log_a.log"] == "" {
+		t.Fatalf("Expected one key per file, but got %v.", data)
+	}
+	position, err = store.Load("/var/log/b.log")
+	if err != nil || position == nil || position.Offset != 20 {
+		t.Errorf("Expected offset 20, but got %v, %v.", position, err)
+	}
+}
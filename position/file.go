@@ -0,0 +1,76 @@
+package position
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileStore keeps the positions of all log files in a JSON file, which is replaced atomically on each save.
+type fileStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewFileStore(path string) Store {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) Load(path string) (*Position, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	positions, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return positions[path], nil
+}
+
+func (s *fileStore) Save(path string, position *Position) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	positions, err := s.read()
+	if err != nil {
+		return err
+	}
+	positions[path] = position
+	data, err := json.MarshalIndent(positions, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return fmt.Errorf("Failed to write positions file: %v", err.Error())
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Failed to write positions file %v: %v", s.path, err.Error())
+	}
+	return nil
+}
+
+func (s *fileStore) read() (map[string]*Position, error) {
+	positions := make(map[string]*Position)
+	data, err := ioutil.ReadFile(s.path)
+	switch {
+	case os.IsNotExist(err):
+		return positions, nil
+	case err != nil:
+		return nil, fmt.Errorf("Failed to read positions file: %v", err.Error())
+	}
+	err = json.Unmarshal(data, &positions)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read positions file %v: %v", s.path, err.Error())
+	}
+	return positions, nil
+}
@@ -0,0 +1,82 @@
+// Package position stores how far a log file was read, so that the exporter resumes where it stopped after a restart.
+package position
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/spf13/afero"
+	"io"
+	"time"
+)
+
+// The fingerprint identifies a file by its first bytes, so that a position is not applied to a rotated file.
+// Unlike the inode, the fingerprint is the same when a volume is mounted on another node.
+const maxFingerprintLength = 1024
+
+// Position is the read position in a log file.
+type Position struct {
+	Offset            int64      `json:"offset"`
+	Fingerprint       string     `json:"fingerprint"`        // hex SHA-256 of the first FingerprintLength bytes of the file
+	FingerprintLength int64      `json:"fingerprint_length"` // up to 1024 bytes
+	Time              *time.Time `json:"time,omitempty"`     // timestamp of the last line read, if 'input.timestamp' is configured
+}
+
+// Store persists positions, one per log file path.
+type Store interface {
+	// Load returns nil if no position is stored for the path.
+	Load(path string) (*Position, error)
+	Save(path string, position *Position) error
+}
+
+// Fingerprint computes the fingerprint of the first length bytes of the file.
+// It returns false if the file is shorter than length.
+// The file is read from fs, which opens the file with the helper if 'input.helper' is configured.
+func Fingerprint(fs afero.Fs, path string, length int64) (string, bool, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	n, err := io.CopyN(hash, file, length)
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	if n < length {
+		return "", false, nil
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true, nil
+}
+
+// New creates the position for the file read up to offset, with a fingerprint of its current content.
+func New(fs afero.Fs, path string, offset int64) (*Position, error) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	length := info.Size()
+	if length > maxFingerprintLength {
+		length = maxFingerprintLength
+	}
+	fingerprint, _, err := Fingerprint(fs, path, length)
+	if err != nil {
+		return nil, err
+	}
+	return &Position{Offset: offset, Fingerprint: fingerprint, FingerprintLength: length}, nil
+}
+
+// Matches returns true if the file at path is the file the position was stored for, and the file was not truncated.
+func (p *Position) Matches(fs afero.Fs, path string) (bool, error) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < p.Offset {
+		return false, nil
+	}
+	fingerprint, ok, err := Fingerprint(fs, path, p.FingerprintLength)
+	if err != nil {
+		return false, err
+	}
+	return ok && fingerprint == p.Fingerprint, nil
+}
@@ -0,0 +1,71 @@
+package position
+
+import (
+	"github.com/spf13/afero"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "grok_exporter_position")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMatches(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "test.log")
+	ioutil.WriteFile(logfile, []byte("line 1\nline 2\n"), 0644)
+	position, err := New(afero.NewOsFs(), logfile, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := position.Matches(afero.NewOsFs(), logfile); !ok {
+		t.Error("Expected the position to match the file it was created for.")
+	}
+	ioutil.WriteFile(logfile, []byte("line 1\nline 2\nline 3\n"), 0644)
+	if ok, _ := position.Matches(afero.NewOsFs(), logfile); !ok {
+		t.Error("Expected the position to match after lines were appended.")
+	}
+	ioutil.WriteFile(logfile, []byte("other\n"), 0644)
+	if ok, _ := position.Matches(afero.NewOsFs(), logfile); ok {
+		t.Error("Expected the position not to match a rotated file.")
+	}
+}
+
+// With 'input.helper', the file is not readable by the exporter, and is opened through the helper's file system.
+func TestMatchesFs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/var/log/secure", []byte("line 1\nline 2\n"), 0600)
+	position, err := New(fs, "/var/log/secure", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := position.Matches(fs, "/var/log/secure"); !ok {
+		t.Errorf("Expected the position to match the file in the file system, but got %v.", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	store := NewFileStore(filepath.Join(dir, "positions.json"))
+	position, err := store.Load("/var/log/a.log")
+	if position != nil || err != nil {
+		t.Fatalf("Expected no position, but got %v, %v.", position, err)
+	}
+	store.Save("/var/log/a.log", &Position{Offset: 10, Fingerprint: "a", FingerprintLength: 10})
+	store.Save("/var/log/b.log", &Position{Offset: 20, Fingerprint: "b", FingerprintLength: 20})
+	store = NewFileStore(filepath.Join(dir, "positions.json"))
+	for path, expected := range map[string]int64{"/var/log/a.log": 10, "/var/log/b.log": 20} {
+		position, err = store.Load(path)
+		if err != nil || position == nil || position.Offset != expected {
+			t.Errorf("%v: Expected offset %v, but got %v, %v.", path, expected, position, err)
+		}
+	}
+}
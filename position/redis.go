@@ -0,0 +1,129 @@
+package position
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redisTimeout = 5 * time.Second
+
+// redisStore keeps each position in a Redis key, which is the key prefix followed by the log file path.
+// A new connection is opened for each request. Positions are saved every few seconds, so pooling is not worth it.
+type redisStore struct {
+	address   string
+	password  string
+	database  int
+	keyPrefix string
+}
+
+func NewRedisStore(address string, password string, database int, keyPrefix string) Store {
+	return &redisStore{
+		address:   address,
+		password:  password,
+		database:  database,
+		keyPrefix: keyPrefix,
+	}
+}
+
+func (s *redisStore) Load(path string) (*Position, error) {
+	value, exists, err := s.do("GET", s.keyPrefix+path)
+	if err != nil || !exists {
+		return nil, err
+	}
+	var position Position
+	err = json.Unmarshal([]byte(value), &position)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse position of %v from Redis: %v", path, err.Error())
+	}
+	return &position, nil
+}
+
+func (s *redisStore) Save(path string, position *Position) error {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.do("SET", s.keyPrefix+path, string(data))
+	return err
+}
+
+// do runs the command on a new connection, after 'AUTH' and 'SELECT' if configured.
+// It returns false if the reply is nil, like for 'GET' on a key that does not exist.
+func (s *redisStore) do(args ...string) (string, bool, error) {
+	conn, err := net.DialTimeout("tcp", s.address, redisTimeout)
+	if err != nil {
+		return "", false, fmt.Errorf("Failed to connect to Redis: %v", err.Error())
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+	reader := bufio.NewReader(conn)
+	commands := make([][]string, 0, 3)
+	if s.password != "" {
+		commands = append(commands, []string{"AUTH", s.password})
+	}
+	if s.database != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(s.database)})
+	}
+	commands = append(commands, args)
+	var reply string
+	var exists bool
+	for _, command := range commands {
+		_, err = io.WriteString(conn, encodeRedisCommand(command))
+		if err == nil {
+			reply, exists, err = readRedisReply(reader)
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("Redis %v failed: %v", command[0], err.Error())
+		}
+	}
+	return reply, exists, nil
+}
+
+// encodeRedisCommand encodes the command as array of bulk strings, see https://redis.io/topics/protocol
+func encodeRedisCommand(args []string) string {
+	result := fmt.Sprintf("*%v\r\n", len(args))
+	for _, arg := range args {
+		result += fmt.Sprintf("$%v\r\n%v\r\n", len(arg), arg)
+	}
+	return result
+}
+
+// readRedisReply reads a simple string, error, integer, or bulk string reply.
+func readRedisReply(reader *bufio.Reader) (string, bool, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", false, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", false, fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], true, nil
+	case '-':
+		return "", false, fmt.Errorf("%v", line[1:])
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", false, fmt.Errorf("invalid reply %q", line)
+		}
+		if length < 0 {
+			return "", false, nil
+		}
+		data := make([]byte, length+2) // including the trailing \r\n
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return "", false, err
+		}
+		return string(data[:length]), true, nil
+	default:
+		return "", false, fmt.Errorf("unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,96 @@
+package position
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeRedis is a minimal Redis server supporting AUTH, SELECT, GET, and SET.
+func fakeRedis(t *testing.T, password string) (string, map[string]string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			for {
+				args, err := readCommand(reader)
+				if err != nil {
+					break
+				}
+				switch strings.ToUpper(args[0]) {
+				case "AUTH":
+					if args[1] == password {
+						conn.Write([]byte("+OK\r\n"))
+					} else {
+						conn.Write([]byte("-ERR invalid password\r\n"))
+					}
+				case "SELECT":
+					conn.Write([]byte("+OK\r\n"))
+				case "SET":
+					data[args[1]] = args[2]
+					conn.Write([]byte("+OK\r\n"))
+				case "GET":
+					if value, exists := data[args[1]]; exists {
+						conn.Write([]byte("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"))
+					} else {
+						conn.Write([]byte("$-1\r\n"))
+					}
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), data
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		reader.ReadString('\n') // $<length>
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	address, data := fakeRedis(t, "secret")
+	store := NewRedisStore(address, "secret", 1, "grok_exporter:")
+	position, err := store.Load("/var/log/a.log")
+	if position != nil || err != nil {
+		t.Fatalf("Expected no position, but got %v, %v.", position, err)
+	}
+	err = store.Save("/var/log/a.log", &Position{Offset: 42, Fingerprint: "abc", FingerprintLength: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := data["grok_exporter:/var/log/a.log"]; !exists {
+		t.Errorf("Expected the position to be stored with the key prefix, but got %v.", data)
+	}
+	position, err = store.Load("/var/log/a.log")
+	if err != nil || position == nil || position.Offset != 42 {
+		t.Errorf("Expected offset 42, but got %v, %v.", position, err)
+	}
+	_, err = NewRedisStore(address, "wrong", 0, "").Load("/var/log/a.log")
+	if err == nil {
+		t.Error("Expected an error for a wrong password.")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/position"
+	"github.com/spf13/afero"
+	"os"
+	"time"
+)
+
+// stopTimeout is how long the shutdown hook waits for the processing goroutine to store the position.
+const stopTimeout = 5 * time.Second
+
+// positionTracker counts the bytes read from the log file, and stores the position every 'input.positions.interval',
+// and when the exporter shuts down.
+// The tailer cannot seek to an offset, so in order to resume, the file is read from the start and the lines up to the stored offset are skipped.
+// All methods are nil-safe, a nil positionTracker means 'input.positions' is not configured.
+type positionTracker struct {
+	store     position.Store
+	fs        afero.Fs // opens the file with the helper if 'input.helper' is configured
+	path      string
+	offset    int64              // bytes read, including skipped lines
+	skipUntil int64              // lines before this offset were processed before the restart
+	saved     *position.Position // the last stored position, which identifies the file by its fingerprint
+	ticker    *time.Ticker
+	parser    *timestampParser // nil unless the stored position should include the timestamp of the last line, for 'input.backfill'
+	last      time.Time        // timestamp of the last line read, or of the stored position
+
+	stops chan chan struct{} // receives a request from the shutdown hook, which waits until the channel is closed
+}
+
+func newPositionStore(cfg *config.PositionsConfig) (position.Store, error) {
+	switch cfg.Type {
+	case "redis":
+		password, err := cfg.ReadPassword()
+		if err != nil {
+			return nil, err
+		}
+		return position.NewRedisStore(cfg.Address, password, cfg.Database, cfg.KeyPrefix), nil
+	case "configmap":
+		return position.NewConfigMapStore(cfg.Namespace, cfg.Name)
+	default:
+		return position.NewFileStore(cfg.Path), nil
+	}
+}
+
+// newPositionTracker loads the stored position. It returns true if the file must be read from the start,
+// which is the case if the position can be resumed, or if 'readall' is set.
+// parser is nil unless the timestamps of the lines should be stored.
+func newPositionTracker(cfg *config.PositionsConfig, fs afero.Fs, path string, readall bool, parser *timestampParser) (*positionTracker, bool, error) {
+	store, err := newPositionStore(cfg)
+	if err != nil {
+		return nil, false, err
+	}
+	t := &positionTracker{store: store, fs: fs, path: path, parser: parser, stops: make(chan chan struct{})}
+	stored, err := store.Load(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to load the position of %v: %v", path, err.Error())
+	}
+	if stored != nil && stored.Time != nil && parser != nil {
+		t.last = *stored.Time // even if the file was rotated, because the rotated file is backfilled
+	}
+	if stored != nil {
+		if ok, _ := stored.Matches(fs, path); ok {
+			t.skipUntil, t.saved = stored.Offset, stored
+			readall = true
+		}
+	}
+	if !readall {
+		if info, err := fs.Stat(path); err == nil {
+			t.offset = info.Size() // the tailer starts at the end of the file
+		}
+	}
+	t.ticker = time.NewTicker(cfg.Interval)
+	return t, readall, nil
+}
+
+// skip returns true if the line was already processed before the restart. It must be called for each line read from the file.
+func (t *positionTracker) skip(line string) bool {
+	if t == nil {
+		return false
+	}
+	skipped := t.offset < t.skipUntil
+	t.offset += int64(lineBytes(line))
+	if !skipped && t.parser != nil {
+		if timestamp, ok := t.parser.parse(line); ok {
+			t.last = timestamp
+		}
+	}
+	return skipped
+}
+
+// lastTimestamp returns the timestamp of the last line that was read before the restart, or zero if it is unknown.
+// This is only known if the position was stored with 'input.backfill' and 'input.timestamp'.
+func (t *positionTracker) lastTimestamp() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.last
+}
+
+func (t *positionTracker) saveChannel() <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.ticker.C
+}
+
+func (t *positionTracker) stopChannel() <-chan chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.stops
+}
+
+// stop is a shutdown hook. The tracker belongs to the goroutine processing the lines, so stop asks that goroutine to store
+// the position, and waits until it is stored. Otherwise the lines read since the last interval would be processed again after a restart.
+func (t *positionTracker) stop() {
+	done := make(chan struct{})
+	select {
+	case t.stops <- done:
+	case <-time.After(stopTimeout):
+		fmt.Fprintf(os.Stderr, "Failed to store the position of %v on shutdown: The processing of the log lines does not respond.\n", t.path)
+		return
+	}
+	<-done
+}
+
+// save stores the current position. If the file was rotated, the tailer follows the new file,
+// but the number of bytes read from the new file is unknown. In that case, the position is set to the end of the new file.
+func (t *positionTracker) save() {
+	if t == nil {
+		return
+	}
+	if t.saved != nil {
+		if ok, err := t.saved.Matches(t.fs, t.path); err == nil && !ok {
+			if info, err := t.fs.Stat(t.path); err == nil {
+				t.offset = info.Size()
+			}
+		}
+	}
+	current, err := position.New(t.fs, t.path, t.offset)
+	if err == nil && !t.last.IsZero() {
+		last := t.last
+		current.Time = &last
+	}
+	if err == nil {
+		err = t.store.Save(t.path, current)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store the position of %v: %v\n", t.path, err.Error())
+		return
+	}
+	t.saved = current
+}
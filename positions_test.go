@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/position"
+	"github.com/spf13/afero"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPositionStoredOnStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "test.log")
+	if err = ioutil.WriteFile(logfile, []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.PositionsConfig{Type: "file", Path: filepath.Join(dir, "positions.json"), Interval: time.Hour}
+	tracker, _, err := newPositionTracker(cfg, afero.NewOsFs(), logfile, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.skip("line 1\n")
+	go func() {
+		done := <-tracker.stopChannel() // like the processing goroutine
+		tracker.save()
+		close(done)
+	}()
+	tracker.stop()
+	stored, err := position.NewFileStore(cfg.Path).Load(logfile)
+	if err != nil || stored == nil || stored.Offset != int64(len("line 1\n")) {
+		t.Fatalf("Expected the position to be stored on stop, but got %+v (error %v).", stored, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+)
+
+type profileFlags struct {
+	cpuProfile *string
+	memProfile *string
+	trace      *string
+}
+
+func addProfileFlags(flags *flag.FlagSet) *profileFlags {
+	return &profileFlags{
+		cpuProfile: flags.String("cpuprofile", "", "Write a CPU profile to this file on exit."),
+		memProfile: flags.String("memprofile", "", "Write a memory profile to this file on exit."),
+		trace:      flags.String("trace", "", "Write an execution trace to this file on exit."),
+	}
+}
+
+// start starts profiling as configured in the flags. The profiles are written when stop() is called.
+// As the exporter usually runs until it is killed, stop() is also a shutdown hook, see onShutdown().
+func (f *profileFlags) start() (stop func(), err error) {
+	var cpuFile, traceFile *os.File
+	if *f.cpuProfile != "" {
+		cpuFile, err = os.Create(*f.cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create CPU profile: %v", err.Error())
+		}
+		err = pprof.StartCPUProfile(cpuFile)
+		if err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("Failed to start CPU profile: %v", err.Error())
+		}
+	}
+	if *f.trace != "" {
+		traceFile, err = os.Create(*f.trace)
+		if err == nil {
+			err = trace.Start(traceFile)
+		}
+		if err != nil {
+			if cpuFile != nil {
+				pprof.StopCPUProfile()
+				cpuFile.Close()
+			}
+			return nil, fmt.Errorf("Failed to start execution trace: %v", err.Error())
+		}
+	}
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			if cpuFile != nil {
+				pprof.StopCPUProfile()
+				cpuFile.Close()
+			}
+			if traceFile != nil {
+				trace.Stop()
+				traceFile.Close()
+			}
+			if *f.memProfile != "" {
+				writeMemProfile(*f.memProfile)
+			}
+		})
+	}
+	if cpuFile != nil || traceFile != nil || *f.memProfile != "" {
+		onShutdown(stop)
+	}
+	return stop, nil
+}
+
+func writeMemProfile(path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create memory profile: %v\n", err.Error())
+		return
+	}
+	defer file.Close()
+	runtime.GC() // get up-to-date statistics
+	err = pprof.WriteHeapProfile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write memory profile: %v\n", err.Error())
+	}
+}
+
+// allocs are the heap allocations of a stage, see measureAllocs().
+type allocs struct {
+	count uint64 // number of allocations
+	bytes uint64
+}
+
+// measureAllocs returns the heap allocations made while f runs. Allocations of other goroutines are included,
+// so the numbers are only meaningful if nothing else is running, like in the bench command.
+func measureAllocs(f func()) allocs {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return allocs{
+		count: after.Mallocs - before.Mallocs,
+		bytes: after.TotalAlloc - before.TotalAlloc,
+	}
+}
+
+// perLine formats the allocations divided by the number of lines.
+func (a allocs) perLine(lines int) string {
+	if lines == 0 {
+		return "0 allocs/line, 0 B/line"
+	}
+	return fmt.Sprintf("%.1f allocs/line, %.0f B/line", float64(a.count)/float64(lines), float64(a.bytes)/float64(lines))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+)
+
+var allocated []byte
+
+func TestMeasureAllocs(t *testing.T) {
+	a := measureAllocs(func() {
+		allocated = make([]byte, 1<<20)
+	})
+	if a.count < 1 || a.bytes < 1<<20 {
+		t.Errorf("Expected at least one allocation of 1 MiB, but got %v allocations with %v bytes.", a.count, a.bytes)
+	}
+	if a.perLine(0) != "0 allocs/line, 0 B/line" {
+		t.Errorf("Unexpected result for zero lines: %v", a.perLine(0))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// pullReader reads the content appended to the log file since the last scrape, for 'input.mode: pull'.
+type pullReader struct {
+	path   string
+	offset int64
+	info   os.FileInfo // the file at the last read, to detect rotation
+}
+
+// newPullReader starts at the end of the file, or at the beginning if readall is true or the file does not exist yet.
+func newPullReader(path string, readall bool) *pullReader {
+	r := &pullReader{path: path}
+	if info, err := os.Stat(path); err == nil && !readall {
+		r.offset, r.info = info.Size(), info
+	}
+	return r
+}
+
+// read returns the complete lines since the last read, without line terminators.
+// An incomplete last line is returned by the next read, when it is complete.
+// If the file was rotated or truncated, it is read from the start. Lines appended to the old file after the last read are lost.
+func (r *pullReader) read() ([]string, error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if r.info == nil || !os.SameFile(info, r.info) || info.Size() < r.offset {
+		r.offset = 0
+	}
+	r.info = info
+	if _, err = file.Seek(r.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil, nil
+	}
+	r.offset += int64(end + 1)
+	result := strings.Split(string(data[:end]), "\n")
+	for i, line := range result {
+		result[i] = strings.TrimSuffix(line, "\r")
+	}
+	return result, nil
+}
+
+// pullHandler processes the new lines before each scrape, so that the response includes them.
+// The lines are processed by the main loop, which closes the channel when it is done.
+func pullHandler(pulls chan<- chan struct{}, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done := make(chan struct{})
+		pulls <- done
+		<-done
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func processLogLinesPull(cfg *config.Config, p *pipeline, serverErrorChannel chan error) error {
+	reader := newPullReader(cfg.Input.Path, cfg.Input.Readall)
+	p.files.set(reader.offset, reader.info)
+	for {
+		select {
+		case err := <-serverErrorChannel:
+			return fmt.Errorf("Server error: %v", err.Error())
+		case done := <-p.pulls:
+			lines, err := reader.read()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read %v: %v\n", cfg.Input.Path, err.Error())
+			}
+			p.files.set(reader.offset, reader.info)
+			for _, line := range lines {
+				p.process(line, time.Now())
+			}
+			close(done)
+		case <-p.multiline.timeoutChannel():
+			p.flush()
+		case <-p.reloads:
+			p.reloadPatterns()
+		case req := <-p.configReloads:
+			p.reloadConfig(req)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPullReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+	ioutil.WriteFile(path, []byte("old line\n"), 0644)
+	r := newPullReader(path, false)
+	appendFile(t, path, "line 1\r\nline 2\nincomplete")
+	expectLines(t, r, []string{"line 1", "line 2"})
+	expectLines(t, r, nil)
+	appendFile(t, path, " line 3\n")
+	expectLines(t, r, []string{"incomplete line 3"})
+	ioutil.WriteFile(path, []byte("truncated\n"), 0644)
+	expectLines(t, r, []string{"truncated"})
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString(data)
+}
+
+func expectLines(t *testing.T, r *pullReader, expected []string) {
+	lines, err := r.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %q, but got %q.", expected, lines)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// dumpOnQuit prints the pipeline's state to stderr when SIGQUIT is received. Then the signal is raised again
+// with Go's default handling, which prints the stack traces of all goroutines and terminates the process.
+func dumpOnQuit(p *pipeline) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGQUIT)
+	go func() {
+		<-signals
+		p.dump.write(os.Stderr, p, time.Now())
+		signal.Reset(syscall.SIGQUIT)
+		syscall.Kill(os.Getpid(), syscall.SIGQUIT)
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// Windows has no SIGQUIT, so the state cannot be dumped.
+func dumpOnQuit(p *pipeline) {}
@@ -0,0 +1,17 @@
+//go:build !pure
+// +build !pure
+
+package regex
+
+import "github.com/moovweb/rubex"
+
+// DefaultEngine is Oniguruma, unless built with '-tags pure'.
+const DefaultEngine = Oniguruma
+
+func compileOniguruma(expression string) (Regexp, error) {
+	result, err := rubex.CompileWithOption(expression, rubex.ONIG_OPTION_DEFAULT)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
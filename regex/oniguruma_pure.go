@@ -0,0 +1,13 @@
+//go:build pure
+// +build pure
+
+package regex
+
+import "fmt"
+
+// DefaultEngine is re2, because Oniguruma is not included when built with '-tags pure'.
+const DefaultEngine = Re2
+
+func compileOniguruma(expression string) (Regexp, error) {
+	return nil, fmt.Errorf("The regex engine '%v' is not available, because grok_exporter was built without cgo. Use '%v'.", Oniguruma, Re2)
+}
@@ -0,0 +1,74 @@
+package regex
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// re2 uses Go's regexp package. Oniguruma features like look-around, atomic groups, possessive quantifiers,
+// and back references are not supported, expressions using them fail to compile.
+type re2 struct {
+	*regexp.Regexp
+	expression string // the original expression, with Oniguruma's named group syntax
+	named      bool
+}
+
+// Oniguruma's named groups (?<name>...) are converted to (?P<name>...), which all Go versions support.
+// Look-behind (?<=...) and (?<!...) is not converted, and is rejected by Go's regexp.
+var namedGroupRegexp = regexp.MustCompile(`(^|[^\\])\(\?<([A-Za-z_])`)
+
+func compileRe2(expression string) (Regexp, error) {
+	converted := expression
+	for { // repeated, because matches like '((?<a>...)(?<b>' overlap
+		next := namedGroupRegexp.ReplaceAllString(converted, "$1(?P<$2")
+		if next == converted {
+			break
+		}
+		converted = next
+	}
+	compiled, err := regexp.Compile(converted)
+	if err != nil {
+		return nil, fmt.Errorf("%v (the expression is not supported by the regex engine '%v')", err.Error(), Re2)
+	}
+	named := false
+	for _, name := range compiled.SubexpNames() {
+		if name != "" {
+			named = true
+		}
+	}
+	return &re2{Regexp: compiled, expression: expression, named: named}, nil
+}
+
+func (r *re2) GsubFunc(src string, replFunc func(string, map[string]string) string) string {
+	names := r.SubexpNames()
+	var result strings.Builder
+	end := 0
+	for _, match := range r.FindAllStringSubmatchIndex(src, -1) {
+		captures := make(map[string]string, len(names))
+		for i := range names {
+			var value string
+			if match[2*i] >= 0 {
+				value = src[match[2*i]:match[2*i+1]]
+			}
+			switch {
+			case !r.named:
+				captures[strconv.Itoa(i)] = value
+			case names[i] != "":
+				captures[names[i]] = value
+			}
+		}
+		result.WriteString(src[end:match[0]])
+		result.WriteString(replFunc(src[match[0]:match[1]], captures))
+		end = match[1]
+	}
+	result.WriteString(src[end:])
+	return result.String()
+}
+
+func (r *re2) String() string {
+	return r.expression
+}
+
+func (r *re2) Free() {}
@@ -0,0 +1,53 @@
+package regex
+
+import (
+	"testing"
+)
+
+func TestRe2NamedGroups(t *testing.T) {
+	r, err := Compile(`user=(?<user>[a-z]+)( id=(?<id>[0-9]+))?`, Re2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String() != `user=(?<user>[a-z]+)( id=(?<id>[0-9]+))?` {
+		t.Errorf("Expected the original expression, but got %v.", r.String())
+	}
+	var all []map[string]string
+	result := r.GsubFunc("a user=alice id=7 b user=bob", func(match string, captures map[string]string) string {
+		all = append(all, captures)
+		return "<" + match + ">"
+	})
+	if result != "a <user=alice id=7> b <user=bob>" {
+		t.Errorf("Unexpected replacement: %v", result)
+	}
+	if len(all) != 2 || all[0]["user"] != "alice" || all[0]["id"] != "7" || all[1]["user"] != "bob" || all[1]["id"] != "" {
+		t.Errorf("Unexpected captures: %v", all)
+	}
+	if _, exists := all[0]["0"]; exists {
+		t.Errorf("Expected only named captures, but got %v.", all[0])
+	}
+}
+
+func TestRe2NumberedGroups(t *testing.T) {
+	r, err := Compile(`([a-z]+)=([0-9]+)`, Re2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.GsubFunc("x=1", func(_ string, captures map[string]string) string {
+		if captures["0"] != "x=1" || captures["1"] != "x" || captures["2"] != "1" {
+			t.Errorf("Unexpected captures: %v", captures)
+		}
+		return ""
+	})
+}
+
+func TestRe2Unsupported(t *testing.T) {
+	for _, expression := range []string{`(?<=a)b`, `a(?=b)`, `(?>a)`, `(a)\1`} {
+		if _, err := Compile(expression, Re2); err == nil {
+			t.Errorf("Expected an error for %v, which RE2 does not support.", expression)
+		}
+	}
+	if _, err := Compile(`a`, "pcre"); err == nil {
+		t.Errorf("Expected an error for an unknown engine.")
+	}
+}
@@ -0,0 +1,45 @@
+// Package regex compiles the expanded grok expressions with one of two engines, see 'grok.regex_engine':
+// Oniguruma, which supports the full syntax of the logstash patterns but requires cgo,
+// or Go's regexp package, which supports the RE2 subset and allows building statically linked binaries without cgo.
+//
+// Build with '-tags pure' to leave out Oniguruma. The default engine is then re2.
+package regex
+
+import "fmt"
+
+const (
+	Oniguruma = "oniguruma"
+	Re2       = "re2"
+)
+
+// Regexp is a compiled expression. All implementations are safe for concurrent use.
+type Regexp interface {
+	MatchString(s string) bool
+	FindStringIndex(s string) []int
+	// GsubFunc calls replFunc for each match with the named captures, or with the numbered captures "0", "1", ...
+	// if the expression has no named groups. Groups that did not participate in the match are empty.
+	GsubFunc(src string, replFunc func(match string, captures map[string]string) string) string
+	String() string
+	Free() // releases C memory with Oniguruma, the Regexp must not be used afterwards
+}
+
+// Compile compiles the expression with the engine, which is Oniguruma or Re2.
+func Compile(expression string, engine string) (Regexp, error) {
+	switch engine {
+	case Oniguruma:
+		return compileOniguruma(expression)
+	case Re2:
+		return compileRe2(expression)
+	default:
+		return nil, fmt.Errorf("Unknown regex engine '%v'. Expecting '%v' or '%v'.", engine, Oniguruma, Re2)
+	}
+}
+
+// MustCompile compiles the expression with the DefaultEngine, and panics if it is invalid.
+func MustCompile(expression string) Regexp {
+	result, err := Compile(expression, DefaultEngine)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
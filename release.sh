@@ -41,6 +41,9 @@ function make_release {
     mkdir -p dist/grok_exporter-$VERSION.$ARCH
     if [ $MACHINE = "docker" ] ; then
         docker run -v $GOPATH/src/github.com/fstab/grok_exporter:/root/go/src/github.com/fstab/grok_exporter --net none --rm -ti fstab/grok_exporter-compiler compile-$ARCH.sh -o dist/grok_exporter-$VERSION.$ARCH/grok_exporter$EXTENSION
+    elif [ $MACHINE = "pure" ] ; then
+        # Statically linked without Oniguruma, the regex engine is re2.
+        CGO_ENABLED=0 GOOS=${ARCH%%-*} GOARCH=${ARCH#*-} go build -tags pure -o dist/grok_exporter-$VERSION.$ARCH/grok_exporter$EXTENSION .
     else
         # export CGO_LDFLAGS=/usr/local/lib/libonig.a
         # TODO: For some reason CGO_LDFLAGS does not work on darwin. As a workaround, we set LDFLAGS directly in the header of regex.go.
@@ -61,3 +64,4 @@ function make_release {
 make_release native darwin-amd64
 make_release docker linux-amd64
 make_release docker windows-amd64 .exe
+make_release pure linux-arm64
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/regex"
+	"gopkg.in/fsnotify.v1"
+	"os"
+	"time"
+)
+
+// Changes are collected for this time before the patterns are reloaded,
+// because editors often write a file in several steps (write to a temp file, rename, chmod).
+const reloadDelay = 500 * time.Millisecond
+
+// patternReloader recompiles the match expressions when the files in 'grok.patterns_dir' change.
+// The metrics keep their values, so iterating on patterns doesn't require a restart.
+type patternReloader struct {
+	cfg      *config.Config
+	metrics  []metrics.Metric // in the same order as cfg.Metrics
+	expanded []string         // the expanded match, repeat, and context expressions of each metric, to find the metrics affected by a change
+}
+
+func newPatternReloader(cfg *config.Config, patterns *Patterns, metricList []metrics.Metric) (*patternReloader, error) {
+	expanded, err := expandAll(cfg, patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &patternReloader{
+		cfg:      cfg,
+		metrics:  metricList,
+		expanded: expanded,
+	}, nil
+}
+
+// watchDir returns a channel receiving a value when files in dir were modified.
+// Watching ends when stop is closed. A nil stop means the directory is watched until the exporter terminates.
+func watchDir(dir string, stop <-chan struct{}) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to watch %v: %v", dir, err.Error())
+	}
+	err = watcher.Add(dir)
+	if err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("Failed to watch %v: %v", dir, err.Error())
+	}
+	result := make(chan struct{}, 1)
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-watcher.Events:
+				if timer == nil {
+					timer = time.After(reloadDelay)
+				}
+			case err := <-watcher.Errors:
+				fmt.Fprintf(os.Stderr, "WARNING: Error watching %v: %v\n", dir, err.Error())
+			case <-timer:
+				timer = nil
+				select {
+				case result <- struct{}{}:
+				default: // a reload is already pending
+				}
+			case <-stop:
+				watcher.Close()
+				return
+			}
+		}
+	}()
+	return result, nil
+}
+
+// reload re-reads the patterns and recompiles the match expressions that changed. It returns the new patterns,
+// and the router with the recompiled 'routing.match', which is nil if 'routing' is not configured.
+// If any expression fails, nothing is changed, and the old expressions remain active.
+// reload must be called from the goroutine processing the log lines, because Matches() is not synchronized.
+func (r *patternReloader) reload() (*Patterns, *router, error) {
+	patterns, err := initPatterns(r.cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = validateMetrics(r.cfg, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = validateRouting(r.cfg, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	expanded, err := expandAll(r.cfg, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	router, err := newRouter(r.cfg, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	type update struct {
+		metric                 metrics.Metric
+		regex, repeat, context regex.Regexp
+	}
+	updates := make([]update, 0)
+	for i, m := range *r.cfg.Metrics {
+		if m.Type == "derived" || expanded[i] == r.expanded[i] {
+			continue
+		}
+		u := update{metric: r.metrics[i]}
+		u.regex, err = Compile(m.Match, patterns)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Repeat != "" {
+			u.repeat, err = Compile(m.Repeat, patterns)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if m.Context != nil {
+			u.context, err = Compile(m.Context.Match, patterns)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		updates = append(updates, u)
+	}
+	for _, u := range updates {
+		u.metric.SetMatch(u.regex, u.repeat, u.context)
+		fmt.Fprintf(os.Stderr, "Reloaded the match expression of metric %v.\n", u.metric.Name())
+	}
+	r.expanded = expanded
+	return patterns, router, nil
+}
+
+func expandAll(cfg *config.Config, patterns *Patterns) ([]string, error) {
+	result := make([]string, 0, len(*cfg.Metrics))
+	for _, m := range *cfg.Metrics {
+		if m.Type == "derived" {
+			result = append(result, "")
+			continue
+		}
+		match, err := expand(m.Match, patterns)
+		if err != nil {
+			return nil, err
+		}
+		repeat, err := expand(m.Repeat, patterns)
+		if err != nil {
+			return nil, err
+		}
+		context := ""
+		if m.Context != nil {
+			context, err = expand(m.Context.Match, patterns)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, match+"\x00"+repeat+"\x00"+context)
+	}
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatternReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writePatterns := func(content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, "patterns"), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePatterns("LEVEL ERROR\n")
+	cfg, err := config.LoadConfigString([]byte(`
+grok:
+    patterns_dir: ` + dir + `
+    watch_patterns_dir: true
+metrics:
+    - type: counter
+      name: errors_total
+      help: Errors.
+      match: '%{LEVEL:level}'
+      labels:
+          - grok_field_name: level
+            prometheus_label: level
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := initPatterns(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricList, err := createMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := newPatternReloader(cfg, patterns, metricList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePatterns("LEVEL ERROR|FATAL\n")
+	p := &pipeline{reloader: reloader, configReloader: &configReloader{patterns: patterns}}
+	p.reloadPatterns()
+	if !metricList[0].Matches("FATAL") {
+		t.Error("Expected the reloaded match expression to match FATAL.")
+	}
+	if expanded, _ := expand("%{LEVEL}", p.configReloader.patterns); expanded != "(?:ERROR|FATAL)" {
+		t.Errorf("Expected the config reloader to get the reloaded patterns, but LEVEL is %v.", expanded)
+	}
+	writePatterns("SEVERITY ERROR\n") // LEVEL is no longer defined
+	if _, _, err = reloader.reload(); err == nil {
+		t.Error("Expected error for undefined pattern.")
+	}
+	if !metricList[0].Matches("FATAL") {
+		t.Error("Expected the previous match expression to remain active after a failed reload.")
+	}
+}
+
+func TestPatternReloadContextAndRouting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writePatterns := func(content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, "patterns"), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePatterns("LEVEL ERROR\nAPP nginx\n")
+	cfg, err := config.LoadConfigString([]byte(`
+input:
+    type: stdin
+grok:
+    patterns_dir: ` + dir + `
+    watch_patterns_dir: true
+routing:
+    match: 'app=%{APP:app}'
+    field: app
+metrics:
+    - type: counter
+      name: retries_total
+      help: Retries after an error.
+      match: 'retry'
+      context:
+          match: '%{LEVEL}'
+      labels: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := initPatterns(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricList, err := createMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := newPatternReloader(cfg, patterns, metricList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pipeline{reloader: reloader}
+	p.router, err = newRouter(cfg, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePatterns("LEVEL ERROR|FATAL\nAPP nginx|redis\n")
+	p.reloadPatterns()
+	if metricList[0].Matches("FATAL"); !metricList[0].Matches("retry") {
+		t.Error("Expected the reloaded context expression to match FATAL.")
+	}
+	if route := p.router.route("app=redis GET key", nil); route != "redis" {
+		t.Errorf("Expected the reloaded 'routing.match' to capture redis, but got %q.", route)
+	}
+}
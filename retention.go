@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+var (
+	seriesExpiredTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grok_exporter_series_expired_total",
+		Help: "Number of series removed because they were not updated within 'retention'.",
+	}, []string{"metric"})
+	lastSweepExpired = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "grok_exporter_retention_last_sweep_expired_series",
+		Help: "Number of series removed in the last retention sweep.",
+	})
+)
+
+const defaultRetentionCheckInterval = time.Minute
+
+var retentionSweep sync.Once
+
+// startRetentionSweep removes expired series every 'global.retention_check_interval'.
+// The sweep runs in the background, independent of scrapes, so that scraping a large label space stays fast.
+// Nothing is started if no metric has a 'retention'. It is called again when the config is reloaded, but the sweep is started only once.
+// expiring returns the current metrics with a 'retention'.
+func startRetentionSweep(cfg *config.Config, expiring func() []metrics.Metric) {
+	if len(expiring()) == 0 {
+		return
+	}
+	retentionSweep.Do(func() {
+		interval := cfg.Global.RetentionCheckInterval
+		if interval == 0 {
+			interval = defaultRetentionCheckInterval
+		}
+		prometheus.MustRegister(seriesExpiredTotal)
+		prometheus.MustRegister(lastSweepExpired)
+		go func() {
+			for now := range time.Tick(interval) {
+				sweep(expiring(), now)
+			}
+		}()
+	})
+}
+
+func sweep(metricList []metrics.Metric, now time.Time) {
+	total := 0
+	for _, m := range metricList {
+		expired := m.Expire(now)
+		seriesExpiredTotal.WithLabelValues(m.Name()).Add(float64(expired))
+		total += expired
+	}
+	lastSweepExpired.Set(float64(total))
+}
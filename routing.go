@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/regex"
+)
+
+// router implements 'routing': Each line is processed only by the metrics whose 'route' is the value of the routing field,
+// and by the metrics without 'route'. A nil router lets all metrics process all lines.
+type router struct {
+	regex  regex.Regexp // nil if the field is a field of the input
+	field  string
+	routes []string // the 'route' of each metric, in the same order as cfg.Metrics
+}
+
+// newRouter returns nil if 'routing' is not configured.
+func newRouter(cfg *config.Config, patterns *Patterns) (*router, error) {
+	if cfg.Routing == nil {
+		return nil, nil
+	}
+	routes := make([]string, 0, len(*cfg.Metrics))
+	for _, m := range *cfg.Metrics {
+		routes = append(routes, m.Route)
+	}
+	result := &router{field: cfg.Routing.Field, routes: routes}
+	if cfg.Routing.Match != "" {
+		var err error
+		result.regex, err = Compile(cfg.Routing.Match, patterns)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'routing.match': %v", err.Error())
+		}
+	}
+	return result, nil
+}
+
+func validateRouting(cfg *config.Config, patterns *Patterns) error {
+	if cfg.Routing == nil || cfg.Routing.Match == "" {
+		return nil
+	}
+	regex, err := expand(cfg.Routing.Match, patterns)
+	if err != nil {
+		return fmt.Errorf("Invalid 'routing.match': %v", err.Error())
+	}
+	if !namedGroups(regex)[cfg.Routing.Field] {
+		return fmt.Errorf("Invalid 'routing.match': There is no capture named %v, which is the 'routing.field'.", cfg.Routing.Field)
+	}
+	return nil
+}
+
+// route returns the value of the routing field. It is empty if the line does not match 'routing.match'.
+func (r *router) route(line string, fields map[string]string) string {
+	if r == nil {
+		return ""
+	}
+	if r.regex == nil {
+		return fields[r.field]
+	}
+	value, _ := metrics.ExtractField(r.regex, line, r.field)
+	return value
+}
+
+// accepts is true if the i-th metric processes the lines with the route.
+func (r *router) accepts(i int, route string) bool {
+	return r == nil || r.routes[i] == "" || r.routes[i] == route
+}
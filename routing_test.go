@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	cfg, err := config.LoadConfigString([]byte(`
+input:
+    type: stdin
+grok:
+    patterns: ['WORD \w+']
+routing:
+    match: 'app=%{WORD:app}'
+    field: app
+metrics:
+    - type: counter
+      name: nginx_total
+      help: Lines of nginx.
+      match: '%{WORD}'
+      labels: []
+      route: nginx
+    - type: counter
+      name: postgres_total
+      help: Lines of postgres.
+      match: '%{WORD}'
+      labels: []
+      route: postgres
+    - type: counter
+      name: lines_total
+      help: All lines.
+      match: '%{WORD}'
+      labels: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\w+")
+	if err = validateRouting(cfg, patterns); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRouter(cfg, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line, expected := range map[string][]bool{
+		"app=nginx GET /":      {true, false, true},
+		"app=postgres SELECT":  {false, true, true},
+		"app=redis GET key":    {false, false, true},
+		"no application field": {false, false, true},
+	} {
+		route := r.route(line, nil)
+		for i, accepted := range expected {
+			if r.accepts(i, route) != accepted {
+				t.Errorf("%q: Expected metric %v to accept the line to be %v.", line, (*cfg.Metrics)[i].Name, accepted)
+			}
+		}
+	}
+	var none *router
+	if !none.accepts(0, none.route("app=nginx", nil)) {
+		t.Errorf("Expected all lines to be accepted without 'routing'.")
+	}
+	cfg.Routing.Field = "application"
+	if err = validateRouting(cfg, patterns); err == nil {
+		t.Errorf("Expected an error, because 'routing.match' has no capture named application.")
+	}
+}
+
+func TestRouterInputField(t *testing.T) {
+	r := &router{field: "logfile", routes: []string{"/var/log/nginx.log", ""}}
+	route := r.route("GET /", map[string]string{"logfile": "/var/log/postgres.log"})
+	if r.accepts(0, route) || !r.accepts(1, route) {
+		t.Errorf("Expected the line to be routed by the logfile.")
+	}
+}
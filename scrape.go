@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"time"
+)
+
+// Metrics about the scrapes of the exporter's own endpoints, so that slow scrapes of large registries can be diagnosed.
+// The handler label is the path, like /metrics or a tenant's path.
+var (
+	scrapeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grok_exporter_scrape_duration_seconds",
+		Help:    "Duration of the scrapes of the exporter's metrics endpoints.",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"handler"})
+	scrapeResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grok_exporter_scrape_response_size_bytes",
+		Help:    "Size of the responses of the exporter's metrics endpoints, after compression.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 8), // 1KiB to 16MiB
+	}, []string{"handler"})
+	scrapesInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grok_exporter_scrapes_in_flight",
+		Help: "Number of scrapes of the exporter's metrics endpoints currently being served.",
+	}, []string{"handler"})
+)
+
+func registerScrapeMetrics() {
+	prometheus.MustRegister(scrapeDuration)
+	prometheus.MustRegister(scrapeResponseSize)
+	prometheus.MustRegister(scrapesInFlight)
+}
+
+// instrumentScrapes wraps the handler of a metrics endpoint. As the response is created while the metrics are collected,
+// the duration includes the time for collecting and sending the metrics.
+func instrumentScrapes(path string, handler http.Handler) http.Handler {
+	duration := scrapeDuration.WithLabelValues(path)
+	size := scrapeResponseSize.WithLabelValues(path)
+	inFlight := scrapesInFlight.WithLabelValues(path)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		inFlight.Inc()
+		defer inFlight.Dec()
+		counter := &countingWriter{ResponseWriter: w}
+		handler.ServeHTTP(counter, r)
+		duration.Observe(time.Since(start).Seconds())
+		size.Observe(float64(counter.bytes))
+	})
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	bytes int
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += n
+	return n, err
+}
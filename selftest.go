@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runTestLines processes each metric's 'test_lines' with a fresh instance of the metric, so that the exposed metrics are not affected.
+// The failed expectations are returned as error, or printed as warnings with 'global.on_test_failure: warn'.
+func runTestLines(cfg *config.Config, patterns *Patterns) error {
+	fields := inputFields(cfg.Input, cfg.Input.Path)
+	failures := make([]string, 0)
+	for _, m := range *cfg.Metrics {
+		for i, test := range m.TestLines {
+			failure, err := runTestLine(m, test, patterns, fields)
+			if err != nil {
+				return err
+			}
+			if failure != "" {
+				failures = append(failures, fmt.Sprintf("Metric %v: Test line %v %q: %v", m.Name, i+1, test.Line, failure))
+			}
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	if cfg.Global.OnTestFailure == "warn" {
+		for _, failure := range failures {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", failure)
+		}
+		return nil
+	}
+	return fmt.Errorf("%v", strings.Join(failures, "\n"))
+}
+
+// runTestLine returns a description of the failed expectation, or an empty string if the expectations hold.
+func runTestLine(m *config.MetricConfig, test config.TestLineConfig, patterns *Patterns, fields map[string]string) (string, error) {
+	isolated := *m
+	isolated.Notify = nil // test lines must not trigger webhooks
+	metric, err := createMetric(&isolated, patterns)
+	if err != nil {
+		return "", err
+	}
+	if metric.Matches(test.Line) {
+		metric.Process(test.Line, fields)
+	}
+	series := collectSeries(metric.Collector())
+	if !test.ExpectsMatch() {
+		if len(series) > 0 {
+			return fmt.Sprintf("Expected no match, but the line was recorded as %v.", seriesLabels(series[0], m.Type)), nil
+		}
+		return "", nil
+	}
+	if len(series) == 0 {
+		return "Expected a match, but the line was not recorded.", nil
+	}
+	recorded := make([]string, 0, len(series))
+	for _, s := range series {
+		labels := seriesLabels(s, m.Type)
+		if !hasLabels(labels, test.Labels) {
+			recorded = append(recorded, fmt.Sprintf("%v", labels))
+			continue
+		}
+		if test.Value != nil {
+			if value := seriesValue(s); math.Abs(value-*test.Value) > 1e-9*math.Max(1, math.Abs(*test.Value)) {
+				return fmt.Sprintf("Expected value %v for labels %v, but got %v.", *test.Value, labels, value), nil
+			}
+		}
+		return "", nil
+	}
+	sort.Strings(recorded)
+	return fmt.Sprintf("Expected labels %v, but the line was recorded as %v.", test.Labels, strings.Join(recorded, ", ")), nil
+}
+
+func collectSeries(collector prometheus.Collector) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	result := make([]*dto.Metric, 0)
+	for m := range ch {
+		var d dto.Metric
+		if m.Write(&d) == nil {
+			result = append(result, &d)
+		}
+	}
+	return result
+}
+
+// seriesLabels returns the labels of the series. The 'quantile' label of quantile metrics is not a configured label, so it is skipped.
+func seriesLabels(series *dto.Metric, metricType string) map[string]string {
+	result := make(map[string]string, len(series.Label))
+	for _, label := range series.Label {
+		if metricType == "quantile" && label.GetName() == "quantile" {
+			continue
+		}
+		result[label.GetName()] = label.GetValue()
+	}
+	return result
+}
+
+func hasLabels(labels map[string]string, expected map[string]string) bool {
+	for name, value := range expected {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesValue is the value of a counter or gauge, or the sum of the observed values of a histogram or summary.
+func seriesValue(series *dto.Metric) float64 {
+	switch {
+	case series.Counter != nil:
+		return series.Counter.GetValue()
+	case series.Gauge != nil:
+		return series.Gauge.GetValue()
+	case series.Histogram != nil:
+		return series.Histogram.GetSampleSum()
+	case series.Summary != nil:
+		return series.Summary.GetSampleSum()
+	default:
+		return series.Untyped.GetValue()
+	}
+}
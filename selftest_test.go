@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"strings"
+	"testing"
+)
+
+const testLinesConfig = `
+grok:
+    patterns: ['WORD \b\w+\b', 'NUMBER \d+']
+metrics:
+    - type: counter
+      name: requests_total
+      help: Requests.
+      match: 'user=%{WORD:user} status=%{NUMBER:status}'
+      labels:
+          - grok_field_name: user
+            prometheus_label: user
+      test_lines:
+          - line: 'user=alice status=200'
+            labels: {user: alice}
+            value: 1
+          - line: 'status=200'
+            matches: false
+    - type: histogram
+      name: request_duration_seconds
+      help: Request duration.
+      match: 'took %{NUMBER:ms}'
+      value: ms
+      labels: []
+      test_lines:
+          - line: 'took 3'
+            value: VALUE
+`
+
+func TestRunTestLines(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\b\\w+\\b")
+	patterns.AddPattern("NUMBER \\d+")
+	cfg, err := config.LoadConfigString([]byte(strings.Replace(testLinesConfig, "VALUE", "3", 1)))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err.Error())
+	}
+	if err = runTestLines(cfg, patterns); err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	cfg, _ = config.LoadConfigString([]byte(strings.Replace(testLinesConfig, "VALUE", "4", 1)))
+	err = runTestLines(cfg, patterns)
+	if err == nil || !strings.Contains(err.Error(), "Expected value 4") {
+		t.Fatalf("Expected the histogram's test line to fail, but got %v.", err)
+	}
+	cfg, _ = config.LoadConfigString([]byte(strings.Replace(strings.Replace(testLinesConfig, "VALUE", "3", 1), "{user: alice}", "{user: bob}", 1)))
+	err = runTestLines(cfg, patterns)
+	if err == nil || !strings.Contains(err.Error(), "Expected labels map[user:bob], but the line was recorded as map[user:alice].") {
+		t.Fatalf("Expected the counter's test line to fail, but got %v.", err)
+	}
+	cfg.Global.OnTestFailure = "warn"
+	if err = runTestLines(cfg, patterns); err != nil {
+		t.Fatalf("Expected only a warning with 'on_test_failure: warn', but got %v.", err)
+	}
+	cfg, _ = config.LoadConfigString([]byte(strings.Replace(strings.Replace(testLinesConfig, "VALUE", "3", 1), "line: 'status=200'", "line: 'user=bob status=500'", 1)))
+	err = runTestLines(cfg, patterns)
+	if err == nil || !strings.Contains(err.Error(), "Expected no match") {
+		t.Fatalf("Expected the test line with 'matches: false' to fail, but got %v.", err)
+	}
+}
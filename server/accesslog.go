@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AccessLog wraps handler so that each request is written to out in the Common Log Format, followed by the duration in seconds, like
+//
+//	127.0.0.1 - - [10/Oct/2016:13:55:36 +0200] "GET /metrics HTTP/1.1" 200 2326 0.004
+func AccessLog(out io.Writer, handler http.Handler) http.Handler {
+	var mutex sync.Mutex // so that concurrent requests don't interleave their lines
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user := "-"
+		if username, _, ok := r.BasicAuth(); ok && username != "" {
+			user = username
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		fmt.Fprintf(out, "%v - %v [%v] \"%v %v %v\" %v %v %.3f\n", host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, r.RequestURI, r.Proto, recorder.status, recorder.bytes, time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder records the status code and the size of the response body.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += n
+	return n, err
+}
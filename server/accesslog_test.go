@@ -0,0 +1,24 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	handler := AccessLog(&out, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}))
+	request := httptest.NewRequest("GET", "/metrics?x=1", nil)
+	request.RemoteAddr = "10.0.0.7:54321"
+	request.SetBasicAuth("prometheus", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	expected := regexp.MustCompile(`^10\.0\.0\.7 - prometheus \[[^\]]+\] "GET /metrics\?x=1 HTTP/1\.1" 403 10 \d+\.\d{3}\n$`)
+	if !expected.MatchString(out.String()) {
+		t.Errorf("Unexpected access log line: %q", out.String())
+	}
+}
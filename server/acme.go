@@ -0,0 +1,507 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal ACME client (RFC 8555) for obtaining certificates from CAs like Let's Encrypt.
+// Only the http-01 challenge is supported, so the challenge port (usually 80) must be reachable from the CA.
+// This avoids vendoring golang.org/x/crypto/acme and its dependencies.
+
+const (
+	renewBefore   = 30 * 24 * time.Hour
+	renewInterval = 12 * time.Hour
+	pollInterval  = 2 * time.Second
+	pollAttempts  = 60
+)
+
+type AcmeOptions struct {
+	Domains       []string
+	CacheDir      string
+	DirectoryUrl  string
+	Email         string
+	ChallengePort int
+}
+
+// RunHttpsWithAcme serves handler via https with a certificate obtained from the ACME CA.
+// The certificate is stored in the cache directory and renewed in the background 30 days before it expires.
+func RunHttpsWithAcme(port int, acme AcmeOptions, path string, handler http.Handler, options Options) error {
+	m := &acmeManager{
+		options:    acme,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		challenges: make(map[string]string),
+	}
+	challengeErrors := make(chan error, 1)
+	go func() {
+		challengeErrors <- http.ListenAndServe(fmt.Sprintf(":%v", acme.ChallengePort), http.HandlerFunc(m.serveChallenge))
+	}()
+	if err := m.loadOrObtain(); err != nil {
+		return err
+	}
+	go m.renewLoop()
+	http.Handle(path, handler)
+	server := newServer(port, options)
+	if options.DisableHttp2 {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	server.TLSConfig = &tls.Config{GetCertificate: m.getCertificate}
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- server.ListenAndServeTLS("", "")
+	}()
+	select {
+	case err := <-challengeErrors:
+		return fmt.Errorf("ACME challenge server on port %v failed: %v", acme.ChallengePort, err.Error())
+	case err := <-serverErrors:
+		return err
+	}
+}
+
+type acmeManager struct {
+	options AcmeOptions
+	client  *http.Client
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	challenges  map[string]string // token -> key authorization
+
+	// state of the ACME session
+	accountKey *ecdsa.PrivateKey
+	accountUrl string
+	directory  acmeDirectory
+	nonce      string
+}
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+type acmeChallenge struct {
+	Type  string `json:"type"`
+	Url   string `json:"url"`
+	Token string `json:"token"`
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (m *acmeManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.certificate, nil
+}
+
+func (m *acmeManager) serveChallenge(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/.well-known/acme-challenge/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	m.mutex.Lock()
+	keyAuthorization, exists := m.challenges[strings.TrimPrefix(r.URL.Path, prefix)]
+	m.mutex.Unlock()
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuthorization))
+}
+
+func (m *acmeManager) loadOrObtain() error {
+	certificate, err := loadCachedCertificate(m.options.CacheDir)
+	if err == nil && !needsRenewal(certificate, m.options.Domains, time.Now()) {
+		m.setCertificate(certificate)
+		return nil
+	}
+	return m.obtain()
+}
+
+func (m *acmeManager) renewLoop() {
+	for range time.Tick(renewInterval) {
+		m.mutex.Lock()
+		certificate := m.certificate
+		m.mutex.Unlock()
+		if needsRenewal(certificate, m.options.Domains, time.Now()) {
+			if err := m.obtain(); err != nil {
+				// The old certificate is still valid, we try again in the next interval.
+				fmt.Fprintf(os.Stderr, "Failed to renew the ACME certificate: %v\n", err.Error())
+			}
+		}
+	}
+}
+
+func (m *acmeManager) setCertificate(certificate *tls.Certificate) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.certificate = certificate
+}
+
+// needsRenewal is true if the certificate expires within 30 days, or if it does not cover all domains.
+func needsRenewal(certificate *tls.Certificate, domains []string, now time.Time) bool {
+	if certificate == nil || certificate.Leaf == nil {
+		return true
+	}
+	if now.Add(renewBefore).After(certificate.Leaf.NotAfter) {
+		return true
+	}
+	for _, domain := range domains {
+		if certificate.Leaf.VerifyHostname(domain) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func loadCachedCertificate(cacheDir string) (*tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(filepath.Join(cacheDir, "cert.pem"), filepath.Join(cacheDir, "key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+// obtain runs the ACME protocol: create the account, create the order, solve the http-01 challenges,
+// finalize the order with a CSR, and download the certificate.
+func (m *acmeManager) obtain() error {
+	err := os.MkdirAll(m.options.CacheDir, 0700)
+	if err != nil {
+		return fmt.Errorf("Failed to create 'server.acme.cache_dir': %v", err.Error())
+	}
+	err = m.getJson(m.options.DirectoryUrl, &m.directory)
+	if err != nil {
+		return err
+	}
+	m.accountKey, err = loadOrCreateKey(filepath.Join(m.options.CacheDir, "account.key"))
+	if err != nil {
+		return err
+	}
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if m.options.Email != "" {
+		account["contact"] = []string{"mailto:" + m.options.Email}
+	}
+	m.accountUrl = ""
+	resp, err := m.post(m.directory.NewAccount, account, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	m.accountUrl = resp.Header.Get("Location")
+	identifiers := make([]map[string]string, 0, len(m.options.Domains))
+	for _, domain := range m.options.Domains {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": domain})
+	}
+	var order acmeOrder
+	resp, err = m.post(m.directory.NewOrder, map[string]interface{}{"identifiers": identifiers}, &order)
+	if err != nil {
+		return err
+	}
+	orderUrl := resp.Header.Get("Location")
+	for _, authorizationUrl := range order.Authorizations {
+		err = m.authorize(authorizationUrl)
+		if err != nil {
+			return err
+		}
+	}
+	certificateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.options.Domains[0]},
+		DNSNames: m.options.Domains,
+	}, certificateKey)
+	if err != nil {
+		return err
+	}
+	_, err = m.post(order.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}, &order)
+	if err != nil {
+		return err
+	}
+	for i := 0; order.Status != "valid"; i++ {
+		if order.Status == "invalid" || i >= pollAttempts {
+			return fmt.Errorf("ACME order for %v failed with status '%v'.", m.options.Domains, order.Status)
+		}
+		time.Sleep(pollInterval)
+		_, err = m.post(orderUrl, nil, &order)
+		if err != nil {
+			return err
+		}
+	}
+	resp, err = m.post(order.Certificate, nil, nil)
+	if err != nil {
+		return err
+	}
+	chain, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to download the ACME certificate: %v", err.Error())
+	}
+	return m.store(chain, certificateKey)
+}
+
+func (m *acmeManager) authorize(authorizationUrl string) error {
+	var authorization acmeAuthorization
+	_, err := m.post(authorizationUrl, nil, &authorization)
+	if err != nil {
+		return err
+	}
+	if authorization.Status == "valid" {
+		return nil
+	}
+	var challenge *acmeChallenge
+	for i := range authorization.Challenges {
+		if authorization.Challenges[i].Type == "http-01" {
+			challenge = &authorization.Challenges[i]
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("The ACME CA does not offer the http-01 challenge for %v.", authorizationUrl)
+	}
+	m.mutex.Lock()
+	m.challenges[challenge.Token] = challenge.Token + "." + jwkThumbprint(&m.accountKey.PublicKey)
+	m.mutex.Unlock()
+	defer func() {
+		m.mutex.Lock()
+		delete(m.challenges, challenge.Token)
+		m.mutex.Unlock()
+	}()
+	resp, err := m.post(challenge.Url, struct{}{}, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	for i := 0; authorization.Status != "valid"; i++ {
+		if authorization.Status == "invalid" || i >= pollAttempts {
+			return fmt.Errorf("ACME http-01 challenge failed with status '%v'. Make sure port %v is reachable from the internet.", authorization.Status, m.options.ChallengePort)
+		}
+		time.Sleep(pollInterval)
+		_, err = m.post(authorizationUrl, nil, &authorization)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *acmeManager) store(chain []byte, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	err = ioutil.WriteFile(filepath.Join(m.options.CacheDir, "key.pem"), keyPem, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to store the ACME certificate: %v", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(m.options.CacheDir, "cert.pem"), chain, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to store the ACME certificate: %v", err.Error())
+	}
+	certificate, err := loadCachedCertificate(m.options.CacheDir)
+	if err != nil {
+		return fmt.Errorf("Failed to load the ACME certificate: %v", err.Error())
+	}
+	m.setCertificate(certificate)
+	return nil
+}
+
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("Failed to read %v: No PEM data found.", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write %v: %v", path, err.Error())
+	}
+	return key, nil
+}
+
+func (m *acmeManager) getJson(url string, result interface{}) error {
+	resp, err := m.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("ACME request to %v failed: %v", url, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("ACME request to %v failed: %v", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// post sends a JWS signed request. If payload is nil, the request is a POST-as-GET.
+// If result is not nil, the response body is decoded into result and closed, otherwise the caller must close the body.
+func (m *acmeManager) post(url string, payload interface{}, result interface{}) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := m.postOnce(url, payload)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 == 2 {
+			if result != nil {
+				defer resp.Body.Close()
+				err = json.NewDecoder(resp.Body).Decode(result)
+				if err != nil {
+					return nil, fmt.Errorf("Failed to parse the ACME response from %v: %v", url, err.Error())
+				}
+			}
+			return resp, nil
+		}
+		var problem acmeProblem
+		json.NewDecoder(resp.Body).Decode(&problem)
+		resp.Body.Close()
+		// The CA may reject a nonce at any time, the client should retry with the fresh nonce from the response.
+		if problem.Type == "urn:ietf:params:acme:error:badNonce" && attempt < 3 {
+			continue
+		}
+		return nil, fmt.Errorf("ACME request to %v failed: %v %v", url, resp.Status, problem.Detail)
+	}
+}
+
+func (m *acmeManager) postOnce(url string, payload interface{}) (*http.Response, error) {
+	if m.nonce == "" {
+		resp, err := m.client.Head(m.directory.NewNonce)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get an ACME nonce: %v", err.Error())
+		}
+		resp.Body.Close()
+		m.nonce = resp.Header.Get("Replay-Nonce")
+	}
+	body, err := m.sign(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	m.nonce = ""
+	resp, err := m.client.Post(url, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("ACME request to %v failed: %v", url, err.Error())
+	}
+	m.nonce = resp.Header.Get("Replay-Nonce")
+	return resp, nil
+}
+
+// sign creates a JWS in flattened JSON serialization with the ES256 algorithm.
+// The account URL is used as key ID. Before the account is created, the public key is included as JWK instead.
+func (m *acmeManager) sign(url string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": m.nonce,
+		"url":   url,
+	}
+	if m.accountUrl != "" {
+		protected["kid"] = m.accountUrl
+	} else {
+		protected["jwk"] = jwk(&m.accountKey.PublicKey)
+	}
+	protectedJson, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	payloadJson := []byte{}
+	if payload != nil {
+		payloadJson, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	encodedProtected := base64.RawURLEncoding.EncodeToString(protectedJson)
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payloadJson)
+	signature, err := signES256(m.accountKey, encodedProtected+"."+encodedPayload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{
+		"protected": encodedProtected,
+		"payload":   encodedPayload,
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
+
+// signES256 returns the signature as r || s with 32 bytes each, as required by JWS (RFC 7518).
+func signES256(key *ecdsa.PrivateKey, data string) ([]byte, error) {
+	hash := sha256.Sum256([]byte(data))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 64)
+	copy(result[32-len(r.Bytes()):32], r.Bytes())
+	copy(result[64-len(s.Bytes()):], s.Bytes())
+	return result, nil
+}
+
+func jwk(key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(padTo32(key.X)),
+		"y":   base64.RawURLEncoding.EncodeToString(padTo32(key.Y)),
+	}
+}
+
+// jwkThumbprint as defined in RFC 7638. The members must be in lexicographic order without whitespace.
+func jwkThumbprint(key *ecdsa.PublicKey) string {
+	k := jwk(key)
+	canonical := fmt.Sprintf(`{"crv":"%v","kty":"%v","x":"%v","y":"%v"}`, k["crv"], k["kty"], k["x"], k["y"])
+	hash := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func padTo32(n *big.Int) []byte {
+	result := make([]byte, 32)
+	b := n.Bytes()
+	copy(result[32-len(b):], b)
+	return result
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSignES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signES256(key, "header.payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signature) != 64 {
+		t.Fatalf("Expected 64 bytes signature, but got %v.", len(signature))
+	}
+	hash := sha256.Sum256([]byte("header.payload"))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&key.PublicKey, hash[:], r, s) {
+		t.Errorf("Invalid signature.")
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Now()
+	certificate := &tls.Certificate{Leaf: &x509.Certificate{
+		DNSNames: []string{"a.example.com", "b.example.com"},
+		NotAfter: now.Add(60 * 24 * time.Hour),
+	}}
+	if needsRenewal(certificate, []string{"a.example.com", "b.example.com"}, now) {
+		t.Errorf("Expected a certificate valid for 60 days not to be renewed.")
+	}
+	if !needsRenewal(certificate, []string{"a.example.com", "c.example.com"}, now) {
+		t.Errorf("Expected renewal if a domain is not covered.")
+	}
+	if !needsRenewal(certificate, []string{"a.example.com"}, now.Add(40*24*time.Hour)) {
+		t.Errorf("Expected renewal 30 days before expiry.")
+	}
+	if !needsRenewal(nil, []string{"a.example.com"}, now) {
+		t.Errorf("Expected renewal if there is no certificate.")
+	}
+}
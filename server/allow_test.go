@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsAllowed(t *testing.T) {
+	networks := make([]*net.IPNet, 0)
+	for _, cidr := range []string{"10.0.0.0/8", "::1/128"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	for remoteAddr, expected := range map[string]bool{
+		"10.1.2.3:54321": true,
+		"[::1]:54321":    true,
+		"192.168.1.1:80": false,
+		"11.0.0.1:80":    false,
+		"not an address": false,
+	} {
+		if isAllowed(networks, remoteAddr) != expected {
+			t.Errorf("Expected isAllowed(%q) to be %v.", remoteAddr, expected)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 )
 
 // cert and key created with openssl req -x509 -newkey rsa:2048 -keyout key.pem -out cert.pem -nodes
@@ -62,7 +66,16 @@ XLgD9hrDBrTbnKBHHQ6MHpT6ILi4w/e4+5XEUUOBf44ZJE71uRr4ZUA=
 -----END RSA PRIVATE KEY-----
 `
 
-func RunHttpsWithDefaultKeys(port int, path string, handler http.Handler) error {
+// Options configures the HTTP server. Zero values mean the defaults of Go's net/http package, i.e. no timeouts.
+type Options struct {
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration // how long keep-alive connections are kept open between requests
+	MaxHeaderBytes int
+	DisableHttp2   bool // HTTP/2 is only available with https
+}
+
+func RunHttpsWithDefaultKeys(port int, path string, handler http.Handler, options Options) error {
 	cert, err := createTempFile("cert", []byte(defaultCert))
 	if err != nil {
 		return err
@@ -73,17 +86,64 @@ func RunHttpsWithDefaultKeys(port int, path string, handler http.Handler) error
 		return err
 	}
 	defer os.Remove(key)
-	return RunHttps(port, cert, key, path, handler)
+	return RunHttps(port, cert, key, "", path, handler, options)
 }
 
-func RunHttps(port int, cert, key, path string, handler http.Handler) error {
+// RunHttps serves handler via https. If passphrase is not empty, the key file is expected to be encrypted with that passphrase.
+func RunHttps(port int, cert, key, passphrase, path string, handler http.Handler, options Options) error {
 	http.Handle(path, handler)
-	return http.ListenAndServeTLS(fmt.Sprintf(":%v", port), cert, key, nil)
+	server := newServer(port, options)
+	if options.DisableHttp2 {
+		// net/http offers HTTP/2 automatically with TLS. A non-nil empty map disables this.
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	if passphrase == "" {
+		return server.ListenAndServeTLS(cert, key)
+	}
+	keyPair, err := loadEncryptedKeyPair(cert, key, passphrase)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+func loadEncryptedKeyPair(cert, key, passphrase string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(cert)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Failed to read %v: %v", cert, err.Error())
+	}
+	keyPEM, err := ioutil.ReadFile(key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Failed to read %v: %v", key, err.Error())
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, fmt.Errorf("Failed to read %v: No PEM data found.", key)
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Failed to decrypt %v: %v", key, err.Error())
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	return tls.X509KeyPair(certPEM, keyPEM)
 }
 
-func RunHttp(port int, path string, handler http.Handler) error {
+func RunHttp(port int, path string, handler http.Handler, options Options) error {
 	http.Handle(path, handler)
-	return http.ListenAndServe(fmt.Sprintf(":%v", port), nil)
+	return newServer(port, options).ListenAndServe()
+}
+
+func newServer(port int, options Options) *http.Server {
+	return &http.Server{
+		Addr:           fmt.Sprintf(":%v", port),
+		ReadTimeout:    options.ReadTimeout,
+		WriteTimeout:   options.WriteTimeout,
+		IdleTimeout:    options.IdleTimeout,
+		MaxHeaderBytes: options.MaxHeaderBytes,
+	}
 }
 
 func createTempFile(prefix string, data []byte) (string, error) {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+func createSessions(cfg *config.Config, patterns *Patterns) ([]*metrics.SessionTracker, error) {
+	result := make([]*metrics.SessionTracker, 0)
+	if cfg.Sessions == nil {
+		return result, nil
+	}
+	for _, s := range *cfg.Sessions {
+		start, err := Compile(s.Start, patterns)
+		if err != nil {
+			return nil, err
+		}
+		var activity regex.Regexp
+		if s.Activity != "" {
+			activity, err = Compile(s.Activity, patterns)
+			if err != nil {
+				return nil, err
+			}
+		}
+		end, err := Compile(s.End, patterns)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, metrics.CreateSessionTracker(s, start, activity, end))
+	}
+	return result, nil
+}
+
+// validateSessions checks that the key field is captured by each expression, like validateMetrics() does for labels.
+func validateSessions(cfg *config.Config, patterns *Patterns) error {
+	if cfg.Sessions == nil {
+		return nil
+	}
+	for _, s := range *cfg.Sessions {
+		for name, expression := range map[string]string{"start": s.Start, "activity": s.Activity, "end": s.End} {
+			if expression == "" {
+				continue
+			}
+			regex, err := expand(expression, patterns)
+			if err != nil {
+				return err
+			}
+			if !namedGroups(regex)[s.Key] {
+				return fmt.Errorf("Invalid session %v: The %v expression has no capture named %v, which is the 'key'.", s.Name, name, s.Key)
+			}
+		}
+	}
+	return nil
+}
+
+func registerSessions(sessions []*metrics.SessionTracker) {
+	for _, s := range sessions {
+		for _, collector := range s.Collectors() {
+			prometheus.MustRegister(collector)
+		}
+	}
+}
+
+// startSessionTimeouts removes timed out sessions in the background. The timeout is checked ten times per 'timeout',
+// but at most once per second.
+func startSessionTimeouts(cfg *config.Config, sessions []*metrics.SessionTracker) {
+	for i, s := range sessions {
+		interval := (*cfg.Sessions)[i].Timeout / 10
+		if interval < time.Second {
+			interval = time.Second
+		}
+		go func(tracker *metrics.SessionTracker) {
+			for now := range time.Tick(interval) {
+				tracker.Expire(now)
+			}
+		}(s)
+	}
+}
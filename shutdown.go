@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// The exporter usually runs until it is killed. The shutdown hooks run when SIGINT or SIGTERM is received,
+// in reverse order of registration, and then the process terminates.
+var (
+	shutdownMutex sync.Mutex
+	shutdownHooks []func()
+	shutdownOnce  sync.Once
+)
+
+func onShutdown(hook func()) {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+	shutdownOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			runShutdownHooks()
+			os.Exit(exitOK)
+		}()
+	})
+}
+
+func runShutdownHooks() {
+	shutdownMutex.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	shutdownMutex.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Metrics about the internal stages of the pipeline: the queues between the inputs and the processing goroutine,
+// the records processed, and the time spent matching and updating the metrics. These show where the pipeline
+// spends its time, so that the capacity can be planned based on data.
+var (
+	pipelineLinesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "grok_exporter_pipeline_lines_total",
+		Help: "Number of lines read from the input and passed to the pipeline.",
+	})
+	pipelineRecordsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grok_exporter_pipeline_records_total",
+		Help: "Number of records processed, with multiline records counted once. The result is 'matched' or 'unmatched'.",
+	}, []string{"result"})
+	pipelineMatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "grok_exporter_pipeline_match_duration_seconds",
+		Help:    "Time to match a record against the match expressions of all metrics.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 9), // 10µs to 655ms
+	})
+	pipelineUpdateDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "grok_exporter_pipeline_update_duration_seconds",
+		Help:    "Time to update the metrics matching a record. Records without a match are not observed.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 9),
+	})
+	pipelineBusySeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "grok_exporter_pipeline_busy_seconds_total",
+		Help: "Time spent processing records. The rate is the utilization of the processing goroutine, 1 means it is saturated.",
+	})
+)
+
+func registerPipelineMetrics(dump *stateDump) {
+	prometheus.MustRegister(pipelineLinesTotal)
+	prometheus.MustRegister(pipelineRecordsTotal)
+	prometheus.MustRegister(pipelineMatchDuration)
+	prometheus.MustRegister(pipelineUpdateDuration)
+	prometheus.MustRegister(pipelineBusySeconds)
+	prometheus.MustRegister(&queueCollector{dump: dump})
+}
+
+// stageTimer sums up the match and update times of a record, so that each record is observed once
+// in the histograms, independent of the number of metrics.
+type stageTimer struct {
+	start  time.Time
+	match  time.Duration
+	update time.Duration
+}
+
+func startStageTimer() stageTimer {
+	return stageTimer{start: time.Now()}
+}
+
+func (t *stageTimer) observe(matched bool) {
+	pipelineMatchDuration.Observe(t.match.Seconds())
+	if matched {
+		pipelineUpdateDuration.Observe(t.update.Seconds())
+		pipelineRecordsTotal.WithLabelValues("matched").Inc()
+	} else {
+		pipelineRecordsTotal.WithLabelValues("unmatched").Inc()
+	}
+	pipelineBusySeconds.Add(time.Since(t.start).Seconds())
+}
+
+// queueSize is the number of lines buffered between an input and the processing goroutine. The buffer absorbs bursts,
+// and its length shows whether the processing keeps up with the input. When it is full, the input waits.
+const queueSize = 1000
+
+// queueCollector exposes the length and capacity of the queues registered in the state dump when the metrics are scraped.
+type queueCollector struct {
+	dump *stateDump
+}
+
+var (
+	queueLengthDesc = prometheus.NewDesc(
+		"grok_exporter_pipeline_queue_length",
+		"Number of items waiting in the queue between the input and the processing goroutine.",
+		[]string{"queue"}, nil)
+	queueCapacityDesc = prometheus.NewDesc(
+		"grok_exporter_pipeline_queue_capacity",
+		"Capacity of the queue between the input and the processing goroutine.",
+		[]string{"queue"}, nil)
+)
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueLengthDesc
+	ch <- queueCapacityDesc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.dump == nil {
+		return
+	}
+	c.dump.mutex.Lock()
+	defer c.dump.mutex.Unlock()
+	for _, q := range c.dump.queues {
+		ch <- prometheus.MustNewConstMetric(queueLengthDesc, prometheus.GaugeValue, float64(q.length()), q.name)
+		ch <- prometheus.MustNewConstMetric(queueCapacityDesc, prometheus.GaugeValue, float64(q.capacity()), q.name)
+	}
+}
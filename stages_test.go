@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+func TestQueueCollector(t *testing.T) {
+	d := newStateDump()
+	lines := make(chan string, 3)
+	lines <- "pending"
+	lines <- "pending"
+	d.addQueue("lines", func() int { return len(lines) }, func() int { return cap(lines) })
+	ch := make(chan prometheus.Metric)
+	go func() {
+		(&queueCollector{dump: d}).Collect(ch)
+		close(ch)
+	}()
+	values := make([]float64, 0)
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if len(metric.Label) != 1 || metric.Label[0].GetValue() != "lines" {
+			t.Fatalf("Expected label queue=\"lines\", but got %v", metric.Label)
+		}
+		values = append(values, metric.Gauge.GetValue())
+	}
+	if len(values) != 2 || values[0] != 2 || values[1] != 3 {
+		t.Fatalf("Expected length 2 and capacity 3, but got %v", values)
+	}
+	(&queueCollector{}).Collect(ch) // must not panic without a dump
+}
+
+func TestStageTimer(t *testing.T) {
+	matched := pipelineRecordsTotal.WithLabelValues("matched")
+	before := counterValue(t, matched)
+	timer := startStageTimer()
+	timer.observe(true)
+	if after := counterValue(t, matched); after != before+1 {
+		t.Fatalf("Expected %v matched records, but got %v", before+1, after)
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var metric dto.Metric
+	if err := c.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.Counter.GetValue()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// runSuggest clusters the lines of a file by token structure, and proposes a grok expression for each of the largest clusters.
+// If a config is given, lines that already match a metric are skipped.
+func runSuggest(args []string) int {
+	flags, configFlags := newFlagSet("suggest")
+	file := flags.String("file", "", "Path to a sample log file.")
+	n := flags.Int("n", 5, "Number of clusters to show.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	if *file == "" || *n < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: grok_exporter suggest -file <path> [-config <path>] [-n <clusters>]\n")
+		return exitUsage
+	}
+	lines, err := readLines(*file, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	if *configFlags.path != "" {
+		_, _, metrics, err := initialize(configFlags)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+		unmatched := make([]string, 0, len(lines))
+	next:
+		for _, line := range lines {
+			for _, metric := range metrics {
+				if metric.Matches(line) {
+					continue next
+				}
+			}
+			unmatched = append(unmatched, line)
+		}
+		lines = unmatched
+	}
+	if len(lines) == 0 {
+		fmt.Println("No unmatched lines.")
+		return exitOK
+	}
+	clusters := clusterLines(lines)
+	for i, c := range clusters {
+		if i == *n {
+			break
+		}
+		fmt.Printf("%v lines (%.1f%%), for example:\n", len(c.lines), 100*float64(len(c.lines))/float64(len(lines)))
+		fmt.Printf("    %v\n", c.lines[0])
+		fmt.Printf("  match: '%v'\n\n", strings.Replace(c.suggest(), "'", "''", -1))
+	}
+	return exitOK
+}
+
+// A token class is either one of the grok patterns below, or empty for words that are kept literally if all lines of a cluster agree.
+var tokenClasses = []struct {
+	pattern string
+	regex   *regexp.Regexp
+}{
+	{"TIMESTAMP_ISO8601", regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(:\d{2}([.,]\d+)?)?(Z|[+-]\d{2}:?\d{2})?$`)},
+	{"IP", regexp.MustCompile(`^(\d{1,3}\.){3}\d{1,3}$`)},
+	{"UUID", regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)},
+	{"INT", regexp.MustCompile(`^[+-]?\d+$`)},
+	{"NUMBER", regexp.MustCompile(`^[+-]?\d+\.\d+$`)},
+	{"QS", regexp.MustCompile(`^"[^"]*"$`)},
+}
+
+var (
+	keyValueToken = regexp.MustCompile(`^(\w+)=(.+)$`)
+	wordToken     = regexp.MustCompile(`^\w+$`)
+)
+
+type token struct {
+	key   string // for key=value tokens, the key, which is kept literally
+	value string
+	class string // grok pattern, or empty for words
+}
+
+func tokenize(line string) []token {
+	fields := strings.Fields(line)
+	result := make([]token, len(fields))
+	for i, field := range fields {
+		t := token{value: field}
+		if m := keyValueToken.FindStringSubmatch(field); m != nil {
+			t.key, t.value = m[1], m[2]
+		}
+		for _, c := range tokenClasses {
+			if c.regex.MatchString(t.value) {
+				t.class = c.pattern
+				break
+			}
+		}
+		result[i] = t
+	}
+	return result
+}
+
+// signature is the same for lines with the same token structure. Keys are part of the structure, words are not.
+func signature(tokens []token) string {
+	parts := make([]string, len(tokens))
+	for i, t := range tokens {
+		parts[i] = t.key + "=" + t.class
+	}
+	return strings.Join(parts, " ")
+}
+
+type cluster struct {
+	lines  []string
+	tokens [][]token
+}
+
+// clusterLines returns the clusters, largest first.
+func clusterLines(lines []string) []*cluster {
+	result := make([]*cluster, 0)
+	bySignature := make(map[string]*cluster)
+	for _, line := range lines {
+		tokens := tokenize(line)
+		if len(tokens) == 0 {
+			continue
+		}
+		sig := signature(tokens)
+		c, exists := bySignature[sig]
+		if !exists {
+			c = &cluster{}
+			bySignature[sig] = c
+			result = append(result, c)
+		}
+		c.lines = append(c.lines, line)
+		c.tokens = append(c.tokens, tokens)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i].lines) > len(result[j].lines)
+	})
+	return result
+}
+
+// suggest returns a grok expression matching all lines of the cluster.
+// Words that are the same in all lines are kept literally, other words become WORD or NOTSPACE.
+// Values of key=value tokens are always captured, with the key as field name.
+func (c *cluster) suggest() string {
+	parts := make([]string, len(c.tokens[0]))
+	for i, first := range c.tokens[0] {
+		pattern := first.class
+		if pattern == "" {
+			constant, words := true, true
+			for _, tokens := range c.tokens {
+				constant = constant && tokens[i].value == first.value
+				words = words && wordToken.MatchString(tokens[i].value)
+			}
+			switch {
+			case constant && first.key == "":
+				parts[i] = regexp.QuoteMeta(first.value)
+				continue
+			case words:
+				pattern = "WORD"
+			default:
+				pattern = "NOTSPACE"
+			}
+		}
+		if first.key != "" {
+			parts[i] = first.key + "=%{" + pattern + ":" + first.key + "}"
+		} else {
+			parts[i] = "%{" + pattern + "}"
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestClusterLines(t *testing.T) {
+	lines := []string{
+		"2017-01-12T10:15:01Z INFO user=alice logged in from 10.0.0.1",
+		"GET /index.html 200",
+		"2017-01-12T10:15:07Z WARN user=bob logged in from 10.0.0.2",
+		"2017-01-12T10:16:31Z INFO user=carol logged in from 192.168.1.17",
+	}
+	clusters := clusterLines(lines)
+	if len(clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, but got %v.", len(clusters))
+	}
+	if len(clusters[0].lines) != 3 {
+		t.Errorf("Expected the largest cluster first, but got %v lines.", len(clusters[0].lines))
+	}
+	expected := `%{TIMESTAMP_ISO8601} %{WORD} user=%{WORD:user} logged in from %{IP}`
+	if clusters[0].suggest() != expected {
+		t.Errorf("Expected '%v', but got '%v'.", expected, clusters[0].suggest())
+	}
+	expected = `GET /index\.html %{INT}`
+	if clusters[1].suggest() != expected {
+		t.Errorf("Expected '%v', but got '%v'.", expected, clusters[1].suggest())
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registerTargetInfo exposes 'global.resource_attributes' as target_info gauge with value 1, following the
+// OpenTelemetry convention for Prometheus. Attribute names like service.name become labels like service_name.
+// Nothing is registered if there are no resource attributes.
+func registerTargetInfo(attributes map[string]string) {
+	if len(attributes) == 0 {
+		return
+	}
+	labels := make(prometheus.Labels, len(attributes))
+	for name, value := range attributes {
+		labels[config.LabelName(name)] = value
+	}
+	targetInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "target_info",
+		Help:        "Target metadata from 'global.resource_attributes'.",
+		ConstLabels: labels,
+	})
+	targetInfo.Set(1)
+	prometheus.MustRegister(targetInfo)
+}
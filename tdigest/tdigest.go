@@ -0,0 +1,135 @@
+package tdigest
+
+import (
+	"math"
+	"sort"
+)
+
+// TDigest estimates quantiles of a stream of values with bounded memory, as described in
+// Ted Dunning, Otmar Ertl: "Computing Extremely Accurate Quantiles Using t-Digests".
+// The values are clustered into centroids, and centroids near the tails are kept small,
+// so that the extreme quantiles like 0.99 are accurate even for heavy-tailed distributions.
+// TDigest is not thread safe.
+type TDigest struct {
+	compression float64    // higher means more centroids and more accurate quantiles
+	centroids   []centroid // sorted by mean
+	buffer      []float64  // values not merged into the centroids yet
+	count       float64
+	min, max    float64
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// New creates an empty t-digest. The number of centroids is roughly bounded by the compression, 100 is a good default.
+func New(compression float64) *TDigest {
+	return &TDigest{
+		compression: compression,
+		buffer:      make([]float64, 0, bufferSize(compression)),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func bufferSize(compression float64) int {
+	return int(math.Ceil(compression)) * 5
+}
+
+// Add adds a value. NaN is ignored.
+func (d *TDigest) Add(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	d.buffer = append(d.buffer, value)
+	d.count++
+	d.min = math.Min(d.min, value)
+	d.max = math.Max(d.max, value)
+	if len(d.buffer) >= bufferSize(d.compression) {
+		d.merge()
+	}
+}
+
+// Count returns the number of values added.
+func (d *TDigest) Count() float64 {
+	return d.count
+}
+
+// merge merges the buffered values into the centroids. Neighbouring centroids are combined as long as
+// the combined centroid spans at most 1 on the scale, see scale().
+func (d *TDigest) merge() {
+	if len(d.buffer) == 0 {
+		return
+	}
+	sort.Float64s(d.buffer)
+	all := make([]centroid, 0, len(d.centroids)+len(d.buffer))
+	i, j := 0, 0
+	for i < len(d.centroids) || j < len(d.buffer) {
+		if j == len(d.buffer) || (i < len(d.centroids) && d.centroids[i].mean <= d.buffer[j]) {
+			all = append(all, d.centroids[i])
+			i++
+		} else {
+			all = append(all, centroid{mean: d.buffer[j], weight: 1})
+			j++
+		}
+	}
+	result := make([]centroid, 0, len(d.centroids))
+	current := all[0]
+	cumulative := 0.0
+	for _, next := range all[1:] {
+		if d.scale((cumulative+current.weight+next.weight)/d.count)-d.scale(cumulative/d.count) <= 1 {
+			current.weight += next.weight
+			current.mean += (next.mean - current.mean) * next.weight / current.weight
+		} else {
+			cumulative += current.weight
+			result = append(result, current)
+			current = next
+		}
+	}
+	d.centroids = append(result, current)
+	d.buffer = d.buffer[:0]
+}
+
+// scale is the k1 scale function of the paper. It is steep near q = 0 and q = 1, so that the centroids there are small.
+func (d *TDigest) scale(q float64) float64 {
+	return d.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// Quantile returns the estimated value at quantile q, with 0 <= q <= 1. It returns NaN if no values were added.
+// Between the centroids, and between the outermost centroids and the minimum and maximum, the value is interpolated linearly.
+func (d *TDigest) Quantile(q float64) float64 {
+	d.merge()
+	switch {
+	case d.count == 0:
+		return math.NaN()
+	case q <= 0:
+		return d.min
+	case q >= 1:
+		return d.max
+	case len(d.centroids) == 1:
+		return d.centroids[0].mean
+	}
+	target := q * d.count
+	first, last := d.centroids[0], d.centroids[len(d.centroids)-1]
+	if target < first.weight/2 {
+		return d.min + (first.mean-d.min)*target/(first.weight/2)
+	}
+	cumulative := 0.0
+	for i := 0; i+1 < len(d.centroids); i++ {
+		left := cumulative + d.centroids[i].weight/2
+		right := cumulative + d.centroids[i].weight + d.centroids[i+1].weight/2
+		if target <= right {
+			return d.centroids[i].mean + (d.centroids[i+1].mean-d.centroids[i].mean)*(target-left)/(right-left)
+		}
+		cumulative += d.centroids[i].weight
+	}
+	center := d.count - last.weight/2
+	return last.mean + (d.max-last.mean)*(target-center)/(last.weight/2)
+}
+
+// Reset removes all values.
+func (d *TDigest) Reset() {
+	d.centroids, d.buffer, d.count = nil, d.buffer[:0], 0
+	d.min, d.max = math.Inf(1), math.Inf(-1)
+}
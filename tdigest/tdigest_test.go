@@ -0,0 +1,58 @@
+package tdigest
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQuantile(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	for name, generate := range map[string]func() float64{
+		"uniform":     func() float64 { return random.Float64() * 1000 },
+		"exponential": func() float64 { return random.ExpFloat64() * 100 },
+		"pareto":      func() float64 { return math.Pow(1-random.Float64(), -1/1.5) }, // heavy tail
+	} {
+		digest := New(100)
+		values := make([]float64, 100000)
+		for i := range values {
+			values[i] = generate()
+			digest.Add(values[i])
+		}
+		sort.Float64s(values)
+		for _, q := range []float64{0.01, 0.5, 0.9, 0.99, 0.999} {
+			// Compare the ranks, because the values of heavy-tailed distributions vary a lot near the tail.
+			estimate := digest.Quantile(q)
+			rank := float64(sort.SearchFloat64s(values, estimate)) / float64(len(values))
+			tolerance := 0.01
+			if q < 0.05 || q > 0.95 {
+				tolerance = 0.001
+			}
+			if math.Abs(rank-q) > tolerance {
+				t.Errorf("%v: Expected quantile %v, but %v is at quantile %v.", name, q, estimate, rank)
+			}
+		}
+		if digest.Quantile(0) != values[0] || digest.Quantile(1) != values[len(values)-1] {
+			t.Errorf("%v: Expected quantiles 0 and 1 to be the minimum and maximum.", name)
+		}
+		if len(digest.centroids) > 200 {
+			t.Errorf("%v: Expected the centroids to be bounded by the compression, but got %v centroids.", name, len(digest.centroids))
+		}
+	}
+}
+
+func TestEmptyAndReset(t *testing.T) {
+	digest := New(100)
+	if !math.IsNaN(digest.Quantile(0.5)) {
+		t.Error("Expected NaN for an empty digest.")
+	}
+	digest.Add(3)
+	if digest.Quantile(0.5) != 3 || digest.Count() != 1 {
+		t.Errorf("Expected median 3 of one value, but got %v.", digest.Quantile(0.5))
+	}
+	digest.Reset()
+	if digest.Count() != 0 || !math.IsNaN(digest.Quantile(0.5)) {
+		t.Error("Expected the digest to be empty after Reset().")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bitbucket.org/ww/goautoneg"
+	"bytes"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/server"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/text"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The vendored Prometheus client library only supports the global registry, which is exposed at /metrics.
+// The metrics of each tenant are exposed by a tenantHandler, which collects them independently of the global registry.
+
+type tenantHandler struct {
+	metrics []metrics.Metric
+	help    map[string]string // metric name -> help text
+}
+
+func newTenantHandler() *tenantHandler {
+	return &tenantHandler{
+		metrics: make([]metrics.Metric, 0),
+		help:    make(map[string]string),
+	}
+}
+
+func (h *tenantHandler) add(metric metrics.Metric, cfg *config.MetricConfig) {
+	h.metrics = append(h.metrics, metric)
+	h.help[metric.Name()] = cfg.Help
+	for _, companion := range metrics.Companions(metric) {
+		h.metrics = append(h.metrics, companion)
+		h.help[companion.Name()] = cfg.SumHelp()
+	}
+}
+
+func (h *tenantHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	families, err := h.collect()
+	if err != nil {
+		http.Error(w, "An error has occurred:\n\n"+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encode, contentType := negotiate(r)
+	var buf bytes.Buffer
+	for _, family := range families {
+		_, err = encode(&buf, family)
+		if err != nil {
+			http.Error(w, "An error has occurred:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+}
+
+// negotiate selects the exposition format from the Accept header, like the handler of the global registry at /metrics does:
+// Prometheus prefers the delimited protobuf format, which is faster to parse for large metric families.
+// The text format is the default.
+func negotiate(r *http.Request) (func(io.Writer, *dto.MetricFamily) (int, error), string) {
+	for _, accept := range goautoneg.ParseAccept(r.Header.Get("Accept")) {
+		switch {
+		case accept.Type == "application" && accept.SubType == "vnd.google.protobuf" && accept.Params["proto"] == "io.prometheus.client.MetricFamily":
+			switch accept.Params["encoding"] {
+			case "delimited":
+				return text.WriteProtoDelimited, prometheus.DelimitedTelemetryContentType
+			case "text":
+				return text.WriteProtoText, prometheus.ProtoTextTelemetryContentType
+			case "compact-text":
+				return text.WriteProtoCompactText, prometheus.ProtoCompactTextTelemetryContentType
+			}
+		case accept.Type == "text" && accept.SubType == "plain" && (accept.Params["version"] == "0.0.4" || accept.Params["version"] == ""):
+			return text.MetricFamilyToText, prometheus.TextTelemetryContentType
+		}
+	}
+	return text.MetricFamilyToText, prometheus.TextTelemetryContentType
+}
+
+func (h *tenantHandler) collect() ([]*dto.MetricFamily, error) {
+	return collectFamilies(h.metrics, h.help, metrics.Metric.Collector)
+}
+
+// collectFamilies collects the metrics with their help texts, sorted by name and labels.
+// collector is either metrics.Metric.Collector for a scrape, or metrics.Snapshot, which does not reset 'per_scrape' metrics.
+func collectFamilies(metricList []metrics.Metric, help map[string]string, collector func(metrics.Metric) prometheus.Collector) ([]*dto.MetricFamily, error) {
+	result := make([]*dto.MetricFamily, 0, len(metricList))
+	for _, metric := range metricList {
+		family := &dto.MetricFamily{
+			Name:   proto.String(metric.Name()),
+			Help:   proto.String(help[metric.Name()]),
+			Metric: make([]*dto.Metric, 0),
+		}
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector(metric).Collect(ch)
+			close(ch)
+		}()
+		var err error
+		for m := range ch {
+			dtoMetric := &dto.Metric{}
+			if writeErr := m.Write(dtoMetric); writeErr != nil && err == nil {
+				err = fmt.Errorf("Error collecting metric %v: %v", metric.Name(), writeErr.Error())
+			}
+			family.Metric = append(family.Metric, dtoMetric)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(family.Metric) == 0 {
+			continue
+		}
+		family.Type = metricType(family.Metric[0])
+		sort.Sort(byLabels(family.Metric))
+		result = append(result, family)
+	}
+	sort.Sort(byName(result))
+	return result, nil
+}
+
+func metricType(m *dto.Metric) *dto.MetricType {
+	switch {
+	case m.Gauge != nil:
+		return dto.MetricType_GAUGE.Enum()
+	case m.Counter != nil:
+		return dto.MetricType_COUNTER.Enum()
+	case m.Summary != nil:
+		return dto.MetricType_SUMMARY.Enum()
+	case m.Histogram != nil:
+		return dto.MetricType_HISTOGRAM.Enum()
+	default:
+		return dto.MetricType_UNTYPED.Enum()
+	}
+}
+
+type byName []*dto.MetricFamily
+
+func (f byName) Len() int           { return len(f) }
+func (f byName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byName) Less(i, j int) bool { return f[i].GetName() < f[j].GetName() }
+
+type byLabels []*dto.Metric
+
+func (m byLabels) Len() int           { return len(m) }
+func (m byLabels) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byLabels) Less(i, j int) bool { return labelString(m[i]) < labelString(m[j]) }
+
+func labelString(m *dto.Metric) string {
+	values := make([]string, 0, len(m.Label))
+	for _, label := range m.Label {
+		values = append(values, label.GetName()+"="+label.GetValue())
+	}
+	return strings.Join(values, ",")
+}
+
+// registerMetrics registers the metrics without tenant with the global registry and returns them,
+// together with the handlers for the tenants' endpoints mapped by path.
+// The metrics are in the same order as in cfg.Metrics, each followed by its companions.
+func registerMetrics(cfg *config.Config, metricList []metrics.Metric) ([]metrics.Metric, map[string]http.Handler, error) {
+	global := make([]metrics.Metric, 0, len(metricList))
+	tenants := make(map[string]*tenantHandler)
+	for i, m := range *cfg.Metrics {
+		if m.Tenant == "" {
+			prometheus.MustRegister(metricList[i].Collector())
+			global = append(global, metricList[i])
+			for _, companion := range metrics.Companions(metricList[i]) {
+				prometheus.MustRegister(companion.Collector())
+				global = append(global, companion)
+			}
+			continue
+		}
+		if tenants[m.Tenant] == nil {
+			tenants[m.Tenant] = newTenantHandler()
+		}
+		tenants[m.Tenant].add(metricList[i], m)
+	}
+	result := make(map[string]http.Handler)
+	if cfg.Tenants == nil {
+		return global, result, nil
+	}
+	for _, tenant := range *cfg.Tenants {
+		var handler http.Handler = newTenantHandler()
+		if tenants[tenant.Name] != nil {
+			handler = tenants[tenant.Name]
+		}
+		if tenant.Username != "" {
+			password, err := tenant.ReadPassword()
+			if err != nil {
+				return nil, nil, err
+			}
+			handler = server.BasicAuth(tenant.Username, password, "grok_exporter "+tenant.Name, handler)
+		}
+		result["/metrics/"+tenant.Name] = handler
+	}
+	return global, result, nil
+}
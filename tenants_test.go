@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTenantHandler(t *testing.T) {
+	cfg, err := config.LoadConfigString([]byte(`
+input:
+    type: stdin
+grok:
+    patterns: ['WORD \w+']
+tenants:
+    - name: team-a
+metrics:
+    - type: counter
+      name: a_total
+      help: Lines of team a.
+      match: 'a=%{WORD:value}'
+      labels:
+          - grok_field_name: value
+            prometheus_label: value
+      tenant: team-a
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\w+")
+	metricList, err := createMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, handlers, err := registerMetrics(cfg, metricList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(global) != 0 {
+		t.Errorf("Expected the tenant's metric not to be registered globally.")
+	}
+	metricList[0].Process("a=x", nil)
+	recorder := httptest.NewRecorder()
+	handlers["/metrics/team-a"].ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/team-a", nil))
+	body := recorder.Body.String()
+	for _, expected := range []string{"# HELP a_total Lines of team a.", "# TYPE a_total counter", "a_total{value=\"x\"} 1"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in:\n%v", expected, body)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics/team-a", nil)
+	req.Header.Set("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3")
+	handlers["/metrics/team-a"].ServeHTTP(recorder, req)
+	if recorder.Header().Get("Content-Type") != prometheus.DelimitedTelemetryContentType {
+		t.Fatalf("Expected the protobuf format, but got %v.", recorder.Header().Get("Content-Type"))
+	}
+	var family dto.MetricFamily
+	if _, err := pbutil.ReadDelimited(recorder.Body, &family); err != nil || family.GetName() != "a_total" || family.Metric[0].Counter.GetValue() != 1 {
+		t.Errorf("Expected a_total in protobuf format, but got %v (%v).", family, err)
+	}
+}
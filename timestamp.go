@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/fstab/grok_exporter/locale"
+	"github.com/fstab/grok_exporter/metrics"
+	"github.com/fstab/grok_exporter/regex"
+	"time"
+)
+
+// timestampParser parses the original timestamp from a log line, as configured in 'input.timestamp'.
+type timestampParser struct {
+	regex      regex.Regexp
+	field      string
+	layout     string
+	location   *time.Location            // for timestamps without zone
+	zones      map[string]*time.Location // zone abbreviations from 'zones'
+	translator *locale.Translator        // nil if 'locale' is not configured
+}
+
+func newTimestampParser(cfg *config.TimestampConfig, patterns *Patterns) (*timestampParser, error) {
+	regex, err := Compile(cfg.Match, patterns)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid 'input.timestamp.match': %v", err.Error())
+	}
+	if !namedGroups(regex.String())[cfg.Field] {
+		return nil, fmt.Errorf("Invalid 'input.timestamp.match': There is no capture named %v.", cfg.Field)
+	}
+	location, err := cfg.Location()
+	if err != nil {
+		return nil, err
+	}
+	zones, err := cfg.ZoneLocations()
+	if err != nil {
+		return nil, err
+	}
+	return &timestampParser{
+		regex:      regex,
+		field:      cfg.Field,
+		layout:     cfg.Layout,
+		location:   location,
+		zones:      zones,
+		translator: locale.NewTranslator(cfg.Locale, cfg.Layout),
+	}, nil
+}
+
+// parse returns false if the line has no timestamp, or if the timestamp cannot be parsed.
+func (p *timestampParser) parse(line string) (time.Time, bool) {
+	value, found := metrics.ExtractField(p.regex, line, p.field)
+	if !found {
+		return time.Time{}, false
+	}
+	if p.translator != nil {
+		value = p.translator.Translate(value)
+	}
+	result, err := time.ParseInLocation(p.layout, value, p.location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// Go knows only the abbreviations of the parser's location. Other abbreviations are parsed with offset zero.
+	if abbreviation, _ := result.Zone(); p.zones[abbreviation] != nil {
+		result = time.Date(result.Year(), result.Month(), result.Day(), result.Hour(), result.Minute(), result.Second(), result.Nanosecond(), p.zones[abbreviation])
+	}
+	return result, true
+}
+
+// ageFilter ignores lines whose timestamp is older than 'input.ignore_lines_older_than', so that replayed or delayed lines
+// don't inflate the current rates. Lines without timestamp are not ignored. A nil ageFilter ignores nothing.
+type ageFilter struct {
+	parser *timestampParser
+	maxAge time.Duration
+}
+
+// newAgeFilter returns nil if maxAge is zero.
+func newAgeFilter(parser *timestampParser, maxAge time.Duration) *ageFilter {
+	if maxAge == 0 {
+		return nil
+	}
+	return &ageFilter{
+		parser: parser,
+		maxAge: maxAge,
+	}
+}
+
+func (f *ageFilter) tooOld(line string, now time.Time) bool {
+	if f == nil {
+		return false
+	}
+	timestamp, ok := f.parser.parse(line)
+	return ok && now.Sub(timestamp) > f.maxAge
+}
+
+// replayer delays log lines so that they are processed at the pace given by their original timestamps,
+// sped up by the replay speed factor. Lines without timestamp are not delayed.
+type replayer struct {
+	parser    *timestampParser
+	speed     float64
+	firstTime time.Time // timestamp of the first line
+	firstWall time.Time // when the first line was processed
+}
+
+func newReplayer(parser *timestampParser, speed float64) *replayer {
+	return &replayer{
+		parser: parser,
+		speed:  speed,
+	}
+}
+
+// delay returns how long to wait before processing the line.
+func (r *replayer) delay(line string, now time.Time) time.Duration {
+	if r == nil {
+		return 0
+	}
+	timestamp, ok := r.parser.parse(line)
+	if !ok {
+		return 0
+	}
+	if r.firstWall.IsZero() {
+		r.firstTime = timestamp
+		r.firstWall = now
+		return 0
+	}
+	elapsed := time.Duration(float64(timestamp.Sub(r.firstTime)) / r.speed)
+	result := r.firstWall.Add(elapsed).Sub(now)
+	if result < 0 {
+		return 0
+	}
+	return result
+}
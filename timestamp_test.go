@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"testing"
+	"time"
+)
+
+func TestReplayerDelay(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("TS \\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}")
+	parser, err := newTimestampParser(&config.TimestampConfig{
+		Match:  "^%{TS:timestamp} ",
+		Field:  "timestamp",
+		Layout: "2006-01-02 15:04:05",
+	}, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := newReplayer(parser, 10)
+	now := time.Now()
+	for _, test := range []struct {
+		line     string
+		now      time.Time
+		expected time.Duration
+	}{
+		{"2016-04-01 12:00:00 first", now, 0},
+		{"no timestamp", now, 0},
+		{"2016-04-01 12:00:20 20s later", now, 2 * time.Second},
+		{"2016-04-01 12:00:20 processed late", now.Add(3 * time.Second), 0},
+		{"2016-04-01 12:01:00 60s later", now.Add(time.Second), 5 * time.Second},
+	} {
+		delay := replay.delay(test.line, test.now)
+		if delay != test.expected {
+			t.Errorf("%q: Expected delay %v, but got %v.", test.line, test.expected, delay)
+		}
+	}
+	var disabled *replayer
+	if disabled.delay("2016-04-01 12:00:00", now) != 0 {
+		t.Error("Expected nil replayer not to delay lines.")
+	}
+}
+
+func TestTimestampZonesAndLocale(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("TS [^\\]]+")
+	for _, test := range []struct {
+		cfg      config.TimestampConfig
+		line     string
+		expected time.Time
+	}{
+		{config.TimestampConfig{Layout: "2006-01-02 15:04:05", Timezone: "Europe/Berlin"}, "[2016-07-01 12:00:00] summer", time.Date(2016, 7, 1, 10, 0, 0, 0, time.UTC)},
+		{config.TimestampConfig{Layout: "2006-01-02 15:04:05"}, "[2016-07-01 12:00:00] default UTC", time.Date(2016, 7, 1, 12, 0, 0, 0, time.UTC)},
+		{config.TimestampConfig{Layout: "2006-01-02 15:04:05 MST", Zones: map[string]string{"IST": "+05:30"}}, "[2016-07-01 12:00:00 IST] offset", time.Date(2016, 7, 1, 6, 30, 0, 0, time.UTC)},
+		{config.TimestampConfig{Layout: "2006-01-02 15:04:05 MST", Zones: map[string]string{"CET": "Europe/Berlin"}}, "[2016-01-01 12:00:00 CET] location", time.Date(2016, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{config.TimestampConfig{Layout: "2. January 2006 15:04", Locale: "de"}, "[3. März 2016 08:15] long German name", time.Date(2016, 3, 3, 8, 15, 0, 0, time.UTC)},
+		{config.TimestampConfig{Layout: "02 Jan 2006", Locale: "fr"}, "[14 MARS 2016] short name from a long French name", time.Date(2016, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{config.TimestampConfig{Layout: "02 Jan 2006", Locale: "es"}, "[14 Mar 2016] English names still work", time.Date(2016, 3, 14, 0, 0, 0, 0, time.UTC)},
+	} {
+		test.cfg.Match = "^\\[%{TS:timestamp}\\]"
+		test.cfg.Field = "timestamp"
+		parser, err := newTimestampParser(&test.cfg, patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamp, ok := parser.parse(test.line)
+		if !ok || !timestamp.Equal(test.expected) {
+			t.Errorf("%q: Expected %v, but got %v (%v).", test.line, test.expected, timestamp, ok)
+		}
+	}
+}
+
+func TestAgeFilter(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("TS \\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}")
+	parser, err := newTimestampParser(&config.TimestampConfig{
+		Match:  "^%{TS:timestamp} ",
+		Field:  "timestamp",
+		Layout: "2006-01-02 15:04:05",
+	}, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := newAgeFilter(parser, time.Hour)
+	now := time.Date(2016, 4, 1, 12, 0, 0, 0, time.UTC)
+	for line, expected := range map[string]bool{
+		"2016-04-01 11:30:00 recent":        false,
+		"2016-04-01 10:59:59 too old":       true,
+		"2016-04-01 12:05:00 in the future": false,
+		"no timestamp":                      false,
+	} {
+		if filter.tooOld(line, now) != expected {
+			t.Errorf("%q: Expected tooOld to be %v.", line, expected)
+		}
+	}
+	if newAgeFilter(parser, 0).tooOld("2016-04-01 10:59:59 too old", now) {
+		t.Error("Expected nil ageFilter not to ignore lines.")
+	}
+}
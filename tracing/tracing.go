@@ -0,0 +1,258 @@
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tracing of the log line processing pipeline.
+// Spans are exported with the OTLP/HTTP protocol using the JSON encoding,
+// see https://opentelemetry.io/docs/specs/otlp/
+// This avoids pulling the OpenTelemetry SDK and its gRPC dependencies into the vendor directory.
+//
+// A nil *Tracer and a nil *Span are valid and do nothing, so callers don't need to check if tracing is enabled.
+
+const (
+	queueSize     = 4096
+	maxBatchSize  = 512
+	flushInterval = 5 * time.Second
+)
+
+type Tracer struct {
+	endpoint    string
+	resource    map[string]string // OTLP resource attributes, including service.name
+	sampleRatio float64
+	client      *http.Client
+	queue       chan *Span
+	done        chan struct{}
+	mutex       sync.RWMutex // protects closed, so that End() never sends to the closed queue
+	closed      bool
+}
+
+type Span struct {
+	tracer     *Tracer
+	traceId    string
+	spanId     string
+	parentId   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+}
+
+// NewTracer starts a goroutine exporting spans to the configured OTLP endpoint. Returns nil if cfg is nil.
+// The resource attributes are from 'global.resource_attributes'. A service.name there takes precedence over 'tracing.service_name'.
+func NewTracer(cfg *config.TracingConfig, resourceAttributes map[string]string) *Tracer {
+	if cfg == nil {
+		return nil
+	}
+	resource := map[string]string{"service.name": cfg.ServiceName}
+	for key, value := range resourceAttributes {
+		resource[key] = value
+	}
+	t := &Tracer{
+		endpoint:    cfg.Endpoint,
+		resource:    resource,
+		sampleRatio: cfg.SampleRatio,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, queueSize),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// StartTrace starts a new root span, or returns nil if the trace is not sampled.
+func (t *Tracer) StartTrace(name string, start time.Time) *Span {
+	if t == nil || mathrand.Float64() >= t.sampleRatio {
+		return nil
+	}
+	return &Span{
+		tracer:  t,
+		traceId: randomId(16),
+		spanId:  randomId(8),
+		name:    name,
+		start:   start,
+	}
+}
+
+// Shutdown exports the remaining spans. Calling it more than once is allowed, the later calls do nothing.
+func (t *Tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mutex.Unlock()
+	<-t.done
+}
+
+func (s *Span) StartChild(name string) *Span {
+	return s.StartChildAt(name, time.Now())
+}
+
+// StartChildAt starts a child span at the given time, for a stage that started before the span could be created.
+func (s *Span) StartChildAt(name string, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{
+		tracer:   s.tracer,
+		traceId:  s.traceId,
+		spanId:   randomId(8),
+		parentId: s.spanId,
+		name:     name,
+		start:    start,
+	}
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+}
+
+// End finishes the span and queues it for export. If the queue is full the span is dropped,
+// because tracing must never slow down the processing of log lines. After Shutdown() the span is dropped as well,
+// because the processing goroutine is still running when the shutdown hooks run.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.mutex.RLock()
+	defer s.tracer.mutex.RUnlock()
+	if s.tracer.closed {
+		return
+	}
+	select {
+	case s.tracer.queue <- s:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	batch := make([]*Span, 0, maxBatchSize)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				t.export(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= maxBatchSize {
+				t.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (t *Tracer) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(t.toOtlp(batch))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export spans: %v\n", err.Error())
+		return
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export spans to %v: %v\n", t.endpoint, err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		fmt.Fprintf(os.Stderr, "Failed to export spans to %v: %v\n", t.endpoint, resp.Status)
+	}
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+func (t *Tracer) toOtlp(batch []*Span) map[string]interface{} {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, otlpSpan{
+			TraceId:           s.traceId,
+			SpanId:            s.spanId,
+			ParentSpanId:      s.parentId,
+			Name:              s.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        toOtlpAttributes(s.attributes),
+		})
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": toOtlpAttributes(t.resource),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "grok_exporter"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func toOtlpAttributes(attributes map[string]string) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attributes))
+	for key, value := range attributes {
+		a := otlpAttribute{Key: key}
+		a.Value.StringValue = value
+		result = append(result, a)
+	}
+	return result
+}
+
+func randomId(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
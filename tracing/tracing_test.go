@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"encoding/json"
+	"github.com/fstab/grok_exporter/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	var received map[string][]struct {
+		Resource struct {
+			Attributes []otlpAttribute
+		}
+		ScopeSpans []struct {
+			Spans []otlpSpan
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+	tracer := NewTracer(&config.TracingConfig{Endpoint: server.URL, SampleRatio: 1, ServiceName: "test"}, map[string]string{"service.namespace": "shop"})
+	root := tracer.StartTrace("process_line", time.Now())
+	child := root.StartChild("match")
+	child.SetAttribute("metric", "test_total")
+	child.End()
+	root.End()
+	tracer.Shutdown()
+	tracer.Shutdown() // called by the shutdown hook and on return
+	spans := received["resourceSpans"][0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, but got %v.", len(spans))
+	}
+	if spans[0].ParentSpanId != spans[1].SpanId || spans[0].TraceId != spans[1].TraceId {
+		t.Errorf("Expected span %v to be a child of span %v.", spans[0].Name, spans[1].Name)
+	}
+	resource := make(map[string]string)
+	for _, a := range received["resourceSpans"][0].Resource.Attributes {
+		resource[a.Key] = a.Value.StringValue
+	}
+	if resource["service.name"] != "test" || resource["service.namespace"] != "shop" {
+		t.Errorf("Unexpected resource attributes %v.", resource)
+	}
+}
+
+func TestEndAfterShutdown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	tracer := NewTracer(&config.TracingConfig{Endpoint: server.URL, SampleRatio: 1, ServiceName: "test"}, nil)
+	root := tracer.StartTrace("process_line", time.Now())
+	tracer.Shutdown()
+	root.StartChild("match").End() // must not panic with "send on closed channel"
+	root.End()
+}
+
+func TestDisabled(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.StartTrace("process_line", time.Now())
+	span.StartChild("match").End()
+	span.End()
+	tracer.Shutdown()
+}
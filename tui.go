@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/fstab/grok_exporter/datepath"
+	"github.com/fstab/grok_exporter/regex"
+	"github.com/google/mtail/tailer"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// The tui command is a simple terminal UI for developing match expressions.
+// It shows the most recent log lines, marks which metrics matched each line, and lets the user
+// edit the match expressions while the log file is tailed. Only ANSI escape codes are used,
+// so there is no dependency on a terminal library.
+
+const (
+	ansiClear = "\033[H\033[2J"
+	ansiReset = "\033[0m"
+	ansiBold  = "\033[1m"
+)
+
+// Colors for metrics. If there are more metrics than colors, colors are re-used.
+var tuiColors = []string{"\033[32m", "\033[33m", "\033[34m", "\033[35m", "\033[36m", "\033[31m"}
+
+type tuiMetric struct {
+	name       string
+	original   string // the match expression from the config file
+	expression string
+	regex      regex.Regexp
+}
+
+type tuiLine struct {
+	text  string
+	delta string // "+" if the line matches since the last edit, "-" if it no longer matches, "" otherwise
+}
+
+type tuiState struct {
+	patterns *Patterns
+	metrics  []*tuiMetric
+	lines    []*tuiLine // the most recent lines, oldest first
+	maxLines int
+	message  string // result of the last command
+}
+
+func runTui(args []string) int {
+	flags, configFlags := newFlagSet("tui")
+	input := flags.String("input", "", "Path to a log file. Default is the file configured in 'input.path'.")
+	maxLines := flags.Int("lines", 20, "Number of log lines shown.")
+	if exitCode := parseFlags(flags, args); exitCode >= 0 {
+		return exitCode
+	}
+	if *maxLines < 1 {
+		fmt.Fprintf(os.Stderr, "'-lines': Expecting a positive number of lines, but got %v.\n", *maxLines)
+		return exitUsage
+	}
+	cfg, patterns, _, err := initialize(configFlags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	path, readall := *input, true
+	if path == "" {
+		if cfg.Input.Type != "file" {
+			fmt.Fprintf(os.Stderr, "The tui command reads commands from stdin, so it cannot be used with the stdin input. Use '-input <path>'.\n")
+			return exitUsage
+		}
+		if cfg.Input.MultipleFiles() {
+			fmt.Fprintf(os.Stderr, "The tui command follows a single file, but 'input.paths' or a glob pattern is configured. Use '-input <path>'.\n")
+			return exitUsage
+		}
+		path, readall = datepath.Expand(cfg.Input.Path, time.Now()), cfg.Input.Readall
+	}
+	state := newTuiState(patterns, *maxLines)
+	for _, m := range *cfg.Metrics {
+		if m.Type == "derived" {
+			continue
+		}
+		regex, err := Compile(m.Match, patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+		state.metrics = append(state.metrics, &tuiMetric{
+			name:       m.Name,
+			original:   m.Match,
+			expression: m.Match,
+			regex:      regex,
+		})
+	}
+	lines := make(chan string)
+	t, err := tailer.New(tailer.Options{Lines: lines})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize the tail process: %v\n", err.Error())
+		return exitFailure
+	}
+	defer t.Close()
+	go t.Tail(path, readall)
+	commands := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			commands <- scanner.Text()
+		}
+		close(commands)
+	}()
+	state.message = "Type 'help' for a list of commands."
+	state.render(os.Stdout)
+	for {
+		select {
+		case line := <-lines:
+			state.addLine(strings.TrimRight(line, "\r\n"))
+		case cmd, ok := <-commands:
+			if !ok || !state.execute(cmd) {
+				return exitOK
+			}
+		}
+		state.render(os.Stdout)
+	}
+}
+
+func newTuiState(patterns *Patterns, maxLines int) *tuiState {
+	return &tuiState{
+		patterns: patterns,
+		metrics:  make([]*tuiMetric, 0),
+		lines:    make([]*tuiLine, 0, maxLines),
+		maxLines: maxLines,
+	}
+}
+
+func (s *tuiState) addLine(text string) {
+	if len(s.lines) >= s.maxLines {
+		s.lines = s.lines[1:]
+	}
+	s.lines = append(s.lines, &tuiLine{text: text})
+}
+
+// execute runs a command entered by the user. Returns false if the user wants to quit.
+func (s *tuiState) execute(cmd string) bool {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return true
+	}
+	switch fields[0] {
+	case "quit", "q":
+		return false
+	case "edit", "e":
+		if len(fields) < 3 {
+			s.message = "Usage: edit <metric> <expression>"
+			return true
+		}
+		// The expression is the rest of the line after the metric name, including whitespace.
+		rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(cmd), fields[0]))
+		expression := strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+		s.message = s.edit(fields[1], expression)
+	case "reset", "r":
+		if len(fields) != 2 {
+			s.message = "Usage: reset <metric>"
+			return true
+		}
+		if m := s.find(fields[1]); m != nil {
+			s.message = s.edit(fields[1], m.original)
+		} else {
+			s.message = fmt.Sprintf("Unknown metric %v.", fields[1])
+		}
+	case "print", "p":
+		var b bytes.Buffer
+		for _, m := range s.metrics {
+			fmt.Fprintf(&b, "%v:\n  match: '%v'\n", m.name, m.expression)
+		}
+		s.message = b.String()
+	case "help", "h":
+		s.message = "Commands:\n" +
+			"  edit <metric> <expression>  Replace the match expression of a metric.\n" +
+			"  reset <metric>              Restore the match expression from the config file.\n" +
+			"  print                       Show the current match expressions.\n" +
+			"  quit                        Exit."
+	default:
+		s.message = fmt.Sprintf("Unknown command '%v'. Type 'help' for a list of commands.", fields[0])
+	}
+	return true
+}
+
+func (s *tuiState) find(name string) *tuiMetric {
+	for _, m := range s.metrics {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// edit replaces the match expression of a metric and marks the lines where the result changed.
+func (s *tuiState) edit(name, expression string) string {
+	m := s.find(name)
+	if m == nil {
+		return fmt.Sprintf("Unknown metric %v.", name)
+	}
+	regex, err := Compile(expression, s.patterns)
+	if err != nil {
+		return err.Error()
+	}
+	added, removed := 0, 0
+	for _, line := range s.lines {
+		before, after := m.regex.MatchString(line.text), regex.MatchString(line.text)
+		switch {
+		case after && !before:
+			line.delta = "+"
+			added++
+		case before && !after:
+			line.delta = "-"
+			removed++
+		default:
+			line.delta = ""
+		}
+	}
+	m.expression = expression
+	m.regex = regex
+	return fmt.Sprintf("%v: %v lines match now, %v lines no longer match.", name, added, removed)
+}
+
+func (s *tuiState) render(w io.Writer) {
+	fmt.Fprint(w, ansiClear)
+	fmt.Fprintf(w, "%vMetrics%v\n", ansiBold, ansiReset)
+	for i, m := range s.metrics {
+		edited := ""
+		if m.expression != m.original {
+			edited = " (edited)"
+		}
+		fmt.Fprintf(w, "  %v[%v]%v %v: %v%v\n", color(i), i, ansiReset, m.name, m.expression, edited)
+	}
+	fmt.Fprintf(w, "\n%vLines%v\n", ansiBold, ansiReset)
+	for _, line := range s.lines {
+		matched := make([]int, 0)
+		for i, m := range s.metrics {
+			if m.regex.MatchString(line.text) {
+				matched = append(matched, i)
+			}
+		}
+		fmt.Fprintf(w, "%1v %-8v %v\n", line.delta, formatMatched(matched), s.highlight(line.text, matched))
+	}
+	fmt.Fprintf(w, "\n%v\n> ", s.message)
+}
+
+// highlight colors the part of the line matched by the first matching metric.
+func (s *tuiState) highlight(text string, matched []int) string {
+	if len(matched) == 0 {
+		return text
+	}
+	loc := s.metrics[matched[0]].regex.FindStringIndex(text)
+	if loc == nil || loc[0] == loc[1] {
+		return text
+	}
+	return text[:loc[0]] + color(matched[0]) + text[loc[0]:loc[1]] + ansiReset + text[loc[1]:]
+}
+
+func formatMatched(matched []int) string {
+	result := make([]string, 0, len(matched))
+	for _, i := range matched {
+		result = append(result, fmt.Sprintf("%v", i))
+	}
+	return "[" + strings.Join(result, ",") + "]"
+}
+
+func color(i int) string {
+	return tuiColors[i%len(tuiColors)]
+}
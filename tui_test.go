@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTuiEdit(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("NUMBER \\d+")
+	state := newTuiState(patterns, 3)
+	regex, err := Compile("error", patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.metrics = append(state.metrics, &tuiMetric{name: "errors_total", original: "error", expression: "error", regex: regex})
+	for _, line := range []string{"dropped", "error 1", "error", "error 2"} {
+		state.addLine(line)
+	}
+	if len(state.lines) != 3 || state.lines[0].text != "error 1" {
+		t.Fatalf("Expected the oldest line to be dropped.")
+	}
+	state.execute("edit errors_total error %{NUMBER}")
+	for i, expected := range []string{"", "-", ""} {
+		if state.lines[i].delta != expected {
+			t.Errorf("Line %q: Expected delta %q, but got %q.", state.lines[i].text, expected, state.lines[i].delta)
+		}
+	}
+	if state.metrics[0].expression != "error %{NUMBER}" {
+		t.Errorf("Unexpected expression %q.", state.metrics[0].expression)
+	}
+	state.execute("reset errors_total")
+	if state.metrics[0].expression != "error" || state.lines[1].delta != "+" {
+		t.Errorf("Expected reset to restore the original expression.")
+	}
+	if state.execute("quit") {
+		t.Errorf("Expected quit to return false.")
+	}
+}
+
+func TestTuiLines(t *testing.T) {
+	for _, lines := range []string{"0", "-1"} {
+		if exitCode := runTui([]string{"-lines", lines}); exitCode != exitUsage {
+			t.Errorf("-lines %v: Expected exit code %v, but got %v.", lines, exitUsage, exitCode)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	unmatchedSampleSize = 100
+	// After this many unmatched lines the sampling starts over, so that the sample is biased towards recent lines.
+	unmatchedWindow = 10000
+)
+
+// unmatchedSample is a reservoir sample of the lines that matched no metric, served at /debug/unmatched.
+// It shows what the patterns are missing without having to search the log file.
+type unmatchedSample struct {
+	mutex sync.Mutex
+	lines []string
+	seen  int // unmatched lines since the sampling started over
+	rand  *rand.Rand
+}
+
+func newUnmatchedSample() *unmatchedSample {
+	return &unmatchedSample{
+		lines: make([]string, 0, unmatchedSampleSize),
+		rand:  rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// add may be called on a nil sample, which does nothing.
+func (s *unmatchedSample) add(line string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	line = strings.TrimRight(line, "\r\n")
+	if s.seen == unmatchedWindow {
+		s.seen = len(s.lines)
+	}
+	s.seen++
+	if len(s.lines) < unmatchedSampleSize {
+		s.lines = append(s.lines, line)
+		return
+	}
+	if i := s.rand.Intn(s.seen); i < unmatchedSampleSize {
+		s.lines[i] = line
+	}
+}
+
+func (s *unmatchedSample) list() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+// ServeHTTP writes the sampled lines as plain text, one per line.
+func (s *unmatchedSample) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range s.list() {
+		fmt.Fprintln(w, line)
+	}
+}
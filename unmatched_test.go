@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnmatchedSample(t *testing.T) {
+	s := newUnmatchedSample()
+	s.add("first\n")
+	s.add("second")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/unmatched", nil))
+	if recorder.Body.String() != "first\nsecond\n" {
+		t.Errorf("Unexpected body: %q", recorder.Body.String())
+	}
+	for i := 0; i < 3*unmatchedWindow; i++ {
+		s.add(fmt.Sprintf("line %v", i))
+	}
+	lines := s.list()
+	if len(lines) != unmatchedSampleSize {
+		t.Fatalf("Expected %v lines, but got %v.", unmatchedSampleSize, len(lines))
+	}
+	recent := 0
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "line ") {
+			t.Errorf("Expected %q to be replaced by a more recent line.", line)
+		}
+		var i int
+		fmt.Sscanf(line, "line %d", &i)
+		if i >= 2*unmatchedWindow {
+			recent++
+		}
+	}
+	if recent < unmatchedSampleSize/2 {
+		t.Errorf("Expected the sample to be biased towards recent lines, but only %v of %v lines are from the last window.", recent, len(lines))
+	}
+}
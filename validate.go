@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"regexp"
+	"strings"
+)
+
+// validateMetrics performs the checks that require the expanded regular expressions,
+// which is why they cannot be done in config.validate().
+// Problems that make a metric unusable are returned as error.
+// Problems that are most likely a mistake, but are not necessarily wrong, are returned as warnings.
+func validateMetrics(cfg *config.Config, patterns *Patterns) ([]string, error) {
+	warnings := make([]string, 0)
+	regexes := make([]string, 0, len(*cfg.Metrics))
+	metrics := make([]*config.MetricConfig, 0, len(*cfg.Metrics))
+	pathFields := make(map[string]bool)
+	for _, field := range cfg.Input.PathFields() {
+		pathFields[field] = true
+	}
+	for name := range cfg.Input.Labels {
+		pathFields[name] = true
+	}
+	if cfg.Input.Type == "file" {
+		pathFields["logfile"] = true
+	}
+	for _, m := range *cfg.Metrics {
+		if m.Type == "derived" {
+			continue // derived metrics do not match log lines
+		}
+		regex, err := expand(m.Match, patterns)
+		if err != nil {
+			return nil, err
+		}
+		groups := namedGroups(regex)
+		groupList := namedGroupsOf(m.Match, patterns)
+		if m.Repeat != "" {
+			// Labels and value are taken from the occurrences of the repeat expression.
+			repeat, err := expand(m.Repeat, patterns)
+			if err != nil {
+				return nil, err
+			}
+			groups = namedGroups(repeat)
+			groupList = namedGroupsOf(m.Repeat, patterns)
+		}
+		if m.Context != nil {
+			// The captures of the context line are fields, too.
+			context, err := expand(m.Context.Match, patterns)
+			if err != nil {
+				return nil, err
+			}
+			for group := range namedGroups(context) {
+				groups[group] = true
+			}
+			groupList = append(groupList, namedGroupsOf(m.Context.Match, patterns)...)
+		}
+		// A field captured by more than one group, usually because a nested pattern captures a field with the same name,
+		// gets the value of an arbitrary one of the groups.
+		duplicates := duplicateGroups(groupList)
+		for _, field := range usedFields(m) {
+			capture, ok := m.Fields.CaptureName(field)
+			if ok && duplicates[capture] > 0 {
+				return nil, fmt.Errorf("Invalid metric %v: Grok field %v is used, but the expanded expressions have %v named groups %v, so the value is ambiguous. Run 'grok_exporter check -explain %v' to see where they come from.", m.Name, field, duplicates[capture], capture, m.Name)
+			}
+		}
+		// Fields added by a 'set' stage of the 'pipeline' can be used like captures.
+		for _, stage := range m.Pipeline {
+			for field := range stage.Set {
+				if capture, ok := m.Fields.CaptureName(field); ok {
+					groups[capture] = true
+				}
+			}
+		}
+		// With format auto, any field may be a JSON or logfmt key, which is only known when the lines are read.
+		isField := func(capture string) bool {
+			return groups[capture] || m.Kv.Allows(capture) || m.Xml[capture] != "" || pathFields[capture] || m.Format == "auto"
+		}
+		for _, field := range stageFields(m) {
+			capture, ok := m.Fields.CaptureName(field)
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("Invalid metric %v: 'pipeline' references grok field %v, but this field is dropped or renamed in 'fields'.", m.Name, field)
+			case !isField(capture):
+				return nil, fmt.Errorf("Invalid metric %v: 'pipeline' references grok field %v, but the match or repeat expression has no capture named %v.", m.Name, field, capture)
+			}
+		}
+		for _, label := range m.Labels {
+			capture, ok := m.Fields.CaptureName(label.GrokFieldName)
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("Invalid metric %v: Label %v references grok field %v, but this field is dropped or renamed in 'fields'.", m.Name, label.PrometheusLabel, label.GrokFieldName)
+			case !isField(capture):
+				return nil, fmt.Errorf("Invalid metric %v: Label %v references grok field %v, but the match or repeat expression has no capture named %v.", m.Name, label.PrometheusLabel, label.GrokFieldName, capture)
+			}
+		}
+		if m.Value != "" {
+			capture, ok := m.Fields.CaptureName(m.Value)
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("Invalid metric %v: 'value' references grok field %v, but this field is dropped or renamed in 'fields'.", m.Name, m.Value)
+			case !isField(capture):
+				return nil, fmt.Errorf("Invalid metric %v: 'value' references grok field %v, but the match or repeat expression has no capture named %v.", m.Name, m.Value, capture)
+			}
+		}
+		if m.SumField != "" {
+			capture, ok := m.Fields.CaptureName(m.SumField)
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("Invalid metric %v: 'sum_field' references grok field %v, but this field is dropped or renamed in 'fields'.", m.Name, m.SumField)
+			case !isField(capture):
+				return nil, fmt.Errorf("Invalid metric %v: 'sum_field' references grok field %v, but the match or repeat expression has no capture named %v.", m.Name, m.SumField, capture)
+			}
+		}
+		if m.Exemplar != nil {
+			for _, field := range m.Exemplar.Fields {
+				capture, ok := m.Fields.CaptureName(field)
+				switch {
+				case !ok:
+					return nil, fmt.Errorf("Invalid metric %v: 'exemplar.fields' references grok field %v, but this field is dropped or renamed in 'fields'.", m.Name, field)
+				case !isField(capture):
+					return nil, fmt.Errorf("Invalid metric %v: 'exemplar.fields' references grok field %v, but the match or repeat expression has no capture named %v.", m.Name, field, capture)
+				}
+			}
+		}
+		if m.Fields != nil {
+			// Most likely the field was renamed in the pattern library, which is what 'fields.rename' should protect against.
+			for from := range m.Fields.Rename {
+				if !groups[from] {
+					warnings = append(warnings, fmt.Sprintf("Metric %v: 'fields.rename' references grok field %v, but the match or repeat expression has no capture named %v.", m.Name, from, from))
+				}
+			}
+			for field := range m.Fields.Mutate {
+				if !usesField(m, field) {
+					warnings = append(warnings, fmt.Sprintf("Metric %v: 'fields.mutate' is configured for field %v, but this field is not used in any label or value.", m.Name, field))
+				}
+			}
+		}
+		regexes = append(regexes, regex)
+		metrics = append(metrics, m)
+	}
+	for i := range metrics {
+		for j := range metrics {
+			switch {
+			case i >= j:
+				continue
+			case regexes[i] == regexes[j]:
+				warnings = append(warnings, fmt.Sprintf("Metrics %v and %v have identical match expressions.", metrics[i].Name, metrics[j].Name))
+			case subsumes(regexes[i], regexes[j]):
+				warnings = append(warnings, fmt.Sprintf("Metric %v matches all lines matched by metric %v.", metrics[i].Name, metrics[j].Name))
+			case subsumes(regexes[j], regexes[i]):
+				warnings = append(warnings, fmt.Sprintf("Metric %v matches all lines matched by metric %v.", metrics[j].Name, metrics[i].Name))
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// metricDisabled is set for the metrics removed by skipInvalidMetrics(), so that a bad metric pushed to a fleet can be alerted on.
+var metricDisabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "grok_exporter_metric_disabled",
+	Help: "1 if the metric is disabled because its expressions are invalid, see 'global.on_pattern_error'.",
+}, []string{"metric"})
+
+// skipInvalidMetrics removes the metrics whose expressions cannot be expanded, compiled, or validated, for 'global.on_pattern_error: skip'.
+// Derived metrics whose source is removed are removed as well. It returns an error for each removed metric.
+func skipInvalidMetrics(cfg *config.Config, patterns *Patterns) []error {
+	result := make([]error, 0)
+	valid := make(config.MetricsConfig, 0, len(*cfg.Metrics))
+	disabled := make(map[string]bool)
+	for _, m := range *cfg.Metrics {
+		if m.Type == "derived" {
+			valid = append(valid, m)
+			continue
+		}
+		single := *cfg
+		single.Metrics = &config.MetricsConfig{m}
+		_, err := validateMetrics(&single, patterns)
+		if err == nil {
+			err = compileExpressions(m, patterns)
+		}
+		if err != nil {
+			result = append(result, err)
+			disabled[m.Name] = true
+			metricDisabled.WithLabelValues(m.Name).Set(1)
+			continue
+		}
+		valid = append(valid, m)
+	}
+	remaining := make(config.MetricsConfig, 0, len(valid))
+	for _, m := range valid {
+		if m.Type == "derived" && disabled[m.Source] {
+			result = append(result, fmt.Errorf("Metric %v: The source metric %v is disabled.", m.Name, m.Source))
+			metricDisabled.WithLabelValues(m.Name).Set(1)
+			continue
+		}
+		remaining = append(remaining, m)
+	}
+	*cfg.Metrics = remaining
+	return result
+}
+
+// compileExpressions compiles the 'match', 'repeat', and 'context' expressions of a metric, like createMetrics() does.
+func compileExpressions(m *config.MetricConfig, patterns *Patterns) error {
+	expressions := []string{m.Match, m.Repeat}
+	if m.Context != nil {
+		expressions = append(expressions, m.Context.Match)
+	}
+	for _, expression := range expressions {
+		if expression == "" {
+			continue
+		}
+		if _, err := Compile(expression, patterns); err != nil {
+			return fmt.Errorf("Invalid metric %v: %v", m.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+func usesField(m *config.MetricConfig, field string) bool {
+	for _, label := range m.Labels {
+		if label.GrokFieldName == field {
+			return true
+		}
+	}
+	if m.Exemplar != nil {
+		for _, exemplarField := range m.Exemplar.Fields {
+			if exemplarField == field {
+				return true
+			}
+		}
+	}
+	return m.Value == field || m.SumField == field
+}
+
+// usedFields returns the fields used in labels, 'value', 'sum_field', 'exemplar.fields', and the 'pipeline'.
+func usedFields(m *config.MetricConfig) []string {
+	result := make([]string, 0, len(m.Labels)+2)
+	for _, label := range m.Labels {
+		result = append(result, label.GrokFieldName)
+	}
+	for _, field := range []string{m.Value, m.SumField} {
+		if field != "" {
+			result = append(result, field)
+		}
+	}
+	if m.Exemplar != nil {
+		result = append(result, m.Exemplar.Fields...)
+	}
+	return append(result, stageFields(m)...)
+}
+
+// stageFields returns the fields read by the 'filter' and 'mutate' stages of the 'pipeline'.
+func stageFields(m *config.MetricConfig) []string {
+	result := make([]string, 0)
+	for _, stage := range m.Pipeline {
+		if stage.Filter != nil {
+			result = append(result, stage.Filter.Field)
+		}
+		for field := range stage.Mutate {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// Matches (?<name>...), but not the look-behind assertions (?<=...) and (?<!...).
+var namedGroupRegexp = regexp.MustCompile(`\(\?<([a-zA-Z0-9_]+)>`)
+
+func namedGroups(regex string) map[string]bool {
+	result := make(map[string]bool)
+	for _, match := range namedGroupRegexp.FindAllStringSubmatch(regex, -1) {
+		result[match[1]] = true
+	}
+	return result
+}
+
+// Matches inline flags like (?i) and flag groups like (?i:...), which change how the rest of the expression matches.
+var inlineFlagsRegexp = regexp.MustCompile(`\(\?[a-zA-Z-]+[:)]`)
+
+// subsumes returns true if every line matching regex b also matches regex a.
+// This is a conservative check for the trivial case where b is a concatenation containing a.
+// As matching is not anchored, any line matching b then contains a match of a.
+// It returns false if the relationship cannot be decided in this simple way, which includes expressions with inline flags,
+// because a like foo matches differently within b like (?i)x foo.
+func subsumes(a, b string) bool {
+	if a == "" || hasTopLevelAlternation(a) || hasTopLevelAlternation(b) {
+		return false
+	}
+	if inlineFlagsRegexp.MatchString(a) || inlineFlagsRegexp.MatchString(b) {
+		return false
+	}
+	depths := nestingDepths(b)
+	for offset := 0; offset+len(a) <= len(b); {
+		i := strings.Index(b[offset:], a)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(a)
+		if depths[start] == 0 && depths[end] == 0 && !isQuantifier(b[end:]) {
+			return true
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+// nestingDepths returns for each position in regex the parenthesis depth before the character at that position,
+// or -1 if the position is within an escape sequence or a character class.
+// The result has len(regex)+1 entries, the last one is the depth after the end of regex.
+func nestingDepths(regex string) []int {
+	result := make([]int, len(regex)+1)
+	depth, inClass := 0, false
+	for i := 0; i < len(regex); i++ {
+		if inClass {
+			result[i] = -1
+			if regex[i] == '\\' && i+1 < len(regex) {
+				i++
+				result[i] = -1
+			} else if regex[i] == ']' {
+				inClass = false
+			}
+			continue
+		}
+		result[i] = depth
+		switch regex[i] {
+		case '\\':
+			if i+1 < len(regex) {
+				i++
+				result[i] = -1
+			}
+		case '[':
+			inClass = true
+			// A ']' directly after '[' or '[^' is a literal character and does not close the class.
+			for _, c := range []byte{'^', ']'} {
+				if i+1 < len(regex) && regex[i+1] == c {
+					i++
+					result[i] = -1
+				}
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+	}
+	result[len(regex)] = depth
+	return result
+}
+
+func hasTopLevelAlternation(regex string) bool {
+	depths := nestingDepths(regex)
+	for i := 0; i < len(regex); i++ {
+		if regex[i] == '|' && depths[i] == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isQuantifier(s string) bool {
+	return len(s) > 0 && strings.ContainsAny(s[:1], "*+?{")
+}
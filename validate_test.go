@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/fstab/grok_exporter/config"
+	"strings"
+	"testing"
+)
+
+func TestSubsumes(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"foo", "a foo b", true},
+		{"o+", "fo+", true},
+		{"fo", "fo+", false},
+		{"n", `a\nb`, false},
+		{"foo", "(?:foo|bar)", false},
+		{"foo", "foo|bar", false},
+		{"a|b", "xa|by", false},
+		{"foo", "[foo]", false},
+		{"(?<x>a)", "b(?<x>a)c", true},
+		{"b", "(?:ab)*", false},
+		{"foo", "(?i)x foo", false},
+		{"foo", "x (?i:foo)", false},
+		{"(?i)foo", "x (?i)foo", false},
+		{"foo", "(?P<x>a) foo", true},
+	} {
+		if subsumes(test.a, test.b) != test.expected {
+			t.Errorf("Expected subsumes(%q, %q) to be %v.", test.a, test.b, test.expected)
+		}
+	}
+}
+
+func TestValidateMetrics(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("WORD \\b\\w+\\b")
+	patterns.AddPattern("NUMBER \\d+")
+	cfg, err := config.LoadConfigString([]byte(`
+grok:
+    patterns: ['WORD \b\w+\b']
+metrics:
+    - type: counter
+      name: all_total
+      help: All lines with a number.
+      match: 'n=%{NUMBER:n}'
+      labels: []
+    - type: counter
+      name: user_total
+      help: All lines with a user and a number.
+      match: 'user=%{WORD:user} n=%{NUMBER:n}'
+      labels:
+          - grok_field_name: user
+            prometheus_label: user
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err.Error())
+	}
+	warnings, err := validateMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, but got %v.", warnings)
+	}
+	(*cfg.Metrics)[1].Labels[0].GrokFieldName = "username"
+	_, err = validateMetrics(cfg, patterns)
+	if err == nil {
+		t.Fatalf("Expected error for label referencing an undefined grok field.")
+	}
+	(*cfg.Metrics)[1].Fields = &config.FieldsConfig{Rename: map[string]string{"user": "username"}}
+	_, err = validateMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatalf("Unexpected error for label referencing a renamed grok field: %v", err.Error())
+	}
+	(*cfg.Metrics)[1].Labels[0].GrokFieldName = "user"
+	_, err = validateMetrics(cfg, patterns)
+	if err == nil {
+		t.Fatalf("Expected error for label referencing the original name of a renamed grok field.")
+	}
+	(*cfg.Metrics)[1].Fields = nil
+	(*cfg.Metrics)[1].Labels = append((*cfg.Metrics)[1].Labels, config.Label{GrokFieldName: "tier", PrometheusLabel: "tier"})
+	(*cfg.Metrics)[1].Pipeline = []config.StageConfig{{Set: map[string]string{"tier": "backend"}}}
+	_, err = validateMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatalf("Unexpected error for label referencing a field set in the pipeline: %v", err.Error())
+	}
+	(*cfg.Metrics)[1].Pipeline = append((*cfg.Metrics)[1].Pipeline, config.StageConfig{Filter: &config.FilterStageConfig{Field: "host", Match: "^web"}})
+	_, err = validateMetrics(cfg, patterns)
+	if err == nil || !strings.Contains(err.Error(), "'pipeline' references grok field host") {
+		t.Fatalf("Expected error for a filter on an undefined grok field, but got %v.", err)
+	}
+	(*cfg.Metrics)[1].Format = "auto"
+	_, err = validateMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatalf("Unexpected error for a filter on a field that may be a JSON or logfmt key with format auto: %v", err.Error())
+	}
+	(*cfg.Metrics)[1].Format = ""
+	(*cfg.Metrics)[1].Pipeline = nil
+	(*cfg.Metrics)[1].Labels = (*cfg.Metrics)[1].Labels[:1]
+	(*cfg.Metrics)[1].Match = "user=%{WORD:user} n=%{NUMBER:n} by %{WORD:user}"
+	_, err = validateMetrics(cfg, patterns)
+	if err == nil || !strings.Contains(err.Error(), "2 named groups user") {
+		t.Fatalf("Expected error for a label with duplicate named groups, but got %v.", err)
+	}
+}
+
+func TestSkipInvalidMetrics(t *testing.T) {
+	patterns := InitPatterns()
+	patterns.AddPattern("NUMBER \\d+")
+	cfg, err := config.LoadConfigString([]byte(`
+global:
+    on_pattern_error: skip
+grok:
+    patterns: ['NUMBER \d+']
+metrics:
+    - type: counter
+      name: good_total
+      help: Valid.
+      match: '%{NUMBER:n}'
+      labels: []
+    - type: counter
+      name: unknown_pattern_total
+      help: Unknown pattern.
+      match: '%{NOPE}'
+      labels: []
+    - type: counter
+      name: unknown_label_total
+      help: Label without capture.
+      match: '%{NUMBER}'
+      labels:
+          - grok_field_name: n
+            prometheus_label: n
+    - type: derived
+      name: unknown_pattern_rate
+      help: Rate of a disabled metric.
+      source: unknown_pattern_total
+      function: rate
+      window: 5m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errors := skipInvalidMetrics(cfg, patterns)
+	if len(errors) != 3 {
+		t.Errorf("Expected 3 errors, but got %v.", errors)
+	}
+	if len(*cfg.Metrics) != 1 || (*cfg.Metrics)[0].Name != "good_total" {
+		t.Errorf("Expected only good_total to remain, but got %v.", cfg.Metrics)
+	}
+	if _, err := validateMetrics(cfg, patterns); err != nil {
+		t.Errorf("Expected the remaining metrics to be valid, but got %v.", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"github.com/fstab/grok_exporter/config"
+	"io"
+	"os"
+	"strings"
+)
+
+// warmupChunkSize is how many bytes are read at a time while searching the last lines backwards from the end of the file.
+const warmupChunkSize = 64 * 1024
+
+// warmup processes the last n lines of the file with the gauges, so that gauges showing the current state, like a queue size,
+// have a value right after the start instead of waiting for the next matching line. Other metrics count events,
+// so they do not process the lines, and neither do gauges with 'notify', which would re-send old events.
+func warmup(path string, n int, cfg *config.Config, p *pipeline) error {
+	lines, err := lastLines(path, n)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		route := p.router.route(line, p.fields)
+		for i, m := range *cfg.Metrics {
+			if m.Type != "gauge" || m.Notify != nil || !p.router.accepts(i, route) {
+				continue
+			}
+			if p.metrics[i].Matches(line) {
+				p.metrics[i].Process(line, p.fields)
+			}
+		}
+	}
+	return nil
+}
+
+// lastLines returns up to the last n complete lines of the file, oldest first. An incomplete line at the end is not returned,
+// because it may still be written.
+func lastLines(path string, n int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := info.Size()
+	data := make([]byte, 0)
+	for offset := end; offset > 0 && bytes.Count(data, []byte("\n")) <= n; {
+		size := int64(warmupChunkSize)
+		if size > offset {
+			size = offset
+		}
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err = file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(chunk, data...)
+		if offset == 0 {
+			data = append([]byte("\n"), data...) // the first line of the file is complete
+		}
+	}
+	last := bytes.LastIndexByte(data, '\n')
+	if last < 0 {
+		return nil, nil
+	}
+	lines := strings.Split(string(data[:last]), "\n")
+	lines = lines[1:] // the first element is empty, or a partial line
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fstab/grok_exporter/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLastLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	long := strings.Repeat("x", warmupChunkSize+10)
+	for content, expected := range map[string][]string{
+		"":                        nil,
+		"a\r\nb\n":                {"a", "b"},
+		"a\nb\nc\nd\n":            {"b", "c", "d"},
+		"a\nb\nincomplete":        {"a", "b"},
+		long + "\nb\nc\nd\n":      {"b", "c", "d"},
+		"a\n" + long + "\nb\n":    {"a", long, "b"},
+		"a\nb\n" + long + "\nc\n": {"b", long, "c"},
+	} {
+		ioutil.WriteFile(path, []byte(content), 0644)
+		lines, err := lastLines(path, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lines, expected) && !(len(lines) == 0 && len(expected) == 0) {
+			t.Errorf("Expected %v lines, but got %v.", len(expected), len(lines))
+		}
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grok_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	content := ""
+	for i := 1; i <= 5; i++ {
+		content += fmt.Sprintf("queue size %v\n", i)
+	}
+	ioutil.WriteFile(path, []byte(content), 0644)
+	cfg, err := config.LoadConfigString([]byte(`
+input:
+    type: file
+    path: ` + path + `
+    warmup_lines: 2
+grok:
+    patterns: ['WORD \w+']
+metrics:
+    - type: gauge
+      name: warmup_queue_size
+      help: Queue size.
+      match: 'queue size (?<size>[0-9]+)'
+      value: size
+      labels: []
+    - type: counter
+      name: warmup_lines_total
+      help: Lines.
+      match: 'queue size'
+      labels: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := initPatterns(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := createMetrics(cfg, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pipeline{metrics: metrics, input: path}
+	if err = warmup(path, cfg.Input.WarmupLines, cfg, p); err != nil {
+		t.Fatal(err)
+	}
+	gauge, counter := seriesJsons(metrics[0].Collector()), seriesJsons(metrics[1].Collector())
+	if len(gauge) != 1 || *gauge[0].Value != 5 {
+		t.Errorf("Expected the gauge to be set by the last line, but got %v.", gauge)
+	}
+	if len(counter) != 0 {
+		t.Errorf("Expected the counter not to count the warmup lines, but got %v.", counter)
+	}
+}
@@ -0,0 +1,216 @@
+package xpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This is a small subset of XPath for selecting a single value from an XML log record.
+// Supported are absolute paths like '/Event/System/EventID', descendants like '//EventID',
+// the wildcard '*', predicates like '[@Name='TargetUserName']' or '[2]',
+// and attributes like '/Event/System/Provider/@Name'. Namespaces are ignored.
+
+type Path struct {
+	steps     []step
+	attribute string // if not empty, the result is the value of this attribute of the selected element
+}
+
+type step struct {
+	descendant bool   // true for '//name', false for '/name'
+	name       string // element name or '*'
+	attrName   string // predicate [@attrName='attrValue']
+	attrValue  string
+	index      int // predicate [index], 0 if there is none
+}
+
+// Element is a parsed XML element.
+type Element struct {
+	Name     string
+	Attrs    map[string]string
+	Children []*Element
+	Text     string
+}
+
+var (
+	stepRegexp      = regexp.MustCompile(`^(\*|[\w.-]+)(?:\[(.*)\])?$`)
+	attrPredRegexp  = regexp.MustCompile(`^@([\w.-]+)\s*=\s*(?:'([^']*)'|"([^"]*)")$`)
+	indexPredRegexp = regexp.MustCompile(`^[1-9][0-9]*$`)
+	attributeRegexp = regexp.MustCompile(`^@([\w.-]+)$`)
+)
+
+func Compile(expression string) (*Path, error) {
+	if !strings.HasPrefix(expression, "/") {
+		return nil, fmt.Errorf("Invalid XPath '%v': Expecting a path starting with '/'.", expression)
+	}
+	result := &Path{}
+	segments := splitSteps(expression[1:])
+	descendant := false
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if segment == "" { // '//'
+			if descendant || last {
+				return nil, fmt.Errorf("Invalid XPath '%v': Empty step.", expression)
+			}
+			descendant = true
+			continue
+		}
+		if match := attributeRegexp.FindStringSubmatch(segment); match != nil && last && !descendant {
+			result.attribute = match[1]
+			break
+		}
+		s, err := parseStep(segment)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid XPath '%v': %v", expression, err.Error())
+		}
+		s.descendant = descendant
+		result.steps = append(result.steps, s)
+		descendant = false
+	}
+	if len(result.steps) == 0 {
+		return nil, fmt.Errorf("Invalid XPath '%v': Expecting at least one element.", expression)
+	}
+	return result, nil
+}
+
+// splitSteps splits the path at '/', except within predicates.
+func splitSteps(path string) []string {
+	result := make([]string, 0)
+	start, depth := 0, 0
+	for i, c := range path {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '/' && depth == 0:
+			result = append(result, path[start:i])
+			start = i + 1
+		}
+	}
+	return append(result, path[start:])
+}
+
+func parseStep(part string) (step, error) {
+	match := stepRegexp.FindStringSubmatch(part)
+	if match == nil {
+		return step{}, fmt.Errorf("Invalid step '%v'.", part)
+	}
+	s := step{name: match[1]}
+	switch predicate := strings.TrimSpace(match[2]); {
+	case predicate == "":
+	case indexPredRegexp.MatchString(predicate):
+		s.index, _ = strconv.Atoi(predicate)
+	case attrPredRegexp.MatchString(predicate):
+		m := attrPredRegexp.FindStringSubmatch(predicate)
+		s.attrName, s.attrValue = m[1], m[2]+m[3]
+	default:
+		return step{}, fmt.Errorf("Unsupported predicate '[%v]'. Expecting [@attribute='value'] or [index].", predicate)
+	}
+	return s, nil
+}
+
+// Parse parses an XML document. Only the root element is returned, comments and processing instructions are ignored.
+func Parse(document string) (*Element, error) {
+	decoder := xml.NewDecoder(strings.NewReader(document))
+	decoder.Strict = false
+	var stack []*Element
+	var root *Element
+	var text bytes.Buffer
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if root != nil && len(stack) == 0 {
+				return root, nil
+			}
+			return nil, fmt.Errorf("Failed to parse XML: %v", err.Error())
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &Element{Name: t.Name.Local, Attrs: make(map[string]string, len(t.Attr))}
+			for _, attr := range t.Attr {
+				e.Attrs[attr.Name.Local] = attr.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, e)
+			} else if root == nil {
+				root = e
+			}
+			stack = append(stack, e)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("Failed to parse XML: Unexpected end element %v.", t.Name.Local)
+			}
+			e := stack[len(stack)-1]
+			if len(e.Children) == 0 {
+				e.Text = strings.TrimSpace(text.String())
+			}
+			stack = stack[:len(stack)-1]
+			text.Reset()
+			if len(stack) == 0 {
+				return root, nil
+			}
+		}
+	}
+}
+
+// Select returns the value of the first element matching the path, or false if there is none.
+func (p *Path) Select(root *Element) (string, bool) {
+	// The document root is a virtual parent of the root element.
+	nodes := []*Element{{Children: []*Element{root}}}
+	for _, s := range p.steps {
+		next := make([]*Element, 0)
+		for _, node := range nodes {
+			candidates := node.Children
+			if s.descendant {
+				candidates = descendants(node)
+			}
+			matching := make([]*Element, 0)
+			for _, child := range candidates {
+				if s.matches(child) {
+					matching = append(matching, child)
+				}
+			}
+			if s.index > 0 {
+				if s.index <= len(matching) {
+					next = append(next, matching[s.index-1])
+				}
+			} else {
+				next = append(next, matching...)
+			}
+		}
+		nodes = next
+	}
+	for _, node := range nodes {
+		if p.attribute == "" {
+			return node.Text, true
+		}
+		if value, exists := node.Attrs[p.attribute]; exists {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func (s step) matches(e *Element) bool {
+	if s.name != "*" && s.name != e.Name {
+		return false
+	}
+	return s.attrName == "" || e.Attrs[s.attrName] == s.attrValue
+}
+
+func descendants(e *Element) []*Element {
+	result := make([]*Element, 0)
+	for _, child := range e.Children {
+		result = append(result, child)
+		result = append(result, descendants(child)...)
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package xpath
+
+import "testing"
+
+const event = `<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-Security-Auditing"/><EventID>4625</EventID></System>` +
+	`<EventData><Data Name="TargetUserName">alice</Data><Data Name="IpAddress">10.0.0.1</Data></EventData></Event>`
+
+func TestSelect(t *testing.T) {
+	root, err := Parse(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path     string
+		expected string
+		found    bool
+	}{
+		{"/Event/System/EventID", "4625", true},
+		{"/Event/System/Provider/@Name", "Microsoft-Windows-Security-Auditing", true},
+		{"/Event/EventData/Data[@Name='TargetUserName']", "alice", true},
+		{`//Data[@Name="IpAddress"]`, "10.0.0.1", true},
+		{"/Event/EventData/Data[2]", "10.0.0.1", true},
+		{"/Event/*/EventID", "4625", true},
+		{"//EventID", "4625", true},
+		{"/Event/System/Keywords", "", false},
+		{"/System/EventID", "", false},
+		{"/Event/System/Provider/@Guid", "", false},
+	} {
+		path, err := Compile(test.path)
+		if err != nil {
+			t.Fatalf("%v: Unexpected error: %v", test.path, err.Error())
+		}
+		value, found := path.Select(root)
+		if value != test.expected || found != test.found {
+			t.Errorf("%v: Expected %q (%v), but got %q (%v).", test.path, test.expected, test.found, value, found)
+		}
+	}
+	for _, invalid := range []string{"Event", "/Event/", "///Event", "/Event[@Name]", "/@Name", "/Event//@Name"} {
+		if _, err := Compile(invalid); err == nil {
+			t.Errorf("Expected error for %q.", invalid)
+		}
+	}
+	if _, err := Parse("<Event><System>"); err == nil {
+		t.Error("Expected error for incomplete XML.")
+	}
+}